@@ -13,6 +13,7 @@ import (
 
 	"github.com/funkygao/gafka"
 	"github.com/funkygao/gafka/cmd/actord/controller"
+	"github.com/funkygao/gafka/cmd/actord/executor"
 	"github.com/funkygao/gafka/cmd/kateway/hh"
 	"github.com/funkygao/gafka/cmd/kateway/hh/disk"
 	"github.com/funkygao/gafka/cmd/kateway/meta"
@@ -47,6 +48,10 @@ func init() {
 	flag.StringVar(&Options.InfluxDbname, "influxdb", "", "influxdb db name")
 	flag.StringVar(&Options.ListenAddr, "addr", ":9065", "monitor http server addr")
 	flag.StringVar(&Options.HintedHandoffDir, "hhdirs", "hh", "hinted handoff dirs separated by comma")
+	flag.IntVar(&Options.WebhookWorkers, "webhookworkers", executor.WebhookWorkers, "max concurrent deliveries of a webhook, per message key in order")
+	flag.IntVar(&Options.JobWorkers, "jobworkers", executor.JobWorkers, "max concurrent fire batches of a job queue, per job id in order")
+	flag.StringVar(&Options.AssignStrategy, "assign", controller.AssignSticky, "resource assignment strategy <sticky|range>")
+	flag.StringVar(&Options.AdminToken, "admintoken", "", "token required by the mutating admin apis, loopback only if empty")
 	flag.Parse()
 
 	if Options.ShowVersion {
@@ -65,6 +70,10 @@ func init() {
 		SetupLogging(Options.LogFile, Options.LogLevel, "")
 	}
 
+	executor.WebhookWorkers = Options.WebhookWorkers
+	executor.JobWorkers = Options.JobWorkers
+	controller.AdminToken = Options.AdminToken
+
 	ctx.LoadFromHome()
 }
 
@@ -110,7 +119,7 @@ func Main() {
 	}
 	log.Trace("pub store[%s] started", store.DefaultPubStore.Name())
 
-	c := controller.New(zkzone, Options.ListenAddr, Options.ManagerType)
+	c := controller.New(zkzone, Options.ListenAddr, Options.ManagerType, Options.AssignStrategy)
 
 	cfg := disk.DefaultConfig()
 	cfg.Dirs = strings.Split(Options.HintedHandoffDir, ",")
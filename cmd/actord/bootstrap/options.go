@@ -11,4 +11,8 @@ var Options struct {
 	ListenAddr       string
 	ManagerType      string
 	HintedHandoffDir string
+	WebhookWorkers   int
+	JobWorkers       int
+	AssignStrategy   string
+	AdminToken       string
 }
@@ -1,11 +1,23 @@
 package controller
 
 import (
+	"hash/fnv"
+	"math"
 	"sort"
+	"time"
 
 	"github.com/funkygao/gafka/zk"
 )
 
+const (
+	AssignSticky = "sticky"
+	AssignRange  = "range"
+
+	weightReportInterval = time.Minute * 5
+)
+
+// assignResourcesToActors hands out contiguous ranges of the sorted resources, which
+// reshuffles nearly everything on any actor or resource change.
 func assignResourcesToActors(actors zk.ActorList, resources zk.ResourceList) (decision map[string]zk.ResourceList) {
 	decision = make(map[string]zk.ResourceList)
 
@@ -43,3 +55,122 @@ func min(a, b int) int {
 	}
 	return a
 }
+
+// stickyLoadFactor bounds the load of an actor to factor times the average load.
+const stickyLoadFactor = 1.25
+
+// assignResourcesSticky assigns resources by rendezvous hashing with bounded load, each
+// actor computes the same decision independently.
+//
+// A resource prefers the actors in the order of hash(actor, resource) and is assigned to
+// the first one whose load stays within the bound, so an actor or resource change only
+// moves the resources of that actor or resource plus a few overflowed ones.
+// Resources without weight are of weight 1.
+func assignResourcesSticky(actors zk.ActorList, resources zk.ResourceList,
+	weights map[string]int) (decision map[string]zk.ResourceList) {
+	decision = make(map[string]zk.ResourceList)
+
+	if len(actors) == 0 || len(resources) == 0 {
+		return
+	}
+
+	sort.Sort(actors)
+
+	// heavier resources are placed first so that the bound is respected
+	rs := make(weightedResources, len(resources))
+	total, heaviest := 0, 0
+	for i, r := range resources {
+		w := weights[r]
+		if w < 1 {
+			w = 1
+		}
+		rs[i] = weightedResource{name: r, weight: w}
+
+		total += w
+		if w > heaviest {
+			heaviest = w
+		}
+	}
+	sort.Sort(rs)
+
+	capacity := int(math.Ceil(float64(total) * stickyLoadFactor / float64(len(actors))))
+	if capacity < heaviest {
+		capacity = heaviest
+	}
+
+	load := make(map[string]int, len(actors))
+	for _, r := range rs {
+		ranked := rankActors(actors, r.name)
+		owner := ranked[0]
+		for _, actor := range ranked {
+			if load[actor]+r.weight <= capacity {
+				owner = actor
+				break
+			}
+
+			if load[actor] < load[owner] {
+				// all actors full: fallback to the least loaded
+				owner = actor
+			}
+		}
+
+		load[owner] += r.weight
+		decision[owner] = append(decision[owner], r.name)
+	}
+
+	for _, rl := range decision {
+		sort.Sort(rl)
+	}
+	return
+}
+
+// rankActors returns the actors in the preference order of a resource.
+func rankActors(actors zk.ActorList, resource string) []string {
+	ranked := make(rankedActors, len(actors))
+	for i, actor := range actors {
+		h := fnv.New64a()
+		h.Write([]byte(actor))
+		h.Write([]byte{'/'})
+		h.Write([]byte(resource))
+		ranked[i] = rankedActor{actor: actor, score: h.Sum64()}
+	}
+	sort.Sort(ranked)
+
+	r := make([]string, len(ranked))
+	for i, a := range ranked {
+		r[i] = a.actor
+	}
+	return r
+}
+
+type weightedResource struct {
+	name   string
+	weight int
+}
+
+type weightedResources []weightedResource
+
+func (this weightedResources) Len() int      { return len(this) }
+func (this weightedResources) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this weightedResources) Less(i, j int) bool {
+	if this[i].weight != this[j].weight {
+		return this[i].weight > this[j].weight
+	}
+	return this[i].name < this[j].name
+}
+
+type rankedActor struct {
+	actor string
+	score uint64
+}
+
+type rankedActors []rankedActor
+
+func (this rankedActors) Len() int      { return len(this) }
+func (this rankedActors) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this rankedActors) Less(i, j int) bool {
+	if this[i].score != this[j].score {
+		return this[i].score > this[j].score
+	}
+	return this[i].actor < this[j].actor
+}
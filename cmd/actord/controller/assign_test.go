@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/funkygao/assert"
@@ -34,3 +35,91 @@ func TestAssignResourcesToActors_ActorMoreThanJobQueues(t *testing.T) {
 	assert.Equal(t, 0, len(decision["2"]))
 	assert.Equal(t, 1, len(decision["1"]))
 }
+
+func TestAssignResourcesSticky_Balanced(t *testing.T) {
+	var jobs zk.ResourceList
+	for i := 0; i < 100; i++ {
+		jobs = append(jobs, fmt.Sprintf("app%d.foo.v1", i))
+	}
+	actors := zk.ActorList([]string{"1", "2", "3", "4"})
+
+	decision := assignResourcesSticky(actors, jobs, nil)
+	n := 0
+	for _, actor := range actors {
+		assert.Equal(t, true, len(decision[actor]) <= 32) // ceil(100*1.25/4)
+		n += len(decision[actor])
+	}
+	assert.Equal(t, 100, n)
+
+	// the decision is independent of input order
+	reversed := zk.ActorList([]string{"4", "3", "2", "1"})
+	assert.Equal(t, decision, assignResourcesSticky(reversed, jobs, nil))
+}
+
+func TestAssignResourcesSticky_MinimalMovement(t *testing.T) {
+	var jobs zk.ResourceList
+	for i := 0; i < 100; i++ {
+		jobs = append(jobs, fmt.Sprintf("app%d.foo.v1", i))
+	}
+	actors := zk.ActorList([]string{"1", "2", "3", "4", "5"})
+	before := ownersOf(assignResourcesSticky(actors, jobs, nil))
+
+	// actor 5 gone
+	after := ownersOf(assignResourcesSticky(actors[:4], jobs, nil))
+	moved := 0
+	for job, owner := range before {
+		if owner != "5" && after[job] != owner {
+			moved++
+		}
+	}
+	assert.Equal(t, true, moved <= 10)
+
+	// a new job queue
+	after = ownersOf(assignResourcesSticky(actors, append(jobs, "app100.foo.v1"), nil))
+	moved = 0
+	for job, owner := range before {
+		if after[job] != owner {
+			moved++
+		}
+	}
+	assert.Equal(t, true, moved <= 2)
+}
+
+func TestAssignResourcesSticky_Weighted(t *testing.T) {
+	jobs := zk.ResourceList([]string{"a", "b", "c", "d", "e", "f"})
+	actors := zk.ActorList([]string{"1", "2"})
+	weights := map[string]int{"a": 10, "b": 10}
+
+	decision := assignResourcesSticky(actors, jobs, weights)
+	load := make(map[string]int)
+	for actor, rl := range decision {
+		for _, r := range rl {
+			if w, present := weights[r]; present {
+				load[actor] += w
+			} else {
+				load[actor]++
+			}
+		}
+	}
+
+	// a and b are never on the same actor: capacity ceil(24*1.25/2)=15
+	assert.Equal(t, true, load["1"] <= 15)
+	assert.Equal(t, true, load["2"] <= 15)
+}
+
+func TestJobQueueWeight(t *testing.T) {
+	assert.Equal(t, 1, jobQueueWeight(0))
+	assert.Equal(t, 2, jobQueueWeight(1))
+	assert.Equal(t, 2, jobQueueWeight(2))
+	assert.Equal(t, 11, jobQueueWeight(1500))
+}
+
+func ownersOf(decision map[string]zk.ResourceList) map[string]string {
+	r := make(map[string]string)
+	for actor, rl := range decision {
+		for _, res := range rl {
+			r[res] = actor
+		}
+	}
+	return r
+}
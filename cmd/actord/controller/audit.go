@@ -1,18 +1,25 @@
 package controller
 
 import (
+	"bytes"
+	"io"
 	"os"
 
 	log "github.com/funkygao/log4go"
 )
 
+const (
+	auditLogFile   = "audit/actord.log"
+	auditTailBytes = 4 << 20 // max bytes read from the tail of audit log
+)
+
 func (this *controller) setupAuditor() {
 	this.auditor = log.NewDefaultLogger(log.TRACE)
 	this.auditor.DeleteFilter("stdout")
 
 	_ = os.Mkdir("audit", os.ModePerm)
 	rotateEnabled, discardWhenDiskFull := true, false
-	filer := log.NewFileLogWriter("audit/actord.log", rotateEnabled, discardWhenDiskFull, 0644)
+	filer := log.NewFileLogWriter(auditLogFile, rotateEnabled, discardWhenDiskFull, 0644)
 	if filer == nil {
 		panic("failed to open audit log")
 	}
@@ -21,3 +28,54 @@ func (this *controller) setupAuditor() {
 	filer.SetRotateDaily(true)
 	this.auditor.AddFilter("file", log.TRACE, filer)
 }
+
+// tailAuditLog returns the last n audit log entries of today that contain q.
+func tailAuditLog(n int, q string) ([]string, error) {
+	f, err := os.Open(auditLogFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := fi.Size() - auditTailBytes
+	if offset < 0 {
+		offset = 0
+	}
+	data := make([]byte, fi.Size()-offset)
+	if _, err = f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if offset > 0 {
+		// skip the partial first line
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	return tailLines(data, n, q), nil
+}
+
+// tailLines returns the last n non-empty lines of data that contain q, in order.
+func tailLines(data []byte, n int, q string) []string {
+	var r []string
+	lines := bytes.Split(data, []byte{'\n'})
+	for i := len(lines) - 1; i >= 0 && len(r) < n; i-- {
+		line := bytes.TrimRight(lines[i], "\r")
+		if len(line) == 0 || !bytes.Contains(line, []byte(q)) {
+			continue
+		}
+
+		r = append(r, string(line))
+	}
+
+	// reverse into file order
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return r
+}
@@ -0,0 +1,15 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestTailLines(t *testing.T) {
+	data := []byte("a1\nb2\r\na3\n\nb4\na5\n")
+	assert.Equal(t, []string{"b4", "a5"}, tailLines(data, 2, ""))
+	assert.Equal(t, []string{"a1", "a3", "a5"}, tailLines(data, 10, "a"))
+	assert.Equal(t, []string{"b2", "b4"}, tailLines(data, 10, "b"))
+	assert.Equal(t, 0, len(tailLines(data, 10, "c")))
+}
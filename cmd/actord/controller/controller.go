@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/funkygao/fae/config"
 	"github.com/funkygao/fae/servant/mysql"
 	"github.com/funkygao/gafka"
+	"github.com/funkygao/gafka/cmd/actord/executor"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	mdummy "github.com/funkygao/gafka/cmd/kateway/manager/dummy"
 	mmysql "github.com/funkygao/gafka/cmd/kateway/manager/mysql"
@@ -41,9 +44,20 @@ type controller struct {
 
 	ident   string // cache
 	shortId string // cache
+
+	webhookExecutorsLock sync.RWMutex
+	webhookExecutors     map[string]*executor.WebhookExecutor // key is topic
+
+	jobExecutorsLock sync.RWMutex
+	jobExecutors     map[string]*executor.JobExecutor // key is job queue
+
+	assignStrategy string
+
+	rebalanceJobs, rebalanceWebhooks chan struct{}
+	resigned                         int32 // atomic
 }
 
-func New(zkzone *zk.ZkZone, listenAddr string, managerType string) Controller {
+func New(zkzone *zk.ZkZone, listenAddr string, managerType string, assignStrategy string) Controller {
 	// mysql cluster config
 	b, err := zkzone.KatewayJobClusterConfig()
 	if err != nil {
@@ -55,12 +69,18 @@ func New(zkzone *zk.ZkZone, listenAddr string, managerType string) Controller {
 	}
 
 	this := &controller{
-		quiting:      make(chan struct{}),
-		orchestrator: zkzone.NewOrchestrator(),
-		mc:           mysql.New(mcc),
-		ListenAddr:   listenAddr,
-		Version:      gafka.BuildId,
+		quiting:        make(chan struct{}),
+		orchestrator:   zkzone.NewOrchestrator(),
+		mc:             mysql.New(mcc),
+		ListenAddr:     listenAddr,
+		Version:        gafka.BuildId,
+		assignStrategy: assignStrategy,
+
+		rebalanceJobs:     make(chan struct{}, 1),
+		rebalanceWebhooks: make(chan struct{}, 1),
 	}
+	this.webhookExecutors = make(map[string]*executor.WebhookExecutor)
+	this.jobExecutors = make(map[string]*executor.JobExecutor)
 	this.ident, err = this.generateIdent()
 	if err != nil {
 		panic(err)
@@ -71,6 +91,12 @@ func New(zkzone *zk.ZkZone, listenAddr string, managerType string) Controller {
 	this.shortId = fmt.Sprintf("%s:%s", p[0], this.ident[strings.LastIndexByte(this.ident, '-')+1:])
 	this.setupAuditor()
 
+	switch assignStrategy {
+	case AssignSticky, AssignRange:
+	default:
+		panic("unknown assign strategy: " + assignStrategy)
+	}
+
 	switch managerType {
 	case "mysql":
 		cf := mmysql.DefaultConfig(zkzone.Name())
@@ -102,6 +128,10 @@ func (this *controller) RunForever() (err error) {
 
 	go this.runWebServer()
 
+	if this.assignStrategy == AssignSticky {
+		go this.reportJobQueueWeights()
+	}
+
 	jobDispatchQuit := make(chan struct{})
 	go this.dispatchJobQueues(jobDispatchQuit)
 
@@ -122,6 +152,68 @@ func (this *controller) RunForever() (err error) {
 	return
 }
 
+func (this *controller) assign(actors zk.ActorList, resources zk.ResourceList,
+	weights map[string]int) map[string]zk.ResourceList {
+	if this.assignStrategy == AssignRange {
+		return assignResourcesToActors(actors, resources)
+	}
+
+	return assignResourcesSticky(actors, resources, weights)
+}
+
+// Rebalance forces the rebalance of job queues and webhooks, executors that quit by
+// themselves are restarted.
+func (this *controller) Rebalance() {
+	for _, ch := range []chan struct{}{this.rebalanceJobs, this.rebalanceWebhooks} {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// already pending
+		}
+	}
+}
+
+// Resign gracefully leaves the orchestrator: the actor stops all its executors on the
+// following rebalance and the other actors take over, it is kept in standby mode.
+func (this *controller) Resign() error {
+	atomic.StoreInt32(&this.resigned, 1)
+	return this.orchestrator.ResignActor(this.Id())
+}
+
+// Rejoin registers the resigned actor back to the orchestrator.
+func (this *controller) Rejoin() error {
+	atomic.StoreInt32(&this.resigned, 0)
+	return this.orchestrator.RegisterActor(this.Id(), this.Bytes())
+}
+
+func (this *controller) Resigned() bool {
+	return atomic.LoadInt32(&this.resigned) == 1
+}
+
+// claimResource keeps claiming the owner of a resource until stopped: the previous owner
+// releases it once its executor is stopped by the same rebalance.
+func (this *controller) claimResource(root, resource string, stopper <-chan struct{}) bool {
+	for retries := 0; ; retries++ {
+		log.Trace("claiming owner of %s #%d", resource, retries)
+		err := this.orchestrator.ClaimResource(this.Id(), root, resource)
+		if err == nil {
+			log.Info("claimed owner of %s", resource)
+			return true
+		} else if err != zk.ErrClaimedByOthers {
+			log.Error("%s #%d", err, retries)
+			return false
+		}
+
+		log.Warn("%s %s #%d", resource, err, retries)
+		select {
+		case <-stopper:
+			return false
+
+		case <-time.After(time.Second):
+		}
+	}
+}
+
 func (this *controller) Stop() {
 	close(this.quiting)
 }
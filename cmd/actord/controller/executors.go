@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+)
+
+type executorHandle struct {
+	stopper chan struct{}
+	done    chan struct{}
+}
+
+func (this *executorHandle) alive() bool {
+	select {
+	case <-this.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// executorSet tracks the running executors of an actor by resource, so that only the
+// executors of moved resources are restarted on rebalance.
+type executorSet struct {
+	kind    string
+	invoke  func(resource string, stopper <-chan struct{})
+	running map[string]*executorHandle
+}
+
+func newExecutorSet(kind string, invoke func(resource string, stopper <-chan struct{})) *executorSet {
+	return &executorSet{
+		kind:    kind,
+		invoke:  invoke,
+		running: make(map[string]*executorHandle),
+	}
+}
+
+// Rebalance stops the executors of the resources no longer assigned and awaits them
+// to release the resources, then starts the executors of the newly assigned resources.
+// Executors that quit by themselves, e,g. failed to claim the resource, are restarted.
+func (this *executorSet) Rebalance(assigned zk.ResourceList) (stopped, started int) {
+	mine := make(map[string]struct{}, len(assigned))
+	for _, r := range assigned {
+		mine[r] = struct{}{}
+	}
+
+	var stopping []*executorHandle
+	for r, h := range this.running {
+		if _, present := mine[r]; present && h.alive() {
+			continue
+		}
+
+		log.Trace("stopping %s executor for %s", this.kind, r)
+		close(h.stopper)
+		stopping = append(stopping, h)
+		delete(this.running, r)
+	}
+	for _, h := range stopping {
+		<-h.done
+	}
+
+	for _, r := range assigned {
+		if _, present := this.running[r]; present {
+			continue
+		}
+
+		log.Trace("invoking %s executor for %s", this.kind, r)
+		h := &executorHandle{stopper: make(chan struct{}), done: make(chan struct{})}
+		this.running[r] = h
+		go func(resource string) {
+			defer close(h.done)
+			this.invoke(resource, h.stopper)
+		}(r)
+		started++
+	}
+
+	return len(stopping), started
+}
+
+// StopAll stops all the executors and awaits them to quit.
+func (this *executorSet) StopAll() {
+	this.Rebalance(nil)
+}
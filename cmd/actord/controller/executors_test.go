@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/zk"
+)
+
+func TestExecutorSetRebalance(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		invoked = make(map[string]int)
+	)
+	set := newExecutorSet("test", func(resource string, stopper <-chan struct{}) {
+		mu.Lock()
+		invoked[resource]++
+		mu.Unlock()
+
+		if resource == "quitter" {
+			// e,g. claimed by others
+			return
+		}
+		<-stopper
+	})
+
+	stopped, started := set.Rebalance(zk.ResourceList{"a", "b", "quitter"})
+	assert.Equal(t, 0, stopped)
+	assert.Equal(t, 3, started)
+
+	<-set.running["quitter"].done
+	stopped, started = set.Rebalance(zk.ResourceList{"b", "c", "quitter"})
+	assert.Equal(t, 2, stopped) // a moved away, quitter died
+	assert.Equal(t, 2, started) // c moved in, quitter restarted
+
+	set.StopAll()
+	assert.Equal(t, 0, len(set.running))
+
+	mu.Lock()
+	assert.Equal(t, 1, invoked["a"])
+	assert.Equal(t, 1, invoked["b"])
+	assert.Equal(t, 1, invoked["c"])
+	assert.Equal(t, 2, invoked["quitter"])
+	mu.Unlock()
+}
@@ -1,13 +1,46 @@
 package controller
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/funkygao/gafka/cmd/actord/executor"
 	log "github.com/funkygao/log4go"
 )
 
+// AdminToken is the shared token required in the X-Admin-Token header by the mutating
+// admin APIs. If empty, they are only allowed from loopback.
+var AdminToken string
+
+const (
+	drainDefaultTimeout = time.Second * 30
+	drainCheckInterval  = time.Millisecond * 200
+)
+
+// executorControl is the admin operations of a job or webhook executor.
+type executorControl interface {
+	Pause()
+	Resume()
+	Paused() bool
+	Inflights() int64
+}
+
 func (this *controller) runWebServer() {
 	http.HandleFunc("/v1/status", this.statusHandler)
+	http.HandleFunc("/v1/webhooks", this.webhooksHandler)
+
+	// admin api
+	http.HandleFunc("/v1/executors", this.executorsHandler)
+	http.HandleFunc("/v1/executors/", this.executorControlHandler)
+	http.HandleFunc("/v1/rebalance", this.rebalanceHandler)
+	http.HandleFunc("/v1/resign", this.resignHandler)
+	http.HandleFunc("/v1/rejoin", this.rejoinHandler)
+	http.HandleFunc("/v1/audit", this.auditHandler)
 	log.Info("web server on %s ready", this.ListenAddr)
 	err := http.ListenAndServe(this.ListenAddr, nil)
 	if err != nil {
@@ -21,3 +54,225 @@ func (this *controller) statusHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Write(this.Bytes())
 }
+
+// webhooksHandler shows the endpoints circuit breaker state of webhooks owned by this actor.
+func (this *controller) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	w.Header().Set("Server", "actord")
+
+	this.webhookExecutorsLock.RLock()
+	circuits := make(map[string]map[string]bool, len(this.webhookExecutors))
+	for topic, exe := range this.webhookExecutors {
+		circuits[topic] = exe.CircuitsOpen()
+	}
+	this.webhookExecutorsLock.RUnlock()
+
+	b, _ := json.Marshal(circuits)
+	w.Write(b)
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	w.Header().Set("Server", "actord")
+
+	b, _ := json.Marshal(v)
+	w.Write(b)
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	return true
+}
+
+// requireAdmin checks the mutating admin API request is a POST from an admin.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !requirePost(w, r) {
+		return false
+	}
+
+	if AdminToken != "" {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(AdminToken)) != 1 {
+			log.Warn("admin %s %s denied from %s: invalid token", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return false
+		}
+
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		log.Warn("admin %s %s denied from %s: not loopback", r.Method, r.URL.Path, r.RemoteAddr)
+		http.Error(w, "admin api only allowed from loopback without -admintoken", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// executorsHandler shows the stats of the job queues and webhooks assigned to this actor.
+// GET /v1/executors
+func (this *controller) executorsHandler(w http.ResponseWriter, r *http.Request) {
+	var out struct {
+		Resigned bool                                     `json:"resigned"`
+		Jobs     map[string]executor.JobExecutorStats     `json:"jobs"`
+		Webhooks map[string]executor.WebhookExecutorStats `json:"webhooks"`
+	}
+	out.Resigned = this.Resigned()
+
+	this.jobExecutorsLock.RLock()
+	out.Jobs = make(map[string]executor.JobExecutorStats, len(this.jobExecutors))
+	for jobQueue, exe := range this.jobExecutors {
+		out.Jobs[jobQueue] = exe.Stats()
+	}
+	this.jobExecutorsLock.RUnlock()
+
+	this.webhookExecutorsLock.RLock()
+	out.Webhooks = make(map[string]executor.WebhookExecutorStats, len(this.webhookExecutors))
+	for topic, exe := range this.webhookExecutors {
+		out.Webhooks[topic] = exe.Stats()
+	}
+	this.webhookExecutorsLock.RUnlock()
+
+	writeJson(w, out)
+}
+
+func (this *controller) lookupExecutor(kind, resource string) (executorControl, bool) {
+	switch kind {
+	case "job":
+		this.jobExecutorsLock.RLock()
+		defer this.jobExecutorsLock.RUnlock()
+		exe, present := this.jobExecutors[resource]
+		return exe, present
+
+	case "webhook":
+		this.webhookExecutorsLock.RLock()
+		defer this.webhookExecutorsLock.RUnlock()
+		exe, present := this.webhookExecutors[resource]
+		return exe, present
+	}
+
+	return nil, false
+}
+
+// executorControlHandler pauses, resumes or drains an executor. Drain pauses the executor
+// and awaits its in-flight work done.
+// The paused state is kept in memory only: it is lost when the executor is restarted,
+// e.g. its resource is moved to another actor by rebalance or the actor restarts.
+// POST /v1/executors/<job|webhook>/:resource/<pause|resume|drain>?timeout=30s
+func (this *controller) executorControlHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/executors/"), "/")
+	if len(parts) != 3 {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	kind, resource, action := parts[0], parts[1], parts[2]
+	exe, present := this.lookupExecutor(kind, resource)
+	if !present {
+		http.Error(w, kind+" executor not found: "+resource, http.StatusNotFound)
+		return
+	}
+
+	log.Info("admin %s %s %s from %s", action, kind, resource, r.RemoteAddr)
+	this.auditor.Info("admin %s %s %s from %s", action, kind, resource, r.RemoteAddr)
+
+	switch action {
+	case "pause":
+		exe.Pause()
+
+	case "resume":
+		exe.Resume()
+
+	case "drain":
+		timeout, err := time.ParseDuration(r.URL.Query().Get("timeout"))
+		if err != nil || timeout <= 0 {
+			timeout = drainDefaultTimeout
+		}
+
+		exe.Pause()
+		deadline := time.Now().Add(timeout)
+		for exe.Inflights() > 0 && time.Now().Before(deadline) {
+			time.Sleep(drainCheckInterval)
+		}
+
+	default:
+		http.Error(w, "invalid action: "+action, http.StatusBadRequest)
+		return
+	}
+
+	writeJson(w, map[string]interface{}{
+		"paused":    exe.Paused(),
+		"inflights": exe.Inflights(),
+	})
+}
+
+// POST /v1/rebalance
+func (this *controller) rebalanceHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	log.Info("admin rebalance from %s", r.RemoteAddr)
+	this.auditor.Info("admin rebalance from %s", r.RemoteAddr)
+	this.Rebalance()
+	writeJson(w, map[string]bool{"ok": true})
+}
+
+// POST /v1/resign
+func (this *controller) resignHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	log.Warn("admin resign from %s", r.RemoteAddr)
+	this.auditor.Warn("admin resign from %s", r.RemoteAddr)
+	if err := this.Resign(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, map[string]bool{"ok": true})
+}
+
+// POST /v1/rejoin
+func (this *controller) rejoinHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	log.Info("admin rejoin from %s", r.RemoteAddr)
+	this.auditor.Info("admin rejoin from %s", r.RemoteAddr)
+	if err := this.Rejoin(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, map[string]bool{"ok": true})
+}
+
+// auditHandler shows the recent audit log entries.
+// GET /v1/audit?n=100&q=xx
+func (this *controller) auditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	n, _ := strconv.Atoi(q.Get("n"))
+	if n <= 0 || n > 10000 {
+		n = 100
+	}
+
+	lines, err := tailAuditLog(n, q.Get("q"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, lines)
+}
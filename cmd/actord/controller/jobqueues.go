@@ -1,7 +1,7 @@
 package controller
 
 import (
-	"sync"
+	"math"
 	"time"
 
 	"github.com/funkygao/gafka/cmd/actord/executor"
@@ -12,6 +12,8 @@ import (
 func (this *controller) dispatchJobQueues(quit chan<- struct{}) {
 	defer close(quit)
 
+	executors := newExecutorSet("job", this.invokeJobExexutor)
+
 REBALANCE:
 	for {
 		// each loop is a new rebalance process
@@ -38,82 +40,63 @@ REBALANCE:
 		}
 		this.ActorN.Set(int32(len(actors)))
 
+		weights, weightChanges, err := this.orchestrator.WatchResourceWeights(zk.PubsubJobQueueWeights)
+		if err != nil {
+			log.Error("watch job queue weights: %s", err)
+			time.Sleep(time.Second)
+			continue REBALANCE
+		}
+
 		log.Info("deciding: found %d job queues, %d actors", len(jobQueues), len(actors))
-		decision := assignResourcesToActors(actors, jobQueues)
+		decision := this.assign(actors, jobQueues, weights)
 		myJobQueues := decision[this.Id()]
 
 		if len(myJobQueues) == 0 {
 			// standby mode
 			log.Warn("decided: no job assignment, awaiting rebalance...")
 		} else {
-			log.Info("decided: claiming %d/%d job queues", len(jobQueues), len(myJobQueues))
+			log.Info("decided: claiming %d/%d job queues", len(myJobQueues), len(jobQueues))
 		}
 
-		var (
-			wg              sync.WaitGroup
-			executorStopper = make(chan struct{})
-		)
-		for _, jobQueue := range myJobQueues {
-			wg.Add(1)
-			this.JobExecutorN.Add(1)
-			log.Trace("invoking executor for %s", jobQueue)
-			go this.invokeJobExexutor(jobQueue, &wg, executorStopper)
-		}
+		// only the executors of moved job queues are restarted
+		stopped, started := executors.Rebalance(myJobQueues)
+		log.Info("rebalanced job queues: %d stopped, %d started", stopped, started)
 
 		select {
 		case <-this.quiting:
-			close(executorStopper)
-			wg.Wait()
 			break REBALANCE
 
 		case <-jobQueueChanges:
 			log.Info("rebalance due to job queue changes")
 
-			close(executorStopper)
-			wg.Wait()
-
 		case <-actorChanges:
 			log.Info("rebalance due to actor changes")
 
 			stillAlive, err := this.orchestrator.ActorRegistered(this.Id())
 			if err != nil {
 				log.Error(err)
-			} else if !stillAlive {
+			} else if !stillAlive && !this.Resigned() {
 				this.orchestrator.RegisterActor(this.Id(), this.Bytes())
 			}
 
-			close(executorStopper)
-			wg.Wait()
+		case <-weightChanges:
+			log.Info("rebalance due to job queue weight changes")
+
+		case <-this.rebalanceJobs:
+			log.Info("rebalance forced")
 		}
 	}
 
+	executors.StopAll()
 	log.Info("controller[%s] dispatchJobQueues stopped", this.Id())
 	return
 }
 
-func (this *controller) invokeJobExexutor(jobQueue string, wg *sync.WaitGroup, stopper <-chan struct{}) {
-	defer func() {
-		wg.Done()
-		this.JobExecutorN.Add(-1)
-	}()
-
-	var err error
-	for retries := 0; retries < 3; retries++ {
-		log.Trace("claiming owner of %s #%d", jobQueue, retries)
-		if err = this.orchestrator.ClaimResource(this.Id(), zk.PubsubJobQueueOwners, jobQueue); err == nil {
-			log.Info("claimed owner of %s", jobQueue)
-			break
-		} else if err == zk.ErrClaimedByOthers {
-			log.Error("%s #%d", err, retries)
-			time.Sleep(time.Second)
-		} else {
-			log.Error("%s #%d", err, retries)
-			return
-		}
-	}
+func (this *controller) invokeJobExexutor(jobQueue string, stopper <-chan struct{}) {
+	this.JobExecutorN.Add(1)
+	defer this.JobExecutorN.Add(-1)
 
-	if err != nil {
-		// still err(ErrClaimedByOthers) encountered after max retries
+	if !this.claimResource(zk.PubsubJobQueueOwners, jobQueue, stopper) {
 		return
 	}
 
@@ -128,6 +111,85 @@ func (this *controller) invokeJobExexutor(jobQueue string, wg *sync.WaitGroup, s
 	}
 
 	exe := executor.NewJobExecutor(this.shortId, cluster, jobQueue, this.mc, stopper, this.auditor)
+	this.registerJobExecutor(jobQueue, exe)
 	exe.Run()
+	this.unregisterJobExecutor(jobQueue)
+
+}
+
+func (this *controller) registerJobExecutor(jobQueue string, exe *executor.JobExecutor) {
+	this.jobExecutorsLock.Lock()
+	this.jobExecutors[jobQueue] = exe
+	this.jobExecutorsLock.Unlock()
+}
+
+func (this *controller) unregisterJobExecutor(jobQueue string) {
+	this.jobExecutorsLock.Lock()
+	delete(this.jobExecutors, jobQueue)
+	this.jobExecutorsLock.Unlock()
+}
+
+// reportJobQueueWeights periodically saves the weight of owned job queues derived from
+// their observed fire rate into the weights snapshot, whose change triggers rebalance.
+func (this *controller) reportJobQueueWeights() {
+	var (
+		ticker    = time.NewTicker(weightReportInterval)
+		lastFired = make(map[string]int64)
+		reported  = make(map[string]int)
+	)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.quiting:
+			return
+
+		case <-ticker.C:
+			this.jobExecutorsLock.RLock()
+			fired := make(map[string]int64, len(this.jobExecutors))
+			for jobQueue, exe := range this.jobExecutors {
+				fired[jobQueue] = exe.FiredN()
+			}
+			this.jobExecutorsLock.RUnlock()
+
+			changed := make(map[string]int)
+			for jobQueue, n := range fired {
+				last, present := lastFired[jobQueue]
+				lastFired[jobQueue] = n
+				if !present || n < last {
+					// first observation or executor restarted
+					continue
+				}
+
+				weight := jobQueueWeight(float64(n-last) / weightReportInterval.Minutes())
+				if reported[jobQueue] != weight {
+					changed[jobQueue] = weight
+				}
+			}
+
+			if len(changed) > 0 {
+				if err := this.orchestrator.UpdateResourceWeights(zk.PubsubJobQueueWeights, changed); err != nil {
+					log.Error("job queue weights: %v", err)
+				} else {
+					for jobQueue, weight := range changed {
+						reported[jobQueue] = weight
+					}
+					log.Trace("job queue weights %+v", changed)
+				}
+			}
+
+			for jobQueue := range lastFired {
+				if _, present := fired[jobQueue]; !present {
+					delete(lastFired, jobQueue)
+					delete(reported, jobQueue)
+				}
+			}
+		}
+	}
+}
 
+// jobQueueWeight quantizes jobs per minute in log scale, so that the weights are stable
+// across actors that read them at different times.
+func jobQueueWeight(jobsPerMinute float64) int {
+	return 1 + int(math.Log2(1+jobsPerMinute))
 }
@@ -43,7 +43,7 @@ func (this *controller) watchZk() {
 				if err != nil {
 					log.Error("registry: %s", err)
 					this.orchestrator.CallSOS(fmt.Sprintf("actord[%s]", this.Id()), "zk session expired")
-				} else if !registered {
+				} else if !registered && !this.Resigned() {
 					if err = this.orchestrator.RegisterActor(this.Id(), this.Bytes()); err != nil {
 						log.Error("registry: %s", err)
 					} else {
@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"sync"
 	"time"
 
 	"github.com/funkygao/gafka/cmd/actord/executor"
@@ -13,6 +12,8 @@ import (
 func (this *controller) dispatchWebhooks(quit chan<- struct{}) {
 	defer close(quit)
 
+	executors := newExecutorSet("webhook", this.invokeWebhookExecutor)
+
 REBALANCE:
 	for {
 		// each loop is a new rebalance process
@@ -60,100 +61,111 @@ REBALANCE:
 		this.WebhookN.Set(int32(len(activeHooks)))
 
 		log.Info("deciding: found %d webhooks, %d actors", len(activeHooks), len(actors))
-		decision := assignResourcesToActors(actors, activeHooks)
+		decision := this.assign(actors, activeHooks, nil)
 		myWebhooks := decision[this.Id()]
 
 		if len(myWebhooks) == 0 {
 			// standby mode
 			log.Warn("decided: no webhook assignment, awaiting rebalance...")
 		} else {
-			log.Info("decided: claiming %d/%d webhooks", len(activeHooks), len(myWebhooks))
+			log.Info("decided: claiming %d/%d webhooks", len(myWebhooks), len(activeHooks))
 		}
 
-		var (
-			wg               sync.WaitGroup
-			executorsStopper = make(chan struct{})
-		)
-		for _, topic := range myWebhooks {
-			wg.Add(1)
-			this.WebhookExecutorN.Add(1)
-			log.Trace("invoking executor for %s", topic)
-			go this.invokeWebhookExecutor(topic, &wg, executorsStopper)
-		}
+		// only the executors of moved webhooks are restarted
+		stopped, started := executors.Rebalance(myWebhooks)
+		log.Info("rebalanced webhooks: %d stopped, %d started", stopped, started)
 
 		select {
 		case <-this.quiting:
-			close(executorsStopper)
-			wg.Wait()
 			break REBALANCE
 
 		case <-offChanges:
 			log.Info("rebalance due to disabled webhooks changes")
 
-			close(executorsStopper)
-			wg.Wait()
-
 		case <-webhookChanges:
 			log.Info("rebalance due to webhooks changes")
 
-			close(executorsStopper)
-			wg.Wait()
-
 		case <-actorChanges:
 			log.Info("rebalance due to actor changes")
 
 			stillAlive, err := this.orchestrator.ActorRegistered(this.Id())
 			if err != nil {
 				log.Error(err)
-			} else if !stillAlive {
+			} else if !stillAlive && !this.Resigned() {
 				this.orchestrator.RegisterActor(this.Id(), this.Bytes())
 			}
 
-			close(executorsStopper)
-			wg.Wait()
+		case <-this.rebalanceWebhooks:
+			log.Info("rebalance forced")
 		}
 	}
 
+	executors.StopAll()
 	log.Info("controller[%s] dispatchWebhooks stopped", this.Id())
 	return
 }
 
-func (this *controller) invokeWebhookExecutor(topic string, wg *sync.WaitGroup, stopper <-chan struct{}) {
-	defer func() {
-		wg.Done()
-		this.WebhookExecutorN.Add(-1)
-	}()
+func (this *controller) invokeWebhookExecutor(topic string, stopper <-chan struct{}) {
+	this.WebhookExecutorN.Add(1)
+	defer this.WebhookExecutorN.Add(-1)
 
-	hook, err := this.orchestrator.WebhookInfo(topic)
-	if err != nil {
-		log.Error("%s: %s", topic, err)
+	if !this.claimResource(zk.PubsubWebhookOwners, topic, stopper) {
 		return
 	}
 
-	for retries := 0; retries < 3; retries++ {
-		log.Trace("claiming owner of %s #%d", topic, retries)
-		if err = this.orchestrator.ClaimResource(this.Id(), zk.PubsubWebhookOwners, topic); err == nil {
-			log.Info("claimed owner of %s", topic)
-			break
-		} else if err == zk.ErrClaimedByOthers {
-			log.Error("%s #%d", err, retries)
-			time.Sleep(time.Second)
-		} else {
-			log.Error("%s #%d", err, retries)
+	defer func(topic string) {
+		this.orchestrator.ReleaseResource(this.Id(), zk.PubsubWebhookOwners, topic)
+		log.Info("de-claimed owner of %s", topic)
+	}(topic)
+
+	for {
+		// the executor restarts on webhook changes, e,g. endpoints added or removed
+		hook, hookChanges, err := this.orchestrator.WatchWebhook(topic)
+		if err != nil {
+			log.Error("%s: %s", topic, err)
 			return
 		}
-	}
 
-	if err != nil {
-		// still err(ErrClaimedByOthers) encountered after max retries
-		return
+		exeStopper, exeDone := make(chan struct{}), make(chan struct{})
+		exe := executor.NewWebhookExecutor(this.shortId, hook.Cluster, topic, hook.Endpoints, exeStopper, this.auditor)
+		this.registerWebhookExecutor(topic, exe)
+		go func() {
+			exe.Run()
+			close(exeDone)
+		}()
+
+		select {
+		case <-stopper:
+		case <-hookChanges:
+		case <-exeDone:
+			// executor quits by itself, e,g. empty endpoints: await changes
+			select {
+			case <-stopper:
+			case <-hookChanges:
+			}
+		}
+
+		close(exeStopper)
+		<-exeDone
+		this.unregisterWebhookExecutor(topic)
+
+		select {
+		case <-stopper:
+			return
+		default:
+			log.Info("%s webhook changed, restarting executor", topic)
+		}
 	}
+}
 
-	defer func(topic string) {
-		this.orchestrator.ReleaseResource(this.Id(), zk.PubsubWebhookOwners, topic)
-		log.Info("de-claimed owner of %s", topic)
-	}(topic)
+func (this *controller) registerWebhookExecutor(topic string, exe *executor.WebhookExecutor) {
+	this.webhookExecutorsLock.Lock()
+	this.webhookExecutors[topic] = exe
+	this.webhookExecutorsLock.Unlock()
+}
 
-	exe := executor.NewWebhookExecutor(this.shortId, hook.Cluster, topic, hook.Endpoints, stopper, this.auditor)
-	exe.Run()
+func (this *controller) unregisterWebhookExecutor(topic string) {
+	this.webhookExecutorsLock.Lock()
+	delete(this.webhookExecutors, topic)
+	this.webhookExecutorsLock.Unlock()
 }
@@ -0,0 +1,77 @@
+package executor
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// keyedDispatcher runs tasks on a fixed number of workers: tasks of the same hash are
+// run by the same worker in dispatch order while different hashes run in parallel.
+//
+// Queued tasks are dropped on stop, their messages are not committed and will be
+// delivered again.
+type keyedDispatcher struct {
+	queues  []chan func()
+	stopper <-chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newKeyedDispatcher(workers, backlog int, stopper <-chan struct{}) *keyedDispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	this := &keyedDispatcher{
+		queues:  make([]chan func(), workers),
+		stopper: stopper,
+	}
+	for i := range this.queues {
+		this.queues[i] = make(chan func(), backlog)
+		this.wg.Add(1)
+		go this.worker(this.queues[i])
+	}
+
+	return this
+}
+
+func (this *keyedDispatcher) worker(queue chan func()) {
+	defer this.wg.Done()
+
+	for {
+		select {
+		case <-this.stopper:
+			return
+
+		case task := <-queue:
+			task()
+		}
+	}
+}
+
+// Dispatch blocks if the worker of the hash is busy, it returns false if stopped.
+func (this *keyedDispatcher) Dispatch(hash uint32, task func()) bool {
+	select {
+	case <-this.stopper:
+		return false
+	default:
+	}
+
+	select {
+	case this.queues[hash%uint32(len(this.queues))] <- task:
+		return true
+
+	case <-this.stopper:
+		return false
+	}
+}
+
+// Wait awaits all the workers to quit after stop.
+func (this *keyedDispatcher) Wait() {
+	this.wg.Wait()
+}
+
+func keyHash(key []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32()
+}
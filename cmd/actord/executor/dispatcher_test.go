@@ -0,0 +1,43 @@
+package executor
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestKeyedDispatcherOrderPerKey(t *testing.T) {
+	stopper := make(chan struct{})
+	d := newKeyedDispatcher(4, 10, stopper)
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		got = make(map[string][]int)
+	)
+	keys := []string{"a", "b", "c", "d", "e"}
+	for i := 0; i < 100; i++ {
+		key, seq := keys[i%len(keys)], i
+		wg.Add(1)
+		assert.Equal(t, true, d.Dispatch(keyHash([]byte(key)), func() {
+			mu.Lock()
+			got[key] = append(got[key], seq)
+			mu.Unlock()
+			wg.Done()
+		}))
+	}
+	wg.Wait()
+
+	for _, key := range keys {
+		seqs := got[key]
+		assert.Equal(t, 20, len(seqs))
+		for i := 1; i < len(seqs); i++ {
+			assert.Equal(t, true, seqs[i] > seqs[i-1])
+		}
+	}
+
+	close(stopper)
+	d.Wait()
+	assert.Equal(t, false, d.Dispatch(0, func() {}))
+}
@@ -2,7 +2,9 @@ package executor
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/funkygao/fae/servant/mysql"
@@ -15,19 +17,50 @@ import (
 )
 
 const (
-	LagWarnThreshold   = 3  // in sec
-	HandlerConcurrentN = 10 // FIXME breaks the delivery order guarantee
+	LagWarnThreshold = 3 // in sec
+
+	WheelTick      = 100 // in ms
+	WheelSize      = 60
+	PreloadWindow  = time.Minute
+	PreloadEvery   = time.Second * 10
+	PreloadBatch   = 10000
+	ProbeEvery     = time.Second
+	ProbeBatch     = 1000
+	FireBatch      = 100
+	dueJobsBacklog = 200
 )
 
-// JobExecutor polls a single JobQueue and handle each Job.
+// JobWorkers is the max concurrent fire batches of a job queue, the jobs are hashed by job id
+// so that the activations of a recurring job are always fired by the same worker in due time order.
+var JobWorkers = 10
+
+// JobExecutor preloads the upcoming jobs of a single JobQueue into a timing wheel
+// and handle each due Job in due time order.
+//
+// MySQL is the durable store only: the due_time index is scanned once per PreloadEvery
+// for jobs within PreloadWindow, and newly added jobs are probed through primary key.
 type JobExecutor struct {
 	parentId       string // controller short id
 	cluster, topic string
 	mc             *mysql.MysqlCluster
 	stopper        <-chan struct{}
-	dueJobs        chan job.JobItem
+	dueJobs        chan []job.JobItem
+	rescheduled    chan job.JobItem // next activation of recurring jobs
 	auditor        log.Logger
 
+	wheel   *timingWheel
+	loaded  map[int64]int64 // job id -> due time in the wheel
+	horizon int64           // jobs due before horizon are all loaded, in sec
+	probeId int64           // max job id seen by probe
+
+	// stats, atomic
+	firedN    int64
+	failedN   int64
+	dueLag    int64 // in sec, of the last due batch
+	wheelN    int64
+	inflights int64 // due jobs not yet fired
+	paused    int32
+
 	// cached values
 	appid string
 	aid   int
@@ -38,19 +71,21 @@ type JobExecutor struct {
 func NewJobExecutor(parentId, cluster, topic string, mc *mysql.MysqlCluster,
 	stopper <-chan struct{}, auditor log.Logger) *JobExecutor {
 	this := &JobExecutor{
-		parentId: parentId,
-		cluster:  cluster,
-		topic:    topic,
-		mc:       mc,
-		stopper:  stopper,
-		dueJobs:  make(chan job.JobItem, 200),
-		auditor:  auditor,
+		parentId:    parentId,
+		cluster:     cluster,
+		topic:       topic,
+		mc:          mc,
+		stopper:     stopper,
+		dueJobs:     make(chan []job.JobItem, dueJobsBacklog),
+		rescheduled: make(chan job.JobItem, dueJobsBacklog),
+		auditor:     auditor,
+		loaded:      make(map[int64]int64),
 	}
 
 	return this
 }
 
-// poll mysql for due jobs and send to kafka.
+// schedule mysql jobs through timing wheel and send due jobs to kafka.
 func (this *JobExecutor) Run() {
 	this.appid = manager.Default.TopicAppid(this.topic)
 	if this.appid == "" {
@@ -60,20 +95,38 @@ func (this *JobExecutor) Run() {
 	this.aid = jm.App_id(this.appid)
 	this.table = jm.JobTable(this.topic)
 	this.ident = this.topic
+	this.wheel = newTimingWheel(WheelTick, WheelSize, time.Now().UnixNano()/1e6)
 
 	log.Trace("starting %s", this.Ident())
 
 	var (
-		wg   sync.WaitGroup
-		item job.JobItem
-		tick = time.NewTicker(time.Second)
-		sql  = fmt.Sprintf("SELECT job_id,payload,ctime,due_time FROM %s WHERE due_time<=?", this.table)
+		wg           sync.WaitGroup
+		tick         = time.NewTicker(time.Millisecond * WheelTick)
+		preloadTick  = time.NewTicker(PreloadEvery)
+		probeTick    = time.NewTicker(ProbeEvery)
+		sqlMaxJobId  = fmt.Sprintf("SELECT IFNULL(MAX(job_id),0) FROM %s", this.table)
+		sqlPreload   = fmt.Sprintf("SELECT job_id,payload,ctime,due_time,schedule FROM %s WHERE due_time<=? AND paused=0 ORDER BY due_time LIMIT %d", this.table, PreloadBatch)
+		sqlProbeJobs = fmt.Sprintf("SELECT job_id,payload,ctime,due_time,schedule,paused FROM %s WHERE job_id>? ORDER BY job_id LIMIT %d", this.table, ProbeBatch)
 	)
+	defer func() {
+		tick.Stop()
+		preloadTick.Stop()
+		probeTick.Stop()
+	}()
 
-	for i := 0; i < HandlerConcurrentN; i++ {
-		wg.Add(1)
-		go this.handleDueJobs(&wg)
+	// jobs added after this point will be probed
+	if rows, err := this.mc.Query(jm.AppPool, this.topic, this.aid, sqlMaxJobId); err != nil {
+		log.Error("%s: %v", this.ident, err)
+	} else {
+		if rows.Next() {
+			rows.Scan(&this.probeId)
+		}
+		rows.Close()
 	}
+	this.preload(sqlPreload, time.Now())
+
+	wg.Add(1)
+	go this.handleDueJobs(&wg)
 
 	for {
 		select {
@@ -82,98 +135,491 @@ func (this *JobExecutor) Run() {
 			wg.Wait()
 			return
 
+		case now := <-preloadTick.C:
+			if !this.Paused() {
+				this.preload(sqlPreload, now)
+			}
+
+		case <-probeTick.C:
+			if !this.Paused() {
+				this.probe(sqlProbeJobs)
+			}
+
+		case item := <-this.rescheduled:
+			if item.DueTime <= this.horizon {
+				this.schedule(item)
+			}
+
 		case now := <-tick.C:
-			rows, err := this.mc.Query(jm.AppPool, this.topic, this.aid, sql, now.Unix())
-			if err != nil {
-				log.Error("%s: %v", this.ident, err)
+			due := this.wheel.advance(now.UnixNano() / 1e6)
+			atomic.StoreInt64(&this.wheelN, int64(this.wheel.Len()))
+			if len(due) == 0 {
 				continue
 			}
 
-			for rows.Next() {
-				err = rows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.DueTime)
-				if err == nil {
-					log.Debug("%s due %s", this.ident, item)
-					if lag := now.Unix() - item.DueTime; lag > LagWarnThreshold {
-						log.Warn("%s lag %ds %s", this.ident, lag, item)
-					}
-
-					this.dueJobs <- item
-				} else {
-					log.Error("%s: %s", this.ident, err)
+			var maxLag int64
+			for _, item := range due {
+				// a job rescheduled by client keeps its newer entry loaded
+				if this.loaded[item.JobId] == item.DueTime {
+					delete(this.loaded, item.JobId)
+				}
+				log.Debug("%s due %s", this.ident, item)
+				lag := now.Unix() - item.DueTime
+				if lag > LagWarnThreshold {
+					log.Warn("%s lag %ds %s", this.ident, lag, item)
+				}
+				if lag > maxLag {
+					maxLag = lag
 				}
 			}
+			atomic.StoreInt64(&this.dueLag, maxLag)
 
-			if err = rows.Err(); err != nil {
-				log.Error("%s: %s", this.ident, err)
+			if this.Paused() {
+				// the jobs stay in job table and will be preloaded again after resume
+				log.Debug("%s paused, %d due jobs deferred", this.ident, len(due))
+				continue
 			}
 
-			rows.Close()
+			atomic.AddInt64(&this.inflights, int64(len(due)))
+			for len(due) > 0 {
+				n := FireBatch
+				if n > len(due) {
+					n = len(due)
+				}
+
+				select {
+				case this.dueJobs <- due[:n]:
+				case <-this.stopper:
+				}
+				due = due[n:]
+			}
 		}
 	}
 
 }
 
-// TODO batch DELETE/INSERT for better performance.
+// preload loads jobs due within PreloadWindow into the timing wheel.
+func (this *JobExecutor) preload(sql string, now time.Time) {
+	horizon := now.Add(PreloadWindow).Unix()
+	rows, err := this.mc.Query(jm.AppPool, this.topic, this.aid, sql, horizon)
+	if err != nil {
+		log.Error("%s: %v", this.ident, err)
+		return
+	}
+
+	var (
+		item job.JobItem
+		n    int
+	)
+	for rows.Next() {
+		if err = rows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.DueTime, &item.Schedule); err != nil {
+			log.Error("%s: %s", this.ident, err)
+			continue
+		}
+
+		n++
+		this.schedule(item)
+	}
+	if err = rows.Err(); err != nil {
+		log.Error("%s: %s", this.ident, err)
+	}
+	rows.Close()
+
+	if n == PreloadBatch {
+		// the window is truncated, jobs at the last due second might be partially loaded
+		horizon = item.DueTime - 1
+	}
+	this.horizon = horizon
+
+	log.Debug("%s preloaded %d jobs, horizon %d, wheel %d", this.ident, n, this.horizon, this.wheel.Len())
+}
+
+// probe loads newly added jobs that are due before horizon into the timing wheel.
+// A job whose id is not monotonic with probeId will be picked up by next preload.
+func (this *JobExecutor) probe(sql string) {
+	rows, err := this.mc.Query(jm.AppPool, this.topic, this.aid, sql, this.probeId)
+	if err != nil {
+		log.Error("%s: %v", this.ident, err)
+		return
+	}
+
+	var item job.JobItem
+	for rows.Next() {
+		if err = rows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.DueTime, &item.Schedule, &item.Paused); err != nil {
+			log.Error("%s: %s", this.ident, err)
+			continue
+		}
+
+		this.probeId = item.JobId
+		if !item.Paused && item.DueTime <= this.horizon {
+			this.schedule(item)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Error("%s: %s", this.ident, err)
+	}
+	rows.Close()
+}
+
+func (this *JobExecutor) schedule(item job.JobItem) {
+	if due, present := this.loaded[item.JobId]; present && due == item.DueTime {
+		return
+	}
+
+	// already due jobs fire on next wheel tick
+	// if rescheduled by client, the stale entry is skipped by liveJobs when due
+	this.wheel.add(item)
+	this.loaded[item.JobId] = item.DueTime
+}
+
+// handleDueJobs fans out the due jobs to JobWorkers workers keyed by job id: jobs are fired
+// in parallel while each recurring job is fired in due time order.
 func (this *JobExecutor) handleDueJobs(wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	var (
-		// zabbix maintains a in-memory delete queue
-		// delete from history_uint where itemid=? and clock<min_clock
-		sqlDeleteJob = fmt.Sprintf("DELETE FROM %s WHERE job_id=?", this.table)
+	workers := JobWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	dispatcher := newKeyedDispatcher(workers, 1, this.stopper)
+	defer dispatcher.Wait()
 
-		sqlInsertArchive = fmt.Sprintf("INSERT INTO %s(job_id,payload,ctime,due_time,etime,actor_id) VALUES(?,?,?,?,?,?)",
-			jm.HistoryTable(this.topic))
-		sqlReinject = fmt.Sprintf("INSERT INTO %s(job_id, payload, ctime, due_time) VALUES(?,?,?,?)", this.table)
-	)
+	groups := make([][]job.JobItem, workers)
 	for {
 		select {
 		case <-this.stopper:
 			return
 
-		case item := <-this.dueJobs:
-			now := time.Now()
-			affectedRows, _, err := this.mc.Exec(jm.AppPool, this.table, this.aid, sqlDeleteJob, item.JobId)
-			if err != nil {
-				log.Error("%s: %s", this.ident, err)
-				continue
-			}
-			if affectedRows == 0 {
-				// 2 possibilities:
-				// - client Cancel job wins
-				// - this handler is too slow and the job fetched twice in tick
-				continue
+		case items := <-this.dueJobs:
+			// split the batch by job id so that each worker fires its own jobs in order
+			for _, item := range items {
+				i := uint32(item.JobId) % uint32(len(groups))
+				groups[i] = append(groups[i], item)
 			}
 
-			log.Debug("%s land %s", this.ident, item)
-			_, _, err = store.DefaultPubStore.SyncPub(this.cluster, this.topic, nil, item.Payload)
-			if err != nil {
-				err = hh.Default.Append(this.cluster, this.topic, nil, item.Payload)
+			for i, group := range groups {
+				if len(group) == 0 {
+					continue
+				}
+
+				batch := group
+				if !dispatcher.Dispatch(uint32(i), func() { this.fire(batch) }) {
+					// not fired jobs stay in job table and will be preloaded again
+					return
+				}
+				groups[i] = nil
 			}
-			if err != nil {
-				// pub fails and hinted handoff also fails: reinject job back to mysql
-				log.Error("%s: %s", this.ident, err)
-				this.mc.Exec(jm.AppPool, this.table, this.aid, sqlReinject,
-					item.JobId, item.Payload, item.Ctime, item.DueTime)
+		}
+	}
+}
+
+// fire moves a batch of due jobs from the job table to kafka and then the archive table.
+// Each activation of a recurring job is archived, and the job stays in job table with the
+// next due time.
+func (this *JobExecutor) fire(items []job.JobItem) {
+	defer atomic.AddInt64(&this.inflights, -int64(len(items)))
+
+	var (
+		now                  = time.Now()
+		sqlRollbackRecurring = fmt.Sprintf("UPDATE %s SET due_time=? WHERE job_id=? AND due_time=?", this.table)
+	)
+
+	// the jobs might be canceled, rescheduled by client or fired by an earlier batch
+	live, err := this.liveJobs(items)
+	if err != nil {
+		log.Error("%s: %s", this.ident, err)
+		return
+	}
+	if len(live) == 0 {
+		return
+	}
+
+	// one-shot jobs are removed in batch while recurring jobs move on to the next activation,
+	// both conditioned on the due time and payload so that only the activation still in job
+	// table is fired as is: client might cancel, reschedule, shift or update the job after liveJobs
+	deleted := this.deleteJobs(live)
+	var fired, failed []job.JobItem
+	for _, item := range live {
+		var next int64
+		if item.Recurring() {
+			if next = this.reschedule(item, now); next == 0 {
 				continue
 			}
+		} else if _, present := deleted[item.JobId]; !present {
+			log.Debug("%s cancelled, rescheduled or updated %s", this.ident, item)
+			continue
+		}
 
-			log.Debug("%s fired %s", this.ident, item)
-			this.auditor.Trace(item.String())
-
-			// mv job to archive table
-			_, _, err = this.mc.Exec(jm.AppPool, this.table, this.aid, sqlInsertArchive,
-				item.JobId, item.Payload, item.Ctime, item.DueTime, now.Unix(), this.parentId)
-			if err != nil {
-				log.Error("%s: %s", this.ident, err)
+		log.Debug("%s land %s", this.ident, item)
+		_, _, err = store.DefaultPubStore.SyncPub(this.cluster, this.topic, nil, item.Payload)
+		if err != nil {
+			err = hh.Default.Append(this.cluster, this.topic, nil, item.Payload)
+		}
+		if err != nil {
+			log.Error("%s: %s", this.ident, err)
+			atomic.AddInt64(&this.failedN, 1)
+			if item.Recurring() {
+				// rollback to this activation so that it will be preloaded again
+				this.mc.Exec(jm.AppPool, this.table, this.aid, sqlRollbackRecurring, item.DueTime, item.JobId, next)
 			} else {
-				log.Debug("%s archived %s", this.ident, item)
+				failed = append(failed, item)
+			}
+			continue
+		}
+
+		log.Debug("%s fired %s", this.ident, item)
+		this.auditor.Trace(item.String())
+		fired = append(fired, item)
+
+		if next > 0 {
+			nextItem := item
+			nextItem.DueTime = next
+			select {
+			case this.rescheduled <- nextItem:
+			default:
+				// next preload will pick it up
 			}
+		}
+	}
+
+	if len(failed) > 0 {
+		// pub fails and hinted handoff also fails: reinject jobs back to mysql
+		args := make([]interface{}, 0, 4*len(failed))
+		for _, item := range failed {
+			args = append(args, item.JobId, item.Payload, item.Ctime, item.DueTime)
+		}
+		sqlReinject := fmt.Sprintf("INSERT INTO %s(job_id,payload,ctime,due_time) VALUES %s",
+			this.table, valuesPlaceholders(len(failed), 4))
+		if _, _, err = this.mc.Exec(jm.AppPool, this.table, this.aid, sqlReinject, args...); err != nil {
+			log.Error("%s reinject %d jobs: %s", this.ident, len(failed), err)
+		}
+	}
 
+	if len(fired) > 0 {
+		atomic.AddInt64(&this.firedN, int64(len(fired)))
+
+		// mv jobs to archive table
+		args := make([]interface{}, 0, 6*len(fired))
+		for _, item := range fired {
+			args = append(args, item.JobId, item.Payload, item.Ctime, item.DueTime, now.Unix(), this.parentId)
+		}
+		// the activation might be archived already, e,g. refired after crash
+		sqlInsertArchive := fmt.Sprintf("INSERT IGNORE INTO %s(job_id,payload,ctime,due_time,etime,actor_id) VALUES %s",
+			jm.HistoryTable(this.topic), valuesPlaceholders(len(fired), 6))
+		if _, _, err = this.mc.Exec(jm.AppPool, this.table, this.aid, sqlInsertArchive, args...); err != nil {
+			log.Error("%s: %s", this.ident, err)
+		} else {
+			log.Debug("%s archived %d jobs", this.ident, len(fired))
 		}
 	}
 }
 
+// deleteJobs removes the one-shot jobs of items from job table in batch, keyed on due time
+// and payload, and returns the ids of the jobs deleted by us.
+func (this *JobExecutor) deleteJobs(items []job.JobItem) map[int64]struct{} {
+	var (
+		oneShots []job.JobItem
+		args     []interface{}
+	)
+	for _, item := range items {
+		if !item.Recurring() {
+			oneShots = append(oneShots, item)
+			args = append(args, item.JobId, item.DueTime, item.Payload)
+		}
+	}
+	if len(oneShots) == 0 {
+		return nil
+	}
+
+	sql := fmt.Sprintf("DELETE FROM %s WHERE (job_id,due_time,payload) IN (%s)",
+		this.table, valuesPlaceholders(len(oneShots), 3))
+	affectedRows, _, err := this.mc.Exec(jm.AppPool, this.table, this.aid, sql, args...)
+	if err != nil {
+		log.Error("%s: %s", this.ident, err)
+		return nil
+	}
+
+	deleted := make(map[int64]struct{}, len(oneShots))
+	for _, item := range oneShots {
+		deleted[item.JobId] = struct{}{}
+	}
+	if int(affectedRows) == len(oneShots) {
+		return deleted
+	}
+
+	// client raced with us after liveJobs: the jobs still in job table are rescheduled or
+	// updated, and the jobs archived as cancelled are deleted by client
+	log.Warn("%s deleted %d/%d jobs", this.ident, affectedRows, len(oneShots))
+	ids := make([]interface{}, len(oneShots))
+	for i, item := range oneShots {
+		ids[i] = item.JobId
+	}
+	sqlPresent := fmt.Sprintf("SELECT job_id FROM %s WHERE job_id IN (%s)", this.table, placeholders(len(ids)))
+	if err = this.excludeJobs(deleted, sqlPresent, ids...); err != nil {
+		log.Error("%s: %s", this.ident, err)
+		return nil
+	}
+	sqlCancelled := fmt.Sprintf("SELECT job_id FROM %s WHERE (job_id,due_time) IN (%s) AND actor_id=?",
+		jm.HistoryTable(this.topic), valuesPlaceholders(len(oneShots), 2))
+	args = args[:0]
+	for _, item := range oneShots {
+		args = append(args, item.JobId, item.DueTime)
+	}
+	if err = this.excludeJobs(deleted, sqlCancelled, append(args, jm.ActorCancelled)...); err != nil {
+		log.Error("%s: %s", this.ident, err)
+		return nil
+	}
+
+	return deleted
+}
+
+// excludeJobs removes the job ids returned by sql from jobs.
+func (this *JobExecutor) excludeJobs(jobs map[int64]struct{}, sql string, args ...interface{}) error {
+	rows, err := this.mc.Query(jm.AppPool, this.topic, this.aid, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jobId int64
+		if err = rows.Scan(&jobId); err != nil {
+			return err
+		}
+		delete(jobs, jobId)
+	}
+	return rows.Err()
+}
+
+// reschedule moves a recurring job to its next activation and returns the next due time.
+// It returns 0 if the job is paused, canceled, rescheduled or updated by client.
+func (this *JobExecutor) reschedule(item job.JobItem, now time.Time) int64 {
+	var next int64
+	sched, err := job.ParseSchedule(item.Schedule)
+	if err == nil {
+		// the missed activations are skipped instead of fired in a burst
+		if t := sched.Next(now); !t.IsZero() {
+			next = t.Unix()
+		}
+	}
+
+	if next == 0 {
+		log.Error("%s invalid schedule, paused %s", this.ident, item)
+
+		sqlPause := fmt.Sprintf("UPDATE %s SET paused=1 WHERE job_id=?", this.table)
+		if _, _, err = this.mc.Exec(jm.AppPool, this.table, this.aid, sqlPause, item.JobId); err != nil {
+			log.Error("%s: %s", this.ident, err)
+		}
+		return 0
+	}
+
+	sqlReschedule := fmt.Sprintf("UPDATE %s SET due_time=? WHERE job_id=? AND due_time=? AND payload=? AND paused=0", this.table)
+	affectedRows, _, err := this.mc.Exec(jm.AppPool, this.table, this.aid, sqlReschedule, next, item.JobId, item.DueTime, item.Payload)
+	if err != nil {
+		log.Error("%s: %s", this.ident, err)
+		return 0
+	}
+	if affectedRows == 0 {
+		// paused, resumed, updated or deleted by client
+		return 0
+	}
+
+	return next
+}
+
+// liveJobs returns the items still present in the job table with the same due time,
+// keeping the order. The payload is reloaded since client might have updated it.
+func (this *JobExecutor) liveJobs(items []job.JobItem) ([]job.JobItem, error) {
+	ids := make([]interface{}, len(items))
+	for i, item := range items {
+		ids[i] = item.JobId
+	}
+
+	sql := fmt.Sprintf("SELECT job_id,due_time,payload FROM %s WHERE job_id IN (%s)", this.table, placeholders(len(ids)))
+	rows, err := this.mc.Query(jm.AppPool, this.topic, this.aid, sql, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	present := make(map[int64]job.JobItem, len(items))
+	for rows.Next() {
+		var item job.JobItem
+		if err = rows.Scan(&item.JobId, &item.DueTime, &item.Payload); err != nil {
+			return nil, err
+		}
+		present[item.JobId] = item
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	live := items[:0]
+	for _, item := range items {
+		if current, ok := present[item.JobId]; ok && current.DueTime == item.DueTime {
+			item.Payload = current.Payload
+			live = append(live, item)
+		}
+	}
+	return live, nil
+}
+
+// FiredN returns the number of fired jobs since started.
+func (this *JobExecutor) FiredN() int64 {
+	return atomic.LoadInt64(&this.firedN)
+}
+
+// Pause stops firing due jobs, they stay in job table until Resume.
+func (this *JobExecutor) Pause() {
+	atomic.StoreInt32(&this.paused, 1)
+}
+
+func (this *JobExecutor) Resume() {
+	atomic.StoreInt32(&this.paused, 0)
+}
+
+func (this *JobExecutor) Paused() bool {
+	return atomic.LoadInt32(&this.paused) == 1
+}
+
+// Inflights returns the number of due jobs being fired.
+func (this *JobExecutor) Inflights() int64 {
+	return atomic.LoadInt64(&this.inflights)
+}
+
+type JobExecutorStats struct {
+	Cluster   string `json:"cluster"`
+	Paused    bool   `json:"paused"`
+	Wheel     int64  `json:"wheel"`   // jobs loaded in the timing wheel
+	DueLag    int64  `json:"due_lag"` // in sec, of the last due batch
+	Fired     int64  `json:"fired"`
+	Failed    int64  `json:"failed"`
+	Inflights int64  `json:"inflights"`
+}
+
+func (this *JobExecutor) Stats() JobExecutorStats {
+	return JobExecutorStats{
+		Cluster:   this.cluster,
+		Paused:    this.Paused(),
+		Wheel:     atomic.LoadInt64(&this.wheelN),
+		DueLag:    atomic.LoadInt64(&this.dueLag),
+		Fired:     this.FiredN(),
+		Failed:    atomic.LoadInt64(&this.failedN),
+		Inflights: this.Inflights(),
+	}
+}
+
 func (this *JobExecutor) Ident() string {
 	return this.ident
 }
+
+// placeholders returns '?,?,?' of n.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// valuesPlaceholders returns '(?,?),(?,?)' of n rows with cols columns.
+func valuesPlaceholders(n, cols int) string {
+	row := "(" + placeholders(cols) + "),"
+	return strings.TrimSuffix(strings.Repeat(row, n), ",")
+}
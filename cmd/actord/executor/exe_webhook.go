@@ -7,11 +7,12 @@ import (
 	"net"
 	"net/http"
 	"strconv"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/funkygao/gafka"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 	"github.com/funkygao/gafka/cmd/kateway/gateway"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/cmd/kateway/meta"
@@ -23,9 +24,15 @@ import (
 )
 
 const (
-	groupName = "_webhook"
+	// WebhookGroup is the consumer group of the webhook executors.
+	WebhookGroup = "_webhook"
+
+	webhookBacklog = 20 // per worker
 )
 
+// WebhookWorkers is the max concurrent deliveries of a webhook.
+var WebhookWorkers = 8
+
 type WebhookExecutor struct {
 	parentId       string // controller short id
 	cluster, topic string
@@ -37,26 +44,33 @@ type WebhookExecutor struct {
 
 	circuits   map[string]*breaker.Consecutive
 	fetcher    *consumergroup.ConsumerGroup
-	msgCh      chan *sarama.ConsumerMessage
+	watermarks *partitionWatermarks
 	httpClient *http.Client // it has builtin pooling
+
+	// stats, atomic
+	deliveredN int64
+	failedN    int64
+	paused     int32
+	resumeCh   chan struct{}
 }
 
 func NewWebhookExecutor(parentId, cluster, topic string, endpoints []string,
 	stopper <-chan struct{}, auditor log.Logger) *WebhookExecutor {
 	this := &WebhookExecutor{
-		parentId:  parentId,
-		cluster:   cluster,
-		topic:     topic,
-		stopper:   stopper,
-		endpoints: endpoints,
-		auditor:   auditor,
-		userAgent: fmt.Sprintf("actor.%s", gafka.BuildId),
-		msgCh:     make(chan *sarama.ConsumerMessage, 20),
-		circuits:  make(map[string]*breaker.Consecutive, len(endpoints)),
+		parentId:   parentId,
+		cluster:    cluster,
+		topic:      topic,
+		stopper:    stopper,
+		endpoints:  endpoints,
+		auditor:    auditor,
+		userAgent:  fmt.Sprintf("actor.%s", gafka.BuildId),
+		watermarks: newPartitionWatermarks(),
+		resumeCh:   make(chan struct{}, 1),
+		circuits:   make(map[string]*breaker.Consecutive, len(endpoints)),
 		httpClient: &http.Client{
 			Timeout: time.Second * 4,
 			Transport: &http.Transport{
-				MaxIdleConnsPerHost: 20, // pooling
+				MaxIdleConnsPerHost: WebhookWorkers, // pooling
 				Dial: (&net.Dialer{
 					Timeout: time.Second * 4,
 				}).Dial,
@@ -108,55 +122,138 @@ func (this *WebhookExecutor) Run() {
 	cf.Offsets.ProcessingTimeout = time.Second
 	cf.Offsets.ResetOffsets = false
 	cf.Offsets.Initial = sarama.OffsetOldest
-	cg, err := consumergroup.JoinConsumerGroup(groupName, []string{this.topic}, meta.Default.ZkAddrs(), cf)
+	cg, err := consumergroup.JoinConsumerGroup(WebhookGroup, []string{this.topic}, meta.Default.ZkAddrs(), cf)
 	if err != nil {
 		log.Error("%s stopped: %s", this.topic, err)
 		return
 	}
 	this.fetcher = cg
 
-	var wg sync.WaitGroup
-	for i := 0; i < 1; i++ {
-		wg.Add(1)
-		go this.pump(&wg)
-	}
-
+	// messages of the same key are delivered in order, different keys in parallel
+	dispatcher := newKeyedDispatcher(WebhookWorkers, webhookBacklog, this.stopper)
 	for {
+		if this.Paused() {
+			// stop fetching, the in-flight messages are still delivered
+			select {
+			case <-this.stopper:
+				log.Debug("%s stopping", this.topic)
+				dispatcher.Wait()
+				return
+
+			case <-this.resumeCh:
+			}
+			continue
+		}
+
 		select {
 		case <-this.stopper:
 			log.Debug("%s stopping", this.topic)
-			wg.Wait()
+			dispatcher.Wait()
 			return
 
+		case <-this.resumeCh:
+			// paused or resumed, recheck the state
+
 		case err := <-cg.Errors():
 			log.Error("%s %s", this.topic, err)
 			// TODO
 
 		case msg := <-cg.Messages():
-			this.msgCh <- msg
+			generation := this.watermarks.Add(msg.Partition, msg.Offset)
+			dispatcher.Dispatch(this.hash(msg), func() {
+				this.deliver(msg, generation)
+			})
 		}
 
 	}
 
 }
 
-func (this *WebhookExecutor) pump(wg *sync.WaitGroup) {
-	defer wg.Done()
+// hash returns the worker hash of a message, keyless messages stay in partition order.
+func (this *WebhookExecutor) hash(msg *sarama.ConsumerMessage) uint32 {
+	if len(msg.Key) == 0 {
+		return uint32(msg.Partition)
+	}
 
-	for {
-		select {
-		case <-this.stopper:
-			return
+	return keyHash(msg.Key)
+}
 
-		case msg := <-this.msgCh:
-			for _, ep := range this.endpoints {
-				this.pushToEndpoint(msg, ep)
-			}
+// CircuitsOpen returns the circuit breaker state of each endpoint, true means open.
+func (this *WebhookExecutor) CircuitsOpen() map[string]bool {
+	r := make(map[string]bool, len(this.circuits))
+	for ep, circuit := range this.circuits {
+		r[ep] = circuit.Open()
+	}
+	return r
+}
 
-			this.fetcher.CommitUpto(msg)
+// deliver pushes a message to all the endpoints and commits the partition offset up to
+// which all the messages are delivered.
+func (this *WebhookExecutor) deliver(msg *sarama.ConsumerMessage, generation int64) {
+	for _, ep := range this.endpoints {
+		if this.pushToEndpoint(msg, ep) {
+			atomic.AddInt64(&this.deliveredN, 1)
+		} else {
+			atomic.AddInt64(&this.failedN, 1)
 		}
 	}
 
+	if upto, ok := this.watermarks.Done(msg.Partition, msg.Offset, generation); ok {
+		this.fetcher.CommitUpto(&sarama.ConsumerMessage{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    upto,
+		})
+	}
+}
+
+// Pause stops fetching messages until Resume.
+func (this *WebhookExecutor) Pause() {
+	atomic.StoreInt32(&this.paused, 1)
+	// wake up the fetch loop to stop fetching
+	select {
+	case this.resumeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (this *WebhookExecutor) Resume() {
+	atomic.StoreInt32(&this.paused, 0)
+	select {
+	case this.resumeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (this *WebhookExecutor) Paused() bool {
+	return atomic.LoadInt32(&this.paused) == 1
+}
+
+// Inflights returns the number of fetched messages not committed.
+func (this *WebhookExecutor) Inflights() int64 {
+	return this.watermarks.Inflights()
+}
+
+type WebhookExecutorStats struct {
+	Cluster   string          `json:"cluster"`
+	Endpoints []string        `json:"endpoints"`
+	Paused    bool            `json:"paused"`
+	Delivered int64           `json:"delivered"` // per endpoint push
+	Failed    int64           `json:"failed"`
+	Inflights int64           `json:"inflights"`
+	Circuits  map[string]bool `json:"circuits"` // true means open
+}
+
+func (this *WebhookExecutor) Stats() WebhookExecutorStats {
+	return WebhookExecutorStats{
+		Cluster:   this.cluster,
+		Endpoints: this.endpoints,
+		Paused:    this.Paused(),
+		Delivered: atomic.LoadInt64(&this.deliveredN),
+		Failed:    atomic.LoadInt64(&this.failedN),
+		Inflights: this.Inflights(),
+		Circuits:  this.CircuitsOpen(),
+	}
 }
 
 func (this *WebhookExecutor) pushToEndpoint(msg *sarama.ConsumerMessage, uri string) (ok bool) {
@@ -167,11 +264,23 @@ func (this *WebhookExecutor) pushToEndpoint(msg *sarama.ConsumerMessage, uri str
 		return false
 	}
 
+	headers, bodyIdx, err := envelope.Decode(msg.Value)
+	if err != nil {
+		// corrupted envelope, deliver as is
+		log.Warn("%s %s/%d %d %v", this.topic, msg.Topic, msg.Partition, msg.Offset, err)
+		headers, bodyIdx = nil, 0
+	}
+
+	if headers.Expired(time.Now()) {
+		log.Debug("%s discard expired %d/%d", this.topic, msg.Partition, msg.Offset)
+		return true
+	}
+
 	body := mpool.BytesBufferGet()
 	defer mpool.BytesBufferPut(body)
 
 	body.Reset()
-	body.Write(msg.Value)
+	body.Write(msg.Value[bodyIdx:])
 
 	// TODO user defined post body schema, e,g. ElasticSearch
 	req, err := http.NewRequest("POST", uri, body)
@@ -184,6 +293,12 @@ func (this *WebhookExecutor) pushToEndpoint(msg *sarama.ConsumerMessage, uri str
 	req.Header.Set(gateway.HttpHeaderPartition, strconv.FormatInt(int64(msg.Partition), 10))
 	req.Header.Set("User-Agent", this.userAgent)
 	req.Header.Set("X-App-Signature", this.appSignature)
+	if tag := headers.Get(envelope.HeaderTag); tag != "" {
+		req.Header.Set(gateway.HttpHeaderMsgTag, tag)
+	}
+	if traceId := headers.Get(envelope.HeaderTraceId); traceId != "" {
+		req.Header.Set(gateway.HttpHeaderTraceId, traceId)
+	}
 	response, err := this.httpClient.Do(req)
 	if err != nil {
 		log.Error("%s %s %s", this.topic, uri, err)
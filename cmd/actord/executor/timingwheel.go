@@ -0,0 +1,125 @@
+package executor
+
+import (
+	"sort"
+
+	"github.com/funkygao/gafka/cmd/kateway/job"
+)
+
+// timingWheel is a hierarchical timing wheel holding the preloaded jobs
+// of a single JobQueue.
+//
+// Each level has wheelSize buckets of tick ms, and the overflow level is
+// created on demand with tick of the whole lower level interval. Jobs
+// beyond the current level cascade down as the wheel advances.
+//
+// timingWheel is not goroutine safe: it is owned by the JobExecutor loop.
+type timingWheel struct {
+	tick        int64 // in ms
+	wheelSize   int64
+	interval    int64 // tick * wheelSize
+	currentTime int64 // in ms, truncated to tick
+
+	buckets  [][]job.JobItem
+	overflow *timingWheel
+	n        int // how many jobs in this level
+
+	expired []job.JobItem // already due when added
+}
+
+func newTimingWheel(tick, wheelSize, startMs int64) *timingWheel {
+	return &timingWheel{
+		tick:        tick,
+		wheelSize:   wheelSize,
+		interval:    tick * wheelSize,
+		currentTime: startMs - startMs%tick,
+		buckets:     make([][]job.JobItem, wheelSize),
+	}
+}
+
+// add puts the job into the wheel and returns false if it is already due,
+// in which case it will be returned by the next advance.
+func (this *timingWheel) add(item job.JobItem) bool {
+	if !this.place(item) {
+		this.expired = append(this.expired, item)
+		return false
+	}
+
+	return true
+}
+
+func (this *timingWheel) place(item job.JobItem) bool {
+	due := item.DueTime * 1000
+	switch {
+	case due < this.currentTime+this.tick:
+		return false
+
+	case due < this.currentTime+this.interval:
+		idx := (due / this.tick) % this.wheelSize
+		this.buckets[idx] = append(this.buckets[idx], item)
+		this.n++
+		return true
+
+	default:
+		if this.overflow == nil {
+			this.overflow = newTimingWheel(this.interval, this.wheelSize, this.currentTime)
+		}
+		return this.overflow.place(item)
+	}
+}
+
+// advance moves the wheel clock to nowMs and returns the due jobs in
+// the order of due time.
+func (this *timingWheel) advance(nowMs int64) (due []job.JobItem) {
+	due, this.expired = this.expired, nil
+	this.advanceTo(nowMs, func(item job.JobItem) {
+		due = append(due, item)
+	})
+
+	sort.Sort(jobsByDueTime(due))
+	return
+}
+
+func (this *timingWheel) advanceTo(nowMs int64, fire func(job.JobItem)) {
+	for this.currentTime+this.tick <= nowMs {
+		this.currentTime += this.tick
+
+		if this.overflow != nil {
+			// cascade the upper level jobs that fall into this level
+			this.overflow.advanceTo(this.currentTime, func(item job.JobItem) {
+				if !this.place(item) {
+					fire(item)
+				}
+			})
+		}
+
+		idx := (this.currentTime / this.tick) % this.wheelSize
+		if bucket := this.buckets[idx]; len(bucket) > 0 {
+			this.buckets[idx] = nil
+			this.n -= len(bucket)
+			for _, item := range bucket {
+				fire(item)
+			}
+		}
+	}
+}
+
+// Len returns how many jobs are pending in the wheel across all levels.
+func (this *timingWheel) Len() int {
+	n := len(this.expired)
+	for w := this; w != nil; w = w.overflow {
+		n += w.n
+	}
+	return n
+}
+
+type jobsByDueTime []job.JobItem
+
+func (this jobsByDueTime) Len() int      { return len(this) }
+func (this jobsByDueTime) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this jobsByDueTime) Less(i, j int) bool {
+	if this[i].DueTime != this[j].DueTime {
+		return this[i].DueTime < this[j].DueTime
+	}
+	return this[i].JobId < this[j].JobId
+}
@@ -0,0 +1,57 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/job"
+)
+
+func TestTimingWheelAddAndAdvance(t *testing.T) {
+	start := int64(1471565204) // in sec
+	tw := newTimingWheel(100, 10, start*1000)
+
+	assert.Equal(t, false, tw.add(job.JobItem{JobId: 1, DueTime: start}))
+	assert.Equal(t, true, tw.add(job.JobItem{JobId: 2, DueTime: start + 1}))
+	assert.Equal(t, true, tw.add(job.JobItem{JobId: 3, DueTime: start + 30}))
+	assert.Equal(t, true, tw.add(job.JobItem{JobId: 4, DueTime: start + 500}))
+	assert.Equal(t, 4, tw.Len())
+
+	due := tw.advance(start*1000 + 900)
+	assert.Equal(t, 1, len(due))
+	assert.Equal(t, int64(1), due[0].JobId)
+	assert.Equal(t, 3, tw.Len())
+
+	due = tw.advance((start + 1) * 1000)
+	assert.Equal(t, 1, len(due))
+	assert.Equal(t, int64(2), due[0].JobId)
+
+	// cascaded from the overflow level
+	assert.Equal(t, 0, len(tw.advance((start+30)*1000-100)))
+	due = tw.advance((start + 30) * 1000)
+	assert.Equal(t, 1, len(due))
+	assert.Equal(t, int64(3), due[0].JobId)
+
+	due = tw.advance((start + 600) * 1000)
+	assert.Equal(t, 1, len(due))
+	assert.Equal(t, int64(4), due[0].JobId)
+	assert.Equal(t, 0, tw.Len())
+}
+
+func TestTimingWheelDueOrder(t *testing.T) {
+	start := int64(1471565204)
+	tw := newTimingWheel(100, 10, start*1000)
+
+	// added out of order and across levels
+	tw.add(job.JobItem{JobId: 9, DueTime: start + 20})
+	tw.add(job.JobItem{JobId: 5, DueTime: start + 2})
+	tw.add(job.JobItem{JobId: 7, DueTime: start + 20})
+	tw.add(job.JobItem{JobId: 6, DueTime: start + 15})
+
+	due := tw.advance((start + 60) * 1000)
+	assert.Equal(t, 4, len(due))
+	assert.Equal(t, int64(5), due[0].JobId)
+	assert.Equal(t, int64(6), due[1].JobId)
+	assert.Equal(t, int64(7), due[2].JobId)
+	assert.Equal(t, int64(9), due[3].JobId)
+}
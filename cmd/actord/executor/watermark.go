@@ -0,0 +1,88 @@
+package executor
+
+import (
+	"sync"
+)
+
+// partitionWatermarks tracks the in-flight offsets of each partition when messages are
+// processed out of order, so that only the contiguous prefix of processed messages is
+// committed.
+type partitionWatermarks struct {
+	mu         sync.Mutex
+	partitions map[int32]*offsetWatermark
+}
+
+type offsetWatermark struct {
+	generation int64
+	last       int64              // last fetched offset
+	offsets    []int64            // fetched and not committed, in fetch order
+	done       map[int64]struct{} // processed offsets not yet contiguous
+}
+
+func newPartitionWatermarks() *partitionWatermarks {
+	return &partitionWatermarks{partitions: make(map[int32]*offsetWatermark)}
+}
+
+// Add tracks a fetched message and returns the generation to pass to Done.
+//
+// Offsets are fetched in order within a partition; an offset not greater than the last
+// one means redelivery after rebalance and starts a new generation, whose watermark is
+// not affected by the in-flight messages of the former generation.
+func (this *partitionWatermarks) Add(partition int32, offset int64) (generation int64) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	w, present := this.partitions[partition]
+	if !present {
+		w = &offsetWatermark{last: -1, done: make(map[int64]struct{})}
+		this.partitions[partition] = w
+	}
+
+	if offset <= w.last {
+		w.generation++
+		w.offsets = nil
+		w.done = make(map[int64]struct{})
+	}
+
+	w.last = offset
+	w.offsets = append(w.offsets, offset)
+	return w.generation
+}
+
+// Done marks a message processed and returns the offset up to which all the fetched
+// messages are processed, ok is false if the watermark is not advanced.
+func (this *partitionWatermarks) Done(partition int32, offset, generation int64) (upto int64, ok bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	w, present := this.partitions[partition]
+	if !present || w.generation != generation {
+		return
+	}
+
+	w.done[offset] = struct{}{}
+	for len(w.offsets) > 0 {
+		head := w.offsets[0]
+		if _, processed := w.done[head]; !processed {
+			break
+		}
+
+		delete(w.done, head)
+		w.offsets = w.offsets[1:]
+		upto, ok = head, true
+	}
+
+	return
+}
+
+// Inflights returns the number of fetched messages not committed.
+func (this *partitionWatermarks) Inflights() int64 {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var n int64
+	for _, w := range this.partitions {
+		n += int64(len(w.offsets))
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestPartitionWatermarksOutOfOrder(t *testing.T) {
+	w := newPartitionWatermarks()
+	for _, offset := range []int64{10, 11, 13, 14} { // 12 compacted
+		assert.Equal(t, int64(0), w.Add(0, offset))
+	}
+	w.Add(1, 5)
+
+	_, ok := w.Done(0, 13, 0)
+	assert.Equal(t, false, ok)
+	_, ok = w.Done(0, 11, 0)
+	assert.Equal(t, false, ok)
+
+	upto, ok := w.Done(0, 10, 0)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(13), upto)
+
+	upto, ok = w.Done(1, 5, 0)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(5), upto)
+
+	upto, ok = w.Done(0, 14, 0)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(14), upto)
+}
+
+func TestPartitionWatermarksRedelivery(t *testing.T) {
+	w := newPartitionWatermarks()
+	w.Add(0, 10)
+	w.Add(0, 11)
+
+	// rebalanced and redelivered from the committed offset
+	assert.Equal(t, int64(1), w.Add(0, 10))
+
+	// in-flight message of the former generation never advances the watermark
+	_, ok := w.Done(0, 10, 0)
+	assert.Equal(t, false, ok)
+
+	upto, ok := w.Done(0, 10, 1)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(10), upto)
+}
@@ -78,6 +78,12 @@ func (this *Start) statusHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Info("%s status", r.RemoteAddr)
 
+	if this.builtin {
+		b, _ := json.Marshal(this.lb.Stats())
+		w.Write(b)
+		return
+	}
+
 	var (
 		wg       sync.WaitGroup
 		mu       sync.Mutex
@@ -0,0 +1,512 @@
+package command
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	lbHealthCheckInterval = time.Second * 5
+	lbHealthCheckTimeout  = time.Second * 2
+	lbRise                = 2 // consecutive successful checks before a backend is up
+	lbFall                = 3 // consecutive failed checks before a backend is down
+	lbConnectTimeout      = time.Second
+	lbFlushInterval       = time.Millisecond * 100
+	lbShutdownTimeout     = time.Second * 30
+)
+
+// lbBackend is a kateway server behind the built-in load balancer.
+type lbBackend struct {
+	Backend
+
+	weight int64
+	proxy  *httputil.ReverseProxy
+
+	active int64 // in-flight requests
+	down   int32 // 1 if health check fails
+
+	// only accessed by the health checker
+	successes, failures int
+}
+
+func (this *lbBackend) isDown() bool {
+	return atomic.LoadInt32(&this.down) == 1
+}
+
+// lbStats are the haproxy stats columns of a proxy that fetchDashboardStats scrapes.
+type lbStats struct {
+	stot, bin, bout, econ int64
+	rateMax, lastStot     int64
+	hrsp1xx, hrsp4xx      int64
+	hrsp5xx, cliAbrt      int64
+}
+
+// lbProxy is a frontend of the built-in load balancer that proxies requests to the
+// backends with weighted least connections, where the weight is the kateway cpu num.
+type lbProxy struct {
+	name       string
+	forwardFor bool
+	alive      http.Handler
+
+	mu       sync.RWMutex
+	backends []*lbBackend          // serving backends
+	draining map[string]*lbBackend // addr:backend gone from zk with in-flight requests
+
+	stats lbStats
+}
+
+func newLbProxy(name string, forwardFor bool, aliveAddr string) *lbProxy {
+	this := &lbProxy{
+		name:       name,
+		forwardFor: forwardFor,
+		draining:   make(map[string]*lbBackend),
+	}
+	this.alive = httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: aliveAddr})
+	return this
+}
+
+// Update replaces the backends, the removed backends are drained: no new requests
+// dispatched to them while the in-flight requests go on.
+func (this *lbProxy) Update(backends []Backend) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	current := make(map[string]*lbBackend, len(this.backends))
+	for _, b := range this.backends {
+		current[b.Addr] = b
+	}
+
+	serving := make([]*lbBackend, 0, len(backends))
+	for _, be := range backends {
+		b, present := current[be.Addr]
+		if present {
+			delete(current, be.Addr)
+		} else if b, present = this.draining[be.Addr]; present {
+			// back before drained
+			delete(this.draining, be.Addr)
+		} else {
+			b = this.newBackend(be)
+			log.Info("lb[%s] +%s %s", this.name, be.Name, be.Addr)
+		}
+
+		b.Backend = be
+		b.weight = backendWeight(be.Cpu)
+		serving = append(serving, b)
+	}
+
+	for addr, b := range current {
+		log.Info("lb[%s] draining %s %s active:%d", this.name, b.Name, addr, atomic.LoadInt64(&b.active))
+		this.draining[addr] = b
+	}
+
+	this.backends = serving
+	this.reapDrained()
+}
+
+func (this *lbProxy) newBackend(be Backend) *lbBackend {
+	b := &lbBackend{Backend: be}
+	b.proxy = httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: be.Addr})
+	b.proxy.FlushInterval = lbFlushInterval // long polling sub is chunked
+	b.proxy.Transport = &lbTransport{
+		RoundTripper: &http.Transport{
+			Proxy:               nil,
+			Dial:                (&net.Dialer{Timeout: lbConnectTimeout, KeepAlive: time.Minute}).Dial,
+			MaxIdleConnsPerHost: 100,
+		},
+		proxy: this,
+		addr:  be.Addr,
+	}
+	director := b.proxy.Director
+	b.proxy.Director = func(r *http.Request) {
+		director(r)
+		if !this.forwardFor {
+			r.Header["X-Forwarded-For"] = nil
+		}
+	}
+	return b
+}
+
+// must hold the lock
+func (this *lbProxy) reapDrained() {
+	for addr, b := range this.draining {
+		if atomic.LoadInt64(&b.active) == 0 {
+			log.Info("lb[%s] -%s %s drained", this.name, b.Name, addr)
+			delete(this.draining, addr)
+		}
+	}
+}
+
+func backendWeight(cpu string) int64 {
+	if w, err := strconv.ParseInt(cpu, 10, 64); err == nil && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// pick returns the healthy backend with least active/weight, nil if none available.
+func (this *lbProxy) pick() *lbBackend {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	var best *lbBackend
+	for _, b := range this.backends {
+		if b.isDown() {
+			continue
+		}
+
+		// compare active/weight without division
+		if best == nil || atomic.LoadInt64(&b.active)*best.weight < atomic.LoadInt64(&best.active)*b.weight {
+			best = b
+		}
+	}
+	return best
+}
+
+func (this *lbProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/alive") {
+		this.alive.ServeHTTP(w, r)
+		return
+	}
+
+	atomic.AddInt64(&this.stats.stot, 1)
+
+	b := this.pick()
+	if b == nil {
+		atomic.AddInt64(&this.stats.hrsp5xx, 1)
+		http.Error(w, "no backend available", http.StatusServiceUnavailable)
+		return
+	}
+
+	atomic.AddInt64(&b.active, 1)
+	defer atomic.AddInt64(&b.active, -1)
+
+	if r.Body != nil {
+		r.Body = &countingReader{ReadCloser: r.Body, n: &this.stats.bin}
+	}
+	cw := &countingWriter{ResponseWriter: w, n: &this.stats.bout}
+	b.proxy.ServeHTTP(cw, r)
+
+	switch {
+	case cw.status >= 500:
+		atomic.AddInt64(&this.stats.hrsp5xx, 1)
+	case cw.status >= 400:
+		atomic.AddInt64(&this.stats.hrsp4xx, 1)
+	case cw.status >= 100 && cw.status < 200:
+		atomic.AddInt64(&this.stats.hrsp1xx, 1)
+	}
+}
+
+// healthCheck probes /alive of each backend.
+func (this *lbProxy) healthCheck() {
+	this.mu.RLock()
+	backends := make([]*lbBackend, len(this.backends))
+	copy(backends, this.backends)
+	this.mu.RUnlock()
+
+	client := http.Client{Timeout: lbHealthCheckTimeout}
+	for _, b := range backends {
+		ok := false
+		if resp, err := client.Get("http://" + b.Addr + "/alive"); err == nil {
+			ok = resp.StatusCode == http.StatusOK
+			resp.Body.Close()
+		}
+
+		this.observe(b, ok)
+	}
+
+	this.mu.Lock()
+	this.reapDrained()
+	this.mu.Unlock()
+}
+
+func (this *lbProxy) observe(b *lbBackend, ok bool) {
+	if ok {
+		b.failures = 0
+		b.successes++
+		if b.isDown() && b.successes >= lbRise {
+			atomic.StoreInt32(&b.down, 0)
+			log.Info("lb[%s] %s %s is UP", this.name, b.Name, b.Addr)
+		}
+		return
+	}
+
+	b.successes = 0
+	b.failures++
+	if !b.isDown() && b.failures >= lbFall {
+		atomic.StoreInt32(&b.down, 1)
+		log.Warn("lb[%s] %s %s is DOWN", this.name, b.Name, b.Addr)
+	}
+}
+
+// tick updates the max sessions per second.
+func (this *lbProxy) tick() {
+	stot := atomic.LoadInt64(&this.stats.stot)
+	if rate := stot - this.stats.lastStot; rate > atomic.LoadInt64(&this.stats.rateMax) {
+		atomic.StoreInt64(&this.stats.rateMax, rate)
+	}
+	this.stats.lastStot = stot
+}
+
+// Stats returns the same columns as the haproxy BACKEND stats.
+func (this *lbProxy) Stats() map[string]int64 {
+	var scur int64
+	this.mu.RLock()
+	for _, b := range this.backends {
+		scur += atomic.LoadInt64(&b.active)
+	}
+	for _, b := range this.draining {
+		scur += atomic.LoadInt64(&b.active)
+	}
+	this.mu.RUnlock()
+
+	return map[string]int64{
+		"scur":     scur,
+		"stot":     atomic.LoadInt64(&this.stats.stot),
+		"bin":      atomic.LoadInt64(&this.stats.bin),
+		"bout":     atomic.LoadInt64(&this.stats.bout),
+		"econ":     atomic.LoadInt64(&this.stats.econ),
+		"wredis":   0, // no redispatch
+		"rate_max": atomic.LoadInt64(&this.stats.rateMax),
+		"hrsp_1xx": atomic.LoadInt64(&this.stats.hrsp1xx),
+		"hrsp_4xx": atomic.LoadInt64(&this.stats.hrsp4xx),
+		"hrsp_5xx": atomic.LoadInt64(&this.stats.hrsp5xx),
+		"cli_abrt": atomic.LoadInt64(&this.stats.cliAbrt),
+		"srv_abrt": 0, // counted as econ
+	}
+}
+
+// builtinLb replaces the haproxy processes with the pub/sub/man proxies in process.
+type builtinLb struct {
+	proxies   map[string]*lbProxy // pub/sub/man:proxy
+	servers   []*http.Server
+	listeners []net.Listener
+	quit      chan struct{}
+
+	mu       sync.RWMutex
+	stopping bool
+	inflight sync.WaitGroup // in-flight requests
+}
+
+func newBuiltinLb(forwardFor bool, aliveAddr string) *builtinLb {
+	host, port, _ := net.SplitHostPort(aliveAddr)
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	aliveAddr = net.JoinHostPort(host, port)
+
+	return &builtinLb{
+		proxies: map[string]*lbProxy{
+			"pub": newLbProxy("pub", forwardFor, aliveAddr),
+			"sub": newLbProxy("sub", forwardFor, aliveAddr),
+			"man": newLbProxy("man", forwardFor, aliveAddr),
+		},
+		quit: make(chan struct{}),
+	}
+}
+
+func (this *builtinLb) Start(pubPort, subPort, manPort int) {
+	for name, port := range map[string]int{"pub": pubPort, "sub": subPort, "man": manPort} {
+		server := &http.Server{
+			Addr:    ":" + strconv.Itoa(port),
+			Handler: this.track(this.proxies[name]),
+		}
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			panic(err)
+		}
+
+		this.servers = append(this.servers, server)
+		this.listeners = append(this.listeners, listener)
+
+		go func(name string, server *http.Server, listener net.Listener) {
+			log.Info("lb[%s] ready on %s", name, server.Addr)
+			if err := server.Serve(listener); err != nil {
+				select {
+				case <-this.quit:
+					// listener closed by Stop
+				default:
+					panic(err)
+				}
+			}
+		}(name, server, listener)
+	}
+
+	go this.watchdog()
+}
+
+// track counts the in-flight requests so that Stop can wait for them.
+func (this *builtinLb) track(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		this.mu.RLock()
+		if this.stopping {
+			this.mu.RUnlock()
+
+			// a keep-alive connection might send requests after Stop
+			w.Header().Set("Connection", "close")
+			http.Error(w, "lb is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		this.inflight.Add(1)
+		this.mu.RUnlock()
+
+		defer this.inflight.Done()
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (this *builtinLb) watchdog() {
+	checker := time.NewTicker(lbHealthCheckInterval)
+	defer checker.Stop()
+	rater := time.NewTicker(time.Second)
+	defer rater.Stop()
+
+	for {
+		select {
+		case <-this.quit:
+			return
+
+		case <-checker.C:
+			for _, p := range this.proxies {
+				p.healthCheck()
+			}
+
+		case <-rater.C:
+			for _, p := range this.proxies {
+				p.tick()
+			}
+		}
+	}
+}
+
+func (this *builtinLb) Update(servers BackendServers) {
+	this.proxies["pub"].Update(servers.Pub)
+	this.proxies["sub"].Update(servers.Sub)
+	this.proxies["man"].Update(servers.Man)
+}
+
+// Stop stops accepting new requests and waits for the in-flight requests.
+func (this *builtinLb) Stop(timeout time.Duration) {
+	close(this.quit)
+
+	this.mu.Lock()
+	this.stopping = true
+	this.mu.Unlock()
+
+	for i, server := range this.servers {
+		server.SetKeepAlivesEnabled(false)
+		if err := this.listeners[i].Close(); err != nil {
+			log.Error("lb %s: %v", server.Addr, err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		this.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("lb in-flight requests not finished in %s", timeout)
+	}
+}
+
+func (this *builtinLb) Stats() map[string]map[string]int64 {
+	r := make(map[string]map[string]int64, len(this.proxies))
+	for name, p := range this.proxies {
+		r[name] = p.Stats()
+	}
+	return r
+}
+
+// lbTransport counts the backend errors, the reverse proxy then responds 502.
+type lbTransport struct {
+	http.RoundTripper
+
+	proxy *lbProxy
+	addr  string
+}
+
+func (this *lbTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := this.RoundTripper.RoundTrip(r)
+	if err != nil {
+		if r.Context().Err() != nil {
+			atomic.AddInt64(&this.proxy.stats.cliAbrt, 1)
+		} else {
+			atomic.AddInt64(&this.proxy.stats.econ, 1)
+		}
+
+		log.Error("lb[%s] %s %s %s: %v", this.proxy.name, r.RemoteAddr, this.addr, r.URL.Path, err)
+	}
+	return resp, err
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (this *countingReader) Read(p []byte) (int, error) {
+	n, err := this.ReadCloser.Read(p)
+	atomic.AddInt64(this.n, int64(n))
+	return n, err
+}
+
+// countingWriter counts the response bytes and keeps the Flusher/Hijacker/CloseNotifier
+// of the underlying writer for chunked sub and websocket.
+type countingWriter struct {
+	http.ResponseWriter
+	n      *int64
+	status int
+}
+
+func (this *countingWriter) WriteHeader(status int) {
+	this.status = status
+	this.ResponseWriter.WriteHeader(status)
+}
+
+func (this *countingWriter) Write(b []byte) (int, error) {
+	if this.status == 0 {
+		this.status = http.StatusOK
+	}
+	n, err := this.ResponseWriter.Write(b)
+	atomic.AddInt64(this.n, int64(n))
+	return n, err
+}
+
+func (this *countingWriter) Flush() {
+	if f, ok := this.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (this *countingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := this.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// CloseNotify lets the reverse proxy cancel the backend request when client is gone.
+func (this *countingWriter) CloseNotify() <-chan bool {
+	if cn, ok := this.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool) // never notified
+}
+
+func (this *countingWriter) Unwrap() http.ResponseWriter {
+	return this.ResponseWriter
+}
@@ -0,0 +1,104 @@
+package command
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestLbProxyPickWeightedLeastConn(t *testing.T) {
+	p := newLbProxy("pub", false, "127.0.0.1:10894")
+	p.Update([]Backend{
+		{Name: "p1", Addr: "127.0.0.1:11", Cpu: "1"},
+		{Name: "p2", Addr: "127.0.0.1:12", Cpu: "4"},
+	})
+
+	// p2 takes 4 times the connections of p1
+	picked := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		b := p.pick()
+		b.active++
+		picked[b.Name]++
+	}
+	assert.Equal(t, 2, picked["p1"])
+	assert.Equal(t, 8, picked["p2"])
+
+	// down backend never picked
+	p.observe(p.backends[1], false)
+	p.observe(p.backends[1], false)
+	assert.Equal(t, false, p.backends[1].isDown())
+	p.observe(p.backends[1], false)
+	assert.Equal(t, true, p.backends[1].isDown())
+	assert.Equal(t, "p1", p.pick().Name)
+
+	p.observe(p.backends[1], true)
+	assert.Equal(t, true, p.backends[1].isDown())
+	p.observe(p.backends[1], true)
+	assert.Equal(t, false, p.backends[1].isDown())
+}
+
+func TestLbProxyDrain(t *testing.T) {
+	p := newLbProxy("sub", false, "127.0.0.1:10894")
+	p.Update([]Backend{
+		{Name: "s1", Addr: "127.0.0.1:11", Cpu: "2"},
+		{Name: "s2", Addr: "127.0.0.1:12"},
+	})
+	s2 := p.backends[1]
+	assert.Equal(t, int64(1), s2.weight)
+	s2.active = 1
+
+	// s2 znode gone with in-flight request
+	p.Update([]Backend{{Name: "s1", Addr: "127.0.0.1:11", Cpu: "2"}})
+	assert.Equal(t, 1, len(p.backends))
+	assert.Equal(t, 1, len(p.draining))
+	assert.Equal(t, int64(1), p.Stats()["scur"])
+	assert.Equal(t, "s1", p.pick().Name)
+
+	s2.active = 0
+	p.healthCheck() // reaps drained backends, s1 check fails
+	assert.Equal(t, 0, len(p.draining))
+
+	// idle backend is removed at once
+	p.Update([]Backend{{Name: "s3", Addr: "127.0.0.1:13"}})
+	assert.Equal(t, 1, len(p.backends))
+	assert.Equal(t, 0, len(p.draining))
+	assert.Equal(t, "s3", p.pick().Name)
+}
+
+func TestBuiltinLbStopAwaitsInflight(t *testing.T) {
+	lb := newBuiltinLb(false, "127.0.0.1:10894")
+	started, release := make(chan struct{}), make(chan struct{})
+	h := lb.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/msgs/foo/v1", nil))
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		lb.Stop(time.Minute)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("stopped with in-flight request")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	// no new requests once stopping
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/msgs/foo/v1", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("in-flight request done, but not stopped")
+	}
+}
@@ -48,6 +48,8 @@ type Start struct {
 	manPort    int
 	starting   bool
 	forwardFor bool
+	builtin    bool
+	lb         *builtinLb
 
 	monitorListener net.Listener
 	monitorServer   *http.Server
@@ -73,6 +75,7 @@ func (this *Start) Run(args []string) (exitCode int) {
 	cmdFlags.StringVar(&this.root, "p", defaultPrefix, "")
 	cmdFlags.BoolVar(&this.debugMode, "d", false, "")
 	cmdFlags.BoolVar(&this.forwardFor, "forwardfor", false, "")
+	cmdFlags.BoolVar(&this.builtin, "builtin", false, "")
 	cmdFlags.IntVar(&this.pubPort, "pub", 10891, "")
 	cmdFlags.IntVar(&this.subPort, "sub", 10892, "")
 	cmdFlags.IntVar(&this.manPort, "man", 10893, "")
@@ -101,9 +104,11 @@ func (this *Start) Run(args []string) (exitCode int) {
 	err := os.Chdir(this.root)
 	swalllow(err)
 
-	this.command = fmt.Sprintf("%s/sbin/haproxy", this.root)
-	if _, err := os.Stat(this.command); err != nil {
-		panic(err)
+	if !this.builtin {
+		this.command = fmt.Sprintf("%s/sbin/haproxy", this.root)
+		if _, err := os.Stat(this.command); err != nil {
+			panic(err)
+		}
 	}
 
 	this.setupLogging(this.logfile, "info", "panic")
@@ -190,6 +195,11 @@ func (this *Start) main() {
 	registry.Default = zkr.New(this.zkzone)
 
 	log.Info("ehaproxy[%s] starting...", gafka.BuildId)
+	if this.builtin {
+		this.lb = newBuiltinLb(this.forwardFor, this.httpAddr)
+		this.lb.Start(this.pubPort, this.subPort, this.manPort)
+	}
+
 	go this.runMonitorServer(this.httpAddr)
 
 	zkConnected := false
@@ -297,7 +307,7 @@ func (this *Start) reload(kwInstances []string) {
 		}
 	}
 
-	for i := 0; i < ctx.NumCPU(); i++ {
+	for i := 0; !this.builtin && i < ctx.NumCPU(); i++ {
 		servers.Dashboard = append(servers.Dashboard, Backend{
 			Port: fmt.Sprintf("%d", dashboardPortHead+i),
 			Name: fmt.Sprintf("%d", i+1), // process id starts from 1
@@ -315,6 +325,11 @@ func (this *Start) reload(kwInstances []string) {
 	}
 
 	this.lastServers = servers
+	if this.builtin {
+		this.lb.Update(servers)
+		return
+	}
+
 	if err := this.createConfigFile(servers); err != nil {
 		log.Error(err)
 		return
@@ -327,6 +342,12 @@ func (this *Start) reload(kwInstances []string) {
 }
 
 func (this *Start) shutdown() {
+	if this.builtin {
+		log.Info("awaiting in-flight requests...")
+		this.lb.Stop(lbShutdownTimeout)
+		return
+	}
+
 	// kill haproxy
 	log.Info("killling haproxy processes")
 
@@ -391,6 +412,10 @@ Options:
       Default false.
       If true, haproxy will add X-Forwarded-For http header.
 
+    -builtin
+      Proxy pub/sub/man in process instead of forking haproxy.
+      Backends are balanced by weighted least connections where weight is the kateway cpu num.
+
     -pub pub server listen port
 
     -sub sub server listen port
@@ -4,8 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/funkygao/gafka/cmd/gk/command/agent"
+	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/gocli"
 )
 
@@ -22,6 +24,15 @@ func (this *Agent) Run(args []string) (exitCode int) {
 		port        int
 		seeds       string
 		tags        string
+		dc          string
+		wan         bool
+		wanSeeds    string
+		keyringFile string
+		pool        string
+		events      bool
+		eventName   string
+		fire        string
+		payload     string
 	)
 	cmdFlags := flag.NewFlagSet("agent", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
@@ -31,6 +42,15 @@ func (this *Agent) Run(args []string) (exitCode int) {
 	cmdFlags.BoolVar(&listMembers, "l", false, "")
 	cmdFlags.StringVar(&seeds, "join", "", "")
 	cmdFlags.StringVar(&tags, "tags", "", "")
+	cmdFlags.StringVar(&dc, "dc", ctx.DefaultZone(), "")
+	cmdFlags.BoolVar(&wan, "wan", false, "")
+	cmdFlags.StringVar(&wanSeeds, "joinwan", "", "")
+	cmdFlags.StringVar(&keyringFile, "keyring", agent.DefaultKeyringFile, "")
+	cmdFlags.StringVar(&pool, "pool", agent.PoolLAN, "")
+	cmdFlags.BoolVar(&events, "events", false, "")
+	cmdFlags.StringVar(&eventName, "name", "", "")
+	cmdFlags.StringVar(&fire, "fire", "", "")
+	cmdFlags.StringVar(&payload, "payload", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -44,26 +64,73 @@ func (this *Agent) Run(args []string) (exitCode int) {
 		return
 	}
 
+	kr, err := agent.LoadKeyring(keyringFile)
+	if err != nil {
+		this.Ui.Error(fmt.Sprintf("keyring %s: %v", keyringFile, err))
+		return 1
+	}
+
 	if start {
-		seedNodes := make([]string, 0)
-		for _, node := range strings.Split(seeds, ",") {
-			node = strings.TrimSpace(node)
-			if node == "" {
-				continue
-			}
-
-			seedNodes = append(seedNodes, node)
-		}
-		agent.New().ServeForever(port, strings.Split(tags, ","), seedNodes...)
+		agent.New().ServeForever(agent.Config{
+			DC:       dc,
+			Port:     port,
+			Tags:     splitNodes(tags),
+			LANSeeds: splitNodes(seeds),
+			WAN:      wan,
+			WANSeeds: splitNodes(wanSeeds),
+			Keyring:  kr,
+		})
+		return
 	}
 
-	if listMembers {
-		agent.New().ListMembers(port)
+	client := agent.NewClient(port, kr)
+	switch {
+	case listMembers:
+		members, err := client.Members(pool)
+		if err != nil {
+			this.Ui.Error(err.Error())
+			return 1
+		}
+
+		for _, m := range members {
+			this.Ui.Output(fmt.Sprintf("%-25s %-21s %-8s %+v", m.Name, m.Addr, m.DC, m.Tags))
+		}
+
+	case fire != "":
+		e, err := client.Fire(fire, payload)
+		if err != nil {
+			this.Ui.Error(err.Error())
+			return 1
+		}
+
+		this.Ui.Info(fmt.Sprintf("fired %s", e.ID))
+
+	case events:
+		if err := client.Subscribe(eventName, func(e *agent.UserEvent) {
+			this.Ui.Output(fmt.Sprintf("%s %-8s %-20s %-20s %s",
+				time.Unix(e.Ctime, 0).Format("01-02 15:04:05"), e.DC, e.Node, e.Name, e.Payload))
+		}); err != nil {
+			this.Ui.Error(err.Error())
+			return 1
+		}
 	}
 
 	return
 }
 
+func splitNodes(s string) []string {
+	r := make([]string, 0)
+	for _, node := range strings.Split(s, ",") {
+		node = strings.TrimSpace(node)
+		if node == "" {
+			continue
+		}
+
+		r = append(r, node)
+	}
+	return r
+}
+
 func (*Agent) Synopsis() string {
 	return "Starts the gk agent daemon which support multiple DC"
 }
@@ -84,9 +151,24 @@ Options:
 
     -port port
       Defaults 10114
+      api port is port+1, wan gossip port is port+2
+
+    -keyring file
+      Defaults %s
+      Shared secrets one per line, the first signs api requests and encrypts gossip.
+      The others are accepted so that keys can be rotated.
+
+    -dc dc
+      Defaults %s
 
     -join seeds
-      Comma separated host:port
+      Comma separated host:port of LAN pool
+
+    -wan
+      Join the WAN pool and relay events between DCs
+
+    -joinwan seeds
+      Comma separated host:port of WAN pool
 
     -tags tags
       Comma separated tag list
@@ -94,6 +176,20 @@ Options:
     -l
       List members
 
-`, this.Cmd, this.Synopsis())
+    -pool <lan|wan>
+      Defaults lan
+
+    -fire event name
+      Broadcast a user event across DCs, e,g. cluster.down
+
+    -payload payload
+
+    -events
+      Subscribe the user events
+
+    -name prefix
+      Only subscribe events whose name has the prefix
+
+`, this.Cmd, this.Synopsis(), agent.DefaultKeyringFile, ctx.DefaultZone())
 	return strings.TrimSpace(help)
 }
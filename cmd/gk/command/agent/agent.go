@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"fmt"
 	"os"
 	"strings"
 	"sync"
@@ -8,15 +9,33 @@ import (
 	"time"
 
 	"github.com/funkygao/gafka/ctx"
-	"github.com/funkygao/golib/peer"
 	"github.com/funkygao/golib/signal"
 	log "github.com/funkygao/log4go"
 )
 
+// Config of an agent.
+type Config struct {
+	DC       string   // tagged on members and events
+	Port     int      // LAN gossip port, api port and WAN gossip port follow it
+	Tags     []string // custom tags
+	LANSeeds []string // host:port of the LAN pool
+	WAN      bool     // join the WAN pool and relay events between DCs
+	WANSeeds []string // host:port of the WAN pool
+	Keyring  *Keyring
+}
+
 // Agent provides membership, failure detection, and event broadcast.
-// TODO LAN+WAN gossip
+//
+// All agents of a DC gossip in the LAN pool, and a few agents of each DC also gossip in
+// the WAN pool. User events are gossiped in the LAN pool and relayed by the WAN agents to
+// other DCs, so the control plane keeps working even if zookeeper of a zone is unreachable.
 type Agent struct {
-	*peer.Peer
+	cf   Config
+	name string
+
+	lan, wan *pool
+	pools    []broadcaster // all the pools joined, events are gossiped in each of them
+	events   *eventBus
 
 	quit chan struct{}
 	once sync.Once
@@ -25,17 +44,25 @@ type Agent struct {
 
 func New() *Agent {
 	return &Agent{
-		quit: make(chan struct{}),
+		quit:   make(chan struct{}),
+		events: newEventBus(eventDedupSize),
 	}
 }
 
-func (a *Agent) ServeForever(port int, tags []string, seeds ...string) {
+func (a *Agent) ServeForever(cf Config) {
+	a.cf = cf
+
 	signal.RegisterHandler(func(sig os.Signal) {
 		log.Info("received signal: %s", strings.ToUpper(sig.String()))
 		log.Info("quiting...")
 
-		if err := a.Leave(time.Second * 35); err != nil {
-			log.Error("leave: %v", err)
+		if a.wan != nil {
+			if err := a.wan.Leave(time.Second * 5); err != nil {
+				log.Error("leave wan: %v", err)
+			}
+		}
+		if err := a.lan.Leave(time.Second * 30); err != nil {
+			log.Error("leave lan: %v", err)
 		}
 
 		a.once.Do(func() {
@@ -44,14 +71,103 @@ func (a *Agent) ServeForever(port int, tags []string, seeds ...string) {
 	}, syscall.SIGINT, syscall.SIGTERM)
 
 	ip, _ := ctx.LocalIP()
-	p, err := peer.New(ip.String(), port, tags, seeds, apiPort(port), false)
+	a.name = fmt.Sprintf("%s:%d", ip.String(), cf.Port)
+	meta := NodeMeta{DC: cf.DC, Tags: cf.Tags}
+
+	var err error
+	a.lan, err = newPool(PoolLAN, a.name, ip.String(), cf.Port, meta, cf.Keyring, a.onEvent)
 	if err != nil {
 		panic(err)
 	}
-	a.Peer = p
+	a.pools = append(a.pools, a.lan)
+	if len(cf.LANSeeds) > 0 {
+		if _, err = a.lan.Join(cf.LANSeeds); err != nil {
+			log.Error("join lan %+v: %v", cf.LANSeeds, err)
+		}
+	}
 
-	go a.startAPIServer(apiPort(port))
+	if cf.WAN {
+		// member names are unique across DCs in WAN pool
+		a.wan, err = newPool(PoolWAN, a.name+"."+cf.DC, ip.String(), wanPort(cf.Port), meta, cf.Keyring, a.onEvent)
+		if err != nil {
+			panic(err)
+		}
+		a.pools = append(a.pools, a.wan)
+		if len(cf.WANSeeds) > 0 {
+			if _, err = a.wan.Join(cf.WANSeeds); err != nil {
+				log.Error("join wan %+v: %v", cf.WANSeeds, err)
+			}
+		}
+	}
+
+	log.Info("agent[%s] dc:%s wan:%v ready", a.name, cf.DC, cf.WAN)
+
+	go a.startAPIServer(apiPort(cf.Port))
 
 	<-a.quit
 	log.Close()
 }
+
+// Fire originates a user event from this agent.
+func (a *Agent) Fire(name, payload string) *UserEvent {
+	now := time.Now()
+	ltime := a.events.clock.Increment()
+	e := &UserEvent{
+		ID:      newEventId(a.name, ltime, now),
+		Name:    name,
+		Payload: payload,
+		DC:      a.cf.DC,
+		Node:    a.name,
+		LTime:   ltime,
+		Ctime:   now.Unix(),
+	}
+
+	a.onEvent("", e)
+	return e
+}
+
+// onEvent delivers the event locally the first time it's seen, and gossips it on in every
+// pool joined including the one it came from: each broadcast is only retransmitted a limited
+// times, so every agent must rebroadcast to spread the event to the whole pool.
+func (a *Agent) onEvent(from string, e *UserEvent) {
+	if !a.events.Publish(e) {
+		// duplicated gossip
+		return
+	}
+
+	log.Debug("event from %s: %+v", from, e)
+
+	for _, p := range a.pools {
+		p.Broadcast(e)
+	}
+}
+
+func (a *Agent) Members(poolName string) ([]Member, error) {
+	switch poolName {
+	case PoolLAN, "":
+		return a.lan.Members(), nil
+
+	case PoolWAN:
+		if a.wan == nil {
+			return nil, ErrWanDisabled
+		}
+		return a.wan.Members(), nil
+
+	default:
+		return nil, ErrInvalidPool
+	}
+}
+
+func (a *Agent) State() map[string]interface{} {
+	state := map[string]interface{}{
+		"name":  a.name,
+		"dc":    a.cf.DC,
+		"tags":  a.cf.Tags,
+		"ltime": a.events.clock.Time(),
+		"lan":   a.lan.list.NumMembers(),
+	}
+	if a.wan != nil {
+		state["wan"] = a.wan.list.NumMembers()
+	}
+	return state
+}
@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+type stubPool struct {
+	events []*UserEvent
+}
+
+func (p *stubPool) Broadcast(e *UserEvent) {
+	p.events = append(p.events, e)
+}
+
+func TestOnEventRebroadcastInAllPools(t *testing.T) {
+	lan, wan := &stubPool{}, &stubPool{}
+	a := New()
+	a.pools = []broadcaster{lan, wan}
+
+	e := &UserEvent{ID: "1", Name: "kateway.drain", Payload: "3", DC: "sit"}
+	a.onEvent(PoolLAN, e)
+	assert.Equal(t, 1, len(lan.events))
+	assert.Equal(t, 1, len(wan.events))
+	assert.Equal(t, "1", lan.events[0].ID)
+
+	// duplicated gossip is not rebroadcast
+	a.onEvent(PoolWAN, e)
+	assert.Equal(t, 1, len(lan.events))
+	assert.Equal(t, 1, len(wan.events))
+}
@@ -1,33 +1,150 @@
 package agent
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	log "github.com/funkygao/log4go"
 )
 
+const (
+	maxApiBodySize         = 1 << 20
+	eventHeartbeatInterval = time.Second * 30
+)
+
 func (a *Agent) startAPIServer(port int) {
-	http.HandleFunc("/v1/state", a.stateHandler)
-	http.HandleFunc("/v1/members", a.membersHandler)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/state", a.auth(a.stateHandler))
+	mux.HandleFunc("/v1/members", a.auth(a.membersHandler))
+	mux.HandleFunc("/v1/event", a.auth(a.fireHandler))
+	mux.HandleFunc("/v1/events", a.auth(a.eventsHandler))
 
-	// FIXME security
 	addr := fmt.Sprintf(":%d", port)
 	log.Info("api server ready on %s", addr)
-	http.ListenAndServe(addr, nil)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Error("api server: %v", err)
+	}
 }
 
-func (a *Agent) stateHandler(w http.ResponseWriter, r *http.Request) {
-	b, _ := json.Marshal(a.State())
+// auth verifies the HMAC signature of the request with the keyring.
+func (a *Agent) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxApiBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err = a.cf.Keyring.Verify(r, body, time.Now()); err != nil {
+			log.Warn("api %s %s %s: %v", r.RemoteAddr, r.Method, r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h(w, r)
+	}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	b, _ := json.Marshal(v)
 	w.Write(b)
 }
 
+func (a *Agent) stateHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, a.State())
+}
+
+// GET /v1/members?pool=<lan|wan>
 func (a *Agent) membersHandler(w http.ResponseWriter, r *http.Request) {
-	b, _ := json.Marshal(a.State()["delegate"])
-	w.Write(b)
+	members, err := a.Members(r.URL.Query().Get("pool"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJson(w, members)
 }
 
-func (a *Agent) membersUri(port int) string {
-	return fmt.Sprintf("http://localhost:%d/v1/members", apiPort(port))
+// POST /v1/event {"name": "cluster.down", "payload": "trade"}
+func (a *Agent) fireHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name    string `json:"name"`
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, ErrInvalidEvent.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e := a.Fire(req.Name, req.Payload)
+	log.Info("api %s fired event %s %s", r.RemoteAddr, e.ID, e.Name)
+	writeJson(w, e)
+}
+
+// GET /v1/events?name=prefix
+// Streams the events as json lines until the client is gone.
+func (a *Agent) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	cn, ok := w.(http.CloseNotifier)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	prefix := r.URL.Query().Get("name")
+	ch := a.events.Subscribe()
+	defer a.events.Unsubscribe(ch)
+
+	log.Info("api %s subscribed events %s", r.RemoteAddr, prefix)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+	clientGone := cn.CloseNotify()
+	for {
+		select {
+		case <-clientGone:
+			log.Info("api %s unsubscribed events", r.RemoteAddr)
+			return
+
+		case <-a.quit:
+			return
+
+		case <-heartbeat.C:
+			// empty line keeps the idle connection alive
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case e := <-ch:
+			if !strings.HasPrefix(e.Name, prefix) {
+				continue
+			}
+
+			if _, err := w.Write(append(e.Bytes(), '\n')); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
 }
@@ -1,23 +1,99 @@
 package agent
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
-
-	"github.com/funkygao/gorequest"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
 )
 
-func (a *Agent) ListMembers(port int) {
-	_, body, _ := gorequest.New().Get(a.membersUri(port)).End()
-	v := map[string]interface{}{}
-	err := json.Unmarshal([]byte(body), &v)
+// Client talks to the api server of the local agent.
+type Client struct {
+	port    int
+	keyring *Keyring
+}
+
+func NewClient(port int, kr *Keyring) *Client {
+	return &Client{port: port, keyring: kr}
+}
+
+func (c *Client) uri(path string, query url.Values) string {
+	u := fmt.Sprintf("http://localhost:%d%s", apiPort(c.port), path)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (c *Client) do(client *http.Client, method, uri string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, uri, bytes.NewReader(body))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	b, err := json.MarshalIndent(v, "", "    ")
+	c.keyring.Sign(req, body, time.Now())
+
+	resp, err := client.Do(req)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+
+	return resp, nil
+}
+
+func (c *Client) call(method, path string, query url.Values, body []byte, v interface{}) error {
+	resp, err := c.do(&http.Client{Timeout: time.Second * 10}, method, c.uri(path, query), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *Client) Members(pool string) (members []Member, err error) {
+	err = c.call("GET", "/v1/members", url.Values{"pool": []string{pool}}, nil, &members)
+	return
+}
+
+func (c *Client) Fire(name, payload string) (*UserEvent, error) {
+	body, _ := json.Marshal(map[string]string{"name": name, "payload": payload})
+	e := &UserEvent{}
+	return e, c.call("POST", "/v1/event", nil, body, e)
+}
+
+// Subscribe streams the events whose name has the prefix until error occurs.
+func (c *Client) Subscribe(prefix string, handler func(*UserEvent)) error {
+	resp, err := c.do(&http.Client{}, "GET", c.uri("/v1/events", url.Values{"name": []string{prefix}}), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			// heartbeat
+			continue
+		}
+
+		e, err := decodeUserEvent(line)
+		if err != nil {
+			return err
+		}
+
+		handler(e)
 	}
 
-	fmt.Println(string(b))
+	return scanner.Err()
 }
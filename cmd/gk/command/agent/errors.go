@@ -0,0 +1,15 @@
+package agent
+
+import (
+	"errors"
+)
+
+var (
+	ErrEmptyKeyring     = errors.New("empty keyring")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrClockSkew        = errors.New("request date out of range")
+	ErrReplayed         = errors.New("replayed request")
+	ErrInvalidEvent     = errors.New("invalid event")
+	ErrInvalidPool      = errors.New("pool must be lan or wan")
+	ErrWanDisabled      = errors.New("agent not in wan pool")
+)
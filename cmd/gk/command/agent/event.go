@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// recent event ids remembered to drop the duplicated gossip
+	eventDedupSize = 4096
+
+	// buffered events of a subscriber, slow subscriber loses events
+	eventSubscriberBuffer = 256
+)
+
+// UserEvent is a custom event broadcast to all agents across DCs.
+//
+// e,g.
+// {"name": "cluster.down", "payload": "trade"}
+// {"name": "kateway.drain", "payload": "3"}
+type UserEvent struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Payload string `json:"payload,omitempty"`
+	DC      string `json:"dc"`   // origin DC
+	Node    string `json:"node"` // origin agent
+	LTime   uint64 `json:"ltime"`
+	Ctime   int64  `json:"ctime"`
+}
+
+func (e *UserEvent) Bytes() []byte {
+	b, _ := json.Marshal(e)
+	return b
+}
+
+func decodeUserEvent(b []byte) (*UserEvent, error) {
+	e := &UserEvent{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+	if e.ID == "" || e.Name == "" {
+		return nil, ErrInvalidEvent
+	}
+
+	return e, nil
+}
+
+// lamportClock orders the events without synchronized clocks.
+type lamportClock struct {
+	counter uint64
+}
+
+func (c *lamportClock) Time() uint64 {
+	return atomic.LoadUint64(&c.counter)
+}
+
+func (c *lamportClock) Increment() uint64 {
+	return atomic.AddUint64(&c.counter, 1)
+}
+
+// Witness updates the clock with a time seen from other agents.
+func (c *lamportClock) Witness(t uint64) {
+	for {
+		cur := atomic.LoadUint64(&c.counter)
+		if t < cur {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&c.counter, cur, t+1) {
+			return
+		}
+	}
+}
+
+// eventBus dedups the gossiped events and dispatches them to the subscribers.
+type eventBus struct {
+	clock lamportClock
+
+	mu          sync.Mutex
+	seen        map[string]struct{}
+	ring        []string // seen ids in arrival order
+	next        int
+	subscribers map[chan *UserEvent]struct{}
+}
+
+func newEventBus(size int) *eventBus {
+	return &eventBus{
+		seen:        make(map[string]struct{}, size),
+		ring:        make([]string, size),
+		subscribers: make(map[chan *UserEvent]struct{}),
+	}
+}
+
+// Publish delivers the event to subscribers, returns false if it has been seen before.
+func (b *eventBus) Publish(e *UserEvent) bool {
+	b.clock.Witness(e.LTime)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, present := b.seen[e.ID]; present {
+		return false
+	}
+
+	if old := b.ring[b.next]; old != "" {
+		delete(b.seen, old)
+	}
+	b.ring[b.next] = e.ID
+	b.next = (b.next + 1) % len(b.ring)
+	b.seen[e.ID] = struct{}{}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// never block the gossip
+		}
+	}
+
+	return true
+}
+
+func (b *eventBus) Subscribe() chan *UserEvent {
+	ch := make(chan *UserEvent, eventSubscriberBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *eventBus) Unsubscribe(ch chan *UserEvent) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
+
+func newEventId(node string, ltime uint64, now time.Time) string {
+	return node + "/" + strconv.FormatUint(ltime, 10) + "/" + strconv.FormatInt(now.UnixNano(), 36)
+}
@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestEventBusDedup(t *testing.T) {
+	b := newEventBus(2)
+	ch := b.Subscribe()
+
+	assert.Equal(t, true, b.Publish(&UserEvent{ID: "a", Name: "x", LTime: 5}))
+	assert.Equal(t, false, b.Publish(&UserEvent{ID: "a", Name: "x", LTime: 5}))
+	assert.Equal(t, uint64(6), b.clock.Time())
+	assert.Equal(t, "a", (<-ch).ID)
+
+	// the oldest id is forgotten
+	assert.Equal(t, true, b.Publish(&UserEvent{ID: "b", Name: "x"}))
+	assert.Equal(t, true, b.Publish(&UserEvent{ID: "c", Name: "x"}))
+	assert.Equal(t, false, b.Publish(&UserEvent{ID: "c", Name: "x"}))
+	assert.Equal(t, true, b.Publish(&UserEvent{ID: "a", Name: "x"}))
+
+	b.Unsubscribe(ch)
+	assert.Equal(t, 3, len(ch))
+}
+
+func TestDecodeUserEvent(t *testing.T) {
+	e := &UserEvent{ID: "1", Name: "kateway.drain", Payload: "3", DC: "sit"}
+	e1, err := decodeUserEvent(e.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, *e, *e1)
+
+	_, err = decodeUserEvent([]byte(`{"payload":"x"}`))
+	assert.Equal(t, ErrInvalidEvent, err)
+}
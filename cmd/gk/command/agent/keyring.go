@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultKeyringFile = "/etc/gkagent.keyring"
+
+	HttpHeaderDate      = "X-Gk-Date"
+	HttpHeaderSignature = "X-Gk-Signature"
+	HttpHeaderNonce     = "X-Gk-Nonce"
+
+	// max clock skew between the api client and agent
+	maxClockSkew = time.Minute * 5
+)
+
+// Keyring holds the shared secrets of the agents, the first key is the primary key that
+// signs the api requests and encrypts the gossip, the others are accepted when verifying
+// so that keys can be rotated without downtime.
+//
+// A signed request is accepted only once: its nonce is remembered until the date is
+// out of the clock skew window.
+type Keyring struct {
+	keys [][]byte
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // nonce:expires
+}
+
+func NewKeyring(keys ...string) (*Keyring, error) {
+	kr := &Keyring{nonces: make(map[string]time.Time)}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			kr.keys = append(kr.keys, []byte(key))
+		}
+	}
+
+	if len(kr.keys) == 0 {
+		return nil, ErrEmptyKeyring
+	}
+
+	return kr, nil
+}
+
+// LoadKeyring reads the keyring file with one key per line, primary key first.
+func LoadKeyring(fn string) (*Keyring, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keys = append(keys, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewKeyring(keys...)
+}
+
+// GossipKeys derives the 32 bytes AES keys of gossip encryption, primary key first.
+func (kr *Keyring) GossipKeys() [][]byte {
+	r := make([][]byte, 0, len(kr.keys))
+	for _, key := range kr.keys {
+		h := sha256.Sum256(key)
+		r = append(r, h[:])
+	}
+	return r
+}
+
+func signature(key []byte, method, path, query, date, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + path + "\n" + query + "\n" + date + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sign signs the request with the primary key, body is the request body.
+func (kr *Keyring) Sign(r *http.Request, body []byte, now time.Time) {
+	date := strconv.FormatInt(now.Unix(), 10)
+	nonce := newNonce()
+	r.Header.Set(HttpHeaderDate, date)
+	r.Header.Set(HttpHeaderNonce, nonce)
+	r.Header.Set(HttpHeaderSignature, signature(kr.keys[0], r.Method, r.URL.Path, r.URL.RawQuery, date, nonce, body))
+}
+
+// Verify checks the signature against all keys to tolerate key rotation.
+func (kr *Keyring) Verify(r *http.Request, body []byte, now time.Time) error {
+	date := r.Header.Get(HttpHeaderDate)
+	ts, err := strconv.ParseInt(date, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return ErrClockSkew
+	}
+
+	nonce := r.Header.Get(HttpHeaderNonce)
+	if nonce == "" {
+		return ErrInvalidSignature
+	}
+
+	sig := []byte(r.Header.Get(HttpHeaderSignature))
+	for _, key := range kr.keys {
+		if hmac.Equal(sig, []byte(signature(key, r.Method, r.URL.Path, r.URL.RawQuery, date, nonce, body))) {
+			return kr.remember(nonce, time.Unix(ts, 0).Add(maxClockSkew), now)
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// remember records the nonce of a verified request, ErrReplayed if seen.
+func (kr *Keyring) remember(nonce string, expires, now time.Time) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, present := kr.nonces[nonce]; present {
+		return ErrReplayed
+	}
+
+	for n, t := range kr.nonces {
+		if t.Before(now) {
+			delete(kr.nonces, n)
+		}
+	}
+	kr.nonces[nonce] = expires
+	return nil
+}
@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestKeyringSignVerify(t *testing.T) {
+	_, err := NewKeyring("", " ")
+	assert.Equal(t, ErrEmptyKeyring, err)
+
+	old, _ := NewKeyring("k1")
+	kr, _ := NewKeyring("k2", "k1")
+	now := time.Now()
+	body := []byte(`{"name":"cluster.down"}`)
+
+	r, _ := http.NewRequest("POST", "http://localhost:10115/v1/event", nil)
+	kr.Sign(r, body, now)
+	assert.Equal(t, ErrInvalidSignature, kr.Verify(r, []byte(`{}`), now))
+	assert.Equal(t, ErrClockSkew, kr.Verify(r, body, now.Add(time.Hour)))
+	assert.Equal(t, ErrInvalidSignature, old.Verify(r, body, now))
+	assert.Equal(t, nil, kr.Verify(r, body, now))
+	assert.Equal(t, ErrReplayed, kr.Verify(r, body, now))
+
+	// rotation: the old key still accepted
+	old.Sign(r, body, now)
+	assert.Equal(t, nil, kr.Verify(r, body, now))
+
+	// the query is signed
+	r, _ = http.NewRequest("GET", "http://localhost:10115/v1/members?pool=lan", nil)
+	kr.Sign(r, nil, now)
+	r.URL.RawQuery = "pool=wan"
+	assert.Equal(t, ErrInvalidSignature, kr.Verify(r, nil, now))
+
+	r.Header.Del(HttpHeaderDate)
+	assert.Equal(t, ErrInvalidSignature, kr.Verify(r, body, now))
+
+	assert.Equal(t, 2, len(kr.GossipKeys()))
+	assert.Equal(t, 32, len(kr.GossipKeys()[0]))
+	assert.Equal(t, old.GossipKeys()[0], kr.GossipKeys()[1])
+}
@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	log "github.com/funkygao/log4go"
+	"github.com/hashicorp/memberlist"
+)
+
+const (
+	PoolLAN = "lan"
+	PoolWAN = "wan"
+)
+
+// NodeMeta is gossiped with the membership.
+type NodeMeta struct {
+	DC   string   `json:"dc"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Member is a node of a gossip pool.
+type Member struct {
+	Name string   `json:"name"`
+	Addr string   `json:"addr"`
+	DC   string   `json:"dc"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// broadcaster gossips the user events in a pool.
+type broadcaster interface {
+	Broadcast(e *UserEvent)
+}
+
+// pool is a gossip pool: the LAN pool has all agents of a DC, while the WAN pool has
+// the wan enabled agents of all DCs which relay the events between DCs.
+type pool struct {
+	name string
+	meta []byte
+	list *memberlist.Memberlist
+
+	broadcasts *memberlist.TransmitLimitedQueue
+	onEvent    func(pool string, e *UserEvent)
+}
+
+func newPool(name, nodeName, bindAddr string, port int, meta NodeMeta, kr *Keyring,
+	onEvent func(pool string, e *UserEvent)) (*pool, error) {
+	var cf *memberlist.Config
+	if name == PoolWAN {
+		cf = memberlist.DefaultWANConfig()
+	} else {
+		cf = memberlist.DefaultLANConfig()
+	}
+
+	keyring, err := memberlist.NewKeyring(kr.GossipKeys(), kr.GossipKeys()[0])
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pool{name: name, onEvent: onEvent}
+	p.meta, _ = json.Marshal(meta)
+	cf.Name = nodeName
+	cf.BindAddr = bindAddr
+	cf.BindPort = port
+	cf.AdvertisePort = port
+	cf.Keyring = keyring
+	cf.Delegate = p
+	cf.Events = p
+	cf.LogOutput = ioutil.Discard
+
+	if p.list, err = memberlist.Create(cf); err != nil {
+		return nil, err
+	}
+
+	p.broadcasts = &memberlist.TransmitLimitedQueue{
+		NumNodes:       p.list.NumMembers,
+		RetransmitMult: cf.RetransmitMult,
+	}
+	return p, nil
+}
+
+func (p *pool) Join(seeds []string) (int, error) {
+	return p.list.Join(seeds)
+}
+
+func (p *pool) Leave(timeout time.Duration) error {
+	if err := p.list.Leave(timeout); err != nil {
+		return err
+	}
+
+	return p.list.Shutdown()
+}
+
+func (p *pool) Members() []Member {
+	nodes := p.list.Members()
+	r := make([]Member, 0, len(nodes))
+	for _, node := range nodes {
+		var meta NodeMeta
+		json.Unmarshal(node.Meta, &meta)
+		r = append(r, Member{
+			Name: node.Name,
+			Addr: node.Address(),
+			DC:   meta.DC,
+			Tags: meta.Tags,
+		})
+	}
+	return r
+}
+
+func (p *pool) Broadcast(e *UserEvent) {
+	p.broadcasts.QueueBroadcast(&eventBroadcast{msg: e.Bytes()})
+}
+
+// NodeMeta implements memberlist.Delegate.
+func (p *pool) NodeMeta(limit int) []byte {
+	if len(p.meta) > limit {
+		log.Warn("%s node meta too large: %d > %d", p.name, len(p.meta), limit)
+		return nil
+	}
+
+	return p.meta
+}
+
+// NotifyMsg implements memberlist.Delegate.
+func (p *pool) NotifyMsg(b []byte) {
+	e, err := decodeUserEvent(b)
+	if err != nil {
+		log.Error("%s event %s: %v", p.name, string(b), err)
+		return
+	}
+
+	p.onEvent(p.name, e)
+}
+
+// GetBroadcasts implements memberlist.Delegate.
+func (p *pool) GetBroadcasts(overhead, limit int) [][]byte {
+	return p.broadcasts.GetBroadcasts(overhead, limit)
+}
+
+// LocalState implements memberlist.Delegate.
+func (p *pool) LocalState(join bool) []byte {
+	return nil
+}
+
+// MergeRemoteState implements memberlist.Delegate.
+func (p *pool) MergeRemoteState(buf []byte, join bool) {}
+
+// NotifyJoin implements memberlist.EventDelegate.
+func (p *pool) NotifyJoin(node *memberlist.Node) {
+	log.Info("%s +%s %s", p.name, node.Name, node.Address())
+}
+
+// NotifyLeave implements memberlist.EventDelegate.
+func (p *pool) NotifyLeave(node *memberlist.Node) {
+	log.Warn("%s -%s %s", p.name, node.Name, node.Address())
+}
+
+// NotifyUpdate implements memberlist.EventDelegate.
+func (p *pool) NotifyUpdate(node *memberlist.Node) {
+	log.Info("%s ~%s %s", p.name, node.Name, node.Address())
+}
+
+type eventBroadcast struct {
+	msg []byte
+}
+
+func (b *eventBroadcast) Invalidates(other memberlist.Broadcast) bool {
+	return false
+}
+
+func (b *eventBroadcast) Message() []byte {
+	return b.msg
+}
+
+func (b *eventBroadcast) Finished() {}
@@ -3,3 +3,7 @@ package agent
 func apiPort(port int) int {
 	return port + 1
 }
+
+func wanPort(port int) int {
+	return port + 2
+}
@@ -0,0 +1,348 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/funkygao/columnize"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/zk"
+	"github.com/funkygao/gocli"
+	"github.com/funkygao/golib/color"
+)
+
+type AppMigrate struct {
+	Ui  cli.Ui
+	Cmd string
+
+	zkzone *zk.ZkZone
+	appid  string
+	force  bool
+}
+
+func (this *AppMigrate) Run(args []string) (exitCode int) {
+	var (
+		zone             string
+		from, to         string
+		start, next      bool
+		rollback, finish bool
+	)
+	cmdFlags := flag.NewFlagSet("appmigrate", flag.ContinueOnError)
+	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
+	cmdFlags.StringVar(&zone, "z", ctx.ZkDefaultZone(), "")
+	cmdFlags.StringVar(&this.appid, "app", "", "")
+	cmdFlags.StringVar(&from, "from", "", "")
+	cmdFlags.StringVar(&to, "to", "", "")
+	cmdFlags.BoolVar(&start, "start", false, "")
+	cmdFlags.BoolVar(&next, "next", false, "")
+	cmdFlags.BoolVar(&rollback, "rollback", false, "")
+	cmdFlags.BoolVar(&finish, "finish", false, "")
+	cmdFlags.BoolVar(&this.force, "force", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if validateArgs(this, this.Ui).
+		on("-start", "-app", "-from", "-to").
+		on("-next", "-app").
+		on("-rollback", "-app").
+		on("-finish", "-app").
+		requireAdminRights("-start", "-next", "-rollback", "-finish").
+		invalid(args) {
+		return 2
+	}
+
+	this.zkzone = zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
+	defer this.zkzone.Close()
+
+	switch {
+	case start:
+		return this.start(from, to)
+
+	case next:
+		return this.next()
+
+	case rollback:
+		return this.rollback()
+
+	case finish:
+		return this.finish()
+
+	case this.appid != "":
+		this.displayMigration()
+
+	default:
+		this.displayMigrations()
+	}
+
+	return
+}
+
+func (this *AppMigrate) start(from, to string) (exitCode int) {
+	if from == to {
+		this.Ui.Error("source and target cluster are the same")
+		return 1
+	}
+
+	clusters := this.zkzone.Clusters()
+	for _, c := range []string{from, to} {
+		if _, present := clusters[c]; !present {
+			this.Ui.Error(fmt.Sprintf("cluster[%s] not found", c))
+			return 1
+		}
+	}
+
+	// Pub will write the target cluster, all the topics must be ready
+	target := this.zkzone.NewCluster(to)
+	var missing []string
+	for _, topic := range this.appTopics(from) {
+		if len(target.Partitions(topic)) == 0 {
+			missing = append(missing, topic)
+		}
+	}
+	if len(missing) > 0 {
+		this.Ui.Error(fmt.Sprintf("topics not found on %s, create them first: %+v", to, missing))
+		return 1
+	}
+
+	swallow(this.zkzone.CreateMigration(this.appid, from, to))
+	this.Ui.Info(fmt.Sprintf("%s %s -> %s %s", this.appid, from, to, zk.MigrationDualWrite))
+	return
+}
+
+func (this *AppMigrate) next() (exitCode int) {
+	m, err := this.zkzone.Migration(this.appid)
+	swallow(err)
+
+	if m.State == zk.MigrationCutover {
+		if pending := this.undrainedGroups(m); len(pending) > 0 && !this.force {
+			this.Ui.Error(fmt.Sprintf("groups still draining %s, -force to skip them: %+v", m.Source, pending))
+			return 1
+		}
+	}
+
+	m, err = this.zkzone.AdvanceMigration(this.appid)
+	swallow(err)
+
+	this.Ui.Info(fmt.Sprintf("%s %s -> %s %s", this.appid, m.Source, m.Target, m.State))
+	if m.State == zk.MigrationDone {
+		this.Ui.Output(fmt.Sprintf("map %s to cluster %s in manager, then: %s appmigrate -app %s -finish",
+			this.appid, m.Target, this.Cmd, this.appid))
+	}
+	return
+}
+
+func (this *AppMigrate) rollback() (exitCode int) {
+	m, err := this.zkzone.Migration(this.appid)
+	swallow(err)
+
+	switch m.State {
+	case zk.MigrationDualWrite:
+
+	case zk.MigrationCutover:
+		if !this.force {
+			this.Ui.Error(fmt.Sprintf("messages published since %s are only on %s, -force to rollback anyway",
+				m.CutoverTime(), m.Target))
+			return 1
+		}
+
+	default:
+		this.Ui.Error(fmt.Sprintf("%s is %s, cannot rollback", this.appid, m.State))
+		return 1
+	}
+
+	swallow(this.zkzone.DeleteMigration(this.appid))
+	this.Ui.Info(fmt.Sprintf("%s rolled back to %s", this.appid, m.Source))
+	return
+}
+
+func (this *AppMigrate) finish() (exitCode int) {
+	m, err := this.zkzone.Migration(this.appid)
+	swallow(err)
+
+	if m.State != zk.MigrationDone {
+		this.Ui.Error(fmt.Sprintf("%s is %s, not done yet", this.appid, m.State))
+		return 1
+	}
+
+	swallow(this.zkzone.DeleteMigration(this.appid))
+	this.Ui.Info(fmt.Sprintf("%s migrated to %s", this.appid, m.Target))
+	return
+}
+
+// appTopics returns the raw kafka topics of the app, including the shadow topics.
+func (this *AppMigrate) appTopics(cluster string) []string {
+	topics, err := this.zkzone.NewCluster(cluster).Topics()
+	swallow(err)
+
+	r := make([]string, 0)
+	for _, topic := range topics {
+		if strings.HasPrefix(topic, this.appid+".") {
+			r = append(r, topic)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// groupLags returns {topic: {group: lag}} of the app on the source cluster.
+func (this *AppMigrate) groupLags(m *zk.MigrationMeta) map[string]map[string]int64 {
+	source := this.zkzone.NewCluster(m.Source)
+	r := make(map[string]map[string]int64)
+	for _, topic := range this.appTopics(m.Source) {
+		consumers, err := source.ConsumerGroupsOfTopic(topic)
+		swallow(err)
+
+		r[topic] = make(map[string]int64)
+		for group, partitions := range consumers {
+			for _, p := range partitions {
+				r[topic][group] += p.Lag
+			}
+		}
+	}
+
+	return r
+}
+
+func (this *AppMigrate) undrainedGroups(m *zk.MigrationMeta) []string {
+	drained := this.drainedSet(m.Appid)
+	r := make([]string, 0)
+	for topic, groups := range this.groupLags(m) {
+		for group := range groups {
+			if !drained[group+":"+topic] {
+				r = append(r, group+":"+topic)
+			}
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+func (this *AppMigrate) drainedSet(appid string) map[string]bool {
+	r := make(map[string]bool)
+	for group, topics := range this.zkzone.MigrationDrainedGroups(appid) {
+		for _, topic := range topics {
+			r[group+":"+topic] = true
+		}
+	}
+	return r
+}
+
+func (this *AppMigrate) displayMigrations() {
+	migrations := this.zkzone.Migrations()
+	appids := make([]string, 0, len(migrations))
+	for appid := range migrations {
+		appids = append(appids, appid)
+	}
+	sort.Strings(appids)
+
+	lines := []string{"App|From|To|State|Cutover|Since"}
+	for _, appid := range appids {
+		m := migrations[appid]
+		cutover := "-"
+		if m.CutoverAt > 0 {
+			cutover = m.CutoverTime().Format("2006-01-02 15:04:05")
+		}
+		lines = append(lines, fmt.Sprintf("%s|%s|%s|%s|%s|%s", appid, m.Source, m.Target, m.State,
+			cutover, time.Since(time.Unix(m.Mtime, 0))))
+	}
+
+	if len(lines) > 1 {
+		this.Ui.Output(columnize.SimpleFormat(lines))
+	}
+}
+
+func (this *AppMigrate) displayMigration() {
+	m, err := this.zkzone.Migration(this.appid)
+	swallow(err)
+
+	this.Ui.Output(fmt.Sprintf("%s %s -> %s %s since %s", m.Appid, m.Source, m.Target,
+		color.Green("%s", m.State), time.Unix(m.Mtime, 0)))
+	if m.CutoverAt > 0 {
+		this.Ui.Output(fmt.Sprintf("cutover at %s", m.CutoverTime()))
+	}
+
+	drained := this.drainedSet(m.Appid)
+	lags := this.groupLags(m)
+	topics := make([]string, 0, len(lags))
+	for topic := range lags {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	lines := []string{"Topic|Group|SourceLag|Drained"}
+	for _, topic := range topics {
+		groups := make([]string, 0, len(lags[topic]))
+		for group := range lags[topic] {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+
+		for _, group := range groups {
+			lines = append(lines, fmt.Sprintf("%s|%s|%d|%v", topic, group, lags[topic][group],
+				drained[group+":"+topic]))
+		}
+	}
+
+	if len(lines) > 1 {
+		this.Ui.Output(columnize.SimpleFormat(lines))
+	}
+}
+
+func (*AppMigrate) Synopsis() string {
+	return "Migrate an app to another cluster without downtime"
+}
+
+func (this *AppMigrate) Help() string {
+	help := fmt.Sprintf(`
+Usage: %s appmigrate [options]
+
+    %s
+
+    The migration goes through the following states:
+    dualwrite: kateway Pub writes both clusters, Sub stays on the source cluster
+    cutover:   kateway Pub writes the target cluster, each consumer group drains
+               the source cluster then moves to the target cluster
+    done:      Pub/Sub are both on the target cluster
+
+    e,g.
+      gk appmigrate -z prod -app app1 -from trade -to trade2 -start
+      gk appmigrate -z prod -app app1 -next
+      gk appmigrate -z prod -app app1
+      gk appmigrate -z prod -app app1 -next
+      gk appmigrate -z prod -app app1 -finish
+
+Options:
+
+    -z zone
+
+    -app appid
+      Display the consumer groups draining status if present alone.
+
+    -from cluster
+      Source cluster the app currently resides.
+
+    -to cluster
+      Target cluster, the topics of the app must be created in advance.
+
+    -start
+      Start migration in dualwrite state.
+
+    -next
+      Advance the migration to the next state.
+
+    -rollback
+      Stop the migration and Pub/Sub goes back to the source cluster.
+
+    -finish
+      Cleanup the done migration after the app is mapped to the target cluster in manager.
+
+    -force
+      Skip the safety check of -next and -rollback.
+
+`, this.Cmd, this.Synopsis())
+	return strings.TrimSpace(help)
+}
@@ -0,0 +1,305 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/funkygao/columnize"
+	"github.com/funkygao/gafka/cmd/gk/command/archive"
+	"github.com/funkygao/gafka/cmd/kateway/meta"
+	"github.com/funkygao/gafka/cmd/kateway/meta/zkmeta"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/gafka/cmd/kateway/store/kafka"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/sla"
+	"github.com/funkygao/gafka/zk"
+	"github.com/funkygao/gocli"
+	"github.com/funkygao/golib/gofmt"
+)
+
+type Archive struct {
+	Ui  cli.Ui
+	Cmd string
+
+	zone, cluster string
+	storeUri      string
+}
+
+func (this *Archive) Run(args []string) (exitCode int) {
+	var (
+		start         bool
+		list          bool
+		enable        string
+		disable       string
+		chunkMB       int64
+		chunkInterval time.Duration
+		logLevel      string
+		replayTopic   string
+		from, to      string
+		targetCluster string
+		targetTopic   string
+		shadow        string
+		group         string
+		dryRun        bool
+	)
+	cmdFlags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
+	cmdFlags.StringVar(&this.zone, "z", ctx.ZkDefaultZone(), "")
+	cmdFlags.StringVar(&this.cluster, "c", "", "")
+	cmdFlags.StringVar(&this.storeUri, "store", "", "")
+	cmdFlags.BoolVar(&start, "start", false, "")
+	cmdFlags.BoolVar(&list, "l", false, "")
+	cmdFlags.StringVar(&enable, "enable", "", "")
+	cmdFlags.StringVar(&disable, "disable", "", "")
+	cmdFlags.Int64Var(&chunkMB, "chunk", 64, "")
+	cmdFlags.DurationVar(&chunkInterval, "interval", time.Minute*10, "")
+	cmdFlags.StringVar(&logLevel, "level", "trace", "")
+	cmdFlags.StringVar(&replayTopic, "replay", "", "")
+	cmdFlags.StringVar(&from, "from", "", "")
+	cmdFlags.StringVar(&to, "to", "", "")
+	cmdFlags.StringVar(&targetCluster, "tc", "", "")
+	cmdFlags.StringVar(&targetTopic, "target", "", "")
+	cmdFlags.StringVar(&shadow, "shadow", "", "")
+	cmdFlags.StringVar(&group, "group", "", "")
+	cmdFlags.BoolVar(&dryRun, "dryrun", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if validateArgs(this, this.Ui).
+		require("-c").
+		on("-start", "-store").
+		on("-l", "-store").
+		on("-replay", "-store", "-from", "-to").
+		on("-shadow", "-replay", "-group").
+		requireAdminRights("-enable", "-disable", "-replay").
+		invalid(args) {
+		return 2
+	}
+
+	zkzone := zk.NewZkZone(zk.DefaultConfig(this.zone, ctx.ZoneZkAddrs(this.zone)))
+
+	switch {
+	case enable != "":
+		swallow(zkzone.SetTopicArchive(this.cluster, enable, true))
+		this.Ui.Info(fmt.Sprintf("%s/%s archive on", this.cluster, enable))
+		return
+
+	case disable != "":
+		swallow(zkzone.SetTopicArchive(this.cluster, disable, false))
+		this.Ui.Info(fmt.Sprintf("%s/%s archive off", this.cluster, disable))
+		return
+	}
+
+	objectStore, err := archive.OpenStore(this.storeUri)
+	if err != nil {
+		this.Ui.Error(fmt.Sprintf("%s: %v", this.storeUri, err))
+		return 1
+	}
+
+	switch {
+	case list:
+		this.listArchives(zkzone, objectStore)
+
+	case replayTopic != "":
+		if targetCluster == "" {
+			targetCluster = this.cluster
+		}
+		if shadow != "" {
+			if !sla.ValidateShadowName(shadow) {
+				this.Ui.Error("invalid shadow, must be retry or dead")
+				return 2
+			}
+
+			// {appid}.{topic}.{ver}.{myAppid}.{group}.{shadow}, group is myAppid.group
+			targetTopic = replayTopic + "." + group + "." + shadow
+		}
+		if targetTopic == "" {
+			this.Ui.Error("-target or -shadow required")
+			return 2
+		}
+
+		return this.replay(zkzone, objectStore, replayTopic, from, to, targetCluster, targetTopic, shadow == "", dryRun)
+
+	case start:
+		cf := archive.DefaultConfig()
+		cf.Zone = this.zone
+		cf.Cluster = this.cluster
+		cf.Store = objectStore
+		cf.ChunkBytes = chunkMB << 20
+		cf.ChunkInterval = chunkInterval
+
+		setupLogging("archive.log", logLevel, "panic")
+		return archive.New(cf).Main()
+	}
+
+	return
+}
+
+func (this *Archive) listArchives(zkzone *zk.ZkZone, objectStore archive.ObjectStore) {
+	lines := []string{"Topic|Chunks|Partitions|From|To"}
+	for _, topic := range zkzone.ArchivedTopics(this.cluster) {
+		chunks, err := archive.ListChunks(objectStore, this.cluster, topic)
+		swallow(err)
+
+		if len(chunks) == 0 {
+			lines = append(lines, fmt.Sprintf("%s|0|0|-|-", topic))
+			continue
+		}
+
+		partitions := make(map[int32]struct{})
+		minTs, maxTs := chunks[0].MinTs, chunks[0].MaxTs
+		for _, c := range chunks {
+			partitions[c.Partition] = struct{}{}
+			if c.MinTs < minTs {
+				minTs = c.MinTs
+			}
+			if c.MaxTs > maxTs {
+				maxTs = c.MaxTs
+			}
+		}
+
+		lines = append(lines, fmt.Sprintf("%s|%d|%d|%s|%s", topic, len(chunks), len(partitions),
+			msToTime(minTs).Format(time.RFC3339), msToTime(maxTs).Format(time.RFC3339)))
+	}
+
+	this.Ui.Output(columnize.SimpleFormat(lines))
+}
+
+func msToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+func (this *Archive) replay(zkzone *zk.ZkZone, objectStore archive.ObjectStore, topic, from, to,
+	targetCluster, targetTopic string, ensureTopic, dryRun bool) (exitCode int) {
+	fromTime, err := zk.ParseOffsetTime(from)
+	swallow(err)
+	toTime, err := zk.ParseOffsetTime(to)
+	swallow(err)
+
+	if ensureTopic && !dryRun {
+		// replay into a new topic with the same SLA
+		target := zkzone.NewCluster(targetCluster)
+		if len(target.Partitions(targetTopic)) == 0 {
+			ts, err := zkzone.NewCluster(this.cluster).TopicSla(topic)
+			swallow(err)
+			ts.Archive = false
+
+			this.Ui.Info(fmt.Sprintf("creating %s/%s with %+v", targetCluster, targetTopic, *ts))
+			_, err = target.AddTopic(targetTopic, ts)
+			swallow(err)
+		}
+	}
+
+	// meta pkg is required for store pkg
+	meta.Default = zkmeta.New(zkmeta.DefaultConfig(), zkzone)
+	meta.Default.Start()
+	defer meta.Default.Stop()
+
+	store.DefaultPubStore = kafka.NewPubStore(1, 0, false, false, dryRun)
+	swallow(store.DefaultPubStore.Start())
+	defer store.DefaultPubStore.Stop()
+
+	r := &archive.Replay{
+		Store:         objectStore,
+		Cluster:       this.cluster,
+		Topic:         topic,
+		From:          fromTime,
+		To:            toTime,
+		TargetCluster: targetCluster,
+		TargetTopic:   targetTopic,
+		DryRun:        dryRun,
+		Progress: func(n int64, m archive.ChunkMeta) {
+			this.Ui.Output(fmt.Sprintf("%s %s", gofmt.Comma(n), m.Key()))
+		},
+	}
+	n, err := r.Run()
+	if err != nil {
+		this.Ui.Error(fmt.Sprintf("replayed %d: %v", n, err))
+		return 1
+	}
+
+	this.Ui.Info(fmt.Sprintf("replayed %s messages %s -> %s/%s", gofmt.Comma(n), topic, targetCluster, targetTopic))
+	return
+}
+
+func (*Archive) Synopsis() string {
+	return "Archive topics to object storage and replay them back to kafka"
+}
+
+func (this *Archive) Help() string {
+	help := fmt.Sprintf(`
+Usage: %s archive [options]
+
+    %s
+
+    Topics with archive SLA flag on are continuously copied into gzip chunks indexed
+    by offset and time, replay republishes an archived time range through kateway pub store.
+
+    e,g.
+    gk archive -c trade -enable app1.foo.v1
+    gk archive -c trade -store s3://archive/kafka?endpoint=s3.internal:9000 -start
+    gk archive -c trade -store file:///data/archive -replay app1.foo.v1 -from 2017-01-02T00:00:00+08:00 -to 2017-01-03T00:00:00+08:00 -target app1.foo_replay.v1
+    gk archive -c trade -store file:///data/archive -replay app1.foo.v1 -from 1483286400 -to 1483372800 -shadow retry -group app2.g1
+
+Options:
+
+    -z zone
+      Default %s
+
+    -c cluster
+
+    -store uri
+      file:///dir or s3://bucket/prefix?endpoint=host:port&region=us-east-1&secure=0
+      s3 credentials are read from env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+
+    -enable topic
+      Turn on the archive SLA flag of a topic.
+
+    -disable topic
+      Turn off the archive SLA flag of a topic, archived chunks are kept.
+
+    -l
+      List archived topics.
+
+    -start
+      Run the archiver of the cluster.
+
+    -chunk n
+      Max raw MB of a chunk, default 64.
+
+    -interval duration
+      Max age of a chunk, default 10m.
+
+    -level log level
+      Default trace.
+
+    -replay topic
+      Replay the archived topic.
+
+    -from time
+      Unix timestamp in seconds or RFC3339, inclusive.
+
+    -to time
+      Unix timestamp in seconds or RFC3339, exclusive.
+
+    -tc target cluster
+      Default the archived cluster.
+
+    -target topic
+      Replay into the topic, created with the source SLA if not exists.
+
+    -shadow <retry|dead>
+      Replay into the shadow topic of a group.
+
+    -group appid.group
+      The group that owns the shadow topic.
+
+    -dryrun
+      Count the messages to replay without pub.
+
+`, this.Cmd, this.Synopsis(), ctx.ZkDefaultZone())
+	return strings.TrimSpace(help)
+}
@@ -0,0 +1,319 @@
+package archive
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/zk"
+	"github.com/funkygao/golib/gofmt"
+	"github.com/funkygao/golib/signal"
+	"github.com/funkygao/kafka-cg/consumergroup"
+	log "github.com/funkygao/log4go"
+)
+
+type Config struct {
+	Zone          string
+	Cluster       string
+	Store         ObjectStore
+	ChunkBytes    int64         // flush a chunk when its raw bytes reach
+	ChunkInterval time.Duration // flush a chunk when it's older than
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		ChunkBytes:    defaultChunkBytes,
+		ChunkInterval: defaultChunkInterval,
+	}
+}
+
+// Archiver continuously copies the archived topics of a cluster into object store.
+//
+// Topics are archived if the archive flag of their SLA is on. Each partition is cut
+// into gzip chunks by size and age, and the offsets are committed only after the chunk
+// is stored: after crash, the messages are archived again and replay skips duplicates.
+type Archiver struct {
+	Config
+
+	quit chan struct{}
+	once sync.Once
+
+	archivedN     int64
+	archivedBytes int64
+	chunks        int64
+}
+
+func New(cf *Config) *Archiver {
+	return &Archiver{Config: *cf}
+}
+
+func (this *Archiver) Main() (exitCode int) {
+	this.quit = make(chan struct{})
+	signal.RegisterHandler(func(sig os.Signal) {
+		log.Info("received signal: %s", strings.ToUpper(sig.String()))
+		log.Info("quiting...")
+
+		this.once.Do(func() {
+			close(this.quit)
+		})
+	}, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Info("starting archiver@%s [%s/%s] -> %s", gafka.BuildId, this.Zone, this.Cluster, this.Store.Name())
+
+	zkzone := zk.NewZkZone(zk.DefaultConfig(this.Zone, ctx.ZoneZkAddrs(this.Zone)))
+	zkcluster := zkzone.NewCluster(this.Cluster)
+	startedAt := time.Now()
+
+	this.runArchiver(zkcluster)
+
+	log.Info("total archived: %s %smsgs in %d chunks",
+		gofmt.ByteSize(this.archivedBytes), gofmt.Comma(this.archivedN), this.chunks)
+	log.Info("bye archiver@%s, %s", gafka.BuildId, time.Since(startedAt))
+	log.Close()
+
+	return
+}
+
+func (this *Archiver) runArchiver(zkcluster *zk.ZkCluster) {
+	group := GroupName(this.Cluster)
+	round := 0
+	for {
+		round++
+
+		topics, topicsChanges, err := zkcluster.WatchTopics()
+		if err != nil {
+			log.Error("#%d watch topics: %v", round, err)
+			time.Sleep(time.Second * 10)
+			continue
+		}
+
+		archived := this.archivedTopics(zkcluster, topics)
+		if len(archived) == 0 {
+			log.Info("#%d no archived topics", round)
+
+			select {
+			case <-topicsChanges:
+			case <-time.After(archivedRecheck):
+			case <-this.quit:
+				return
+			}
+			continue
+		}
+
+		sub, err := this.makeSub(zkcluster, group, archived)
+		if err != nil {
+			log.Error("#%d %v", round, err)
+			time.Sleep(time.Second * 10)
+			continue
+		}
+
+		log.Info("#%d starting pump %d topics with group %s for %+v", round, len(archived), group, archived)
+
+		pumpStopper := make(chan struct{})
+		pumpStopped := make(chan struct{})
+		go this.pump(sub, pumpStopper, pumpStopped)
+
+		recheck := time.NewTicker(archivedRecheck)
+		for running := true; running; {
+			select {
+			case <-topicsChanges:
+				log.Warn("#%d topics changed, stopping pump...", round)
+				close(pumpStopper)
+				<-pumpStopped
+				running = false
+
+			case <-recheck.C:
+				if latest := this.archivedTopics(zkcluster, topics); !sameTopics(latest, archived) {
+					log.Warn("#%d archived topics changed %+v, stopping pump...", round, latest)
+					close(pumpStopper)
+					<-pumpStopped
+					running = false
+				}
+
+			case <-this.quit:
+				log.Info("#%d awaiting pump cleanup...", round)
+				<-pumpStopped
+				recheck.Stop()
+				return
+
+			case <-pumpStopped:
+				// pump encounters problems, just retry
+				running = false
+			}
+		}
+		recheck.Stop()
+	}
+}
+
+// archivedTopics returns the sorted existing topics whose archive flag is on.
+func (this *Archiver) archivedTopics(zkcluster *zk.ZkCluster, topics []string) []string {
+	existing := make(map[string]struct{}, len(topics))
+	for _, t := range topics {
+		existing[t] = struct{}{}
+	}
+
+	var r []string
+	for _, t := range zkcluster.ZkZone().ArchivedTopics(this.Cluster) {
+		if _, present := existing[t]; present {
+			r = append(r, t)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+func sameTopics(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *Archiver) makeSub(zkcluster *zk.ZkCluster, group string, topics []string) (*consumergroup.ConsumerGroup, error) {
+	cf := consumergroup.NewConfig()
+	cf.Zookeeper.Chroot = zkcluster.Chroot()
+	cf.Offsets.CommitInterval = time.Second * 10
+	cf.Offsets.ProcessingTimeout = time.Second
+	cf.Consumer.Offsets.Initial = sarama.OffsetOldest // archive all that are still retained
+	cf.ChannelBufferSize = 256
+	cf.Consumer.Return.Errors = true
+	cf.OneToOne = false
+
+	return consumergroup.JoinConsumerGroup(group, topics, zkcluster.ZkZone().ZkAddrList(), cf)
+}
+
+func (this *Archiver) pump(sub *consumergroup.ConsumerGroup, stop, stopped chan struct{}) {
+	chunks := make(map[string]map[int32]*chunkWriter) // topic:partition:chunk
+	flushTicker := time.NewTicker(flushCheckInterval)
+
+	defer func() {
+		flushTicker.Stop()
+
+		// flush the partial chunks so that offsets can be committed
+		for _, partitions := range chunks {
+			for _, w := range partitions {
+				this.flush(sub, w)
+			}
+		}
+
+		log.Trace("closing sub, commit offsets...")
+		sub.Close()
+
+		close(stopped)
+	}()
+
+	for {
+		select {
+		case <-this.quit:
+			return
+
+		case <-stop:
+			return
+
+		case <-flushTicker.C:
+			now := time.Now()
+			for _, partitions := range chunks {
+				for partition, w := range partitions {
+					if now.Sub(w.ctime) < this.ChunkInterval {
+						continue
+					}
+
+					if err := this.flush(sub, w); err != nil {
+						return
+					}
+					delete(partitions, partition)
+				}
+			}
+
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				log.Warn("sub encounters end of message stream")
+				return
+			}
+
+			if _, present := chunks[msg.Topic]; !present {
+				chunks[msg.Topic] = make(map[int32]*chunkWriter)
+			}
+			w, present := chunks[msg.Topic][msg.Partition]
+			if !present {
+				w = newChunkWriter(this.Cluster, msg.Topic, msg.Partition, time.Now())
+				chunks[msg.Topic][msg.Partition] = w
+			}
+
+			ts := msg.Timestamp
+			if ts.IsZero() {
+				// message format before kafka 0.10
+				ts = time.Now()
+			}
+			if err := w.Append(Record{
+				Offset:    msg.Offset,
+				Timestamp: ts.UnixNano() / int64(time.Millisecond),
+				Key:       msg.Key,
+				Value:     msg.Value,
+			}); err != nil {
+				log.Error("%s/%d %d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+				return
+			}
+
+			if w.rawBytes >= this.ChunkBytes {
+				if err := this.flush(sub, w); err != nil {
+					return
+				}
+				delete(chunks[msg.Topic], msg.Partition)
+			}
+
+		case err := <-sub.Errors():
+			log.Error("quitting pump %v", err)
+			return
+		}
+	}
+}
+
+// flush stores the chunk and then commits its offsets.
+func (this *Archiver) flush(sub *consumergroup.ConsumerGroup, w *chunkWriter) (err error) {
+	if w.Empty() {
+		return nil
+	}
+
+	key, data, err := w.Close()
+	if err != nil {
+		log.Error("%s: %v", w.meta.Key(), err)
+		return
+	}
+
+	for i := 0; i < putRetries; i++ {
+		if err = this.Store.Put(key, data); err == nil {
+			break
+		}
+
+		log.Error("#%d put %s: %v", i+1, key, err)
+		time.Sleep(time.Second * time.Duration(i+1))
+	}
+	if err != nil {
+		// offsets not committed, will be archived again
+		return
+	}
+
+	this.archivedN += int64(w.n)
+	this.archivedBytes += w.rawBytes
+	this.chunks++
+	log.Trace("archived %s %d msgs %s -> %s", key, w.n, gofmt.ByteSize(w.rawBytes), gofmt.ByteSize(len(data)))
+
+	sub.CommitUpto(&sarama.ConsumerMessage{
+		Topic:     w.meta.Topic,
+		Partition: w.meta.Partition,
+		Offset:    w.meta.Last,
+	})
+	return
+}
@@ -0,0 +1,231 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is an archived kafka message.
+type Record struct {
+	Offset    int64
+	Timestamp int64 // unix ms, archived time if the message has no timestamp
+	Key       []byte
+	Value     []byte
+}
+
+// ChunkMeta indexes a chunk by offset and time range, it's encoded in the object key
+// so that the chunks of a time range are located by listing without reading them.
+//
+// {cluster}/{topic}/{partition}/{first offset}-{last offset}-{min ts}-{max ts}.gz
+type ChunkMeta struct {
+	Cluster   string
+	Topic     string
+	Partition int32
+	First     int64
+	Last      int64
+	MinTs     int64
+	MaxTs     int64
+}
+
+func (m ChunkMeta) Key() string {
+	return fmt.Sprintf("%s%020d-%020d-%d-%d.gz", chunkPrefix(m.Cluster, m.Topic, m.Partition),
+		m.First, m.Last, m.MinTs, m.MaxTs)
+}
+
+// Overlaps checks whether the chunk has records in [from, to) unix ms.
+func (m ChunkMeta) Overlaps(from, to int64) bool {
+	return m.MaxTs >= from && m.MinTs < to
+}
+
+func chunkPrefix(cluster, topic string, partition int32) string {
+	return fmt.Sprintf("%s/%s/%d/", cluster, topic, partition)
+}
+
+func topicPrefix(cluster, topic string) string {
+	return cluster + "/" + topic + "/"
+}
+
+func ParseChunkKey(key string) (m ChunkMeta, err error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 || !strings.HasSuffix(parts[3], ".gz") {
+		return m, ErrInvalidChunkKey
+	}
+
+	m.Cluster, m.Topic = parts[0], parts[1]
+	p, err := strconv.ParseInt(parts[2], 10, 32)
+	if err != nil {
+		return m, ErrInvalidChunkKey
+	}
+	m.Partition = int32(p)
+
+	fields := strings.Split(strings.TrimSuffix(parts[3], ".gz"), "-")
+	if len(fields) != 4 {
+		return m, ErrInvalidChunkKey
+	}
+	var n [4]int64
+	for i, f := range fields {
+		if n[i], err = strconv.ParseInt(f, 10, 64); err != nil {
+			return m, ErrInvalidChunkKey
+		}
+	}
+	m.First, m.Last, m.MinTs, m.MaxTs = n[0], n[1], n[2], n[3]
+	return m, nil
+}
+
+// ListChunks returns the chunks of a topic ordered by partition and offset.
+func ListChunks(store ObjectStore, cluster, topic string) ([]ChunkMeta, error) {
+	keys, err := store.List(topicPrefix(cluster, topic))
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]ChunkMeta, 0, len(keys))
+	for _, key := range keys {
+		m, err := ParseChunkKey(key)
+		if err != nil {
+			// not a chunk
+			continue
+		}
+
+		r = append(r, m)
+	}
+
+	sort.Sort(chunksByOffset(r))
+	return r, nil
+}
+
+type chunksByOffset []ChunkMeta
+
+func (c chunksByOffset) Len() int      { return len(c) }
+func (c chunksByOffset) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c chunksByOffset) Less(i, j int) bool {
+	if c[i].Partition != c[j].Partition {
+		return c[i].Partition < c[j].Partition
+	}
+	return c[i].First < c[j].First
+}
+
+// chunkWriter buffers the records of a partition into a gzip chunk.
+//
+// record: offset(8) timestamp(8) key len(4, -1 for nil) key value len(4) value
+type chunkWriter struct {
+	meta     ChunkMeta
+	n        int
+	rawBytes int64
+	ctime    time.Time
+
+	buf bytes.Buffer
+	gz  *gzip.Writer
+}
+
+func newChunkWriter(cluster, topic string, partition int32, now time.Time) *chunkWriter {
+	w := &chunkWriter{
+		meta:  ChunkMeta{Cluster: cluster, Topic: topic, Partition: partition},
+		ctime: now,
+	}
+	w.gz = gzip.NewWriter(&w.buf)
+	return w
+}
+
+func (w *chunkWriter) Append(r Record) error {
+	if w.n == 0 {
+		w.meta.First, w.meta.MinTs, w.meta.MaxTs = r.Offset, r.Timestamp, r.Timestamp
+	}
+	w.meta.Last = r.Offset
+	if r.Timestamp < w.meta.MinTs {
+		w.meta.MinTs = r.Timestamp
+	}
+	if r.Timestamp > w.meta.MaxTs {
+		w.meta.MaxTs = r.Timestamp
+	}
+
+	var header [20]byte
+	binary.BigEndian.PutUint64(header[0:], uint64(r.Offset))
+	binary.BigEndian.PutUint64(header[8:], uint64(r.Timestamp))
+	keyLen := int32(len(r.Key))
+	if r.Key == nil {
+		keyLen = -1
+	}
+	binary.BigEndian.PutUint32(header[16:], uint32(keyLen))
+	if _, err := w.gz.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.gz.Write(r.Key); err != nil {
+		return err
+	}
+
+	var valueLen [4]byte
+	binary.BigEndian.PutUint32(valueLen[:], uint32(len(r.Value)))
+	if _, err := w.gz.Write(valueLen[:]); err != nil {
+		return err
+	}
+	if _, err := w.gz.Write(r.Value); err != nil {
+		return err
+	}
+
+	w.n++
+	w.rawBytes += int64(len(header) + len(r.Key) + len(valueLen) + len(r.Value))
+	return nil
+}
+
+func (w *chunkWriter) Empty() bool {
+	return w.n == 0
+}
+
+// Close returns the object key and data of the chunk.
+func (w *chunkWriter) Close() (string, []byte, error) {
+	if err := w.gz.Close(); err != nil {
+		return "", nil, err
+	}
+
+	return w.meta.Key(), w.buf.Bytes(), nil
+}
+
+// ReadChunk iterates the records of a chunk.
+func ReadChunk(data []byte, fn func(Record) error) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	var header [20]byte
+	for {
+		if _, err = io.ReadFull(gz, header[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return ErrCorruptedChunk
+		}
+
+		r := Record{
+			Offset:    int64(binary.BigEndian.Uint64(header[0:])),
+			Timestamp: int64(binary.BigEndian.Uint64(header[8:])),
+		}
+		if keyLen := int32(binary.BigEndian.Uint32(header[16:])); keyLen >= 0 {
+			r.Key = make([]byte, keyLen)
+			if _, err = io.ReadFull(gz, r.Key); err != nil {
+				return ErrCorruptedChunk
+			}
+		}
+
+		var valueLen [4]byte
+		if _, err = io.ReadFull(gz, valueLen[:]); err != nil {
+			return ErrCorruptedChunk
+		}
+		r.Value = make([]byte, binary.BigEndian.Uint32(valueLen[:]))
+		if _, err = io.ReadFull(gz, r.Value); err != nil {
+			return ErrCorruptedChunk
+		}
+
+		if err = fn(r); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestChunkRoundTrip(t *testing.T) {
+	w := newChunkWriter("trade", "app1.foo.v1", 2, time.Now())
+	assert.Equal(t, true, w.Empty())
+	w.Append(Record{Offset: 10, Timestamp: 2000, Key: []byte("k"), Value: []byte("hello")})
+	w.Append(Record{Offset: 11, Timestamp: 1000, Value: []byte("world")})
+	w.Append(Record{Offset: 13, Timestamp: 3000, Key: []byte{}, Value: nil})
+	assert.Equal(t, 3, w.n)
+
+	key, data, err := w.Close()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "trade/app1.foo.v1/2/00000000000000000010-00000000000000000013-1000-3000.gz", key)
+
+	m, err := ParseChunkKey(key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, w.meta, m)
+	assert.Equal(t, true, m.Overlaps(0, 1001))
+	assert.Equal(t, true, m.Overlaps(3000, 4000))
+	assert.Equal(t, false, m.Overlaps(3001, 4000))
+	assert.Equal(t, false, m.Overlaps(0, 1000))
+
+	var records []Record
+	assert.Equal(t, nil, ReadChunk(data, func(r Record) error {
+		records = append(records, r)
+		return nil
+	}))
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, "k", string(records[0].Key))
+	assert.Equal(t, "hello", string(records[0].Value))
+	assert.Equal(t, true, records[1].Key == nil)
+	assert.Equal(t, int64(1000), records[1].Timestamp)
+	assert.Equal(t, false, records[2].Key == nil)
+	assert.Equal(t, int64(13), records[2].Offset)
+
+	assert.Equal(t, ErrCorruptedChunk, ReadChunk(data[:len(data)-10], func(r Record) error { return nil }))
+}
+
+func TestParseChunkKeyInvalid(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"trade/foo/0/1-2-3-4",
+		"trade/foo/x/1-2-3-4.gz",
+		"trade/foo/0/1-2-3.gz",
+		"trade/foo/0/1-2-3-x.gz",
+	} {
+		_, err := ParseChunkKey(key)
+		assert.Equal(t, ErrInvalidChunkKey, err)
+	}
+}
+
+func TestFsStoreListChunks(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "archive")
+	defer os.RemoveAll(dir)
+
+	s, err := OpenStore("file://" + dir)
+	assert.Equal(t, nil, err)
+
+	for _, m := range []ChunkMeta{
+		{Cluster: "trade", Topic: "foo", Partition: 1, First: 0, Last: 9},
+		{Cluster: "trade", Topic: "foo", Partition: 0, First: 100, Last: 199},
+		{Cluster: "trade", Topic: "foo", Partition: 0, First: 20, Last: 99},
+		{Cluster: "trade", Topic: "foobar", Partition: 0, First: 0, Last: 9},
+	} {
+		assert.Equal(t, nil, s.Put(m.Key(), []byte("x")))
+	}
+
+	chunks, err := ListChunks(s, "trade", "foo")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(chunks))
+	assert.Equal(t, int64(20), chunks[0].First)
+	assert.Equal(t, int64(100), chunks[1].First)
+	assert.Equal(t, int32(1), chunks[2].Partition)
+
+	data, err := s.Get(chunks[0].Key())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "x", string(data))
+	_, err = s.Get("trade/none")
+	assert.Equal(t, ErrObjectNotFound, err)
+}
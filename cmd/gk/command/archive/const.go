@@ -0,0 +1,19 @@
+package archive
+
+import (
+	"time"
+)
+
+const (
+	defaultChunkBytes    = 64 << 20 // raw bytes before compression
+	defaultChunkInterval = time.Minute * 10
+
+	flushCheckInterval = time.Second * 10
+	archivedRecheck    = time.Minute // archive flag of topics is not watched
+	putRetries         = 3
+)
+
+// GroupName is the consumer group of the archiver on the archived cluster.
+func GroupName(cluster string) string {
+	return "_archive_." + cluster
+}
@@ -0,0 +1,12 @@
+package archive
+
+import (
+	"errors"
+)
+
+var (
+	ErrUnsupportedStore = errors.New("unsupported store, must be file:// or s3://")
+	ErrObjectNotFound   = errors.New("object not found")
+	ErrInvalidChunkKey  = errors.New("invalid chunk key")
+	ErrCorruptedChunk   = errors.New("corrupted chunk")
+)
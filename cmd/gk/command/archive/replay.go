@@ -0,0 +1,75 @@
+package archive
+
+import (
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	log "github.com/funkygao/log4go"
+)
+
+// Replay republishes the archived messages of a time range through store.DefaultPubStore.
+type Replay struct {
+	Store   ObjectStore
+	Cluster string // archived cluster
+	Topic   string // archived topic
+
+	From, To time.Time
+
+	TargetCluster string
+	TargetTopic   string // a new topic or the shadow topic of a group
+	DryRun        bool
+
+	Progress func(n int64, m ChunkMeta)
+}
+
+// Run replays the chunks in partition and offset order, the overlapped offsets caused
+// by re-archiving after crash are skipped.
+func (this *Replay) Run() (n int64, err error) {
+	chunks, err := ListChunks(this.Store, this.Cluster, this.Topic)
+	if err != nil {
+		return
+	}
+
+	from := this.From.UnixNano() / int64(time.Millisecond)
+	to := this.To.UnixNano() / int64(time.Millisecond)
+	replayed := make(map[int32]int64) // partition:last replayed offset
+	for _, m := range chunks {
+		if !m.Overlaps(from, to) {
+			continue
+		}
+
+		data, err := this.Store.Get(m.Key())
+		if err != nil {
+			return n, err
+		}
+
+		if err = ReadChunk(data, func(r Record) error {
+			if last, present := replayed[m.Partition]; present && r.Offset <= last {
+				return nil
+			}
+			replayed[m.Partition] = r.Offset
+
+			if r.Timestamp < from || r.Timestamp >= to {
+				return nil
+			}
+
+			if !this.DryRun {
+				if _, _, err := store.DefaultPubStore.SyncPub(this.TargetCluster, this.TargetTopic, r.Key, r.Value); err != nil {
+					log.Error("replay %s/%d %d: %v", m.Topic, m.Partition, r.Offset, err)
+					return err
+				}
+			}
+
+			n++
+			return nil
+		}); err != nil {
+			return n, err
+		}
+
+		if this.Progress != nil {
+			this.Progress(n, m)
+		}
+	}
+
+	return
+}
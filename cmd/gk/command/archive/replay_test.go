@@ -0,0 +1,59 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+)
+
+type recordingPubStore struct {
+	store.PubStore
+
+	msgs []string
+}
+
+func (this *recordingPubStore) SyncPub(cluster, topic string, key, msg []byte) (int32, int64, error) {
+	this.msgs = append(this.msgs, cluster+"/"+topic+":"+string(msg))
+	return 0, int64(len(this.msgs)), nil
+}
+
+func TestReplaySkipsOverlapsAndOutOfRange(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "archive")
+	defer os.RemoveAll(dir)
+	s, _ := newFsStore(dir)
+
+	put := func(partition int32, records ...Record) {
+		w := newChunkWriter("trade", "foo", partition, time.Now())
+		for _, r := range records {
+			w.Append(r)
+		}
+		key, data, _ := w.Close()
+		s.Put(key, data)
+	}
+
+	put(0, Record{Offset: 0, Timestamp: 1000, Value: []byte("a")}, Record{Offset: 1, Timestamp: 2000, Value: []byte("b")})
+	// re-archived after crash
+	put(0, Record{Offset: 1, Timestamp: 2000, Value: []byte("b")}, Record{Offset: 2, Timestamp: 3000, Value: []byte("c")})
+	put(0, Record{Offset: 3, Timestamp: 9000, Value: []byte("z")})
+	put(1, Record{Offset: 0, Timestamp: 2500, Value: []byte("d")})
+
+	pub := &recordingPubStore{}
+	store.DefaultPubStore = pub
+	r := &Replay{
+		Store:         s,
+		Cluster:       "trade",
+		Topic:         "foo",
+		From:          time.Unix(1, 500*int64(time.Millisecond)),
+		To:            time.Unix(5, 0),
+		TargetCluster: "dr",
+		TargetTopic:   "foo.replay",
+	}
+	n, err := r.Run()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, []string{"dr/foo.replay:b", "dr/foo.replay:c", "dr/foo.replay:d"}, pub.msgs)
+}
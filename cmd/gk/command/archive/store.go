@@ -0,0 +1,54 @@
+package archive
+
+import (
+	"net/url"
+	"os"
+)
+
+// ObjectStore is a flat key/value store for the archived chunks.
+type ObjectStore interface {
+	// Name returns the name of the underlying store.
+	Name() string
+
+	Put(key string, data []byte) error
+
+	// Get returns ErrObjectNotFound if the key does not exist.
+	Get(key string) ([]byte, error)
+
+	// List returns the sorted keys with the prefix.
+	List(prefix string) ([]string, error)
+}
+
+// OpenStore opens an object store by uri.
+//
+// e,g.
+// file:///data/archive
+// s3://bucket/prefix?endpoint=s3.internal:9000&region=us-east-1&secure=0
+//
+// Credentials of s3 are read from env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func OpenStore(uri string) (ObjectStore, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return newFsStore(u.Path)
+
+	case "s3":
+		q := u.Query()
+		return newS3Store(s3Config{
+			Endpoint:  q.Get("endpoint"),
+			Region:    q.Get("region"),
+			Secure:    q.Get("secure") != "0",
+			Bucket:    u.Host,
+			Prefix:    u.Path,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		})
+
+	default:
+		return nil, ErrUnsupportedStore
+	}
+}
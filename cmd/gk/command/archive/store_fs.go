@@ -0,0 +1,74 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fsStore keeps objects as files under a root dir, e,g. a NFS mount.
+type fsStore struct {
+	root string
+}
+
+func newFsStore(root string) (*fsStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &fsStore{root: root}, nil
+}
+
+func (this *fsStore) Name() string {
+	return "file://" + this.root
+}
+
+// Put writes a tmp file then renames it, so that a crash never leaves partial objects.
+func (this *fsStore) Put(key string, data []byte) error {
+	fn := filepath.Join(this.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fn)
+}
+
+func (this *fsStore) Get(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(this.root, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+
+	return data, err
+}
+
+func (this *fsStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(this.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(this.root, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+
+	sort.Strings(keys)
+	return keys, err
+}
@@ -0,0 +1,247 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type s3Config struct {
+	Endpoint  string // host:port of S3 compatible service
+	Region    string
+	Secure    bool
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+// s3Store talks to S3 compatible object store in path style with AWS signature v4.
+type s3Store struct {
+	s3Config
+
+	client *http.Client
+}
+
+func newS3Store(cf s3Config) (*s3Store, error) {
+	if cf.Endpoint == "" {
+		cf.Endpoint = "s3.amazonaws.com"
+	}
+	if cf.Region == "" {
+		cf.Region = "us-east-1"
+	}
+	cf.Prefix = strings.Trim(cf.Prefix, "/")
+	if cf.Prefix != "" {
+		cf.Prefix += "/"
+	}
+
+	return &s3Store{
+		s3Config: cf,
+		client:   &http.Client{Timeout: time.Minute * 5},
+	}, nil
+}
+
+func (this *s3Store) Name() string {
+	return fmt.Sprintf("s3://%s/%s", this.Bucket, this.Prefix)
+}
+
+func (this *s3Store) url(key string, query url.Values) *url.URL {
+	u := &url.URL{
+		Scheme:   "http",
+		Host:     this.Endpoint,
+		Path:     "/" + this.Bucket + "/" + key,
+		RawQuery: query.Encode(),
+	}
+	if this.Secure {
+		u.Scheme = "https"
+	}
+	u.RawPath = awsEscape(u.Path, false)
+	return u
+}
+
+func (this *s3Store) do(method string, u *url.URL, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	signV4(req, body, this.AccessKey, this.SecretKey, this.Region, time.Now())
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return data, nil
+
+	case http.StatusNotFound:
+		return nil, ErrObjectNotFound
+
+	default:
+		return nil, fmt.Errorf("s3 %s %s: %s %s", method, u.Path, resp.Status, bytes.TrimSpace(data))
+	}
+}
+
+func (this *s3Store) Put(key string, data []byte) error {
+	_, err := this.do("PUT", this.url(this.Prefix+key, nil), data)
+	return err
+}
+
+func (this *s3Store) Get(key string) ([]byte, error) {
+	return this.do("GET", this.url(this.Prefix+key, nil), nil)
+}
+
+func (this *s3Store) List(prefix string) ([]string, error) {
+	var (
+		keys  []string
+		token string
+	)
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", this.Prefix+prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		data, err := this.do("GET", this.url("", query), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key string
+			}
+		}
+		if err = xml.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(c.Key, this.Prefix))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// signV4 signs the request with AWS signature version 4, all the headers are signed.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+	const (
+		algorithm = "AWS4-HMAC-SHA256"
+		service   = "s3"
+	)
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	queryKeys := make([]string, 0, len(query))
+	for k := range query {
+		queryKeys = append(queryKeys, k)
+	}
+	sort.Strings(queryKeys)
+	canonicalQuery := make([]string, 0, len(queryKeys))
+	for _, k := range queryKeys {
+		for _, v := range query[k] {
+			canonicalQuery = append(canonicalQuery, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, false),
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := algorithm + "\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSha256([]byte("AWS4"+secretKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, service)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape is the URI encoding of AWS: only the unreserved chars are kept.
+func awsEscape(s string, encodeSlash bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
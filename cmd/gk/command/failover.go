@@ -0,0 +1,334 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/columnize"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/zk"
+	"github.com/funkygao/gocli"
+)
+
+// failoverGroup is the consumer group that tracks the reconciled offsets on the failover cluster.
+const failoverGroup = "_failover"
+
+type Failover struct {
+	Ui  cli.Ui
+	Cmd string
+
+	zkzone    *zk.ZkZone
+	appid     string
+	producers map[string]sarama.SyncProducer // origin cluster:producer
+}
+
+func (this *Failover) Run(args []string) (exitCode int) {
+	var (
+		zone      string
+		cluster   string
+		del       bool
+		reconcile bool
+	)
+	cmdFlags := flag.NewFlagSet("failover", flag.ContinueOnError)
+	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
+	cmdFlags.StringVar(&zone, "z", ctx.ZkDefaultZone(), "")
+	cmdFlags.StringVar(&this.appid, "app", "", "")
+	cmdFlags.StringVar(&cluster, "c", "", "")
+	cmdFlags.BoolVar(&del, "del", false, "")
+	cmdFlags.BoolVar(&reconcile, "reconcile", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if validateArgs(this, this.Ui).
+		on("-c", "-app").
+		on("-del", "-app").
+		on("-reconcile", "-app").
+		requireAdminRights("-c", "-del", "-reconcile").
+		invalid(args) {
+		return 2
+	}
+
+	this.zkzone = zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
+	defer this.zkzone.Close()
+
+	switch {
+	case cluster != "":
+		return this.setFailover(cluster)
+
+	case del:
+		swallow(this.zkzone.DelPubFailover(this.appid))
+		this.Ui.Info(fmt.Sprintf("%s failover removed", this.appid))
+
+	case reconcile:
+		return this.reconcile()
+
+	default:
+		this.displayFailovers()
+	}
+
+	return
+}
+
+func (this *Failover) setFailover(cluster string) (exitCode int) {
+	if _, present := this.zkzone.Clusters()[cluster]; !present {
+		this.Ui.Error(fmt.Sprintf("cluster[%s] not found", cluster))
+		return 1
+	}
+
+	// failover Pub writes the same topic names, they must be ready
+	failover := this.zkzone.NewCluster(cluster)
+	var missing []string
+	this.zkzone.ForSortedClusters(func(zkcluster *zk.ZkCluster) {
+		if zkcluster.Name() == cluster {
+			return
+		}
+
+		for _, topic := range this.appTopics(zkcluster) {
+			if len(failover.Partitions(topic)) == 0 {
+				missing = append(missing, topic)
+			}
+		}
+	})
+	if len(missing) > 0 {
+		this.Ui.Error(fmt.Sprintf("topics not found on %s, create them first: %+v", cluster, missing))
+		return 1
+	}
+
+	swallow(this.zkzone.SetPubFailover(this.appid, cluster))
+	this.Ui.Info(fmt.Sprintf("%s failover -> %s", this.appid, cluster))
+	return
+}
+
+// reconcile moves the failed over messages back to their origin cluster.
+func (this *Failover) reconcile() (exitCode int) {
+	cluster, present := this.zkzone.PubFailovers()[this.appid]
+	if !present {
+		this.Ui.Error(fmt.Sprintf("%s has no failover cluster", this.appid))
+		return 1
+	}
+
+	zkcluster := this.zkzone.NewCluster(cluster)
+	kfk, err := sarama.NewClient(zkcluster.BrokerList(), sarama.NewConfig())
+	swallow(err)
+	defer kfk.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(kfk)
+	swallow(err)
+	defer consumer.Close()
+
+	this.producers = make(map[string]sarama.SyncProducer)
+	defer func() {
+		for _, p := range this.producers {
+			p.Close()
+		}
+	}()
+
+	committed := zkcluster.ConsumerOffsetsOfGroup(failoverGroup)
+	for _, topic := range this.appTopics(zkcluster) {
+		partitions, err := kfk.Partitions(topic)
+		swallow(err)
+
+		for _, partitionId := range partitions {
+			start, end := this.pendingRange(kfk, committed, topic, partitionId)
+			if start >= end {
+				continue
+			}
+
+			moved, offset, err := this.reconcilePartition(consumer, topic, partitionId, start, end)
+			if offset > start {
+				swallow(zkcluster.CommitConsumerGroupOffset(topic, failoverGroup, partitionId, offset))
+			}
+			if err != nil {
+				this.Ui.Error(fmt.Sprintf("%s#%d stopped at %d: %v", topic, partitionId, offset, err))
+				return 1
+			}
+
+			this.Ui.Output(fmt.Sprintf("%s#%d [%d, %d) moved %d", topic, partitionId, start, end, moved))
+		}
+	}
+
+	return
+}
+
+// reconcilePartition produces the messages with origin in [start, end) to the origin cluster and
+// returns the offset reconciled up to.
+func (this *Failover) reconcilePartition(consumer sarama.Consumer, topic string, partitionId int32,
+	start, end int64) (moved int, offset int64, err error) {
+	p, err := consumer.ConsumePartition(topic, partitionId, start)
+	if err != nil {
+		return 0, start, err
+	}
+	defer p.Close()
+
+	offset = start
+	for offset < end {
+		select {
+		case msg := <-p.Messages():
+			headers, bodyIdx, err := envelope.Decode(msg.Value)
+			if err != nil {
+				return moved, offset, err
+			}
+
+			if origin := headers.Get(envelope.HeaderOrigin); origin != "" {
+				value := msg.Value[bodyIdx:]
+				if headers = headers.Without(envelope.HeaderOrigin); len(headers) > 0 {
+					if value, err = envelope.Encode(headers, value); err != nil {
+						return moved, offset, err
+					}
+				}
+
+				if err = this.produce(origin, topic, msg.Key, value); err != nil {
+					return moved, offset, err
+				}
+				moved++
+			}
+
+			offset = msg.Offset + 1
+
+		case err := <-p.Errors():
+			return moved, offset, err
+		}
+	}
+
+	return
+}
+
+func (this *Failover) produce(cluster, topic string, key, value []byte) error {
+	p, present := this.producers[cluster]
+	if !present {
+		cf := sarama.NewConfig()
+		cf.Producer.RequiredAcks = sarama.WaitForAll
+		cf.Producer.Return.Successes = true
+		var err error
+		if p, err = sarama.NewSyncProducer(this.zkzone.NewCluster(cluster).BrokerList(), cf); err != nil {
+			return err
+		}
+		this.producers[cluster] = p
+	}
+
+	msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(value)}
+	if len(key) > 0 {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	_, _, err := p.SendMessage(msg)
+	return err
+}
+
+// pendingRange returns the offsets [start, end) of a partition not reconciled yet.
+func (this *Failover) pendingRange(kfk sarama.Client, committed map[string]map[string]int64,
+	topic string, partitionId int32) (start, end int64) {
+	end, err := kfk.GetOffset(topic, partitionId, sarama.OffsetNewest)
+	swallow(err)
+
+	start, present := committed[topic][strconv.Itoa(int(partitionId))]
+	if !present {
+		start, err = kfk.GetOffset(topic, partitionId, sarama.OffsetOldest)
+		swallow(err)
+	}
+
+	return
+}
+
+func (this *Failover) appTopics(zkcluster *zk.ZkCluster) []string {
+	topics, err := zkcluster.Topics()
+	swallow(err)
+
+	r := make([]string, 0)
+	for _, topic := range topics {
+		if strings.HasPrefix(topic, this.appid+".") {
+			r = append(r, topic)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// pending returns the number of messages on the failover cluster not reconciled yet.
+func (this *Failover) pending(cluster string) int64 {
+	zkcluster := this.zkzone.NewCluster(cluster)
+	kfk, err := sarama.NewClient(zkcluster.BrokerList(), sarama.NewConfig())
+	swallow(err)
+	defer kfk.Close()
+
+	committed := zkcluster.ConsumerOffsetsOfGroup(failoverGroup)
+	var n int64
+	for _, topic := range this.appTopics(zkcluster) {
+		partitions, err := kfk.Partitions(topic)
+		swallow(err)
+
+		for _, partitionId := range partitions {
+			start, end := this.pendingRange(kfk, committed, topic, partitionId)
+			if end > start {
+				n += end - start
+			}
+		}
+	}
+
+	return n
+}
+
+func (this *Failover) displayFailovers() {
+	failovers := this.zkzone.PubFailovers()
+	appids := make([]string, 0, len(failovers))
+	for appid := range failovers {
+		if this.appid == "" || this.appid == appid {
+			appids = append(appids, appid)
+		}
+	}
+	sort.Strings(appids)
+
+	lines := []string{"App|Failover|Pending"}
+	for _, appid := range appids {
+		this.appid = appid
+		lines = append(lines, fmt.Sprintf("%s|%s|%d", appid, failovers[appid], this.pending(failovers[appid])))
+	}
+
+	if len(lines) > 1 {
+		this.Ui.Output(columnize.SimpleFormat(lines))
+	}
+}
+
+func (*Failover) Synopsis() string {
+	return "Manage Pub failover cluster of apps"
+}
+
+func (this *Failover) Help() string {
+	help := fmt.Sprintf(`
+Usage: %s failover [options]
+
+    %s
+
+    When the cluster of an app keeps failing, kateway publishes to the failover
+    cluster with the origin cluster tagged in the message envelope.
+    After the origin cluster recovers, reconcile moves the messages back.
+
+    e,g.
+      gk failover -z prod -app app1 -c trade2
+      gk failover -z prod
+      gk failover -z prod -app app1 -reconcile
+
+Options:
+
+    -z zone
+
+    -app appid
+
+    -c failover cluster
+      The topics of the app must be created on it in advance.
+
+    -del
+      Remove the failover cluster of the app.
+
+    -reconcile
+      Move the failed over messages back to the origin cluster.
+      Reconciled offsets are committed as consumer group %s on the failover cluster.
+
+`, this.Cmd, this.Synopsis(), failoverGroup)
+	return strings.TrimSpace(help)
+}
@@ -4,125 +4,370 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/columnize"
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
 	"github.com/funkygao/golib/color"
+	"github.com/funkygao/golib/gofmt"
+)
+
+const (
+	hostHealthOk       = "OK"
+	hostHealthWarn     = "WARN"
+	hostHealthCritical = "CRITICAL"
 )
 
 type Host struct {
 	Ui  cli.Ui
 	Cmd string
 
-	zone string
-	host string
+	zkzone    *zk.ZkZone
+	host      string
+	hostnames map[string]struct{} // ip and its resolved host names
+
+	warnings, criticals []string
 }
 
 func (this *Host) Run(args []string) (exitCode int) {
+	var zone string
 	cmdFlags := flag.NewFlagSet("host", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
-	cmdFlags.StringVar(&this.zone, "z", "", "")
+	cmdFlags.StringVar(&zone, "z", ctx.ZkDefaultZone(), "")
 	cmdFlags.StringVar(&this.host, "ip", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
 	if validateArgs(this, this.Ui).
-		require("-z", "-h").
+		require("-z", "-ip").
 		invalid(args) {
 		return 2
 	}
 
-	for {
-		this.diagnose()
-		this.Ui.Output(color.Green("%s", strings.Repeat("=", 40)))
+	if net.ParseIP(this.host) == nil {
+		this.Ui.Error(fmt.Sprintf("invalid ip: %s", this.host))
+		return 2
+	}
+
+	ensureZoneValid(zone)
+	this.zkzone = zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
+	defer this.zkzone.Close()
 
-		time.Sleep(time.Second * 5)
+	this.hostnames = map[string]struct{}{this.host: {}}
+	if names, err := net.LookupAddr(this.host); err == nil {
+		for _, name := range names {
+			name = strings.TrimSuffix(name, ".")
+			this.hostnames[name] = struct{}{}
+			if idx := strings.IndexByte(name, '.'); idx > 0 {
+				this.hostnames[name[:idx]] = struct{}{}
+			}
+		}
+	}
+
+	this.diagnoseBrokers()
+	this.diagnoseConsumers()
+	this.diagnoseServices()
+	this.diagnoseZkSessions()
+	if this.verdict() == hostHealthCritical {
+		exitCode = 1
 	}
 
+	printSwallowedErrors(this.Ui, this.zkzone)
+
 	return
 }
 
-func (this *Host) diagnose() {
-	clusters := make([]string, 0)
-	leadingTopics := make(map[string][]int32) // topic:partitionID
-	msgs := make(map[string]int64)
+func (this *Host) section(title string) {
+	this.Ui.Output(color.Cyan("%s\n%s", title, strings.Repeat("-", 80)))
+}
 
-	// check if it is registered
-	zkzone := zk.NewZkZone(zk.DefaultConfig(this.zone, ctx.ZoneZkAddrs(this.zone)))
-	zkzone.ForSortedBrokers(func(cluster string, liveBrokers map[string]*zk.BrokerZnode) {
-		zkcluster := zkzone.NewCluster(cluster)
+func (this *Host) warn(format string, args ...interface{}) {
+	this.warnings = append(this.warnings, fmt.Sprintf(format, args...))
+}
 
-		for _, broker := range liveBrokers {
-			if broker.Host != this.host {
-				continue
+func (this *Host) critical(format string, args ...interface{}) {
+	this.criticals = append(this.criticals, fmt.Sprintf(format, args...))
+}
+
+func (this *Host) isMe(host string) bool {
+	_, present := this.hostnames[host]
+	return present
+}
+
+// diagnoseBrokers checks the clusters the host belongs to and the partitions on it.
+func (this *Host) diagnoseBrokers() {
+	liveClusters, registeredClusters := this.zkzone.HostBelongs(this.host)
+	live := make(map[string]struct{}, len(liveClusters))
+	for _, c := range liveClusters {
+		live[c] = struct{}{}
+	}
+
+	this.section("clusters")
+	lines := []string{"Cluster|Registered|Live"}
+	for _, c := range registeredClusters {
+		_, isLive := live[c]
+		lines = append(lines, fmt.Sprintf("%s|true|%v", c, isLive))
+		if !isLive {
+			this.critical("%s: registered broker not alive", c)
+		}
+	}
+	for _, c := range liveClusters {
+		registered := false
+		for _, r := range registeredClusters {
+			if r == c {
+				registered = true
+				break
 			}
+		}
+		if !registered {
+			lines = append(lines, fmt.Sprintf("%s|false|true", c))
+			this.warn("%s: live broker not registered", c)
+		}
+	}
+	if len(lines) > 1 {
+		this.Ui.Output(columnize.SimpleFormat(lines))
+	}
+
+	for _, cluster := range liveClusters {
+		this.diagnosePartitions(this.zkzone.NewCluster(cluster))
+	}
+}
+
+func (this *Host) diagnosePartitions(zkcluster *zk.ZkCluster) {
+	brokerIds := make(map[int32]struct{})
+	for id, broker := range zkcluster.Brokers() {
+		if !this.isMe(broker.Host) {
+			continue
+		}
+
+		if brokerId, err := strconv.Atoi(id); err == nil {
+			brokerIds[int32(brokerId)] = struct{}{}
+		}
+	}
 
-			this.Ui.Output(broker.String())
+	kfk, err := sarama.NewClient(zkcluster.BrokerList(), saramaConfig())
+	if err != nil {
+		this.critical("%s: %v", zkcluster.Name(), err)
+		return
+	}
+	defer kfk.Close()
+
+	topics, err := kfk.Topics()
+	if err != nil {
+		this.critical("%s: %v", zkcluster.Name(), err)
+		return
+	}
+	sort.Strings(topics)
 
-			clusters = append(clusters, cluster)
+	var (
+		leaders, replicas int
+		msgs              int64
+		unknownOffsets    int // leader partitions whose offsets are not available
+		lines             = []string{"Topic|Partition|Role|Leader|Replicas|Isr"}
+	)
+	for _, topic := range topics {
+		partitions, err := kfk.Partitions(topic)
+		if err != nil {
+			this.warn("%s topic[%s]: %v", zkcluster.Name(), topic, err)
+			continue
+		}
 
-			kfk, err := sarama.NewClient([]string{broker.Addr()}, saramaConfig())
+		for _, partitionID := range partitions {
+			replicaIds, err := kfk.Replicas(topic, partitionID)
 			if err != nil {
-				this.Ui.Error(err.Error())
+				this.warn("%s topic[%s] P:%d: %v", zkcluster.Name(), topic, partitionID, err)
 				continue
 			}
 
-			topics, err := kfk.Topics()
-			swallow(err)
-			for _, topic := range topics {
-				partions, err := kfk.WritablePartitions(topic)
-				swallow(err)
-				for _, partitionID := range partions {
-					leader, err := kfk.Leader(topic, partitionID)
-					swallow(err)
-					host, _, err := net.SplitHostPort(leader.Addr())
-					swallow(err)
-					if host != this.host {
-						continue
-					}
+			hosted := false
+			for _, id := range replicaIds {
+				if _, present := brokerIds[id]; present {
+					hosted = true
+					break
+				}
+			}
+			if !hosted {
+				continue
+			}
 
-					latestOffset, err := kfk.GetOffset(topic, partitionID, sarama.OffsetNewest)
-					swallow(err)
-					oldestOffset, err := kfk.GetOffset(topic, partitionID, sarama.OffsetOldest)
-					swallow(err)
+			replicas++
+			role := "follower"
+			leaderAddr := "-"
+			if leader, err := kfk.Leader(topic, partitionID); err != nil {
+				this.critical("%s topic[%s] P:%d offline: %v", zkcluster.Name(), topic, partitionID, err)
+			} else {
+				leaderAddr = leader.Addr()
+				if host, _, _ := net.SplitHostPort(leaderAddr); this.isMe(host) {
+					role = "leader"
+					leaders++
 
-					msgs[topic] += (latestOffset - oldestOffset)
-					if _, present := leadingTopics[topic]; !present {
-						leadingTopics[topic] = make([]int32, 0)
+					latestOffset, err := kfk.GetOffset(topic, partitionID, sarama.OffsetNewest)
+					if err == nil {
+						var oldestOffset int64
+						if oldestOffset, err = kfk.GetOffset(topic, partitionID, sarama.OffsetOldest); err == nil {
+							msgs += latestOffset - oldestOffset
+						}
+					}
+					if err != nil {
+						unknownOffsets++
+						this.warn("%s topic[%s] P:%d offset unknown: %v", zkcluster.Name(), topic, partitionID, err)
 					}
-					leadingTopics[topic] = append(leadingTopics[topic], partitionID)
 				}
 			}
 
-			kfk.Close()
+			isr, _, _ := zkcluster.Isr(topic, partitionID)
+			if len(isr) == len(replicaIds) {
+				continue
+			}
+
+			// only under replicated partitions are listed
+			inSync := false
+			for _, id := range isr {
+				if _, present := brokerIds[int32(id)]; present {
+					inSync = true
+					break
+				}
+			}
+			switch {
+			case !inSync:
+				this.critical("%s topic[%s] P:%d replica on host out of isr", zkcluster.Name(), topic, partitionID)
+			case role == "leader":
+				this.warn("%s topic[%s] P:%d under replicated", zkcluster.Name(), topic, partitionID)
+			}
+
+			lines = append(lines, fmt.Sprintf("%s|%d|%s|%s|%+v|%+v", topic, partitionID, role,
+				leaderAddr, replicaIds, isr))
+		}
+	}
+
+	this.section(fmt.Sprintf("%s partitions", zkcluster.Name()))
+	if unknownOffsets > 0 {
+		this.Ui.Output(fmt.Sprintf("leader:%d replica:%d msgs:%s offset unknown partitions:%d",
+			leaders, replicas, gofmt.Comma(msgs), unknownOffsets))
+	} else {
+		this.Ui.Output(fmt.Sprintf("leader:%d replica:%d msgs:%s", leaders, replicas, gofmt.Comma(msgs)))
+	}
+	if len(lines) > 1 {
+		this.Ui.Warn("under replicated")
+		this.Ui.Output(columnize.SimpleFormat(lines))
+	}
+}
+
+// diagnoseConsumers finds the consumer group owners running on the host.
+func (this *Host) diagnoseConsumers() {
+	lines := []string{"Cluster|Group|Consumer|Topics|Uptime"}
+	this.zkzone.ForSortedClusters(func(zkcluster *zk.ZkCluster) {
+		groups := zkcluster.ConsumerGroups()
+		sortedGroups := make([]string, 0, len(groups))
+		for group := range groups {
+			sortedGroups = append(sortedGroups, group)
 		}
+		sort.Strings(sortedGroups)
+
+		for _, group := range sortedGroups {
+			for _, c := range groups[group] {
+				if !this.isMe(c.Host()) && !this.isMe(c.ClientRealIP()) {
+					continue
+				}
 
-		registeredBrokers := zkcluster.RegisteredInfo().Roster
-		for _, b := range registeredBrokers {
-			if b.Host == this.host {
-				this.Ui.Output(fmt.Sprintf("registered in cluster: %s", cluster))
+				lines = append(lines, fmt.Sprintf("%s|%s|%s|%+v|%s", zkcluster.Name(), group,
+					c.Id, c.Topics(), gofmt.PrettySince(c.Uptime())))
 			}
 		}
 	})
 
-	this.Ui.Output(color.Cyan("clusters\n%s", strings.Repeat("-", 80)))
-	for _, c := range clusters {
-		this.Ui.Output(c)
+	this.section("consumers")
+	if len(lines) > 1 {
+		this.Ui.Output(columnize.SimpleFormat(lines))
+	}
+}
+
+// diagnoseServices finds the kateway, actord and kguard instances on the host.
+func (this *Host) diagnoseServices() {
+	lines := []string{"Service|Id|Detail"}
+
+	kateways, err := this.zkzone.KatewayInfos()
+	if err != nil {
+		this.warn("kateway: %v", err)
+	}
+	for _, kw := range kateways {
+		if this.isMe(kw.Ip) || this.isMe(kw.Host) {
+			lines = append(lines, fmt.Sprintf("kateway|%s|%s up:%s", kw.Id, kw.Ver,
+				gofmt.PrettySince(kw.Ctime)))
+		}
+	}
+
+	for actorId, data := range this.zkzone.ChildrenWithData(zk.PubsubActors) {
+		// actor id is hostname:uuid
+		if idx := strings.IndexByte(actorId, ':'); idx > 0 && this.isMe(actorId[:idx]) {
+			lines = append(lines, fmt.Sprintf("actord|%s|up:%s", actorId, gofmt.PrettySince(data.Ctime())))
+		}
+	}
+
+	if kguards, err := this.zkzone.KguardInfos(); err == nil {
+		for _, kg := range kguards {
+			if this.isMe(kg.Host) {
+				lines = append(lines, fmt.Sprintf("kguard|leader|candidates:%d up:%s", kg.Candidates,
+					gofmt.PrettySince(kg.Ctime)))
+			}
+		}
+	}
+
+	this.section("services")
+	if len(lines) > 1 {
+		this.Ui.Output(columnize.SimpleFormat(lines))
+	}
+}
+
+// diagnoseZkSessions finds the zookeeper connections from the host.
+func (this *Host) diagnoseZkSessions() {
+	this.section("zookeeper sessions")
+	prefix := "/" + this.host + ":"
+	sessions := 0
+	for zkhost, output := range this.zkzone.RunZkFourLetterCommand("cons") {
+		for _, l := range strings.Split(output, "\n") {
+			l = strings.TrimSpace(l)
+			if strings.HasPrefix(l, prefix) {
+				sessions++
+				this.Ui.Output(fmt.Sprintf("%s %s", zkhost, l))
+			}
+		}
 	}
 
-	this.Ui.Output(color.Cyan("clusters\n%s", strings.Repeat("-", 80)))
-	for t, ps := range leadingTopics {
-		this.Ui.Output(fmt.Sprintf("%35s M:%-10d P:%+v", t, msgs[t], ps))
+	this.Ui.Output(fmt.Sprintf("total %d", sessions))
+}
+
+func (this *Host) verdict() (health string) {
+	this.section("verdict")
+	for _, msg := range this.criticals {
+		this.Ui.Error(msg)
 	}
+	for _, msg := range this.warnings {
+		this.Ui.Warn(msg)
+	}
+
+	switch {
+	case len(this.criticals) > 0:
+		health = hostHealthCritical
+		this.Ui.Error(fmt.Sprintf("%s %s", this.host, health))
+	case len(this.warnings) > 0:
+		health = hostHealthWarn
+		this.Ui.Warn(fmt.Sprintf("%s %s", this.host, health))
+	default:
+		health = hostHealthOk
+		this.Ui.Info(fmt.Sprintf("%s %s", this.host, health))
+	}
+	return
 }
 
 func (*Host) Synopsis() string {
-	return "Diagnose a broker by ip address TODO"
+	return "Diagnose a host by ip address"
 }
 
 func (this *Host) Help() string {
@@ -131,6 +376,14 @@ Usage: %s host -z zone -ip addr
 
     %s
 
+    Report everything related to the host in one place:
+    - clusters it belongs to
+    - leader and replica partitions, under replicated partitions on it
+    - consumer group owners running on it
+    - kateway/actord/kguard instances running on it
+    - zookeeper sessions from it
+    And finally a health verdict of OK, WARN or CRITICAL, exit code is 1 on CRITICAL.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
@@ -3,9 +3,14 @@ package command
 import (
 	"flag"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/funkygao/columnize"
 	"github.com/funkygao/gafka/cmd/gk/command/mirror"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
 )
 
@@ -24,6 +29,9 @@ type Mirror struct {
 	bandwidthLimit     int64
 	progressStep       int64
 	showStatus         bool
+	translateGroup     string
+	apply              bool
+	rulesFile          string
 }
 
 func (this *Mirror) Run(args []string) (exitCode int) {
@@ -42,16 +50,34 @@ func (this *Mirror) Run(args []string) (exitCode int) {
 	cmdFlags.Int64Var(&this.bandwidthLimit, "net", 100, "")
 	cmdFlags.BoolVar(&this.autoCommit, "commit", true, "")
 	cmdFlags.Int64Var(&this.progressStep, "step", 10000, "")
+	cmdFlags.StringVar(&this.translateGroup, "translate", "", "")
+	cmdFlags.BoolVar(&this.apply, "apply", false, "")
+	cmdFlags.StringVar(&this.rulesFile, "rules", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
 	if validateArgs(this, this.Ui).
 		require("-z1", "-z2", "-c1", "-c2").
+		on("-apply", "-translate").
+		requireAdminRights("-apply").
 		invalid(args) {
 		return 2
 	}
 
+	rules := mirror.DefaultRules()
+	if this.rulesFile != "" {
+		var err error
+		if rules, err = mirror.LoadRules(this.rulesFile); err != nil {
+			this.Ui.Error(fmt.Sprintf("%s: %v", this.rulesFile, err))
+			return 1
+		}
+	}
+
+	if this.translateGroup != "" {
+		return this.translateOffsets(rules)
+	}
+
 	topicsExcluded := make(map[string]struct{})
 	for _, e := range strings.Split(this.excludes, ",") {
 		if e != "" {
@@ -79,6 +105,7 @@ func (this *Mirror) Run(args []string) (exitCode int) {
 	cf.BandwidthLimit = this.bandwidthLimit
 	cf.AutoCommit = this.autoCommit
 	cf.ProgressStep = this.progressStep
+	cf.Rules = rules
 
 	setupLogging("mirror.log", this.logLevel, "panic")
 
@@ -86,6 +113,61 @@ func (this *Mirror) Run(args []string) (exitCode int) {
 	return m.Main()
 }
 
+// translateOffsets converts the consumer group offsets on source cluster to target
+// cluster with the mirror checkpoints, so that the group can fail over to target.
+func (this *Mirror) translateOffsets(rules *mirror.Rules) (exitCode int) {
+	z1 := zk.NewZkZone(zk.DefaultConfig(this.zone1, ctx.ZoneZkAddrs(this.zone1)))
+	defer z1.Close()
+	z2 := zk.NewZkZone(zk.DefaultConfig(this.zone2, ctx.ZoneZkAddrs(this.zone2)))
+	defer z2.Close()
+
+	c1, c2 := z1.NewCluster(this.cluster1), z2.NewCluster(this.cluster2)
+	name := mirror.GroupName(c1, c2)
+	offsets := c1.ConsumerOffsetsOfGroup(this.translateGroup)
+	topics := make([]string, 0, len(offsets))
+	for topic := range offsets {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	if !rules.Translatable() {
+		this.Ui.Error("offsets can only be translated with partition placement and without tags/keys filters")
+		return 1
+	}
+
+	lines := []string{"Topic|Partition|Source|TargetTopic|Target"}
+	for _, topic := range topics {
+		targetTopic, err := rules.TargetTopic(c1.ZkZone().Name(), c1.Name(), topic)
+		swallow(err)
+
+		partitions := make([]int, 0, len(offsets[topic]))
+		for p := range offsets[topic] {
+			partitionId, err := strconv.Atoi(p)
+			swallow(err)
+			partitions = append(partitions, partitionId)
+		}
+		sort.Ints(partitions)
+
+		for _, partitionId := range partitions {
+			offset := offsets[topic][strconv.Itoa(partitionId)]
+			target := "-"
+			if cp, err := z2.MirrorCheckpoint(name, topic, int32(partitionId)); err == nil {
+				if translated, ok := cp.Translate(offset); ok {
+					target = strconv.FormatInt(translated, 10)
+					if this.apply {
+						swallow(c2.CommitConsumerGroupOffset(targetTopic, this.translateGroup, int32(partitionId), translated))
+					}
+				}
+			}
+
+			lines = append(lines, fmt.Sprintf("%s|%d|%d|%s|%s", topic, partitionId, offset, targetTopic, target))
+		}
+	}
+
+	this.Ui.Output(columnize.SimpleFormat(lines))
+	return
+}
+
 func (*Mirror) Synopsis() string {
 	return "Continuously copy data between two remote Kafka clusters"
 }
@@ -98,6 +180,7 @@ Usage: %s mirror [options]
 
     e,g.
     gk mirror -z1 prod -c1 logstash -z2 mirror -c2 aggregator -net 100 -step 2000
+    gk mirror -z1 prod -c1 logstash -z2 mirror -c2 aggregator -translate group1 -apply
 
 Options:
 
@@ -112,6 +195,18 @@ Options:
     -t comma separated topic names
       Only mirror for the specified topics.
 
+    -rules file
+      JSON rules of the topics and messages to mirror:
+      {
+          "include": ["^app1\\."],           topic regex, all if empty
+          "exclude": ["\\.retry$"],          topic regex, higher priority over include
+          "rename": "{{.Zone}}.{{.Topic}}",  target topic template with Zone, Cluster, Topic
+          "placement": "partition",          partition(default) or hash by message key
+          "tags": ["dr"],                    mirror messages with any of the tags
+          "keys": ["^order-"]                mirror messages whose key matches any regex
+      }
+      Missing target topics are created with the source SLA.
+
     -level [debug|trace|info]
 
     -stat
@@ -132,9 +227,17 @@ Options:
       Defaults none.
 
     -commit
-      Auto commit the checkpoint offset.
+      Auto commit the checkpoint offset after target acks.
       Defaults true.
 
+    -translate group
+      Translate the offsets of a consumer group from source to target cluster.
+      '-' means the offset is beyond the checkpoints, reset the group on target manually.
+      It resumes right after the latest consumed checkpoint, a few messages are consumed again.
+
+    -apply
+      Commit the translated offsets on target cluster for the group failover.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
@@ -0,0 +1,63 @@
+package mirror
+
+import (
+	"bytes"
+	"compress/gzip"
+
+	"github.com/golang/snappy"
+)
+
+const (
+	ratioSampleBytes = 64 << 10 // compress this many bytes to sample the ratio
+	ratioSmoothing   = 0.2      // weight of the latest sample
+)
+
+// compressRatio estimates the wire size of the messages when the producer compresses
+// them, which is sampled by compressing batches of the payload with the same codec.
+type compressRatio struct {
+	codec string
+	ratio float64
+	buf   bytes.Buffer
+}
+
+func newCompressRatio(codec string) *compressRatio {
+	return &compressRatio{codec: codec, ratio: 1}
+}
+
+// Estimate returns the estimated wire size of a payload and samples it.
+func (this *compressRatio) Estimate(payload []byte, n int) int {
+	if this.codec != "gzip" && this.codec != "snappy" {
+		return n
+	}
+
+	this.buf.Write(payload)
+	if this.buf.Len() >= ratioSampleBytes {
+		if sample := this.sample(this.buf.Bytes()); sample > 0 {
+			this.ratio = ratioSmoothing*sample + (1-ratioSmoothing)*this.ratio
+		}
+		this.buf.Reset()
+	}
+
+	return int(float64(n)*this.ratio) + 1
+}
+
+func (this *compressRatio) sample(raw []byte) float64 {
+	var compressed int
+	switch this.codec {
+	case "gzip":
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(raw); err != nil {
+			return 0
+		}
+		if err := w.Close(); err != nil {
+			return 0
+		}
+		compressed = b.Len()
+
+	case "snappy":
+		compressed = len(snappy.Encode(nil, raw))
+	}
+
+	return float64(compressed) / float64(len(raw))
+}
@@ -0,0 +1,28 @@
+package mirror
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestCompressRatio(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"uid":12345,"action":"login","ok":true}`), 10)
+
+	plain := newCompressRatio("")
+	assert.Equal(t, len(payload), plain.Estimate(payload, len(payload)))
+
+	for _, codec := range []string{"gzip", "snappy"} {
+		r := newCompressRatio(codec)
+		assert.Equal(t, len(payload)+1, r.Estimate(payload, len(payload)))
+		for i := 0; i < 1000; i++ {
+			r.Estimate(payload, len(payload))
+		}
+
+		// repeated json compresses well
+		n := r.Estimate(payload, len(payload))
+		assert.Equal(t, true, n < len(payload)/2)
+		t.Logf("%s %d -> %d", codec, len(payload), n)
+	}
+}
@@ -11,6 +11,7 @@ type Config struct {
 	AutoCommit     bool
 	ProgressStep   int64
 	ShowStatus     bool
+	Rules          *Rules
 }
 
 func DefaultConfig() *Config {
@@ -21,6 +22,7 @@ func DefaultConfig() *Config {
 		ProgressStep:   5000,
 		ExcludedTopics: make(map[string]struct{}),
 		TopicsOnly:     make(map[string]struct{}),
+		Rules:          DefaultRules(),
 	}
 }
 
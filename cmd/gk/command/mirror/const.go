@@ -1,5 +1,15 @@
 package mirror
 
+import (
+	"time"
+)
+
+const (
+	checkpointInterval = time.Minute
+	maxOffsetSyncs     = 64 // about 1h of offset syncs for the consumer group failover
+	drainTimeout       = time.Second * 30
+)
+
 var (
 	internalTopics = map[string]struct{}{
 		"__consumer_offsets": {},
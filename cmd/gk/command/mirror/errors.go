@@ -0,0 +1,10 @@
+package mirror
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidPlacement = errors.New("placement must be partition or hash")
+	ErrTooFewPartitions = errors.New("target topic has less partitions than source")
+)
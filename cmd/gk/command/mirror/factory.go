@@ -17,6 +17,12 @@ func (this *Mirror) makePub(c2 *zk.ZkCluster) (sarama.AsyncProducer, error) {
 	cf.ChannelBufferSize = 1000
 
 	cf.Producer.Return.Errors = true
+	cf.Producer.Return.Successes = true // commit source offsets after target acks
+	cf.Producer.Partitioner = sarama.NewHashPartitioner
+	if this.Rules.PreservePartition() {
+		cf.Producer.Partitioner = sarama.NewManualPartitioner // keep the partition to translate offsets
+	}
+
 	cf.Producer.Flush.Messages = 2000         // 2000 message in batch
 	cf.Producer.Flush.Frequency = time.Second // flush interval
 	cf.Producer.Flush.MaxMessages = 0         // unlimited
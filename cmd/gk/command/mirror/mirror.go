@@ -10,9 +10,9 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/Shopify/sarama"
 	"github.com/funkygao/gafka"
 	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/sla"
 	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/golib/gofmt"
 	"github.com/funkygao/golib/ratelimiter"
@@ -27,10 +27,14 @@ import (
 //               topics discover
 //               consumer balancing
 //
-// target kafka auto.create.topics.enable=true
+// Rules filter and rename the topics, and the missing target topics are created with
+// the source SLA. With partition placement, the target topic must have at least the
+// partitions of the source topic because messages are mirrored to the same partition.
+// Source offsets are committed after target acks. Without message filters, the offset
+// syncs are checkpointed in target zone, so that consumer groups can fail over to target
+// with translated offsets.
 //
 // TODO
-// * sync pub to assure no message lost
 // * pub pool
 // * we might add a data channel between pub and sub
 type Mirror struct {
@@ -44,6 +48,12 @@ type Mirror struct {
 	transferBytes int64
 
 	bandwidthRateLimiter *ratelimiter.LeakyBucket
+	compressRatio        *compressRatio
+
+	checkpointZone *zk.ZkZone
+	checkpointName string
+
+	targetTopics map[string]string // source topic:target topic
 }
 
 func New(cf *Config) *Mirror {
@@ -100,24 +110,9 @@ func (this *Mirror) runMirror(c1, c2 *zk.ZkCluster, limit int64) {
 	}
 	log.Trace("pub[%s/%s] created", c2.ZkZone().Name(), c2.Name())
 
-	go func(pub sarama.AsyncProducer, c *zk.ZkCluster) {
-		for {
-			select {
-			case <-this.quit:
-				return
-
-			case err := <-pub.Errors():
-				// messages will only be returned here after all retry attempts are exhausted.
-				//
-				// e,g
-				// Failed to produce message to topic xx: write tcp src->kfk: i/o timeout
-				// kafka: broker not connected
-				log.Error("pub[%s/%s] %v", c.ZkZone().Name(), c.Name(), err)
-			}
-		}
-	}(pub, c2)
-
 	group := this.groupName(c1, c2)
+	this.checkpointZone, this.checkpointName = c2.ZkZone(), group
+	this.compressRatio = newCompressRatio(this.Compress)
 	ever := true
 	round := 0
 	for ever {
@@ -130,7 +125,7 @@ func (this *Mirror) runMirror(c1, c2 *zk.ZkCluster, limit int64) {
 			continue
 		}
 
-		topics = this.realTopics(topics)
+		topics = this.prepareTopics(c1, c2, this.realTopics(topics))
 		sub, err := this.makeSub(c1, group, topics)
 		if err != nil {
 			log.Error("#%d [%s/%s] %v", round, c1.ZkZone().Name(), c1.Name(), err)
@@ -172,6 +167,72 @@ func (this *Mirror) runMirror(c1, c2 *zk.ZkCluster, limit int64) {
 	pub.Close()
 }
 
+// prepareTopics applies the rules to source topics and creates the missing target topics.
+func (this *Mirror) prepareTopics(c1, c2 *zk.ZkCluster, topics []string) []string {
+	this.targetTopics = make(map[string]string)
+	r := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if !this.Rules.MatchTopic(topic) {
+			continue
+		}
+
+		target, err := this.Rules.TargetTopic(c1.ZkZone().Name(), c1.Name(), topic)
+		if err != nil {
+			log.Error("rename %s: %v", topic, err)
+			continue
+		}
+
+		if err = this.ensureTopic(c1, c2, topic, target); err != nil {
+			log.Error("%s -> %s: %v", topic, target, err)
+			continue
+		}
+
+		this.targetTopics[topic] = target
+		r = append(r, topic)
+	}
+
+	return r
+}
+
+func (this *Mirror) ensureTopic(c1, c2 *zk.ZkCluster, topic, target string) error {
+	partitions := len(c2.Partitions(target))
+	if partitions == 0 {
+		ts, err := c1.TopicSla(topic)
+		if err != nil {
+			return err
+		}
+
+		log.Info("creating [%s/%s] %s with %+v", c2.ZkZone().Name(), c2.Name(), target, *ts)
+		if _, err = c2.AddTopic(target, ts); err != nil {
+			return err
+		}
+
+		// partitions can't be altered to the same number
+		retention := *ts
+		retention.Partitions = sla.DefaultSla().Partitions
+		if len(retention.DumpForAlterTopic()) > 0 {
+			if _, err = c2.AlterTopic(target, &retention); err != nil {
+				return err
+			}
+		}
+
+		// the partition znodes are created by controller asynchronously
+		partitions = ts.Partitions
+	}
+
+	if this.Rules.PreservePartition() && partitions < len(c1.Partitions(topic)) {
+		return ErrTooFewPartitions
+	}
+
+	return nil
+}
+
 func (this *Mirror) groupName(c1, c2 *zk.ZkCluster) string {
+	return GroupName(c1, c2)
+}
+
+// GroupName is the consumer group of the mirror on source cluster, which also
+// names the checkpoints in target zone.
+func GroupName(c1, c2 *zk.ZkCluster) string {
 	return fmt.Sprintf("_mirror_.%s.%s.%s.%s", c1.ZkZone().Name(), c1.Name(), c2.ZkZone().Name(), c2.Name())
 }
@@ -4,14 +4,49 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/golib/gofmt"
 	"github.com/funkygao/kafka-cg/consumergroup"
 	log "github.com/funkygao/log4go"
 )
 
+// partitionState is the mirror progress of a source partition.
+type partitionState struct {
+	tracker    *offsetTracker
+	checkpoint *zk.MirrorCheckpoint
+	lastSync   zk.OffsetSync // latest acked, not checkpointed yet
+	dirty      bool
+}
+
+// pump moves messages from sub to pub, and the source offset is committed only after
+// the message and all messages before it in the same partition are acked by target.
 func (this *Mirror) pump(sub *consumergroup.ConsumerGroup, pub sarama.AsyncProducer,
 	stop, stopped chan struct{}) {
+	var (
+		partitions  = make(map[string]map[int32]*partitionState) // topic:partition:state
+		inflights   = 0
+		pending     *sarama.ProducerMessage
+		checkpoints = time.NewTicker(checkpointInterval)
+	)
+
+	state := func(topic string, partition int32) *partitionState {
+		if _, present := partitions[topic]; !present {
+			partitions[topic] = make(map[int32]*partitionState)
+		}
+		s, present := partitions[topic][partition]
+		if !present {
+			s = &partitionState{tracker: newOffsetTracker(), checkpoint: this.loadCheckpoint(topic, partition)}
+			partitions[topic][partition] = s
+		}
+		return s
+	}
+
 	defer func() {
+		checkpoints.Stop()
+
+		this.drain(sub, pub, state, inflights)
+		this.checkpoint(partitions)
+
 		log.Trace("closing sub, commit offsets...")
 		sub.Close()
 
@@ -22,6 +57,17 @@ func (this *Mirror) pump(sub *consumergroup.ConsumerGroup, pub sarama.AsyncProdu
 	backoff := time.Second * 2
 	idle := time.Second * 10
 	for {
+		// never block on pub input, otherwise the acks will deadlock the producer
+		var (
+			input    chan<- *sarama.ProducerMessage
+			messages <-chan *sarama.ConsumerMessage
+		)
+		if pending != nil {
+			input = pub.Input()
+		} else {
+			messages = sub.Messages()
+		}
+
 		select {
 		case <-this.quit:
 			log.Trace("got signal quit")
@@ -36,7 +82,14 @@ func (this *Mirror) pump(sub *consumergroup.ConsumerGroup, pub sarama.AsyncProdu
 			active = false
 			log.Info("idle 10s waiting for new message")
 
-		case msg, ok := <-sub.Messages():
+		case <-checkpoints.C:
+			this.checkpoint(partitions)
+
+		case input <- pending:
+			pending = nil
+			inflights++
+
+		case msg, ok := <-messages:
 			if !ok {
 				log.Warn("sub encounters end of message stream")
 				return
@@ -47,18 +100,28 @@ func (this *Mirror) pump(sub *consumergroup.ConsumerGroup, pub sarama.AsyncProdu
 			}
 			active = true
 
-			pub.Input() <- &sarama.ProducerMessage{
-				Topic: msg.Topic,
-				Key:   sarama.ByteEncoder(msg.Key),
-				Value: sarama.ByteEncoder(msg.Value),
+			s := state(msg.Topic, msg.Partition)
+			s.tracker.Add(msg.Offset)
+			if !this.Rules.MatchMessage(msg) {
+				// filtered out messages are done once they are consumed
+				if offset, ok := s.tracker.Ack(msg.Offset); ok && this.AutoCommit {
+					sub.CommitUpto(&sarama.ConsumerMessage{Topic: msg.Topic, Partition: msg.Partition, Offset: offset})
+				}
+				continue
 			}
-			if this.AutoCommit {
-				sub.CommitUpto(msg)
+
+			// keep the partition so that the offsets can be translated
+			pending = &sarama.ProducerMessage{
+				Topic:     this.targetTopics[msg.Topic],
+				Partition: msg.Partition,
+				Key:       sarama.ByteEncoder(msg.Key),
+				Value:     sarama.ByteEncoder(msg.Value),
+				Metadata:  msg,
 			}
 
 			// rate limit, never overflood the limited bandwidth between IDCs
-			// FIXME when compressed, the bandwidth calculation is wrong
-			bytesN := len(msg.Topic) + len(msg.Key) + len(msg.Value) + 20 // payload overhead
+			bytesN := len(msg.Topic) + len(msg.Key) + len(msg.Value)
+			bytesN = this.compressRatio.Estimate(msg.Value, bytesN) + 20 // payload overhead
 			if this.bandwidthRateLimiter != nil && !this.bandwidthRateLimiter.Pour(bytesN) {
 				log.Warn("%s -> bandwidth reached, backoff %s", gofmt.ByteSize(this.transferBytes), backoff)
 				time.Sleep(backoff)
@@ -70,9 +133,93 @@ func (this *Mirror) pump(sub *consumergroup.ConsumerGroup, pub sarama.AsyncProdu
 				log.Trace("%s %s %s", gofmt.Comma(this.transferN), gofmt.ByteSize(this.transferBytes), msg.Topic)
 			}
 
+		case msg := <-pub.Successes():
+			inflights--
+			this.ack(sub, state, msg)
+
+		case err := <-pub.Errors():
+			// messages will only be returned here after all retry attempts are exhausted.
+			// the source offset is not committed, they will be mirrored again after pump restarts
+			//
+			// e,g
+			// Failed to produce message to topic xx: write tcp src->kfk: i/o timeout
+			// kafka: broker not connected
+			log.Error("quitting pump, pub: %v", err)
+			inflights--
+			return
+
 		case err := <-sub.Errors():
 			log.Error("quitting pump %v", err)
 			return
 		}
 	}
 }
+
+func (this *Mirror) ack(sub *consumergroup.ConsumerGroup, state func(string, int32) *partitionState,
+	msg *sarama.ProducerMessage) {
+	src, ok := msg.Metadata.(*sarama.ConsumerMessage)
+	if !ok {
+		return
+	}
+
+	s := state(src.Topic, src.Partition)
+	if this.Rules.Translatable() {
+		s.lastSync = zk.OffsetSync{Source: src.Offset, Target: msg.Offset}
+		s.dirty = true
+	}
+
+	if offset, ok := s.tracker.Ack(src.Offset); ok && this.AutoCommit {
+		sub.CommitUpto(&sarama.ConsumerMessage{Topic: src.Topic, Partition: src.Partition, Offset: offset})
+	}
+}
+
+// drain awaits the in-flight messages acked by target before the offsets are committed.
+func (this *Mirror) drain(sub *consumergroup.ConsumerGroup, pub sarama.AsyncProducer,
+	state func(string, int32) *partitionState, inflights int) {
+	timeout := time.After(drainTimeout)
+	for inflights > 0 {
+		select {
+		case msg := <-pub.Successes():
+			inflights--
+			this.ack(sub, state, msg)
+
+		case err := <-pub.Errors():
+			inflights--
+			log.Error("drain pub: %v", err)
+
+		case <-timeout:
+			log.Warn("%d in-flight messages not acked in %s, will be mirrored again", inflights, drainTimeout)
+			return
+		}
+	}
+}
+
+func (this *Mirror) loadCheckpoint(topic string, partition int32) *zk.MirrorCheckpoint {
+	cp, err := this.checkpointZone.MirrorCheckpoint(this.checkpointName, topic, partition)
+	if err != nil {
+		// e,g. the partition is mirrored the first time
+		return &zk.MirrorCheckpoint{}
+	}
+
+	return cp
+}
+
+// checkpoint persists the offset syncs so that consumer groups can fail over to target.
+func (this *Mirror) checkpoint(partitions map[string]map[int32]*partitionState) {
+	for topic, states := range partitions {
+		for partition, s := range states {
+			if !s.dirty {
+				continue
+			}
+
+			s.checkpoint.Add(s.lastSync, maxOffsetSyncs)
+			s.checkpoint.Mtime = time.Now().Unix()
+			if err := this.checkpointZone.SaveMirrorCheckpoint(this.checkpointName, topic, partition, s.checkpoint); err != nil {
+				log.Error("checkpoint %s/%d: %v", topic, partition, err)
+				continue
+			}
+
+			s.dirty = false
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package mirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"text/template"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+)
+
+const (
+	PlacementPartition = "partition" // same partition as source, required by offset translation
+	PlacementHash      = "hash"      // hash the message key over target partitions
+)
+
+// Rules decides which topics and messages are mirrored and where they go.
+//
+// e,g. aggregate the app1 topics of several zones into a DR cluster:
+//
+//	{
+//	    "include": ["^app1\\."],
+//	    "exclude": ["\\.retry$"],
+//	    "rename": "{{.Zone}}.{{.Topic}}",
+//	    "placement": "hash",
+//	    "tags": ["dr"],
+//	    "keys": ["^order-"]
+//	}
+type Rules struct {
+	Include   []string `json:"include"`   // topic regex, all if empty
+	Exclude   []string `json:"exclude"`   // topic regex, higher priority over include
+	Rename    string   `json:"rename"`    // target topic template with Zone, Cluster, Topic
+	Placement string   `json:"placement"` // partition or hash
+	Tags      []string `json:"tags"`      // mirror messages with any of the tags, all if empty
+	Keys      []string `json:"keys"`      // mirror messages whose key matches any regex, all if empty
+
+	include, exclude, keys []*regexp.Regexp
+	rename                 *template.Template
+}
+
+// DefaultRules mirrors all topics with the same name and partition.
+func DefaultRules() *Rules {
+	r := &Rules{}
+	r.compile()
+	return r
+}
+
+func LoadRules(fn string) (*Rules, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRules(b)
+}
+
+func ParseRules(b []byte) (*Rules, error) {
+	r := &Rules{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+
+	return r, r.compile()
+}
+
+func (this *Rules) compile() (err error) {
+	switch this.Placement {
+	case "":
+		this.Placement = PlacementPartition
+
+	case PlacementPartition, PlacementHash:
+
+	default:
+		return ErrInvalidPlacement
+	}
+
+	if this.include, err = compileRegexps(this.Include); err != nil {
+		return
+	}
+	if this.exclude, err = compileRegexps(this.Exclude); err != nil {
+		return
+	}
+	if this.keys, err = compileRegexps(this.Keys); err != nil {
+		return
+	}
+
+	if this.Rename != "" {
+		this.rename, err = template.New("rename").Option("missingkey=error").Parse(this.Rename)
+	}
+	return
+}
+
+func compileRegexps(exprs []string) ([]*regexp.Regexp, error) {
+	r := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, re)
+	}
+	return r, nil
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTopic checks whether a source topic is mirrored.
+func (this *Rules) MatchTopic(topic string) bool {
+	if matchAny(this.exclude, topic) {
+		return false
+	}
+
+	return len(this.include) == 0 || matchAny(this.include, topic)
+}
+
+// TargetTopic renders the target topic name of a source topic.
+func (this *Rules) TargetTopic(zone, cluster, topic string) (string, error) {
+	if this.rename == nil {
+		return topic, nil
+	}
+
+	var b bytes.Buffer
+	err := this.rename.Execute(&b, struct{ Zone, Cluster, Topic string }{zone, cluster, topic})
+	return b.String(), err
+}
+
+// PreservePartition checks whether messages go to the same partition as source.
+func (this *Rules) PreservePartition() bool {
+	return this.Placement == PlacementPartition
+}
+
+// Translatable checks whether consumer group offsets can be translated to target:
+// partitions are 1:1 mapped and no messages are filtered out.
+func (this *Rules) Translatable() bool {
+	return this.PreservePartition() && len(this.Tags) == 0 && len(this.Keys) == 0
+}
+
+// MatchMessage checks whether a message is mirrored by its tags and key.
+func (this *Rules) MatchMessage(msg *sarama.ConsumerMessage) bool {
+	if len(this.keys) > 0 && !matchAny(this.keys, string(msg.Key)) {
+		return false
+	}
+
+	if len(this.Tags) == 0 {
+		return true
+	}
+
+	headers, _, err := envelope.Decode(msg.Value)
+	if err != nil {
+		return false
+	}
+	for _, tag := range headers.Tags() {
+		for _, t := range this.Tags {
+			if tag == t {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package mirror
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+)
+
+func TestDefaultRules(t *testing.T) {
+	r := DefaultRules()
+	assert.Equal(t, true, r.MatchTopic("app1.foo.v1"))
+	assert.Equal(t, true, r.PreservePartition())
+	assert.Equal(t, true, r.Translatable())
+	target, err := r.TargetTopic("prod", "trade", "app1.foo.v1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "app1.foo.v1", target)
+	assert.Equal(t, true, r.MatchMessage(&sarama.ConsumerMessage{Value: []byte("hello")}))
+}
+
+func TestRulesTopics(t *testing.T) {
+	r, err := ParseRules([]byte(`{"include":["^app1\\."],"exclude":["\\.retry$"],"rename":"{{.Zone}}.{{.Topic}}","placement":"hash"}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, r.MatchTopic("app1.foo.v1"))
+	assert.Equal(t, false, r.MatchTopic("app1.foo.v1.retry"))
+	assert.Equal(t, false, r.MatchTopic("app2.foo.v1"))
+	assert.Equal(t, false, r.PreservePartition())
+	assert.Equal(t, false, r.Translatable())
+
+	target, err := r.TargetTopic("prod", "trade", "app1.foo.v1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "prod.app1.foo.v1", target)
+
+	_, err = ParseRules([]byte(`{"placement":"random"}`))
+	assert.Equal(t, ErrInvalidPlacement, err)
+	_, err = ParseRules([]byte(`{"include":["("]}`))
+	assert.NotEqual(t, nil, err)
+	_, err = ParseRules([]byte(`{"rename":"{{.Zone"}`))
+	assert.NotEqual(t, nil, err)
+}
+
+func TestRulesMessages(t *testing.T) {
+	r, err := ParseRules([]byte(`{"tags":["dr"],"keys":["^order-"]}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, r.PreservePartition())
+	assert.Equal(t, false, r.Translatable())
+
+	tagged, _ := envelope.Encode(envelope.Headers{{Key: envelope.HeaderTag, Value: "a=b;dr"}}, []byte("hello"))
+	untagged, _ := envelope.Encode(envelope.Headers{{Key: envelope.HeaderTag, Value: "a=b"}}, []byte("hello"))
+	assert.Equal(t, true, r.MatchMessage(&sarama.ConsumerMessage{Key: []byte("order-1"), Value: tagged}))
+	assert.Equal(t, false, r.MatchMessage(&sarama.ConsumerMessage{Key: []byte("user-1"), Value: tagged}))
+	assert.Equal(t, false, r.MatchMessage(&sarama.ConsumerMessage{Key: []byte("order-1"), Value: untagged}))
+	assert.Equal(t, false, r.MatchMessage(&sarama.ConsumerMessage{Key: []byte("order-1"), Value: []byte("raw")}))
+}
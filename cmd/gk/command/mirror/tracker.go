@@ -0,0 +1,39 @@
+package mirror
+
+// offsetTracker tracks the in-flight messages of a source partition so that the
+// source offset is committed only after all the messages before it are acked by target.
+type offsetTracker struct {
+	inflights []int64 // source offsets in consuming order
+	acked     map[int64]struct{}
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{acked: make(map[int64]struct{})}
+}
+
+func (this *offsetTracker) Add(offset int64) {
+	this.inflights = append(this.inflights, offset)
+}
+
+// Ack marks the offset as acked and returns the highest offset that can be committed.
+func (this *offsetTracker) Ack(offset int64) (commitable int64, ok bool) {
+	this.acked[offset] = struct{}{}
+
+	commitable = -1
+	for len(this.inflights) > 0 {
+		head := this.inflights[0]
+		if _, present := this.acked[head]; !present {
+			break
+		}
+
+		delete(this.acked, head)
+		this.inflights = this.inflights[1:]
+		commitable, ok = head, true
+	}
+
+	return
+}
+
+func (this *offsetTracker) Pending() int {
+	return len(this.inflights)
+}
@@ -0,0 +1,29 @@
+package mirror
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestOffsetTrackerOutOfOrderAck(t *testing.T) {
+	tracker := newOffsetTracker()
+	for _, offset := range []int64{5, 6, 7, 8} {
+		tracker.Add(offset)
+	}
+
+	_, ok := tracker.Ack(7)
+	assert.Equal(t, false, ok)
+	_, ok = tracker.Ack(6)
+	assert.Equal(t, false, ok)
+
+	offset, ok := tracker.Ack(5)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(7), offset)
+	assert.Equal(t, 1, tracker.Pending())
+
+	offset, ok = tracker.Ack(8)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(8), offset)
+	assert.Equal(t, 0, tracker.Pending())
+}
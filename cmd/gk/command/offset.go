@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/funkygao/columnize"
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
@@ -24,6 +25,7 @@ func (this *Offset) Run(args []string) (exitCode int) {
 		group     string
 		partition string
 		offset    int64
+		at        string
 	)
 	cmdFlags := flag.NewFlagSet("offset", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
@@ -33,17 +35,41 @@ func (this *Offset) Run(args []string) (exitCode int) {
 	cmdFlags.StringVar(&group, "g", "", "")
 	cmdFlags.Int64Var(&offset, "offset", -1, "")
 	cmdFlags.StringVar(&partition, "p", "", "")
+	cmdFlags.StringVar(&at, "at", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
 	if validateArgs(this, this.Ui).
-		require("-z", "-c", "-t", "-g", "-p", "-offset").
+		require("-z", "-c", "-t", "-g").
+		on("-offset", "-p").
 		requireAdminRights("-z").
 		invalid(args) {
 		return 2
 	}
 
+	zkzone := zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
+	zkcluster := zkzone.NewCluster(cluster)
+
+	if at != "" {
+		t, err := zk.ParseOffsetTime(at)
+		if err != nil {
+			this.Ui.Error(err.Error())
+			return 2
+		}
+
+		offsets, err := zkcluster.ResetConsumerGroupOffsetsOfTime(topic, group, t)
+		swallow(err)
+
+		lines := []string{"Partition|Offset"}
+		for partitionId := int32(0); partitionId < int32(len(offsets)); partitionId++ {
+			lines = append(lines, fmt.Sprintf("%d|%d", partitionId, offsets[partitionId]))
+		}
+		this.Ui.Output(columnize.SimpleFormat(lines))
+		this.Ui.Info(fmt.Sprintf("%s reset to %s, restart its consumers to apply", group, t))
+		return
+	}
+
 	if offset < 0 {
 		this.Ui.Error("offset must be positive")
 		return
@@ -57,8 +83,6 @@ func (this *Offset) Run(args []string) (exitCode int) {
 		return
 	}
 
-	zkzone := zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
-	zkcluster := zkzone.NewCluster(cluster)
 	zkcluster.ResetConsumerGroupOffset(topic, group, partition, offset)
 	this.Ui.Output("done")
 	return
@@ -70,10 +94,22 @@ func (*Offset) Synopsis() string {
 
 func (this *Offset) Help() string {
 	help := fmt.Sprintf(`
-Usage: %s offset -z zone -c cluster -t topic -g group -p partition -offset offset
+Usage: %s offset -z zone -c cluster -t topic -g group [options]
 
     %s
 
+    Stop all the consumers of the group before setting offset, otherwise
+    the offset will be overwritten when they commit.
+
+Options:
+
+    -p partition -offset offset
+      Set the offset of a partition.
+
+    -at time
+      Reset all partitions to the offsets of the time, which is either
+      unix timestamp in seconds or RFC3339, e,g. 2016-08-19T14:05:00+08:00
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/go-metrics"
@@ -186,13 +187,22 @@ LOOP:
 				stats.MsgCountPerSecond.Mark(1)
 				stats.MsgBytesPerSecond.Mark(int64(len(msg.Value)))
 			} else {
-				if len(grepB) > 0 && !bytes.Contains(msg.Value, grepB) {
+				// kateway enveloped message: strip the envelope and show its headers
+				value, hdrs := msg.Value, ""
+				if headers, bodyIdx, err := envelope.Decode(msg.Value); err == nil && len(headers) > 0 {
+					value = msg.Value[bodyIdx:]
+					for _, h := range headers {
+						hdrs += fmt.Sprintf(" %s:%s", h.Key, h.Value)
+					}
+				}
+
+				if len(grepB) > 0 && !bytes.Contains(value, grepB) {
 					continue
 				}
 
 				var outmsg string
 				if this.column != "" {
-					if err := json.Unmarshal(msg.Value, &j); err != nil {
+					if err := json.Unmarshal(value, &j); err != nil {
 						this.Ui.Error(err.Error())
 					} else {
 						var colVal string
@@ -230,20 +240,20 @@ LOOP:
 				} else {
 					if this.bodyOnly {
 						if this.pretty {
-							json.Indent(&prettyJSON, msg.Value, "", "    ")
+							json.Indent(&prettyJSON, value, "", "    ")
 							outmsg = string(prettyJSON.Bytes())
 						} else {
-							outmsg = string(msg.Value)
+							outmsg = string(value)
 						}
 					} else if this.colorize {
-						outmsg = fmt.Sprintf("%s/%d %s k:%s, v:%s",
+						outmsg = fmt.Sprintf("%s/%d %s k:%s,%s v:%s",
 							color.Green(msg.Topic), msg.Partition,
-							gofmt.Comma(msg.Offset), string(msg.Key), string(msg.Value))
+							gofmt.Comma(msg.Offset), string(msg.Key), hdrs, string(value))
 					} else {
 						// colored UI will have invisible chars output
-						outmsg = fmt.Sprintf("%s/%d %s k:%s, v:%s",
+						outmsg = fmt.Sprintf("%s/%d %s k:%s,%s v:%s",
 							msg.Topic, msg.Partition,
-							gofmt.Comma(msg.Offset), string(msg.Key), string(msg.Value))
+							gofmt.Comma(msg.Offset), string(msg.Key), hdrs, string(value))
 					}
 				}
 
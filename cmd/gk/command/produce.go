@@ -1,8 +1,10 @@
 package command
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -22,6 +24,8 @@ type Produce struct {
 	benchMode            bool
 	benchmarkMaster      string
 	ackAll               bool
+	batchMode            bool
+	keySeparator         string
 	zkcluster            *zk.ZkCluster
 }
 
@@ -33,6 +37,8 @@ func (this *Produce) Run(args []string) (exitCode int) {
 	cmdFlags.StringVar(&this.topic, "t", "", "")
 	cmdFlags.BoolVar(&this.ackAll, "ackall", false, "")
 	cmdFlags.BoolVar(&this.benchMode, "bench", false, "")
+	cmdFlags.BoolVar(&this.batchMode, "batch", false, "")
+	cmdFlags.StringVar(&this.keySeparator, "sep", "", "")
 	cmdFlags.StringVar(&this.benchmarkMaster, "master", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
@@ -61,6 +67,10 @@ func (this *Produce) Run(args []string) (exitCode int) {
 		return
 	}
 
+	if this.batchMode {
+		return this.produceBatch(zkcluster.BrokerList(), os.Stdin)
+	}
+
 	msg, err := this.Ui.Ask("Input>")
 	swallow(err)
 
@@ -87,6 +97,70 @@ func (this *Produce) Run(args []string) (exitCode int) {
 	return
 }
 
+// produceBatch reads messages line by line and sends them in a single batch.
+func (this *Produce) produceBatch(brokerList []string, r io.Reader) (exitCode int) {
+	var msgs []*sarama.ProducerMessage
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+
+		pm := &sarama.ProducerMessage{Topic: this.topic}
+		if this.keySeparator != "" {
+			if tuples := strings.SplitN(line, this.keySeparator, 2); len(tuples) == 2 {
+				pm.Key = sarama.StringEncoder(tuples[0])
+				line = tuples[1]
+			}
+		}
+		pm.Value = sarama.StringEncoder(line)
+		msgs = append(msgs, pm)
+	}
+	swallow(scanner.Err())
+
+	if len(msgs) == 0 {
+		this.Ui.Warn("empty batch")
+		return
+	}
+
+	cf := sarama.NewConfig()
+	cf.Producer.RequiredAcks = sarama.WaitForLocal
+	if this.ackAll {
+		cf.Producer.RequiredAcks = sarama.WaitForAll
+	}
+	p, err := sarama.NewSyncProducer(brokerList, cf)
+	swallow(err)
+	defer p.Close()
+
+	failed := make(map[*sarama.ProducerMessage]error)
+	if err = p.SendMessages(msgs); err != nil {
+		if errs, ok := err.(sarama.ProducerErrors); ok {
+			for _, pe := range errs {
+				failed[pe.Msg] = pe.Err
+			}
+		} else {
+			this.Ui.Error(err.Error())
+			return 1
+		}
+	}
+
+	for i, pm := range msgs {
+		if err, present := failed[pm]; present {
+			this.Ui.Error(fmt.Sprintf("#%d %v", i, err))
+		} else {
+			this.Ui.Output(fmt.Sprintf("#%d ok, partition:%d, offset:%d", i, pm.Partition, pm.Offset))
+		}
+	}
+
+	this.Ui.Output(fmt.Sprintf("%d/%d sent", len(msgs)-len(failed), len(msgs)))
+	if len(failed) > 0 {
+		exitCode = 1
+	}
+
+	return
+}
+
 func (this *Produce) benchmarkProducer(seq int) {
 	cf := sarama.NewConfig()
 	cf.Producer.RequiredAcks = sarama.WaitForLocal
@@ -131,6 +205,13 @@ Usage: %s produce [options]
     -bench
       Run in benchmark mode.
 
+    -batch
+      Read messages line by line from stdin and send them in a single batch.
+
+    -sep key separator
+      In batch mode, split each line into key and value by the separator.
+      e,g. echo "order123|hello" | gk produce -c cluster -t topic -batch -sep '|'
+
     -ackall
       Replicate to all brokers before reply.
 
@@ -3,75 +3,178 @@ package protos
 import (
 	"encoding/binary"
 	"fmt"
+	"sort"
+	"time"
 )
 
+// kafka decodes kafka requests and responses and correlates them by
+// correlation_id of each client connection to calculate the latency.
+//
+// kafka is not goroutine safe: all the streams are reassembled in the
+// packet reading goroutine.
 type kafka struct {
 	serverPort int
+
+	pending map[string]map[int32]kafkaRequest // client:correlationId:request
+	stats   map[string]*kafkaApiStats         // api name:stats
+	orphans int                               // responses without request
+}
+
+type kafkaRequest struct {
+	apiKey     int16
+	apiVersion int16
+	ts         time.Time
+}
+
+type kafkaApiStats struct {
+	requests, responses int
+	total, max          time.Duration
 }
 
+func newKafka(serverPort int) *kafka {
+	return &kafka{
+		serverPort: serverPort,
+		pending:    make(map[string]map[int32]kafkaRequest),
+		stats:      make(map[string]*kafkaApiStats),
+	}
+}
+
+// Unmarshal decodes a single packet payload that holds a whole kafka frame.
+// Use the stream assembler for frames across packets.
 func (k *kafka) Unmarshal(srcPort, dstPort uint16, payload []byte) string {
+	if len(payload) < 4 {
+		return ""
+	}
+
+	frame := payload[4:]
+	if size := int(binary.BigEndian.Uint32(payload)); size < len(frame) {
+		frame = frame[:size]
+	}
 	if dstPort == uint16(k.serverPort) {
-		return k.unmarshalRequest(payload)
+		return k.Request(fmt.Sprintf(":%d", srcPort), time.Now(), frame)
 	}
 
-	return k.unmarshalResponse(payload)
+	return k.Response(fmt.Sprintf(":%d", dstPort), time.Now(), frame)
 }
 
-func (k *kafka) unmarshalRequest(payload []byte) string {
+func (k *kafka) apiStats(name string) *kafkaApiStats {
+	s, present := k.stats[name]
+	if !present {
+		s = &kafkaApiStats{}
+		k.stats[name] = s
+	}
+	return s
+}
+
+// Request decodes a request frame without the leading message_size sent by client.
+func (k *kafka) Request(client string, ts time.Time, frame []byte) string {
 	// request header
 	//===============
-	// 0-3 message_size
-	// 4-5 api_key
-	// 6-7 api_version
-	// 8-11 correlation_id
-	// 12-13 client_id length
-
-	apiKey := binary.BigEndian.Uint16(payload[4:6])
-	switch apiKey {
-	case 0: // Produce
-	case 1: // Fetch
-		apiVersion := binary.BigEndian.Uint16(payload[6:8]) // 0 1 2
-
-		clientIDLen := binary.BigEndian.Uint16(payload[12:14])
-		clientID := string(payload[14 : 14+int(clientIDLen)])
-
-		offset := int(clientIDLen + 14)
-		// replica:4 max_wait:4 min_bytes:4 topicsN:4
-		offset += 4 * 4
-		topicLen := binary.BigEndian.Uint16(payload[offset : offset+2])
-		offset += 2
-		topic := string(payload[offset : offset+int(topicLen)])
-		offset += int(topicLen)
-		partitionN := int(binary.BigEndian.Uint32(payload[offset : offset+4]))
-		offset += 4
-		partition := binary.BigEndian.Uint32(payload[offset : offset+4])
-		offset += 4
-		msgOffset := binary.BigEndian.Uint64(payload[offset : offset+8])
-		offset += 8
-		maxBytes := binary.BigEndian.Uint32(payload[offset : offset+4])
-		return fmt.Sprintf("Fetch-%d[%s/%2d#%d] @%-11d max:%-8d %s",
-			apiVersion, topic, partition, partitionN, msgOffset, maxBytes, clientID)
-
-	case 2: // Offsets
-	case 3: // Metadata
-	case 4: // LeaderAndIsr
-	case 5: // StopReplica
-	case 6: // UpdateMetadata
-	case 7: // ControlledShutdown
-	case 8: // OffsetCommit
-	case 9: // OffsetFetch
-	case 19: // CreateTopics
-	case 20: // DeleteTopics
-	}
-
-	return ""
+	// api_key int16
+	// api_version int16
+	// correlation_id int32
+	// client_id string
+	r := &kafkaReader{b: frame}
+	apiKey := r.int16()
+	apiVersion := r.int16()
+	correlationId := r.int32()
+	clientId := r.str()
+	if r.err != nil {
+		return ""
+	}
+
+	api, present := kafkaApis[apiKey]
+	if !present {
+		return fmt.Sprintf("api:%d-%d #%d %s %dB", apiKey, apiVersion, correlationId, clientId, len(frame))
+	}
+
+	detail := api.request(r, apiVersion)
+	if r.err != nil {
+		detail += " " + r.err.Error()
+	}
+
+	k.apiStats(api.name).requests++
+	if apiKey != 0 || acksOfProduce(frame, clientId) != 0 {
+		// Produce with acks=0 has no response
+		if _, present := k.pending[client]; !present {
+			k.pending[client] = make(map[int32]kafkaRequest)
+		}
+		k.pending[client][correlationId] = kafkaRequest{apiKey: apiKey, apiVersion: apiVersion, ts: ts}
+	}
+
+	return fmt.Sprintf("%s-%d #%d %s %s", api.name, apiVersion, correlationId, clientId, detail)
 }
 
-func (k *kafka) unmarshalResponse(payload []byte) string {
+// Response decodes a response frame without the leading message_size sent by broker.
+func (k *kafka) Response(client string, ts time.Time, frame []byte) string {
 	// response header
 	//================
-	// 0-3 message_size
-	// 4-7 correlation_id
+	// correlation_id int32
+	r := &kafkaReader{b: frame}
+	correlationId := r.int32()
+	if r.err != nil {
+		return ""
+	}
+
+	req, present := k.pending[client][correlationId]
+	if !present {
+		// the request was sent before capture started
+		k.orphans++
+		return fmt.Sprintf("? #%d %dB", correlationId, len(frame))
+	}
+	delete(k.pending[client], correlationId)
+
+	api := kafkaApis[req.apiKey]
+	detail := api.response(r, req.apiVersion)
+	if r.err != nil {
+		detail += " " + r.err.Error()
+	}
+
+	latency := ts.Sub(req.ts)
+	s := k.apiStats(api.name)
+	s.responses++
+	s.total += latency
+	if latency > s.max {
+		s.max = latency
+	}
+
+	return fmt.Sprintf("%s-%d #%d %s %s", api.name, req.apiVersion, correlationId, latency, detail)
+}
+
+// Closed discards the pending requests of a closed client connection.
+func (k *kafka) Closed(client string) {
+	delete(k.pending, client)
+}
+
+// Report returns the per api latency in columnize format.
+func (k *kafka) Report() []string {
+	names := make([]string, 0, len(k.stats))
+	for name := range k.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	return ""
+	lines := []string{"API|Requests|Responses|AvgLatency|MaxLatency"}
+	for _, name := range names {
+		s := k.stats[name]
+		var avg time.Duration
+		if s.responses > 0 {
+			avg = s.total / time.Duration(s.responses)
+		}
+		lines = append(lines, fmt.Sprintf("%s|%d|%d|%s|%s", name, s.requests, s.responses, avg, s.max))
+	}
+	if k.orphans > 0 {
+		lines = append(lines, fmt.Sprintf("?|-|%d|-|-", k.orphans))
+	}
+	return lines
+}
+
+// acksOfProduce returns the required acks of a Produce request frame.
+func acksOfProduce(frame []byte, clientId string) int16 {
+	// api_key:2 api_version:2 correlation_id:4 client_id:2+len
+	off := 10 + len(clientId)
+	if len(frame) < off+2 {
+		return -1
+	}
+	return int16(binary.BigEndian.Uint16(frame[off:]))
 }
@@ -0,0 +1,649 @@
+package protos
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errKafkaShortFrame = errors.New("short frame")
+
+// kafkaReader decodes the kafka primitive types in big endian.
+// The first decode error is sticky and all subsequent reads return zero value.
+type kafkaReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *kafkaReader) need(n int) bool {
+	if r.err != nil {
+		return false
+	}
+	if n < 0 || r.off+n > len(r.b) {
+		r.err = errKafkaShortFrame
+		return false
+	}
+	return true
+}
+
+func (r *kafkaReader) int8() int8 {
+	if !r.need(1) {
+		return 0
+	}
+	v := int8(r.b[r.off])
+	r.off++
+	return v
+}
+
+func (r *kafkaReader) int16() int16 {
+	if !r.need(2) {
+		return 0
+	}
+	v := int16(binary.BigEndian.Uint16(r.b[r.off:]))
+	r.off += 2
+	return v
+}
+
+func (r *kafkaReader) int32() int32 {
+	if !r.need(4) {
+		return 0
+	}
+	v := int32(binary.BigEndian.Uint32(r.b[r.off:]))
+	r.off += 4
+	return v
+}
+
+func (r *kafkaReader) int64() int64 {
+	if !r.need(8) {
+		return 0
+	}
+	v := int64(binary.BigEndian.Uint64(r.b[r.off:]))
+	r.off += 8
+	return v
+}
+
+// str reads a nullable string, null is returned as empty string.
+func (r *kafkaReader) str() string {
+	n := int(r.int16())
+	if n < 0 || !r.need(n) {
+		return ""
+	}
+	v := string(r.b[r.off : r.off+n])
+	r.off += n
+	return v
+}
+
+// bytes skips a nullable bytes and returns its length.
+func (r *kafkaReader) bytes() int {
+	n := int(r.int32())
+	if n < 0 || !r.need(n) {
+		return 0
+	}
+	r.off += n
+	return n
+}
+
+// array calls fn for each element and returns the array length, -1 for null array.
+func (r *kafkaReader) array(fn func()) int {
+	n := int(r.int32())
+	if n > len(r.b)-r.off {
+		// each element takes at least 1 byte
+		r.err = errKafkaShortFrame
+		return 0
+	}
+	for i := 0; i < n && r.err == nil; i++ {
+		fn()
+	}
+	return n
+}
+
+func (r *kafkaReader) int32s() []int32 {
+	var v []int32
+	r.array(func() {
+		v = append(v, r.int32())
+	})
+	return v
+}
+
+// messageSet skips a message set and returns how many messages in it.
+// A compressed wrapper message is counted as 1 message.
+func (r *kafkaReader) messageSet() (msgs int, size int) {
+	size = int(r.int32())
+	if !r.need(size) {
+		return
+	}
+
+	end := r.off + size
+	for off := r.off; off+12 <= end; msgs++ {
+		// offset:8 message_size:4
+		n := int(int32(binary.BigEndian.Uint32(r.b[off+8:])))
+		if n < 0 || off+12+n > end {
+			// broker might return a partial message at the end of fetch response
+			break
+		}
+		off += 12 + n
+	}
+	r.off = end
+	return
+}
+
+func kafkaErr(code int16) string {
+	if code == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" err:%d", code)
+}
+
+type kafkaApi struct {
+	name     string
+	request  func(r *kafkaReader, ver int16) string
+	response func(r *kafkaReader, ver int16) string
+}
+
+// kafkaApis covers all the api keys of kafka 0.8 ~ 0.10.
+var kafkaApis = map[int16]kafkaApi{
+	0:  {"Produce", decodeProduceRequest, decodeProduceResponse},
+	1:  {"Fetch", decodeFetchRequest, decodeFetchResponse},
+	2:  {"Offsets", decodeOffsetsRequest, decodeOffsetsResponse},
+	3:  {"Metadata", decodeMetadataRequest, decodeMetadataResponse},
+	4:  {"LeaderAndIsr", decodeLeaderAndIsrRequest, decodePartitionErrorsResponse},
+	5:  {"StopReplica", decodeStopReplicaRequest, decodePartitionErrorsResponse},
+	6:  {"UpdateMetadata", decodeUpdateMetadataRequest, decodeErrorResponse},
+	7:  {"ControlledShutdown", decodeControlledShutdownRequest, decodeControlledShutdownResponse},
+	8:  {"OffsetCommit", decodeOffsetCommitRequest, decodeOffsetCommitResponse},
+	9:  {"OffsetFetch", decodeOffsetFetchRequest, decodeOffsetFetchResponse},
+	10: {"GroupCoordinator", decodeGroupRequest, decodeGroupCoordinatorResponse},
+	11: {"JoinGroup", decodeJoinGroupRequest, decodeJoinGroupResponse},
+	12: {"Heartbeat", decodeHeartbeatRequest, decodeErrorResponse},
+	13: {"LeaveGroup", decodeLeaveGroupRequest, decodeErrorResponse},
+	14: {"SyncGroup", decodeSyncGroupRequest, decodeSyncGroupResponse},
+	15: {"DescribeGroups", decodeDescribeGroupsRequest, decodeDescribeGroupsResponse},
+	16: {"ListGroups", decodeEmptyRequest, decodeListGroupsResponse},
+	17: {"SaslHandshake", decodeSaslHandshakeRequest, decodeSaslHandshakeResponse},
+	18: {"ApiVersions", decodeEmptyRequest, decodeApiVersionsResponse},
+	19: {"CreateTopics", decodeCreateTopicsRequest, decodeCreateTopicsResponse},
+	20: {"DeleteTopics", decodeDeleteTopicsRequest, decodeDeleteTopicsResponse},
+}
+
+// forPartitions iterates the [topic [partition ...]] structure, fn decodes the
+// partition fields after partition id and returns its summary.
+func (r *kafkaReader) forPartitions(fn func(topic string, partition int32) string) string {
+	var parts []string
+	r.array(func() {
+		topic := r.str()
+		r.array(func() {
+			partition := r.int32()
+			if s := fn(topic, partition); s != "" {
+				parts = append(parts, s)
+			}
+		})
+	})
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func decodeEmptyRequest(r *kafkaReader, ver int16) string {
+	return ""
+}
+
+func decodeErrorResponse(r *kafkaReader, ver int16) string {
+	return strings.TrimSpace(kafkaErr(r.int16()))
+}
+
+func decodeProduceRequest(r *kafkaReader, ver int16) string {
+	acks := r.int16()
+	timeout := r.int32()
+	return fmt.Sprintf("acks:%d timeout:%dms %s", acks, timeout,
+		r.forPartitions(func(topic string, partition int32) string {
+			msgs, size := r.messageSet()
+			return fmt.Sprintf("%s/%d:%d/%dB", topic, partition, msgs, size)
+		}))
+}
+
+func decodeProduceResponse(r *kafkaReader, ver int16) string {
+	s := r.forPartitions(func(topic string, partition int32) string {
+		errCode := r.int16()
+		offset := r.int64()
+		if ver >= 2 {
+			r.int64() // timestamp
+		}
+		return fmt.Sprintf("%s/%d@%d%s", topic, partition, offset, kafkaErr(errCode))
+	})
+	if ver >= 1 {
+		s += fmt.Sprintf(" throttle:%dms", r.int32())
+	}
+	return s
+}
+
+func decodeFetchRequest(r *kafkaReader, ver int16) string {
+	replica := r.int32()
+	maxWait := r.int32()
+	minBytes := r.int32()
+	if ver >= 3 {
+		r.int32() // max_bytes
+	}
+	return fmt.Sprintf("replica:%d wait:%dms min:%d %s", replica, maxWait, minBytes,
+		r.forPartitions(func(topic string, partition int32) string {
+			offset := r.int64()
+			maxBytes := r.int32()
+			return fmt.Sprintf("%s/%d@%d max:%d", topic, partition, offset, maxBytes)
+		}))
+}
+
+func decodeFetchResponse(r *kafkaReader, ver int16) string {
+	var throttle int32
+	if ver >= 1 {
+		throttle = r.int32()
+	}
+	s := r.forPartitions(func(topic string, partition int32) string {
+		errCode := r.int16()
+		hw := r.int64()
+		msgs, size := r.messageSet()
+		return fmt.Sprintf("%s/%d hw:%d %d/%dB%s", topic, partition, hw, msgs, size, kafkaErr(errCode))
+	})
+	if ver >= 1 {
+		s += fmt.Sprintf(" throttle:%dms", throttle)
+	}
+	return s
+}
+
+func decodeOffsetsRequest(r *kafkaReader, ver int16) string {
+	replica := r.int32()
+	return fmt.Sprintf("replica:%d %s", replica,
+		r.forPartitions(func(topic string, partition int32) string {
+			ts := r.int64()
+			if ver == 0 {
+				r.int32() // max_num_offsets
+			}
+			return fmt.Sprintf("%s/%d@%d", topic, partition, ts)
+		}))
+}
+
+func decodeOffsetsResponse(r *kafkaReader, ver int16) string {
+	return r.forPartitions(func(topic string, partition int32) string {
+		errCode := r.int16()
+		if ver == 0 {
+			offsets := make([]int64, 0, 2)
+			r.array(func() {
+				offsets = append(offsets, r.int64())
+			})
+			return fmt.Sprintf("%s/%d%v%s", topic, partition, offsets, kafkaErr(errCode))
+		}
+
+		r.int64() // timestamp
+		return fmt.Sprintf("%s/%d@%d%s", topic, partition, r.int64(), kafkaErr(errCode))
+	})
+}
+
+func decodeMetadataRequest(r *kafkaReader, ver int16) string {
+	var topics []string
+	n := r.array(func() {
+		topics = append(topics, r.str())
+	})
+	if n < 0 || (n == 0 && ver == 0) {
+		return "all topics"
+	}
+	return fmt.Sprintf("%v", topics)
+}
+
+func decodeMetadataResponse(r *kafkaReader, ver int16) string {
+	brokers := r.array(func() {
+		r.int32() // node_id
+		r.str()   // host
+		r.int32() // port
+		if ver >= 1 {
+			r.str() // rack
+		}
+	})
+	if ver >= 2 {
+		r.str() // cluster_id
+	}
+	controller := int32(-1)
+	if ver >= 1 {
+		controller = r.int32()
+	}
+
+	var topics []string
+	r.array(func() {
+		errCode := r.int16()
+		topic := r.str()
+		if ver >= 1 {
+			r.int8() // is_internal
+		}
+		var partitions, underReplicated int
+		partitions = r.array(func() {
+			r.int16() // error_code
+			r.int32() // partition
+			r.int32() // leader
+			replicas := r.int32s()
+			isr := r.int32s()
+			if len(isr) < len(replicas) {
+				underReplicated++
+			}
+		})
+		s := fmt.Sprintf("%s:%dP", topic, partitions)
+		if underReplicated > 0 {
+			s += fmt.Sprintf("/%dU", underReplicated)
+		}
+		topics = append(topics, s+kafkaErr(errCode))
+	})
+
+	return fmt.Sprintf("brokers:%d controller:%d [%s]", brokers, controller, strings.Join(topics, " "))
+}
+
+// partitionStates decodes the partition states of LeaderAndIsr and UpdateMetadata.
+func (r *kafkaReader) partitionStates() int {
+	return r.array(func() {
+		r.str()   // topic
+		r.int32() // partition
+		r.int32() // controller_epoch
+		r.int32() // leader
+		r.int32() // leader_epoch
+		r.int32s()
+		r.int32() // zk_version
+		r.int32s()
+	})
+}
+
+func decodeLeaderAndIsrRequest(r *kafkaReader, ver int16) string {
+	controller := r.int32()
+	epoch := r.int32()
+	partitions := r.partitionStates()
+	leaders := r.array(func() {
+		r.int32() // id
+		r.str()   // host
+		r.int32() // port
+	})
+	return fmt.Sprintf("controller:%d epoch:%d partitions:%d leaders:%d", controller, epoch,
+		partitions, leaders)
+}
+
+func decodeStopReplicaRequest(r *kafkaReader, ver int16) string {
+	controller := r.int32()
+	epoch := r.int32()
+	deletePartitions := r.int8() != 0
+	partitions := r.array(func() {
+		r.str()   // topic
+		r.int32() // partition
+	})
+	return fmt.Sprintf("controller:%d epoch:%d delete:%v partitions:%d", controller, epoch,
+		deletePartitions, partitions)
+}
+
+func decodePartitionErrorsResponse(r *kafkaReader, ver int16) string {
+	errCode := r.int16()
+	var failed []string
+	partitions := r.array(func() {
+		topic := r.str()
+		partition := r.int32()
+		if code := r.int16(); code != 0 {
+			failed = append(failed, fmt.Sprintf("%s/%d%s", topic, partition, kafkaErr(code)))
+		}
+	})
+	return fmt.Sprintf("partitions:%d%s %v", partitions, kafkaErr(errCode), failed)
+}
+
+func decodeUpdateMetadataRequest(r *kafkaReader, ver int16) string {
+	controller := r.int32()
+	epoch := r.int32()
+	partitions := r.partitionStates()
+	brokers := r.array(func() {
+		r.int32() // id
+		if ver == 0 {
+			r.str()   // host
+			r.int32() // port
+			return
+		}
+
+		r.array(func() {
+			r.int32() // port
+			r.str()   // host
+			r.int16() // security_protocol_type
+		})
+		if ver >= 2 {
+			r.str() // rack
+		}
+	})
+	return fmt.Sprintf("controller:%d epoch:%d partitions:%d brokers:%d", controller, epoch,
+		partitions, brokers)
+}
+
+func decodeControlledShutdownRequest(r *kafkaReader, ver int16) string {
+	return fmt.Sprintf("broker:%d", r.int32())
+}
+
+func decodeControlledShutdownResponse(r *kafkaReader, ver int16) string {
+	errCode := r.int16()
+	remaining := r.array(func() {
+		r.str()   // topic
+		r.int32() // partition
+	})
+	return fmt.Sprintf("remaining:%d%s", remaining, kafkaErr(errCode))
+}
+
+func decodeOffsetCommitRequest(r *kafkaReader, ver int16) string {
+	group := r.str()
+	s := "group:" + group
+	if ver >= 1 {
+		generation := r.int32()
+		member := r.str()
+		s += fmt.Sprintf(" gen:%d member:%s", generation, member)
+	}
+	if ver >= 2 {
+		s += fmt.Sprintf(" retention:%dms", r.int64())
+	}
+	return s + " " + r.forPartitions(func(topic string, partition int32) string {
+		offset := r.int64()
+		if ver == 1 {
+			r.int64() // timestamp
+		}
+		r.str() // metadata
+		return fmt.Sprintf("%s/%d@%d", topic, partition, offset)
+	})
+}
+
+func decodeOffsetCommitResponse(r *kafkaReader, ver int16) string {
+	return r.forPartitions(func(topic string, partition int32) string {
+		return fmt.Sprintf("%s/%d%s", topic, partition, kafkaErr(r.int16()))
+	})
+}
+
+func decodeOffsetFetchRequest(r *kafkaReader, ver int16) string {
+	group := r.str()
+	return "group:" + group + " " + r.forPartitions(func(topic string, partition int32) string {
+		return fmt.Sprintf("%s/%d", topic, partition)
+	})
+}
+
+func decodeOffsetFetchResponse(r *kafkaReader, ver int16) string {
+	return r.forPartitions(func(topic string, partition int32) string {
+		offset := r.int64()
+		r.str() // metadata
+		return fmt.Sprintf("%s/%d@%d%s", topic, partition, offset, kafkaErr(r.int16()))
+	})
+}
+
+func decodeGroupRequest(r *kafkaReader, ver int16) string {
+	return "group:" + r.str()
+}
+
+func decodeGroupCoordinatorResponse(r *kafkaReader, ver int16) string {
+	errCode := r.int16()
+	node := r.int32()
+	host := r.str()
+	port := r.int32()
+	return fmt.Sprintf("coordinator:%d %s:%d%s", node, host, port, kafkaErr(errCode))
+}
+
+func decodeJoinGroupRequest(r *kafkaReader, ver int16) string {
+	group := r.str()
+	sessionTimeout := r.int32()
+	if ver >= 1 {
+		r.int32() // rebalance_timeout
+	}
+	member := r.str()
+	protocolType := r.str()
+	var protocols []string
+	r.array(func() {
+		protocols = append(protocols, r.str())
+		r.bytes() // metadata
+	})
+	return fmt.Sprintf("group:%s session:%dms member:%s type:%s protocols:%v", group, sessionTimeout,
+		member, protocolType, protocols)
+}
+
+func decodeJoinGroupResponse(r *kafkaReader, ver int16) string {
+	errCode := r.int16()
+	generation := r.int32()
+	protocol := r.str()
+	leader := r.str()
+	member := r.str()
+	members := r.array(func() {
+		r.str()   // member_id
+		r.bytes() // metadata
+	})
+	return fmt.Sprintf("gen:%d protocol:%s leader:%s member:%s members:%d%s", generation, protocol,
+		leader, member, members, kafkaErr(errCode))
+}
+
+func decodeHeartbeatRequest(r *kafkaReader, ver int16) string {
+	group := r.str()
+	generation := r.int32()
+	member := r.str()
+	return fmt.Sprintf("group:%s gen:%d member:%s", group, generation, member)
+}
+
+func decodeLeaveGroupRequest(r *kafkaReader, ver int16) string {
+	group := r.str()
+	member := r.str()
+	return fmt.Sprintf("group:%s member:%s", group, member)
+}
+
+func decodeSyncGroupRequest(r *kafkaReader, ver int16) string {
+	group := r.str()
+	generation := r.int32()
+	member := r.str()
+	assignments := r.array(func() {
+		r.str()   // member_id
+		r.bytes() // assignment
+	})
+	return fmt.Sprintf("group:%s gen:%d member:%s assignments:%d", group, generation, member, assignments)
+}
+
+func decodeSyncGroupResponse(r *kafkaReader, ver int16) string {
+	errCode := r.int16()
+	return fmt.Sprintf("assignment:%dB%s", r.bytes(), kafkaErr(errCode))
+}
+
+func decodeDescribeGroupsRequest(r *kafkaReader, ver int16) string {
+	var groups []string
+	r.array(func() {
+		groups = append(groups, r.str())
+	})
+	return fmt.Sprintf("%v", groups)
+}
+
+func decodeDescribeGroupsResponse(r *kafkaReader, ver int16) string {
+	var groups []string
+	r.array(func() {
+		errCode := r.int16()
+		group := r.str()
+		state := r.str()
+		r.str() // protocol_type
+		protocol := r.str()
+		members := r.array(func() {
+			r.str()   // member_id
+			r.str()   // client_id
+			r.str()   // client_host
+			r.bytes() // metadata
+			r.bytes() // assignment
+		})
+		groups = append(groups, fmt.Sprintf("%s:%s/%s/%d%s", group, state, protocol, members, kafkaErr(errCode)))
+	})
+	return "[" + strings.Join(groups, " ") + "]"
+}
+
+func decodeListGroupsResponse(r *kafkaReader, ver int16) string {
+	errCode := r.int16()
+	var groups []string
+	r.array(func() {
+		groups = append(groups, r.str())
+		r.str() // protocol_type
+	})
+	return fmt.Sprintf("%v%s", groups, kafkaErr(errCode))
+}
+
+func decodeSaslHandshakeRequest(r *kafkaReader, ver int16) string {
+	return "mechanism:" + r.str()
+}
+
+func decodeSaslHandshakeResponse(r *kafkaReader, ver int16) string {
+	errCode := r.int16()
+	var mechanisms []string
+	r.array(func() {
+		mechanisms = append(mechanisms, r.str())
+	})
+	return fmt.Sprintf("%v%s", mechanisms, kafkaErr(errCode))
+}
+
+func decodeApiVersionsResponse(r *kafkaReader, ver int16) string {
+	errCode := r.int16()
+	var apis []string
+	r.array(func() {
+		key := r.int16()
+		minVer := r.int16()
+		maxVer := r.int16()
+		apis = append(apis, fmt.Sprintf("%d:%d-%d", key, minVer, maxVer))
+	})
+	return "[" + strings.Join(apis, " ") + "]" + kafkaErr(errCode)
+}
+
+func decodeCreateTopicsRequest(r *kafkaReader, ver int16) string {
+	var topics []string
+	r.array(func() {
+		topic := r.str()
+		partitions := r.int32()
+		replicationFactor := r.int16()
+		r.array(func() {
+			r.int32() // partition
+			r.int32s()
+		})
+		r.array(func() {
+			r.str() // config_key
+			r.str() // config_value
+		})
+		topics = append(topics, fmt.Sprintf("%s:%dP/%dR", topic, partitions, replicationFactor))
+	})
+	timeout := r.int32()
+	return fmt.Sprintf("[%s] timeout:%dms", strings.Join(topics, " "), timeout)
+}
+
+func decodeDeleteTopicsRequest(r *kafkaReader, ver int16) string {
+	var topics []string
+	r.array(func() {
+		topics = append(topics, r.str())
+	})
+	return fmt.Sprintf("%v timeout:%dms", topics, r.int32())
+}
+
+func decodeCreateTopicsResponse(r *kafkaReader, ver int16) string {
+	return r.topicErrors(ver >= 1)
+}
+
+func decodeDeleteTopicsResponse(r *kafkaReader, ver int16) string {
+	return r.topicErrors(false)
+}
+
+func (r *kafkaReader) topicErrors(withMessage bool) string {
+	var topics []string
+	r.array(func() {
+		topic := r.str()
+		errCode := r.int16()
+		if withMessage {
+			r.str() // error_message
+		}
+		topics = append(topics, topic+kafkaErr(errCode))
+	})
+	return "[" + strings.Join(topics, " ") + "]"
+}
@@ -0,0 +1,118 @@
+package protos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+type kafkaFrame struct {
+	bytes.Buffer
+}
+
+func (f *kafkaFrame) int16(v int16) *kafkaFrame {
+	binary.Write(f, binary.BigEndian, v)
+	return f
+}
+
+func (f *kafkaFrame) int32(v int32) *kafkaFrame {
+	binary.Write(f, binary.BigEndian, v)
+	return f
+}
+
+func (f *kafkaFrame) int64(v int64) *kafkaFrame {
+	binary.Write(f, binary.BigEndian, v)
+	return f
+}
+
+func (f *kafkaFrame) str(s string) *kafkaFrame {
+	f.int16(int16(len(s)))
+	f.WriteString(s)
+	return f
+}
+
+func TestKafkaProduceLatency(t *testing.T) {
+	k := newKafka(9092)
+	t0 := time.Unix(1471565204, 0)
+
+	// a message set of 2 messages
+	var msgs kafkaFrame
+	msgs.int64(0).int32(5).str("abc")
+	msgs.int64(1).int32(5).str("def")
+
+	var req kafkaFrame
+	req.int16(0).int16(2).int32(7).str("gk") // header
+	req.int16(1).int32(1000)                 // acks timeout
+	req.int32(1).str("foo").int32(1).int32(3).int32(int32(msgs.Len()))
+	req.Write(msgs.Bytes())
+	assert.Equal(t, "Produce-2 #7 gk acks:1 timeout:1000ms [foo/3:2/34B]",
+		k.Request("10.1.1.1:5000", t0, req.Bytes()))
+
+	var resp kafkaFrame
+	resp.int32(7)
+	resp.int32(1).str("foo").int32(1).int32(3).int16(0).int64(100).int64(-1)
+	resp.int32(0) // throttle
+	assert.Equal(t, "Produce-2 #7 5ms [foo/3@100] throttle:0ms",
+		k.Response("10.1.1.1:5000", t0.Add(5*time.Millisecond), resp.Bytes()))
+
+	// correlation id from another client
+	assert.Equal(t, "? #7 43B", k.Response("10.1.1.2:5000", t0, resp.Bytes()))
+
+	lines := k.Report()
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t, "Produce|1|1|5ms|5ms", lines[1])
+	assert.Equal(t, "?|-|1|-|-", lines[2])
+}
+
+func TestKafkaProduceWithoutAcks(t *testing.T) {
+	k := newKafka(9092)
+
+	var req kafkaFrame
+	req.int16(0).int16(0).int32(1).str("")
+	req.int16(0).int32(1000).int32(0)
+	assert.Equal(t, "Produce-0 #1  acks:0 timeout:1000ms []", k.Request("c", time.Now(), req.Bytes()))
+	assert.Equal(t, 0, len(k.pending["c"]))
+}
+
+func TestKafkaMetadata(t *testing.T) {
+	k := newKafka(9092)
+
+	var req kafkaFrame
+	req.int16(3).int16(1).int32(9).str("sarama")
+	req.int32(-1)
+	assert.Equal(t, "Metadata-1 #9 sarama all topics", k.Request("c", time.Now(), req.Bytes()))
+
+	var resp kafkaFrame
+	resp.int32(9)
+	resp.int32(1).int32(0).str("localhost").int32(9092).int16(-1) // brokers
+	resp.int32(0)                                                 // controller
+	resp.int32(1).int16(0).str("foo").WriteByte(0)
+	resp.int32(2)
+	resp.int16(0).int32(0).int32(0).int32(2).int32(0).int32(1).int32(2).int32(0).int32(1)
+	resp.int16(0).int32(1).int32(1).int32(2).int32(1).int32(0).int32(1).int32(1)
+	s := k.Response("c", time.Now(), resp.Bytes())
+	assert.Equal(t, "brokers:1 controller:0 [foo:2P/1U]", s[len(s)-34:])
+}
+
+func TestKafkaShortFrame(t *testing.T) {
+	k := newKafka(9092)
+
+	var req kafkaFrame
+	req.int16(1).int16(0).int32(3).str("gk")
+	req.int32(-1).int32(100).int32(1).int32(1).str("foo").int32(1).int32(0)
+	assert.Equal(t, "Fetch-0 #3 gk replica:-1 wait:100ms min:1 [foo/0@0 max:0] short frame",
+		k.Request("c", time.Now(), req.Bytes()))
+
+	assert.Equal(t, "", k.Request("c", time.Now(), []byte{0, 1}))
+}
+
+func TestKafkaUnknownApi(t *testing.T) {
+	k := newKafka(9092)
+
+	var req kafkaFrame
+	req.int16(99).int16(0).int32(1).str("gk")
+	assert.Equal(t, "api:99-0 #1 gk 12B", k.Request("c", time.Now(), req.Bytes()))
+}
@@ -13,9 +13,15 @@ func New(prot string, serverPort int) Protocol {
 		return &zk{serverPort: serverPort}
 
 	case "kafka":
-		return &kafka{serverPort: serverPort}
+		return newKafka(serverPort)
 
 	default:
 		return nil
 	}
 }
+
+// Reporter is implemented by the protocols that keep traffic statistics.
+type Reporter interface {
+	// Report returns the statistics in columnize format.
+	Report() []string
+}
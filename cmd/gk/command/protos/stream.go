@@ -1,18 +1,21 @@
 package protos
 
 import (
-	"bufio"
-	"io"
-	"net/http"
+	"encoding/binary"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/funkygao/gocli"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/tcpassembly"
-	"github.com/google/gopacket/tcpassembly/tcpreader"
 )
 
-func Assembler(prot string, serverPort int, ui cli.Ui) *tcpassembly.Assembler {
+// kafkaMaxFrameSize is the sanity check of frame size: the capture might
+// start in the middle of a frame or lose packets.
+const kafkaMaxFrameSize = 100 << 20
+
+func Assembler(prot Protocol, serverPort int, ui cli.Ui) *tcpassembly.Assembler {
 	streamFactory := &tcpStreamFactory{
 		protocol:   prot,
 		serverPort: serverPort,
@@ -23,48 +26,106 @@ func Assembler(prot string, serverPort int, ui cli.Ui) *tcpassembly.Assembler {
 }
 
 type tcpStreamFactory struct {
-	protocol   string
+	protocol   Protocol
 	serverPort int
 	ui         cli.Ui
 }
 
 func (factory *tcpStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream {
 	s := &tcpStream{
-		net:        net,
-		transport:  transport,
-		startedAt:  time.Now(),
-		r:          tcpreader.NewReaderStream(),
-		protocol:   factory.protocol,
-		serverPort: factory.serverPort,
-		ui:         factory.ui,
+		net:       net,
+		transport: transport,
+		protocol:  factory.protocol,
+		ui:        factory.ui,
+		request:   transport.Dst().String() == strconv.Itoa(factory.serverPort),
+	}
+	if s.request {
+		s.client = net.Src().String() + ":" + transport.Src().String()
+	} else {
+		s.client = net.Dst().String() + ":" + transport.Dst().String()
 	}
-	go s.run()
 
-	return &s.r
+	return s
 }
 
+// tcpStream is a half duplex tcp stream, the streams are reassembled
+// synchronously in the packet reading goroutine.
 type tcpStream struct {
 	net, transport gopacket.Flow
-	startedAt      time.Time
-	r              tcpreader.ReaderStream
 
-	protocol   string
-	serverPort int
-	ui         cli.Ui
+	protocol Protocol
+	ui       cli.Ui
+	request  bool   // client -> server
+	client   string // ip:port of client
+
+	buf []byte    // pending bytes of an incomplete frame
+	ts  time.Time // when the pending frame is seen
+}
+
+func (s *tcpStream) Reassembled(reassemblies []tcpassembly.Reassembly) {
+	for _, r := range reassemblies {
+		if r.Skip != 0 {
+			// lost packets, discard the incomplete frame
+			s.buf = nil
+		}
+		if len(r.Bytes) == 0 {
+			continue
+		}
+
+		k, ok := s.protocol.(*kafka)
+		if !ok {
+			srcPort := binary.BigEndian.Uint16(s.transport.Src().Raw())
+			dstPort := binary.BigEndian.Uint16(s.transport.Dst().Raw())
+			s.output(r.Seen, s.protocol.Unmarshal(srcPort, dstPort, r.Bytes))
+			continue
+		}
+
+		if len(s.buf) == 0 {
+			s.ts = r.Seen
+		}
+		s.buf = append(s.buf, r.Bytes...)
+		s.decodeKafkaFrames(k, r.Seen)
+	}
 }
 
-func (s *tcpStream) run() {
-	buf := bufio.NewReader(&s.r)
-	for {
-		// kafka read request from buf
-		req, err := http.ReadRequest(buf)
-		if err == io.EOF {
+func (s *tcpStream) decodeKafkaFrames(k *kafka, seen time.Time) {
+	for len(s.buf) >= 4 {
+		size := int(int32(binary.BigEndian.Uint32(s.buf)))
+		if size <= 0 || size > kafkaMaxFrameSize {
+			s.buf = nil
 			return
-		} else if err != nil {
-			println(err)
+		}
+		if len(s.buf) < 4+size {
+			return
+		}
+
+		frame := s.buf[4 : 4+size]
+		if s.request {
+			s.output(s.ts, k.Request(s.client, s.ts, frame))
 		} else {
-			println(req)
+			s.output(s.ts, k.Response(s.client, s.ts, frame))
 		}
 
+		s.buf = s.buf[4+size:]
+		s.ts = seen
+	}
+
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+}
+
+func (s *tcpStream) output(ts time.Time, msg string) {
+	if msg == "" {
+		return
+	}
+
+	s.ui.Output(fmt.Sprintf("%s %s:%s -> %s:%s %s", ts.Format("15:04:05.000"),
+		s.net.Src(), s.transport.Src(), s.net.Dst(), s.transport.Dst(), msg))
+}
+
+func (s *tcpStream) ReassemblyComplete() {
+	if k, ok := s.protocol.(*kafka); ok && !s.request {
+		k.Closed(s.client)
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/funkygao/columnize"
 	"github.com/funkygao/gafka/cmd/gk/command/protos"
 	"github.com/funkygao/gocli"
 	"github.com/google/gopacket"
@@ -21,6 +22,7 @@ type Sniff struct {
 func (this *Sniff) Run(args []string) (exitCode int) {
 	var (
 		device     string
+		pcapFile   string
 		filter     string
 		protocol   string
 		serverPort int
@@ -28,6 +30,7 @@ func (this *Sniff) Run(args []string) (exitCode int) {
 	cmdFlags := flag.NewFlagSet("sniff", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
 	cmdFlags.StringVar(&device, "i", "", "")
+	cmdFlags.StringVar(&pcapFile, "r", "", "")
 	cmdFlags.StringVar(&filter, "f", "", "")
 	cmdFlags.StringVar(&protocol, "p", "ascii", "")
 	cmdFlags.IntVar(&serverPort, "sp", 0, "")
@@ -36,11 +39,24 @@ func (this *Sniff) Run(args []string) (exitCode int) {
 	}
 
 	if validateArgs(this, this.Ui).
-		require("-i", "-f").
+		on("-i", "-f").
 		invalid(args) {
 		return 2
 	}
 
+	if (device == "") == (pcapFile == "") {
+		this.Ui.Error("either -i or -r required")
+		this.Ui.Output(this.Help())
+		return 2
+	}
+
+	if protocol == "kafka" && serverPort <= 0 {
+		// requests and responses are told apart by the server port
+		this.Ui.Error("-sp required when -p kafka")
+		this.Ui.Output(this.Help())
+		return 2
+	}
+
 	prot := protos.New(protocol, serverPort)
 	if prot == nil {
 		this.Ui.Error("unknown protocol")
@@ -48,74 +64,74 @@ func (this *Sniff) Run(args []string) (exitCode int) {
 		return 2
 	}
 
-	this.Ui.Outputf("starting sniff on interface %s", device)
-	snaplen := int32(1 << 20) // max number of bytes to read per packet
-	handle, err := pcap.OpenLive(device, snaplen, true, pcap.BlockForever)
+	var (
+		handle *pcap.Handle
+		err    error
+	)
+	if pcapFile != "" {
+		this.Ui.Outputf("reading packets from %s", pcapFile)
+		handle, err = pcap.OpenOffline(pcapFile)
+	} else {
+		this.Ui.Outputf("starting sniff on interface %s", device)
+		snaplen := int32(1 << 20) // max number of bytes to read per packet
+		handle, err = pcap.OpenLive(device, snaplen, true, pcap.BlockForever)
+	}
 	swallow(err)
 	defer handle.Close()
 
-	swallow(handle.SetBPFFilter(filter))
+	if filter != "" {
+		swallow(handle.SetBPFFilter(filter))
+	}
 
 	// Use the handle as a packet source to process all packets
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
 	packets := packetSource.Packets()
-	assembler := protos.Assembler(protocol, serverPort, this.Ui)
+	assembler := protos.Assembler(prot, serverPort, this.Ui)
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
+	// in offline mode the packet timestamps drive the clock
+	var lastSeen time.Time
+
 	this.Ui.Output("starting to read packets...")
 	for {
 		select {
 		case packet := <-packets:
 			if packet == nil {
+				// EOF of the pcap file
+				assembler.FlushAll()
+				this.report(prot)
 				return
 			}
 			if packet.NetworkLayer() == nil || packet.TransportLayer() == nil || packet.TransportLayer().LayerType() != layers.LayerTypeTCP {
 				continue
 			}
 			tcp := packet.TransportLayer().(*layers.TCP)
-			assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, packet.Metadata().Timestamp)
+			lastSeen = packet.Metadata().Timestamp
+			assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, lastSeen)
 
 		case <-ticker.C:
-			assembler.FlushOlderThan(time.Now().Add(time.Minute * -2))
+			if pcapFile == "" {
+				assembler.FlushOlderThan(time.Now().Add(time.Minute * -2))
+				this.report(prot)
+			} else {
+				assembler.FlushOlderThan(lastSeen.Add(time.Minute * -2))
+			}
 		}
 	}
 
 	return
 }
 
-func (this *Sniff) handlePacket(packet gopacket.Packet, prot protos.Protocol) {
-	ipLayer := packet.Layer(layers.LayerTypeIPv4)
-	if ipLayer == nil {
-		return
-	}
-	ip, _ := ipLayer.(*layers.IPv4)
-	if ip == nil {
-		return
-	}
-
-	tcpLayer := packet.Layer(layers.LayerTypeTCP)
-	if tcpLayer == nil {
-		return
-	}
-	tcp, _ := tcpLayer.(*layers.TCP)
-	if tcp == nil {
+func (this *Sniff) report(prot protos.Protocol) {
+	reporter, ok := prot.(protos.Reporter)
+	if !ok {
 		return
 	}
 
-	applicationLayer := packet.ApplicationLayer()
-	if applicationLayer == nil {
-		return
-	}
-
-	output := prot.Unmarshal(uint16(tcp.SrcPort), uint16(tcp.DstPort), applicationLayer.Payload())
-	if len(output) == 0 {
-		return
+	if lines := reporter.Report(); len(lines) > 1 {
+		this.Ui.Output(columnize.SimpleFormat(lines))
 	}
-
-	this.Ui.Outputf("%s:%d -> %s:%d %dB",
-		ip.SrcIP, tcp.SrcPort, ip.DstIP, tcp.DstPort, len(applicationLayer.Payload()))
-	this.Ui.Output(output)
 }
 
 func (this *Sniff) Synopsis() string {
@@ -131,6 +147,10 @@ Usage: %s sniff [options]
 Options:
 
     -i interface
+      Sniff live traffic on the interface.
+
+    -r pcap file
+      Analyse the packets captured by tcpdump -w file.pcap instead of live traffic.
 
     -f filter
       e,g. tcp and port 80
@@ -139,7 +159,9 @@ Options:
       Default protocol: ascii
 
     -sp port
-      Server port
+      Server port, required by kafka.
+      For kafka, requests and responses are correlated by correlation_id to
+      report the per api latency.
 
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
@@ -1,23 +1,290 @@
 package command
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/funkygao/columnize"
+	"github.com/funkygao/gafka/cmd/actord/executor"
+	"github.com/funkygao/gafka/cmd/kateway/gateway"
+	mandb "github.com/funkygao/gafka/cmd/kateway/manager/mysql"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
 )
 
 type Webhook struct {
 	Ui  cli.Ui
 	Cmd string
+
+	zone     string
+	zkzone   *zk.ZkZone
+	topic    string
+	endpoint string
 }
 
 func (this *Webhook) Run(args []string) (exitCode int) {
+	var (
+		add, remove         bool
+		pause, resume, test bool
+		body                string
+	)
+	cmdFlags := flag.NewFlagSet("webhook", flag.ContinueOnError)
+	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
+	cmdFlags.StringVar(&this.zone, "z", ctx.DefaultZone(), "")
+	cmdFlags.StringVar(&this.topic, "t", "", "")
+	cmdFlags.StringVar(&this.endpoint, "ep", "", "")
+	cmdFlags.BoolVar(&add, "add", false, "")
+	cmdFlags.BoolVar(&remove, "del", false, "")
+	cmdFlags.BoolVar(&pause, "pause", false, "")
+	cmdFlags.BoolVar(&resume, "resume", false, "")
+	cmdFlags.BoolVar(&test, "test", false, "")
+	cmdFlags.StringVar(&body, "body", "webhook test from gk", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if validateArgs(this, this.Ui).
+		on("-add", "-t", "-ep").
+		on("-del", "-t", "-ep").
+		on("-pause", "-t").
+		on("-resume", "-t").
+		on("-test", "-t").
+		requireAdminRights("-add", "-del", "-pause", "-resume").
+		invalid(args) {
+		return 2
+	}
+
+	this.zkzone = zk.NewZkZone(zk.DefaultConfig(this.zone, ctx.ZoneZkAddrs(this.zone)))
+	defer this.zkzone.Close()
+
+	switch {
+	case add:
+		this.updateEndpoints(func(endpoints []string) []string {
+			for _, ep := range endpoints {
+				if ep == this.endpoint {
+					return endpoints
+				}
+			}
+			return append(endpoints, this.endpoint)
+		})
+
+	case remove:
+		this.updateEndpoints(func(endpoints []string) []string {
+			r := make([]string, 0, len(endpoints))
+			for _, ep := range endpoints {
+				if ep != this.endpoint {
+					r = append(r, ep)
+				}
+			}
+			return r
+		})
+
+	case pause:
+		swallow(this.zkzone.PauseWebhook(this.topic))
+		this.Ui.Info(fmt.Sprintf("%s paused", this.topic))
+
+	case resume:
+		swallow(this.zkzone.ResumeWebhook(this.topic))
+		this.Ui.Info(fmt.Sprintf("%s resumed", this.topic))
+
+	case test:
+		this.testDelivery([]byte(body))
+
+	default:
+		this.displayWebhooks()
+	}
+
 	return
 }
 
+func (this *Webhook) updateEndpoints(fn func(endpoints []string) []string) {
+	hook, err := this.zkzone.NewOrchestrator().WebhookInfo(this.topic)
+	swallow(err)
+
+	hook.Endpoints = fn(hook.Endpoints)
+	swallow(this.zkzone.CreateOrUpdateWebhook(this.topic, *hook))
+
+	// actord restarts the executor on webhook znode changes
+	this.Ui.Info(fmt.Sprintf("%s endpoints: %+v", this.topic, hook.Endpoints))
+}
+
+// testDelivery posts a test message to each endpoint the same way actord does.
+func (this *Webhook) testDelivery(body []byte) {
+	hook, err := this.zkzone.NewOrchestrator().WebhookInfo(this.topic)
+	swallow(err)
+
+	// sign with the app secret like actord
+	man := mandb.New(mandb.DefaultConfig(this.zone))
+	swallow(man.Start())
+	defer man.Stop()
+	signature := man.Signature(man.TopicAppid(this.topic))
+	if signature == "" {
+		this.Ui.Warn(fmt.Sprintf("%s invalid app signature", this.topic))
+	}
+
+	client := &http.Client{Timeout: time.Second * 4}
+	for _, ep := range hook.Endpoints {
+		if this.endpoint != "" && ep != this.endpoint {
+			continue
+		}
+
+		req, err := http.NewRequest("POST", ep, bytes.NewReader(body))
+		swallow(err)
+		req.Header.Set(gateway.HttpHeaderOffset, "-1")
+		req.Header.Set(gateway.HttpHeaderPartition, "-1")
+		req.Header.Set("User-Agent", "gk.webhook.test")
+		req.Header.Set("X-App-Signature", signature)
+
+		t0 := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			this.Ui.Error(fmt.Sprintf("%s %v", ep, err))
+			continue
+		}
+
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		msg := fmt.Sprintf("%s %s %s %s", ep, resp.Status, time.Since(t0), strings.TrimSpace(string(b)))
+		if resp.StatusCode >= 300 {
+			this.Ui.Error(msg)
+		} else {
+			this.Ui.Info(msg)
+		}
+	}
+}
+
+func (this *Webhook) displayWebhooks() {
+	var (
+		webhooks = this.zkzone.ChildrenWithData(zk.PubsubWebhooks)
+		owners   = this.zkzone.ChildrenWithData(zk.PubsubWebhookOwners)
+		actors   = this.zkzone.ChildrenWithData(zk.PubsubActors)
+		lags     = make(map[string]map[string]int64) // cluster:topic:lag
+		circuits = make(map[string]map[string]map[string]bool)
+		topics   = make([]string, 0, len(webhooks))
+	)
+	for topic := range webhooks {
+		if this.topic == "" || this.topic == topic {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+
+	lines := []string{"Topic|Cluster|Endpoint|Circuit|Actor|Lag|Paused|Mtime"}
+	for _, topic := range topics {
+		zdata := webhooks[topic]
+		var hook zk.WebhookMeta
+		hook.From(zdata.Data())
+
+		paused, err := this.zkzone.WebhookPaused(topic)
+		swallow(err)
+
+		var actor string
+		if owner, present := owners[topic]; present {
+			actor = string(owner.Data())
+		}
+
+		if _, present := lags[hook.Cluster]; !present {
+			lags[hook.Cluster] = this.webhookLags(hook.Cluster)
+		}
+		lag := "-"
+		if n, present := lags[hook.Cluster][topic]; present {
+			lag = fmt.Sprintf("%d", n)
+		}
+
+		if _, present := circuits[actor]; !present && actor != "" {
+			if actorData, present := actors[actor]; present {
+				circuits[actor] = this.actorCircuits(actor, actorData.Data())
+			}
+		}
+
+		if len(hook.Endpoints) == 0 {
+			lines = append(lines, fmt.Sprintf("%s|%s|-|-|%s|%s|%v|%s", topic, hook.Cluster,
+				actor, lag, paused, zdata.Mtime()))
+			continue
+		}
+
+		for _, ep := range hook.Endpoints {
+			circuit := "-"
+			if open, present := circuits[actor][topic][ep]; present {
+				circuit = "closed"
+				if open {
+					circuit = "open"
+				}
+			}
+
+			lines = append(lines, fmt.Sprintf("%s|%s|%s|%s|%s|%s|%v|%s", topic, hook.Cluster, ep, circuit,
+				actor, lag, paused, zdata.Mtime()))
+		}
+	}
+
+	if len(lines) > 1 {
+		this.Ui.Output(columnize.SimpleFormat(lines))
+	}
+}
+
+// webhookLags returns the consumer lag of each topic of actord webhook group.
+func (this *Webhook) webhookLags(cluster string) map[string]int64 {
+	r := make(map[string]int64)
+	if cluster == "" {
+		return r
+	}
+
+	zkcluster := this.zkzone.NewCluster(cluster)
+	for group, consumers := range zkcluster.ConsumersByGroup(executor.WebhookGroup) {
+		if group != executor.WebhookGroup {
+			continue
+		}
+
+		for _, c := range consumers {
+			r[c.Topic] += c.Lag
+		}
+	}
+
+	return r
+}
+
+// actorCircuits asks the owner actord for the circuit breaker state of its webhooks.
+func (this *Webhook) actorCircuits(actorId string, actorData []byte) map[string]map[string]bool {
+	var actor struct {
+		Addr string `json:"addr"`
+	}
+	if err := json.Unmarshal(actorData, &actor); err != nil {
+		return nil
+	}
+
+	if idx := strings.IndexByte(actorId, ':'); idx > 0 && strings.HasPrefix(actor.Addr, ":") {
+		// actor id is hostname:uuid
+		actor.Addr = actorId[:idx] + actor.Addr
+	}
+
+	client := &http.Client{Timeout: time.Second * 4}
+	resp, err := client.Get(fmt.Sprintf("http://%s/v1/webhooks", actor.Addr))
+	if err != nil {
+		this.Ui.Warn(fmt.Sprintf("actor %s: %v", actorId, err))
+		return nil
+	}
+	defer resp.Body.Close()
+
+	var r map[string]map[string]bool
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		this.Ui.Warn(fmt.Sprintf("actor %s: %v", actorId, err))
+		return nil
+	}
+
+	return r
+}
+
 func (*Webhook) Synopsis() string {
-	return "Display kateway webhooks TODO"
+	return "Manage kateway webhooks"
 }
 
 func (this *Webhook) Help() string {
@@ -26,6 +293,29 @@ Usage: %s webhook [options]
 
     %s
 
+    Without operation options, display webhooks with their endpoints circuit breaker
+    state, owner actor, consumer lag and whether paused.
+
+Options:
+
+    -z zone
+
+    -t topic
+      Kafka topic name of the webhook.
+
+    -add -t topic -ep endpoint
+      Add an endpoint to the webhook.
+
+    -del -t topic -ep endpoint
+      Remove an endpoint from the webhook.
+
+    -pause -t topic
+
+    -resume -t topic
+
+    -test -t topic [-ep endpoint] [-body text]
+      Post a test message to the webhook endpoints.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
@@ -318,6 +318,27 @@ func init() {
 			}, nil
 		},
 
+		"appmigrate": func() (cli.Command, error) {
+			return &command.AppMigrate{
+				Ui:  ui,
+				Cmd: cmd,
+			}, nil
+		},
+
+		"failover": func() (cli.Command, error) {
+			return &command.Failover{
+				Ui:  ui,
+				Cmd: cmd,
+			}, nil
+		},
+
+		"archive": func() (cli.Command, error) {
+			return &command.Archive{
+				Ui:  ui,
+				Cmd: cmd,
+			}, nil
+		},
+
 		"kateway": func() (cli.Command, error) {
 			return &command.Kateway{
 				Ui:  ui,
@@ -325,6 +346,13 @@ func init() {
 			}, nil
 		},
 
+		"host": func() (cli.Command, error) {
+			return &command.Host{
+				Ui:  ui,
+				Cmd: cmd,
+			}, nil
+		},
+
 		"kguard": func() (cli.Command, error) {
 			return &command.Kguard{
 				Ui:  ui,
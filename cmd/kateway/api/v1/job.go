@@ -1,17 +1,22 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/funkygao/gafka/cmd/kateway/gateway"
 	"github.com/funkygao/gafka/mpool"
 )
 
+// AddJob creates a delayed job, or a recurring job if opt.Schedule is set.
 func (this *Client) AddJob(payload []byte, delay string, opt PubOption) (jobId string, err error) {
 	buf := mpool.BytesBufferGet()
 	defer mpool.BytesBufferPut(buf)
@@ -25,7 +30,12 @@ func (this *Client) AddJob(payload []byte, delay string, opt PubOption) (jobId s
 	u.Host = this.cf.Pub.Endpoint
 	u.Path = fmt.Sprintf("/v1/jobs/%s/%s", opt.Topic, opt.Ver)
 	q := u.Query()
-	q.Set("delay", delay)
+	if opt.Schedule != "" {
+		// recurring job ignores delay
+		q.Set("schedule", opt.Schedule)
+	} else {
+		q.Set("delay", delay)
+	}
 	u.RawQuery = q.Encode()
 
 	req, err = http.NewRequest("POST", u.String(), buf)
@@ -105,3 +115,240 @@ func (this *Client) DeleteJob(jobId string, opt PubOption) (err error) {
 
 	return nil
 }
+
+// ListJobs returns the recurring jobs of a topic.
+func (this *Client) ListJobs(opt PubOption) (jobs []gateway.RecurringJob, err error) {
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/jobs/%s/%s", opt.Topic, opt.Ver)
+
+	var req *http.Request
+	req, err = http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("AppId", this.cf.AppId)
+	req.Header.Set("Pubkey", this.cf.Secret)
+
+	var response *http.Response
+	response, err = this.pubConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(string(b))
+	}
+
+	err = json.Unmarshal(b, &jobs)
+	return
+}
+
+// PauseJob pauses a recurring job.
+func (this *Client) PauseJob(jobId string, opt PubOption) error {
+	return this.pauseJob(jobId, "pause", opt)
+}
+
+// ResumeJob resumes a paused recurring job from now on.
+func (this *Client) ResumeJob(jobId string, opt PubOption) error {
+	return this.pauseJob(jobId, "resume", opt)
+}
+
+func (this *Client) pauseJob(jobId string, action string, opt PubOption) (err error) {
+	var req *http.Request
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/jobs/%s/%s", opt.Topic, opt.Ver)
+	q := u.Query()
+	q.Set("id", jobId)
+	q.Set("action", action)
+	u.RawQuery = q.Encode()
+
+	req, err = http.NewRequest("PUT", u.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("AppId", this.cf.AppId)
+	req.Header.Set("Pubkey", this.cf.Secret)
+
+	var response *http.Response
+	response, err = this.pubConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(string(b))
+	}
+
+	return nil
+}
+
+// GetJob returns a job with its state: pending, fired or cancelled.
+func (this *Client) GetJob(jobId string, opt PubOption) (j gateway.Job, err error) {
+	q := url.Values{}
+	q.Set("id", jobId)
+
+	var b []byte
+	if b, err = this.jobRequest("GET", q, nil, opt); err != nil {
+		return
+	}
+
+	err = json.Unmarshal(b, &j)
+	return
+}
+
+// ListPendingJobs returns a page of pending jobs due within [from, to] in due time order.
+// Pass the returned cursor to get the next page, an empty cursor means no more jobs.
+func (this *Client) ListPendingJobs(from, to int64, cursor string, limit int, opt PubOption) (jobs []gateway.Job, next string, err error) {
+	q := url.Values{}
+	q.Set("from", strconv.FormatInt(from, 10))
+	q.Set("to", strconv.FormatInt(to, 10))
+	q.Set("cursor", cursor)
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	var b []byte
+	if b, err = this.jobRequest("GET", q, nil, opt); err != nil {
+		return
+	}
+
+	var page gateway.JobPage
+	if err = json.Unmarshal(b, &page); err != nil {
+		return
+	}
+
+	return page.Jobs, page.Cursor, nil
+}
+
+// RescheduleJob changes the due time(unix seconds) of a pending job.
+func (this *Client) RescheduleJob(jobId string, due int64, opt PubOption) (err error) {
+	q := url.Values{}
+	q.Set("id", jobId)
+	q.Set("action", "reschedule")
+	q.Set("due", strconv.FormatInt(due, 10))
+	_, err = this.jobRequest("PUT", q, nil, opt)
+	return
+}
+
+// UpdateJobPayload replaces the payload of a pending job, with opt.Tag and opt.TraceId.
+func (this *Client) UpdateJobPayload(jobId string, payload []byte, opt PubOption) (err error) {
+	q := url.Values{}
+	q.Set("id", jobId)
+	q.Set("action", "payload")
+	_, err = this.jobRequest("PUT", q, bytes.NewReader(payload), opt)
+	return
+}
+
+// ShiftJobs moves the pending jobs due within [from, to] by delay seconds and returns
+// the number of moved jobs. The server moves a limited number of jobs per request, so
+// it takes as many requests as needed.
+func (this *Client) ShiftJobs(from, to, delay int64, opt PubOption) (n int64, err error) {
+	q := url.Values{}
+	q.Set("action", "shift")
+	q.Set("from", strconv.FormatInt(from, 10))
+	q.Set("to", strconv.FormatInt(to, 10))
+	q.Set("delay", strconv.FormatInt(delay, 10))
+	return this.bulkJobRequest("PUT", q, opt)
+}
+
+// CancelJobs cancels the pending jobs due within [from, to] and returns the number of
+// cancelled jobs. The server cancels a limited number of jobs per request, so it takes
+// as many requests as needed.
+func (this *Client) CancelJobs(from, to int64, opt PubOption) (n int64, err error) {
+	q := url.Values{}
+	q.Set("from", strconv.FormatInt(from, 10))
+	q.Set("to", strconv.FormatInt(to, 10))
+	return this.bulkJobRequest("DELETE", q, opt)
+}
+
+func (this *Client) bulkJobRequest(method string, q url.Values, opt PubOption) (n int64, err error) {
+	for {
+		var b []byte
+		if b, err = this.jobRequest(method, q, nil, opt); err != nil {
+			return
+		}
+
+		var result gateway.BulkJobResult
+		if err = json.Unmarshal(b, &result); err != nil {
+			return
+		}
+
+		n += result.Affected
+		if result.Next == "" {
+			return
+		}
+
+		q.Set("cursor", result.Next)
+	}
+}
+
+func (this *Client) jobRequest(method string, q url.Values, body io.Reader, opt PubOption) (b []byte, err error) {
+	var req *http.Request
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/jobs/%s/%s", opt.Topic, opt.Ver)
+	u.RawQuery = q.Encode()
+
+	req, err = http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("AppId", this.cf.AppId)
+	req.Header.Set("Pubkey", this.cf.Secret)
+	if opt.Tag != "" {
+		req.Header.Set(gateway.HttpHeaderMsgTag, opt.Tag)
+	}
+	if opt.TraceId != "" {
+		req.Header.Set(gateway.HttpHeaderTraceId, opt.TraceId)
+	}
+
+	var response *http.Response
+	response, err = this.pubConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(string(b))
+	}
+
+	if this.cf.Debug {
+		log.Printf("--> [%s]", response.Status)
+	}
+
+	return
+}
@@ -1,12 +1,14 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/funkygao/gafka/cmd/kateway/gateway"
 	"github.com/funkygao/gafka/mpool"
@@ -17,6 +19,9 @@ type PubOption struct {
 	Async      bool
 	AckAll     bool
 	Tag        string
+	TraceId    string
+	TTL        int    // in seconds, 0 means never expire
+	Schedule   string // recurring job schedule for AddJob, e.g. '@every 30s' or '*/5 * * * *'
 }
 
 // Pub publish a keyed message to specified versioned topic.
@@ -52,6 +57,12 @@ func (this *Client) Pub(key string, msg []byte, opt PubOption) (err error) {
 	if opt.Tag != "" {
 		req.Header.Set(gateway.HttpHeaderMsgTag, opt.Tag)
 	}
+	if opt.TraceId != "" {
+		req.Header.Set(gateway.HttpHeaderTraceId, opt.TraceId)
+	}
+	if opt.TTL > 0 {
+		req.Header.Set(gateway.HttpHeaderMsgTTL, strconv.Itoa(opt.TTL))
+	}
 
 	var response *http.Response
 	response, err = this.pubConn.Do(req)
@@ -84,3 +95,61 @@ func (this *Client) Pub(key string, msg []byte, opt PubOption) (err error) {
 
 	return nil
 }
+
+// PubBatch publish a batch of keyed and tagged messages to specified versioned topic
+// in a single http request, the result of each message is returned in the same order.
+func (this *Client) PubBatch(msgs []gateway.BatchMessage, opt PubOption) (results []gateway.BatchPubResult, err error) {
+	buf := mpool.BytesBufferGet()
+	defer mpool.BytesBufferPut(buf)
+
+	buf.Reset()
+	for _, m := range msgs {
+		if err = gateway.WriteBatchFrame(buf, m.Key, m.Tag, []byte(m.Value)); err != nil {
+			return
+		}
+	}
+
+	var req *http.Request
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/msgs/%s/%s/batch", opt.Topic, opt.Ver)
+
+	req, err = http.NewRequest("POST", u.String(), buf)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
+	req.Header.Set("Content-Type", gateway.ContentTypeBatchBinary)
+
+	var response *http.Response
+	response, err = this.pubConn.Do(req)
+	// when you get a redirection failure both response and err will be non-nil
+	if response != nil {
+		// reuse the connection
+		defer response.Body.Close()
+	}
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	if response.StatusCode != http.StatusCreated {
+		return nil, errors.New(string(b))
+	}
+
+	if this.cf.Debug {
+		log.Printf("--> [%s]", response.Status)
+		log.Printf("%s", string(b))
+	}
+
+	err = json.Unmarshal(b, &results)
+	return
+}
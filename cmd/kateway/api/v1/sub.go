@@ -18,10 +18,11 @@ type SubOption struct {
 	Topic, Ver string
 	Group      string
 	Batch      int
-	Reset      string // newest | oldest
+	Reset      string // newest | oldest | time:<unix|RFC3339>
 	Shadow     string
 	Wait       string
-	Tag        string // tag filter
+	Tag        string // tag filter expression, e,g. (city=bj || city=sh) && !vip
+	Scan       int    // max unmatched messages to scan with tag filter
 	AutoClose  bool
 	Mux        bool
 }
@@ -47,6 +48,9 @@ func (this *Client) Sub(opt SubOption, h SubHandler) error {
 	if opt.Wait != "" {
 		q.Set("wait", opt.Wait)
 	}
+	if opt.Scan > 0 {
+		q.Set("scan", strconv.Itoa(opt.Scan))
+	}
 	if opt.Mux {
 		q.Set("mux", "1")
 	}
@@ -102,6 +106,7 @@ type SubXResult struct {
 	Offset    string
 	Partition string
 	Tag       string
+	TraceId   string
 }
 
 func (this *SubXResult) Reset() {
@@ -129,6 +134,9 @@ func (this *Client) SubX(opt SubOption, h SubXHandler) error {
 	if opt.Wait != "" {
 		q.Set("wait", opt.Wait)
 	}
+	if opt.Scan > 0 {
+		q.Set("scan", strconv.Itoa(opt.Scan))
+	}
 	u.RawQuery = q.Encode()
 
 	req := gorequest.New()
@@ -164,12 +172,14 @@ func (this *Client) SubX(opt SubOption, h SubXHandler) error {
 		r.Partition = response.Header.Get(gateway.HttpHeaderPartition)
 		r.Offset = response.Header.Get(gateway.HttpHeaderOffset)
 		r.Tag = response.Header.Get(gateway.HttpHeaderMsgTag)
+		r.TraceId = response.Header.Get(gateway.HttpHeaderTraceId)
 		if err := h(response.StatusCode, b, r); err != nil {
 			return err
 		}
 
 		req.Set(gateway.HttpHeaderPartition, r.Partition)
 		req.Set(gateway.HttpHeaderOffset, r.Offset)
+		req.Set(gateway.HttpHeaderCluster, response.Header.Get(gateway.HttpHeaderCluster))
 
 		if r.Bury != "" {
 			if r.Bury != ShadowRetry && r.Bury != ShadowDead {
@@ -0,0 +1,46 @@
+// Package dedup provides a message id index for idempotent Pub.
+//
+// Client supplies a unique message id in the X-Message-Id header and retries
+// with the same id after a timeout. Within the dedup window, the retried Pub
+// returns the original partition/offset instead of appending the message again.
+package dedup
+
+import (
+	"errors"
+)
+
+// ErrInFlight is returned when the message id is reserved by a Pub in flight.
+var ErrInFlight = errors.New("message id in flight")
+
+// Position is where a message was published, -1 if unknown, e.g. async Pub.
+type Position struct {
+	Partition int32
+	Offset    int64
+}
+
+type Service interface {
+
+	// Start the dedup service.
+	Start() error
+
+	// Stop the dedup service.
+	Stop()
+
+	// Name returns the underlying implementation name.
+	Name() string
+
+	// Reserve atomically reserves a message id before Pub.
+	// If the message id was published within the window, reserved is false
+	// and pos is the original position. If another Pub of the same message id
+	// is in flight, ErrInFlight is returned.
+	Reserve(appid, topic, ver, msgId string) (pos Position, reserved bool, err error)
+
+	// Record remembers the position of a reserved message id once published.
+	Record(appid, topic, ver, msgId string, pos Position) error
+
+	// Release gives up the reservation of a message id after Pub fails, so
+	// that the client can retry with it.
+	Release(appid, topic, ver, msgId string) error
+}
+
+var Default Service
@@ -0,0 +1,36 @@
+package dummy
+
+import (
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+)
+
+var _ dedup.Service = &dummyStore{}
+
+type dummyStore struct {
+}
+
+func New() dedup.Service {
+	return &dummyStore{}
+}
+
+func (this *dummyStore) Start() (err error) {
+	return
+}
+
+func (this *dummyStore) Stop() {}
+
+func (this *dummyStore) Name() string {
+	return "dummy"
+}
+
+func (this *dummyStore) Reserve(appid, topic, ver, msgId string) (pos dedup.Position, reserved bool, err error) {
+	return pos, true, nil
+}
+
+func (this *dummyStore) Record(appid, topic, ver, msgId string, pos dedup.Position) error {
+	return nil
+}
+
+func (this *dummyStore) Release(appid, topic, ver, msgId string) error {
+	return nil
+}
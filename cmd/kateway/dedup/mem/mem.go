@@ -0,0 +1,200 @@
+package mem
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	log "github.com/funkygao/log4go"
+)
+
+var _ dedup.Service = &memDedup{}
+
+// memDedup is a per instance dedup index: each topic keeps at most capacity
+// published message ids within the window, the earliest published is evicted
+// first. The reservations of Pub in flight are never evicted by capacity.
+type memDedup struct {
+	capacity int
+	window   time.Duration
+
+	mu     sync.Mutex
+	topics map[string]*topicIndex // appid.topic.ver:index
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+type topicIndex struct {
+	ll      *list.List // *entry in publish order, front is the latest
+	items   map[string]*list.Element
+	pending int // reserved entries in ll
+}
+
+type entry struct {
+	msgId   string
+	pos     dedup.Position
+	pending bool // reserved by a Pub in flight
+	ctime   time.Time
+}
+
+func New(capacity int, window time.Duration) *memDedup {
+	return &memDedup{
+		capacity: capacity,
+		window:   window,
+		topics:   make(map[string]*topicIndex),
+		quit:     make(chan struct{}),
+	}
+}
+
+func (this *memDedup) Name() string {
+	return "mem"
+}
+
+func (this *memDedup) Start() error {
+	this.wg.Add(1)
+	go this.purgeExpired()
+	return nil
+}
+
+func (this *memDedup) Stop() {
+	close(this.quit)
+	this.wg.Wait()
+}
+
+func (this *memDedup) key(appid, topic, ver string) string {
+	return appid + "." + topic + "." + ver
+}
+
+// index returns the index of a topic, creating it if absent.
+func (this *memDedup) index(appid, topic, ver string) *topicIndex {
+	key := this.key(appid, topic, ver)
+	idx, present := this.topics[key]
+	if !present {
+		idx = &topicIndex{
+			ll:    list.New(),
+			items: make(map[string]*list.Element),
+		}
+		this.topics[key] = idx
+	}
+
+	return idx
+}
+
+func (this *memDedup) Reserve(appid, topic, ver, msgId string) (pos dedup.Position, reserved bool, err error) {
+	now := time.Now()
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	idx := this.index(appid, topic, ver)
+	if el, present := idx.items[msgId]; present {
+		e := el.Value.(*entry)
+		if now.Sub(e.ctime) <= this.window {
+			if e.pending {
+				err = dedup.ErrInFlight
+				return
+			}
+
+			return e.pos, false, nil
+		}
+
+		idx.evict(el)
+	}
+
+	this.add(idx, &entry{msgId: msgId, pending: true, ctime: now})
+	return pos, true, nil
+}
+
+func (this *memDedup) Record(appid, topic, ver, msgId string, pos dedup.Position) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	// the window counts from the publish, the reservation is replaced
+	idx := this.index(appid, topic, ver)
+	if el, present := idx.items[msgId]; present {
+		idx.evict(el)
+	}
+	this.add(idx, &entry{msgId: msgId, pos: pos, ctime: time.Now()})
+
+	return nil
+}
+
+func (this *memDedup) Release(appid, topic, ver, msgId string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	idx, present := this.topics[this.key(appid, topic, ver)]
+	if !present {
+		return nil
+	}
+
+	if el, present := idx.items[msgId]; present && el.Value.(*entry).pending {
+		idx.evict(el)
+	}
+
+	return nil
+}
+
+// add puts the latest entry into the index and evicts the stale ones.
+func (this *memDedup) add(idx *topicIndex, e *entry) {
+	idx.items[e.msgId] = idx.ll.PushFront(e)
+	if e.pending {
+		idx.pending++
+	}
+
+	for el := idx.ll.Back(); el != nil && idx.ll.Len()-idx.pending > this.capacity; {
+		prev := el.Prev()
+		if !el.Value.(*entry).pending {
+			idx.evict(el)
+		}
+		el = prev
+	}
+	idx.purge(e.ctime.Add(-this.window))
+}
+
+func (this *topicIndex) evict(el *list.Element) {
+	e := el.Value.(*entry)
+	if e.pending {
+		this.pending--
+	}
+	delete(this.items, e.msgId)
+	this.ll.Remove(el)
+}
+
+// purge evicts the entries published before deadline.
+func (this *topicIndex) purge(deadline time.Time) {
+	for el := this.ll.Back(); el != nil && el.Value.(*entry).ctime.Before(deadline); el = this.ll.Back() {
+		this.evict(el)
+	}
+}
+
+// purgeExpired frees the memory of idle topics.
+func (this *memDedup) purgeExpired() {
+	defer this.wg.Done()
+
+	ticker := time.NewTicker(this.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.quit:
+			return
+
+		case now := <-ticker.C:
+			deadline := now.Add(-this.window)
+
+			this.mu.Lock()
+			for key, idx := range this.topics {
+				idx.purge(deadline)
+				if idx.ll.Len() == 0 {
+					delete(this.topics, key)
+				}
+			}
+			n := len(this.topics)
+			this.mu.Unlock()
+
+			log.Debug("dedup[mem] %d active topics", n)
+		}
+	}
+}
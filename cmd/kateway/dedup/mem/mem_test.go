@@ -0,0 +1,88 @@
+package mem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+)
+
+func TestReserveAndRecord(t *testing.T) {
+	d := New(2, time.Minute)
+
+	_, reserved, err := d.Reserve("app1", "foo", "v1", "id1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, reserved)
+
+	// concurrent Pub of the same message id
+	_, _, err = d.Reserve("app1", "foo", "v1", "id1")
+	assert.Equal(t, dedup.ErrInFlight, err)
+
+	d.Record("app1", "foo", "v1", "id1", dedup.Position{Partition: 1, Offset: 10})
+	pos, reserved, err := d.Reserve("app1", "foo", "v1", "id1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, reserved)
+	assert.Equal(t, int32(1), pos.Partition)
+	assert.Equal(t, int64(10), pos.Offset)
+
+	// per topic index
+	_, reserved, _ = d.Reserve("app1", "bar", "v1", "id1")
+	assert.Equal(t, true, reserved)
+
+	// bounded capacity evicts the earliest
+	d.Record("app1", "foo", "v1", "id2", dedup.Position{Partition: 0, Offset: 11})
+	d.Record("app1", "foo", "v1", "id3", dedup.Position{Partition: 0, Offset: 12})
+	_, reserved, _ = d.Reserve("app1", "foo", "v1", "id3")
+	assert.Equal(t, false, reserved)
+	_, reserved, _ = d.Reserve("app1", "foo", "v1", "id1")
+	assert.Equal(t, true, reserved)
+}
+
+func TestCapacityKeepsPending(t *testing.T) {
+	d := New(1, time.Minute)
+
+	d.Reserve("app1", "foo", "v1", "id1")
+	d.Record("app1", "foo", "v1", "id2", dedup.Position{Partition: 0, Offset: 1})
+	d.Record("app1", "foo", "v1", "id3", dedup.Position{Partition: 0, Offset: 2})
+
+	// the Pub of id1 is still in flight
+	_, _, err := d.Reserve("app1", "foo", "v1", "id1")
+	assert.Equal(t, dedup.ErrInFlight, err)
+	_, reserved, _ := d.Reserve("app1", "foo", "v1", "id2")
+	assert.Equal(t, true, reserved)
+
+	d.Record("app1", "foo", "v1", "id1", dedup.Position{Partition: 0, Offset: 3})
+	_, reserved, _ = d.Reserve("app1", "foo", "v1", "id1")
+	assert.Equal(t, false, reserved)
+}
+
+func TestRelease(t *testing.T) {
+	d := New(100, time.Minute)
+
+	d.Reserve("app1", "foo", "v1", "id1")
+	d.Release("app1", "foo", "v1", "id1")
+	_, reserved, err := d.Reserve("app1", "foo", "v1", "id1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, reserved)
+
+	// a published message id is never released
+	d.Record("app1", "foo", "v1", "id1", dedup.Position{Partition: 0, Offset: 1})
+	d.Release("app1", "foo", "v1", "id1")
+	_, reserved, _ = d.Reserve("app1", "foo", "v1", "id1")
+	assert.Equal(t, false, reserved)
+}
+
+func TestWindow(t *testing.T) {
+	d := New(100, time.Millisecond*20)
+	d.Record("app1", "foo", "v1", "id1", dedup.Position{Partition: -1, Offset: -1})
+	_, reserved, _ := d.Reserve("app1", "foo", "v1", "id1")
+	assert.Equal(t, false, reserved)
+
+	time.Sleep(time.Millisecond * 30)
+	_, reserved, _ = d.Reserve("app1", "foo", "v1", "id1")
+	assert.Equal(t, true, reserved)
+
+	d.Record("app1", "foo", "v1", "id2", dedup.Position{})
+	assert.Equal(t, 2, d.topics["app1.foo.v1"].ll.Len())
+}
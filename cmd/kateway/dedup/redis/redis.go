@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	"github.com/garyburd/redigo/redis"
+)
+
+var _ dedup.Service = &redisDedup{}
+
+const (
+	keyPrefix = "kwdedup:"
+
+	// pending is the value of a message id reserved by a Pub in flight.
+	pending = "-"
+)
+
+// releaseScript deletes the key only if it is still reserved.
+var releaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// redisDedup shares the dedup index across kateway instances: the retried
+// Pub might be routed to another instance by the load balancer.
+type redisDedup struct {
+	addr   string
+	window time.Duration
+	pool   *redis.Pool
+
+	// pendingTTL bounds a reservation whose Pub never completes, e,g. kateway
+	// crashed in between, so that the client retry is not rejected for long.
+	pendingTTL time.Duration
+}
+
+// New creates a redis dedup index. pendingTTL should be about the max duration
+// of a Pub in flight.
+func New(addr string, window, pendingTTL time.Duration) *redisDedup {
+	return &redisDedup{
+		addr:       addr,
+		window:     window,
+		pendingTTL: pendingTTL,
+	}
+}
+
+func (this *redisDedup) Name() string {
+	return "redis"
+}
+
+func (this *redisDedup) Start() error {
+	this.pool = &redis.Pool{
+		MaxIdle:     100,
+		IdleTimeout: time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialTimeout("tcp", this.addr, time.Second, time.Second, time.Second)
+		},
+	}
+
+	conn := this.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err
+}
+
+func (this *redisDedup) Stop() {
+	this.pool.Close()
+}
+
+func (this *redisDedup) key(appid, topic, ver, msgId string) string {
+	return keyPrefix + appid + "." + topic + "." + ver + ":" + msgId
+}
+
+func (this *redisDedup) Reserve(appid, topic, ver, msgId string) (pos dedup.Position, reserved bool, err error) {
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	key := this.key(appid, topic, ver, msgId)
+	var reply interface{}
+	reply, err = conn.Do("SET", key, pending, "PX", millis(this.pendingTTL), "NX")
+	if err != nil {
+		return
+	} else if reply != nil {
+		reserved = true
+		return
+	}
+
+	var val string
+	val, err = redis.String(conn.Do("GET", key))
+	if err == redis.ErrNil || val == pending {
+		// expired just now or reserved by another Pub in flight
+		err = dedup.ErrInFlight
+		return
+	} else if err != nil {
+		return
+	}
+
+	_, err = fmt.Sscanf(val, "%d:%d", &pos.Partition, &pos.Offset)
+	return
+}
+
+func (this *redisDedup) Record(appid, topic, ver, msgId string, pos dedup.Position) error {
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	// the window counts from the publish, the reservation is replaced
+	_, err := conn.Do("SET", this.key(appid, topic, ver, msgId),
+		fmt.Sprintf("%d:%d", pos.Partition, pos.Offset), "PX", millis(this.window))
+	return err
+}
+
+func (this *redisDedup) Release(appid, topic, ver, msgId string) error {
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	_, err := releaseScript.Do(conn, this.key(appid, topic, ver, msgId), pending)
+	return err
+}
+
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
@@ -0,0 +1,13 @@
+// Package envelope implements the versioned, self-describing message format
+// that carries headers like tag, TTL and trace id along with the message body.
+//
+// ┌─────────┬───────┬───────────┬────────────────────────────────────────────┬──────┐
+// │magic(3B)│ver(1B)│headerN(1B)│[keyLen(1B) key valueLen(2B) value]*headerN │ Body │
+// └─────────┴───────┴───────────┴────────────────────────────────────────────┴──────┘
+//
+// The magic starts with byte 0 which can never be the first byte of a text/JSON message,
+// nor of a ProtocolBuffer message because field number 0 is reserved.
+//
+// Messages tagged by kateway before the envelope is introduced are
+// 0x01 tag 0x02 body, they are still decoded through a compatibility path.
+package envelope
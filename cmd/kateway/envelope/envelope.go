@@ -0,0 +1,253 @@
+package envelope
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Version1 = byte(1)
+
+	HeaderTag         = "tag"    // a=b;c=d
+	HeaderTTL         = "ttl"    // expires at unix timestamp in seconds
+	HeaderTraceId     = "trace"  // distributed tracing id
+	HeaderContentType = "ctype"  // content type of the body
+	HeaderOrigin      = "origin" // original cluster of a failed over message
+
+	TagSeperator = ";" // follow cookie rules a=b;c=d
+
+	MaxHeaders        = 255
+	MaxHeaderKeyLen   = 255
+	MaxHeaderValueLen = 65535
+
+	prefixLen = 5 // magic ver headerN
+
+	// legacy tag envelope
+	legacyTagMarkStart = byte(1)
+	legacyTagMarkEnd   = byte(2)
+	legacyMaxTagLen    = 1 << 10
+)
+
+var (
+	magic = [3]byte{0, 'K', 'W'}
+
+	ErrTooManyHeaders   = errors.New("too many envelope headers")
+	ErrTooBigHeader     = errors.New("too big envelope header")
+	ErrCorrupted        = errors.New("corrupted envelope")
+	ErrUnknownVersion   = errors.New("unknown envelope version")
+	ErrNotEnveloped     = errors.New("not an enveloped message")
+	ErrIllegalHeaderKey = errors.New("illegal envelope header key")
+)
+
+// Header is a key/value pair carried with the message.
+type Header struct {
+	Key, Value string
+}
+
+// Headers is an ordered list of message headers.
+type Headers []Header
+
+// Get returns the value of the first header named key.
+func (h Headers) Get(key string) string {
+	for _, hdr := range h {
+		if hdr.Key == key {
+			return hdr.Value
+		}
+	}
+	return ""
+}
+
+// Tags returns the parsed tag header.
+func (h Headers) Tags() []string {
+	tag := h.Get(HeaderTag)
+	if tag == "" {
+		return nil
+	}
+
+	return ParseTag(tag)
+}
+
+// Expired checks the TTL header against now.
+func (h Headers) Expired(now time.Time) bool {
+	ttl := h.Get(HeaderTTL)
+	if ttl == "" {
+		return false
+	}
+
+	expireAt, err := strconv.ParseInt(ttl, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return now.Unix() > expireAt
+}
+
+// Without returns the headers except the ones named key.
+func (h Headers) Without(key string) Headers {
+	r := make(Headers, 0, len(h))
+	for _, hdr := range h {
+		if hdr.Key != key {
+			r = append(r, hdr)
+		}
+	}
+	return r
+}
+
+// ParseTag splits a tag header value into tags.
+func ParseTag(tag string) []string {
+	return strings.Split(strings.TrimSuffix(tag, TagSeperator), TagSeperator)
+}
+
+// Validate checks the headers can be encoded.
+func (h Headers) Validate() error {
+	if len(h) > MaxHeaders {
+		return ErrTooManyHeaders
+	}
+
+	for _, hdr := range h {
+		if len(hdr.Key) == 0 {
+			return ErrIllegalHeaderKey
+		}
+		if len(hdr.Key) > MaxHeaderKeyLen || len(hdr.Value) > MaxHeaderValueLen {
+			return ErrTooBigHeader
+		}
+	}
+
+	return nil
+}
+
+// Len returns the encoded length of the envelope without body.
+func (h Headers) Len() int {
+	n := prefixLen
+	for _, hdr := range h {
+		n += 1 + len(hdr.Key) + 2 + len(hdr.Value)
+	}
+	return n
+}
+
+// WriteTo encodes the envelope into buf, which must be at least h.Len() long.
+// The caller is responsible for validating the headers.
+func (h Headers) WriteTo(buf []byte) int {
+	i := copy(buf, magic[:])
+	buf[i] = Version1
+	i++
+	buf[i] = byte(len(h))
+	i++
+	for _, hdr := range h {
+		buf[i] = byte(len(hdr.Key))
+		i++
+		i += copy(buf[i:], hdr.Key)
+		binary.BigEndian.PutUint16(buf[i:], uint16(len(hdr.Value)))
+		i += 2
+		i += copy(buf[i:], hdr.Value)
+	}
+
+	return i
+}
+
+// Encode wraps the body with headers into a new message.
+func Encode(h Headers, body []byte) ([]byte, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, h.Len()+len(body))
+	i := h.WriteTo(msg)
+	copy(msg[i:], body)
+	return msg, nil
+}
+
+// IsEnveloped checks whether msg is wrapped in envelope, either current or legacy.
+func IsEnveloped(msg []byte) bool {
+	return isCurrent(msg) || isLegacy(msg)
+}
+
+func isCurrent(msg []byte) bool {
+	return len(msg) >= prefixLen && msg[0] == magic[0] && msg[1] == magic[1] && msg[2] == magic[2]
+}
+
+// isLegacy detects the 0x01 tag 0x02 envelope: the tag must be short printable text
+// to avoid misparsing binary messages that happen to start with 0x01.
+func isLegacy(msg []byte) bool {
+	if len(msg) < 2 || msg[0] != legacyTagMarkStart {
+		return false
+	}
+
+	for i := 1; i < len(msg) && i <= legacyMaxTagLen+1; i++ {
+		switch c := msg[i]; {
+		case c == legacyTagMarkEnd:
+			return true
+
+		case c < 0x20 || c > 0x7e:
+			return false
+		}
+	}
+
+	return false
+}
+
+// Decode parses the envelope of msg and returns its headers and the index where body starts.
+// For a message without envelope, nil headers and 0 are returned.
+func Decode(msg []byte) (h Headers, bodyIdx int, err error) {
+	if isLegacy(msg) {
+		return decodeLegacy(msg)
+	}
+
+	if !isCurrent(msg) {
+		return nil, 0, nil
+	}
+
+	if msg[3] != Version1 {
+		return nil, 0, ErrUnknownVersion
+	}
+
+	n := int(msg[4])
+	i := prefixLen
+	if n > 0 {
+		h = make(Headers, 0, n)
+	}
+	for j := 0; j < n; j++ {
+		if i >= len(msg) {
+			return nil, 0, ErrCorrupted
+		}
+		keyLen := int(msg[i])
+		i++
+		if i+keyLen+2 > len(msg) {
+			return nil, 0, ErrCorrupted
+		}
+		key := string(msg[i : i+keyLen])
+		i += keyLen
+		valueLen := int(binary.BigEndian.Uint16(msg[i:]))
+		i += 2
+		if i+valueLen > len(msg) {
+			return nil, 0, ErrCorrupted
+		}
+		h = append(h, Header{Key: key, Value: string(msg[i : i+valueLen])})
+		i += valueLen
+	}
+
+	return h, i, nil
+}
+
+func decodeLegacy(msg []byte) (Headers, int, error) {
+	for i := 1; i < len(msg); i++ {
+		if msg[i] == legacyTagMarkEnd {
+			return Headers{{Key: HeaderTag, Value: string(msg[1:i])}}, i + 1, nil
+		}
+	}
+
+	return nil, 0, ErrCorrupted
+}
+
+// Body returns the body of msg with envelope stripped.
+func Body(msg []byte) ([]byte, error) {
+	_, i, err := Decode(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg[i:], nil
+}
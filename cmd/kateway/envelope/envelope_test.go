@@ -0,0 +1,94 @@
+package envelope
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestEncodeAndDecode(t *testing.T) {
+	h := Headers{
+		{Key: HeaderTag, Value: "a=b;c=d"},
+		{Key: HeaderTraceId, Value: "abc123"},
+	}
+	body := []byte("hello world")
+	msg, err := Encode(h, body)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, h.Len()+len(body), len(msg))
+	assert.Equal(t, true, IsEnveloped(msg))
+
+	h1, i, err := Decode(msg)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, h, h1)
+	assert.Equal(t, body, msg[i:])
+	assert.Equal(t, []string{"a=b", "c=d"}, h1.Tags())
+	assert.Equal(t, "abc123", h1.Get(HeaderTraceId))
+	assert.Equal(t, "", h1.Get(HeaderTTL))
+}
+
+func TestHeadersExpired(t *testing.T) {
+	now := time.Unix(1000, 0)
+	h := Headers{{Key: HeaderTTL, Value: "1010"}}
+	assert.Equal(t, false, h.Expired(now.Add(time.Second*10)))
+	assert.Equal(t, true, h.Expired(now.Add(time.Second*11)))
+	assert.Equal(t, false, Headers{}.Expired(now))
+}
+
+func TestDecodeNotEnveloped(t *testing.T) {
+	for _, msg := range [][]byte{
+		[]byte("hello world"),
+		[]byte{1, 0xff, 0x08, 2, 9}, // binary message starts with 0x01
+		[]byte{1, 'a', 'b'},         // no tag mark end
+		[]byte{},
+	} {
+		assert.Equal(t, false, IsEnveloped(msg))
+		h, i, err := Decode(msg)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 0, i)
+		assert.Equal(t, 0, len(h))
+	}
+}
+
+func TestDecodeLegacyTag(t *testing.T) {
+	msg := append([]byte{1}, []byte("a=b;c\x02hello")...)
+	assert.Equal(t, true, IsEnveloped(msg))
+	h, i, err := Decode(msg)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", string(msg[i:]))
+	assert.Equal(t, []string{"a=b", "c"}, h.Tags())
+}
+
+func TestDecodeCorrupted(t *testing.T) {
+	msg, _ := Encode(Headers{{Key: HeaderTag, Value: "a"}}, nil)
+	_, _, err := Decode(msg[:len(msg)-1])
+	assert.Equal(t, ErrCorrupted, err)
+
+	msg[3] = 9
+	_, _, err = Decode(msg)
+	assert.Equal(t, ErrUnknownVersion, err)
+}
+
+func TestHeadersValidate(t *testing.T) {
+	assert.Equal(t, ErrIllegalHeaderKey, Headers{{Key: "", Value: "x"}}.Validate())
+	assert.Equal(t, nil, Headers{{Key: "k", Value: ""}}.Validate())
+}
+
+func BenchmarkDecode(b *testing.B) {
+	b.ReportAllocs()
+	msg, _ := Encode(Headers{{Key: HeaderTag, Value: "a=b;c=d"}}, make([]byte, 900))
+	for i := 0; i < b.N; i++ {
+		Decode(msg)
+	}
+	b.SetBytes(int64(len(msg)))
+}
+
+func TestHeadersWithout(t *testing.T) {
+	h := Headers{
+		{Key: HeaderTag, Value: "a=b"},
+		{Key: HeaderOrigin, Value: "trade"},
+	}
+	assert.Equal(t, Headers{{Key: HeaderTag, Value: "a=b"}}, h.Without(HeaderOrigin))
+	assert.Equal(t, 0, len(h[1:].Without(HeaderOrigin)))
+	assert.Equal(t, "trade", h.Get(HeaderOrigin))
+}
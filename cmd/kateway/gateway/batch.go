@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"mime"
+)
+
+const (
+	// ContentTypeBatchBinary is the length prefixed binary framing of batch pub.
+	ContentTypeBatchBinary = "application/x-kateway-batch"
+
+	// ContentTypeBatchJsonLines is the JSON lines framing of batch pub.
+	ContentTypeBatchJsonLines = "application/x-ndjson"
+
+	batchFrameHeaderLen = 2 + 2 + 4 // keyLen tagLen bodyLen
+)
+
+var (
+	ErrIllegalBatchFrame  = errors.New("illegal batch frame")
+	ErrTooBigBatchFrame   = errors.New("too big key, tag or body of batch frame")
+	ErrEmptyBatch         = errors.New("empty batch")
+	ErrUnknownBatchFormat = errors.New("unknown batch content type")
+)
+
+// BatchMessage is a keyed and tagged message within a batch pub.
+type BatchMessage struct {
+	Key   string `json:"key,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+	Value string `json:"value"`
+}
+
+// BatchPubResult is the pub result of a BatchMessage in the same position of the batch.
+type BatchPubResult struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Err       string `json:"errmsg,omitempty"`
+}
+
+// ┌───────────┬───────────┬────────────┬─────┬─────┬──────┐
+// │keyLen(u16)│tagLen(u16)│bodyLen(u32)│ Key │ Tag │ Body │ ...
+// └───────────┴───────────┴────────────┴─────┴─────┴──────┘
+func WriteBatchFrame(w io.Writer, key, tag string, body []byte) error {
+	if len(key) > math.MaxUint16 || len(tag) > math.MaxUint16 || int64(len(body)) > math.MaxUint32 {
+		return ErrTooBigBatchFrame
+	}
+
+	var buf [batchFrameHeaderLen]byte
+	binary.BigEndian.PutUint16(buf[0:2], uint16(len(key)))
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(tag)))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(body)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, key); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, tag); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// DecodeBatch decodes a batch pub body according to its content type, parameters
+// such as charset are ignored.
+// The returned message values reference the underlying body, no copy is made.
+func DecodeBatch(contentType string, body []byte) ([]BatchMessage, [][]byte, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, nil, ErrUnknownBatchFormat
+	}
+
+	switch mediaType {
+	case ContentTypeBatchBinary:
+		return decodeBinaryBatch(body)
+
+	case ContentTypeBatchJsonLines:
+		return decodeJsonLinesBatch(body)
+
+	default:
+		return nil, nil, ErrUnknownBatchFormat
+	}
+}
+
+func decodeBinaryBatch(body []byte) (msgs []BatchMessage, values [][]byte, err error) {
+	idx := 0
+	for idx < len(body) {
+		if idx+batchFrameHeaderLen > len(body) {
+			return nil, nil, ErrIllegalBatchFrame
+		}
+
+		keyLen := int(binary.BigEndian.Uint16(body[idx : idx+2]))
+		tagLen := int(binary.BigEndian.Uint16(body[idx+2 : idx+4]))
+		bodyLen := int(binary.BigEndian.Uint32(body[idx+4 : idx+8]))
+		idx += batchFrameHeaderLen
+
+		if idx+keyLen+tagLen+bodyLen > len(body) {
+			return nil, nil, ErrIllegalBatchFrame
+		}
+
+		m := BatchMessage{}
+		m.Key = string(body[idx : idx+keyLen])
+		idx += keyLen
+		m.Tag = string(body[idx : idx+tagLen])
+		idx += tagLen
+
+		msgs = append(msgs, m)
+		values = append(values, body[idx:idx+bodyLen])
+		idx += bodyLen
+	}
+
+	if len(msgs) == 0 {
+		return nil, nil, ErrEmptyBatch
+	}
+
+	return
+}
+
+func decodeJsonLinesBatch(body []byte) (msgs []BatchMessage, values [][]byte, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 4<<10), len(body)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var m BatchMessage
+		if err = json.Unmarshal(line, &m); err != nil {
+			return nil, nil, err
+		}
+
+		msgs = append(msgs, m)
+		values = append(values, []byte(m.Value))
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(msgs) == 0 {
+		return nil, nil, ErrEmptyBatch
+	}
+
+	return
+}
@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestDecodeBinaryBatch(t *testing.T) {
+	w := bytes.NewBuffer(make([]byte, 0))
+	assert.Equal(t, nil, WriteBatchFrame(w, "k1", "a=b", []byte("hello world")))
+	assert.Equal(t, nil, WriteBatchFrame(w, "", "", []byte("good morning")))
+
+	msgs, values, err := DecodeBatch(ContentTypeBatchBinary, w.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, "k1", msgs[0].Key)
+	assert.Equal(t, "a=b", msgs[0].Tag)
+	assert.Equal(t, "hello world", string(values[0]))
+	assert.Equal(t, "", msgs[1].Key)
+	assert.Equal(t, "good morning", string(values[1]))
+
+	// truncated frame
+	_, _, err = DecodeBatch(ContentTypeBatchBinary, w.Bytes()[:w.Len()-1])
+	assert.Equal(t, ErrIllegalBatchFrame, err)
+
+	_, _, err = DecodeBatch(ContentTypeBatchBinary, nil)
+	assert.Equal(t, ErrEmptyBatch, err)
+}
+
+func TestDecodeJsonLinesBatch(t *testing.T) {
+	body := `{"key":"k1","tag":"a=b","value":"hello world"}
+
+{"value":"good morning"}
+`
+	msgs, values, err := DecodeBatch(ContentTypeBatchJsonLines, []byte(body))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, "k1", msgs[0].Key)
+	assert.Equal(t, "a=b", msgs[0].Tag)
+	assert.Equal(t, "good morning", string(values[1]))
+
+	msgs, _, err = DecodeBatch("application/x-ndjson; charset=utf-8", []byte(body))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(msgs))
+
+	_, _, err = DecodeBatch(ContentTypeBatchJsonLines, []byte("{bad json"))
+	assert.NotEqual(t, nil, err)
+}
+
+func TestDecodeBatchUnknownContentType(t *testing.T) {
+	_, _, err := DecodeBatch("text/plain", []byte("hello"))
+	assert.Equal(t, ErrUnknownBatchFormat, err)
+
+	_, _, err = DecodeBatch("", []byte("hello"))
+	assert.Equal(t, ErrUnknownBatchFormat, err)
+}
+
+func TestWriteBatchFrameTooBig(t *testing.T) {
+	w := bytes.NewBuffer(make([]byte, 0))
+	assert.Equal(t, ErrTooBigBatchFrame, WriteBatchFrame(w, strings.Repeat("k", math.MaxUint16+1), "", []byte("hello")))
+	assert.Equal(t, ErrTooBigBatchFrame, WriteBatchFrame(w, "", strings.Repeat("t", math.MaxUint16+1), []byte("hello")))
+	assert.Equal(t, 0, w.Len())
+
+	assert.Equal(t, nil, WriteBatchFrame(w, strings.Repeat("k", math.MaxUint16), "", []byte("hello")))
+}
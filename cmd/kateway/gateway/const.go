@@ -7,7 +7,13 @@ const (
 	HttpHeaderMsgBury         = "X-Bury"
 	HttpHeaderMsgKey          = "X-Key"
 	HttpHeaderMsgTag          = "X-Tag"
+	HttpHeaderMsgTTL          = "X-Ttl"
+	HttpHeaderTraceId         = "X-Trace-Id"
 	HttpHeaderJobId           = "X-Job-Id"
+	HttpHeaderMsgId           = "X-Message-Id"
+	HttpHeaderScanned         = "X-Scanned"
+	HttpHeaderSkipped         = "X-Skipped"
+	HttpHeaderCluster         = "X-Cluster"
 	HttpHeaderAcceptEncoding  = "Accept-Encoding"
 	HttpHeaderContentEncoding = "Content-Encoding"
 	HttpEncodingGzip          = "gzip"
@@ -18,6 +24,7 @@ const (
 	UrlParamGroup   = "group"
 
 	MaxPartitionKeyLen = 256
+	MaxMessageIdLen    = 128
 )
 
 var (
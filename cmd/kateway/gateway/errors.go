@@ -8,7 +8,16 @@ var (
 	ErrClientGone           = errors.New("remote client gone")
 	ErrTooBigMessage        = errors.New("too big message")
 	ErrTooSmallMessage      = errors.New("too small message")
+	ErrTooBigTag            = errors.New("too big tag")
 	ErrIllegalTaggedMessage = errors.New("illegal tagged message")
+	ErrIllegalTTL           = errors.New("illegal ttl")
 	ErrClientKilled         = errors.New("client killed")
 	ErrBadResponseWriter    = errors.New("ResponseWriter Close not supported")
+	ErrInvalidEventId       = errors.New("invalid event id")
+	ErrEmptySearchPredicate = errors.New("one of key, tag, q and re required")
+	ErrIllegalSearchRange   = errors.New("from must be earlier than to")
+	ErrIllegalDueRange      = errors.New("illegal due range")
+	ErrIllegalDue           = errors.New("illegal due time")
+	ErrInvalidContentLength = errors.New("invalid content length")
+	ErrIllegalScan          = errors.New("illegal scan")
 )
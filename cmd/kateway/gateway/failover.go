@@ -0,0 +1,204 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	failoverRefreshInterval = time.Second * 10
+
+	// hysteresis of the cluster health so that Pub doesn't flap between clusters
+	failoverTripAfter     = 10 // consecutive system errors before the cluster is down
+	failoverRecoverAfter  = 3  // consecutive successful probes before the cluster is up
+	failoverProbeInterval = time.Second * 5
+)
+
+// pubFailover redirects Pub of an app to its failover cluster when the cluster
+// of the app is down.
+//
+// The failover cluster is configured per app by 'gk failover' and the failed
+// over messages are tagged with envelope.HeaderOrigin so that they can be
+// reconciled back to the origin cluster after it recovers.
+// The cluster health is observed on sync, ack=all and async Pub; Pub handed off to hh
+// is not observed as hh retries in background. While the cluster is down, the allowed
+// probes are always sync Pub.
+type pubFailover struct {
+	gw *Gateway
+
+	mu       sync.RWMutex
+	clusters map[string]string         // appid:failover cluster
+	health   map[string]*clusterHealth // cluster:health
+}
+
+func newPubFailover(gw *Gateway) *pubFailover {
+	return &pubFailover{
+		gw:       gw,
+		clusters: make(map[string]string),
+		health:   make(map[string]*clusterHealth),
+	}
+}
+
+func (this *pubFailover) Start() {
+	ticker := time.NewTicker(failoverRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.gw.shutdownCh:
+			return
+
+		case <-ticker.C:
+			this.refresh()
+		}
+	}
+}
+
+func (this *pubFailover) refresh() {
+	clusters := this.gw.zkzone.PubFailovers()
+
+	this.mu.Lock()
+	for appid, cluster := range clusters {
+		if old, present := this.clusters[appid]; !present || old != cluster {
+			log.Info("failover[%s] -> %s", appid, cluster)
+		}
+	}
+	for appid := range this.clusters {
+		if _, present := clusters[appid]; !present {
+			log.Info("failover[%s] removed", appid)
+		}
+	}
+	this.clusters = clusters
+	this.mu.Unlock()
+}
+
+// Cluster returns the failover cluster of an app, empty if not configured.
+func (this *pubFailover) Cluster(appid string) string {
+	this.mu.RLock()
+	cluster := this.clusters[appid]
+	this.mu.RUnlock()
+	return cluster
+}
+
+// Allow checks whether Pub can be sent to the cluster.
+func (this *pubFailover) Allow(cluster string) bool {
+	return this.clusterHealth(cluster).Allow(time.Now())
+}
+
+// Down checks whether the cluster is tripped, Pub to it is then a probe.
+func (this *pubFailover) Down(cluster string) bool {
+	return this.clusterHealth(cluster).Down()
+}
+
+// Observe feeds the Pub result of the cluster to its health.
+func (this *pubFailover) Observe(cluster string, err error) {
+	switch {
+	case err == nil:
+		this.clusterHealth(cluster).Succeed()
+
+	case store.DefaultPubStore.IsSystemError(err):
+		this.clusterHealth(cluster).Fail(time.Now())
+	}
+}
+
+func (this *pubFailover) clusterHealth(cluster string) *clusterHealth {
+	this.mu.RLock()
+	h, present := this.health[cluster]
+	this.mu.RUnlock()
+	if present {
+		return h
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if h, present = this.health[cluster]; !present {
+		h = newClusterHealth(cluster, failoverTripAfter, failoverRecoverAfter, failoverProbeInterval)
+		this.health[cluster] = h
+	}
+	return h
+}
+
+// clusterHealth is a circuit breaker with hysteresis: a cluster is down after
+// tripAfter consecutive failures, and while down only 1 probe is allowed each
+// probeInterval; it is up again after recoverAfter consecutive successes.
+type clusterHealth struct {
+	cluster string
+
+	tripAfter     int
+	recoverAfter  int
+	probeInterval time.Duration
+
+	mu        sync.Mutex
+	down      bool
+	failures  int
+	successes int
+	lastProbe time.Time
+}
+
+func newClusterHealth(cluster string, tripAfter, recoverAfter int, probeInterval time.Duration) *clusterHealth {
+	return &clusterHealth{
+		cluster:       cluster,
+		tripAfter:     tripAfter,
+		recoverAfter:  recoverAfter,
+		probeInterval: probeInterval,
+	}
+}
+
+func (this *clusterHealth) Allow(now time.Time) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if !this.down {
+		return true
+	}
+
+	if now.Sub(this.lastProbe) < this.probeInterval {
+		return false
+	}
+
+	this.lastProbe = now
+	return true
+}
+
+func (this *clusterHealth) Fail(now time.Time) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.successes = 0
+	if this.down {
+		return
+	}
+
+	this.failures++
+	if this.failures >= this.tripAfter {
+		this.down = true
+		this.lastProbe = now
+		log.Warn("cluster[%s] down after %d consecutive failures", this.cluster, this.failures)
+	}
+}
+
+func (this *clusterHealth) Succeed() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.failures = 0
+	if !this.down {
+		return
+	}
+
+	this.successes++
+	if this.successes >= this.recoverAfter {
+		this.down = false
+		this.successes = 0
+		log.Info("cluster[%s] up after %d consecutive successes", this.cluster, this.recoverAfter)
+	}
+}
+
+func (this *clusterHealth) Down() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.down
+}
@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestClusterHealthTripAndRecover(t *testing.T) {
+	h := newClusterHealth("c1", 3, 2, time.Second)
+	now := time.Now()
+
+	// a success in between resets the failures
+	h.Fail(now)
+	h.Fail(now)
+	h.Succeed()
+	h.Fail(now)
+	h.Fail(now)
+	assert.Equal(t, false, h.Down())
+	assert.Equal(t, true, h.Allow(now))
+
+	h.Fail(now)
+	assert.Equal(t, true, h.Down())
+	assert.Equal(t, false, h.Allow(now))
+
+	// 1 probe each interval
+	now = now.Add(time.Second)
+	assert.Equal(t, true, h.Allow(now))
+	assert.Equal(t, false, h.Allow(now))
+	h.Succeed()
+	assert.Equal(t, true, h.Down())
+
+	// a failed probe restarts the recovery
+	now = now.Add(time.Second)
+	assert.Equal(t, true, h.Allow(now))
+	h.Fail(now)
+	now = now.Add(time.Second)
+	assert.Equal(t, true, h.Allow(now))
+	h.Succeed()
+	assert.Equal(t, true, h.Down())
+
+	now = now.Add(time.Second)
+	assert.Equal(t, true, h.Allow(now))
+	h.Succeed()
+	assert.Equal(t, false, h.Down())
+	assert.Equal(t, true, h.Allow(now))
+}
@@ -15,6 +15,10 @@ import (
 
 	"github.com/funkygao/fae/config"
 	"github.com/funkygao/gafka"
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	dedupdummy "github.com/funkygao/gafka/cmd/kateway/dedup/dummy"
+	dedupmem "github.com/funkygao/gafka/cmd/kateway/dedup/mem"
+	dedupredis "github.com/funkygao/gafka/cmd/kateway/dedup/redis"
 	"github.com/funkygao/gafka/cmd/kateway/hh"
 	hhdisk "github.com/funkygao/gafka/cmd/kateway/hh/disk"
 	hhdummy "github.com/funkygao/gafka/cmd/kateway/hh/dummy"
@@ -62,6 +66,9 @@ type Gateway struct {
 	subServer *subServer
 	manServer *manServer
 	debugMux  *http.ServeMux
+
+	migrator *migrator
+	failover *pubFailover
 }
 
 func New(id string) *Gateway {
@@ -84,6 +91,8 @@ func New(id string) *Gateway {
 	metaConf := zkmeta.DefaultConfig()
 	metaConf.Refresh = Options.MetaRefresh
 	meta.Default = zkmeta.New(metaConf, this.zkzone)
+	this.migrator = newMigrator(this)
+	this.failover = newPubFailover(this)
 	this.accessLogger = NewAccessLogger("access_log", 100)
 	this.svrMetrics = NewServerMetrics(Options.ReporterInterval, this)
 	rc, err := influxdb.NewConfig(Options.InfluxServer, Options.InfluxDbName, "", "", Options.ReporterInterval)
@@ -191,6 +200,21 @@ func New(id string) *Gateway {
 			panic("unknown hinted handoff type")
 		}
 
+		switch Options.DedupStore {
+		case "mem":
+			dedup.Default = dedupmem.New(Options.DedupCapacity, Options.DedupWindow)
+
+		case "redis":
+			dedup.Default = dedupredis.New(Options.DedupRedisAddr, Options.DedupWindow,
+				Options.HttpWriteTimeout) // roughly bounds a Pub in flight
+
+		case "":
+			dedup.Default = dedupdummy.New()
+
+		default:
+			panic("unknown dedup store")
+		}
+
 		if Options.FlushHintedOffOnly {
 			meta.Default.Start()
 			log.Trace("meta store[%s] started", meta.Default.Name())
@@ -291,6 +315,23 @@ func (this *Gateway) Start() (err error) {
 	this.svrMetrics.Load()
 	go startRuntimeMetrics(Options.ReporterInterval)
 
+	// load the migrations before serving Pub/Sub
+	this.migrator.refresh()
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		this.migrator.Start()
+	}()
+	log.Trace("app migrator started")
+
+	this.failover.refresh()
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		this.failover.Start()
+	}()
+	log.Trace("pub failover started")
+
 	// start up the servers
 	this.manServer.Start() // man server is always present
 	if this.pubServer != nil {
@@ -304,6 +345,11 @@ func (this *Gateway) Start() (err error) {
 		}
 		log.Trace("hh[%s] started", hh.Default.Name())
 
+		if err = dedup.Default.Start(); err != nil {
+			panic(err)
+		}
+		log.Trace("dedup[%s] started", dedup.Default.Name())
+
 		if err = job.Default.Start(); err != nil {
 			panic(err)
 		}
@@ -368,6 +414,11 @@ func (this *Gateway) ServeForever() {
 			hh.Default.Stop()
 		}
 
+		if dedup.Default != nil {
+			log.Trace("dedup[%s] stop...", dedup.Default.Name())
+			dedup.Default.Stop()
+		}
+
 		if Options.EnableAccessLog {
 			log.Trace("stopping access logger")
 			this.accessLogger.Stop()
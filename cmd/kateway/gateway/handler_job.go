@@ -1,21 +1,32 @@
 package gateway
 
 import (
+	"encoding/json"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 	"github.com/funkygao/gafka/cmd/kateway/job"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
-	"github.com/funkygao/gafka/mpool"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
 )
 
+const (
+	jobsPageSize    = 100
+	maxJobsPageSize = 1000
+	bulkJobsLimit   = 200 // max jobs touched by a bulk request
+)
+
 //go:generate goannotation $GOFILE
-// @rest POST /v1/jobs/:topic/:ver?delay=100|due=1471565204
-// TODO tag, partitionKey
+// @rest POST /v1/jobs/:topic/:ver?delay=100|due=1471565204|schedule=@every 30s
+// Optional headers: X-Tag, X-Trace-Id
+// schedule creates a recurring job with fixed interval or cron expression, e.g. '*/5 * * * *'.
+// TODO partitionKey
 // TODO use dedicated metrics
 func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	if !Options.DisableMetrics {
@@ -28,8 +39,16 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 
 	var due int64
 	q := r.URL.Query()
-	dueParam := q.Get("due") // due has higher priority than delay
-	if dueParam != "" {
+	schedule := q.Get("schedule") // schedule has the highest priority
+	dueParam := q.Get("due")      // due has higher priority than delay
+	if schedule != "" {
+		if _, err := job.ParseSchedule(schedule); err != nil {
+			log.Error("+job[%s] %s(%s) schedule:%s %s", appid, r.RemoteAddr, realIp, schedule, err)
+
+			writeBadRequest(w, "invalid schedule param")
+			return
+		}
+	} else if dueParam != "" {
 		d, err := strconv.ParseInt(dueParam, 10, 64)
 		if err != nil {
 			log.Error("+job[%s] %s(%s) due:%s %s", appid, r.RemoteAddr, realIp, dueParam, err)
@@ -52,7 +71,7 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 		due = t1.Unix() + delay
 	}
 
-	if due <= t1.Unix() {
+	if schedule == "" && due <= t1.Unix() {
 		log.Error("+job[%s] %s(%s) due=%d before now?", appid, r.RemoteAddr, realIp, due)
 
 		writeBadRequest(w, "invalid param")
@@ -98,20 +117,32 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 		return
 	}
 
-	lbr := io.LimitReader(r.Body, Options.MaxJobSize+1)
-	msg := mpool.NewMessage(msgLen)
-	msg.Body = msg.Body[0:msgLen]
-	if _, err := io.ReadAtLeast(lbr, msg.Body, msgLen); err != nil {
-		msg.Free()
+	tag := r.Header.Get(HttpHeaderMsgTag)
+	if len(tag) > Options.MaxMsgTagLen {
+		writeBadRequest(w, "too big tag")
+		return
+	}
+
+	// the job payload is enveloped, actord will fire it as is
+	headers, err := pubMessageHeaders(tag, r.Header.Get(HttpHeaderTraceId), "", t1)
+	if err != nil {
+		log.Warn("+job[%s] %s(%s) {topic:%s, ver:%s} %s", appid, r.RemoteAddr, realIp, topic, ver, err)
 
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	lbr := io.LimitReader(r.Body, Options.MaxJobSize+1)
+	msg, _, err := readEnvelopedMessage(lbr, headers, msgLen)
+	if err != nil {
 		log.Error("+job[%s] %s(%s) {topic:%s, ver:%s} %s",
 			appid, r.RemoteAddr, realIp, topic, ver, err)
 		writeBadRequest(w, ErrTooBigMessage.Error()) // TODO http.StatusRequestEntityTooLarge
 		return
 	}
 
-	log.Debug("+job[%s] %s(%s) {topic:%s, ver:%s} due:%d/%ds",
-		appid, r.RemoteAddr, realIp, topic, ver, due, due-t1.Unix())
+	log.Debug("+job[%s] %s(%s) {topic:%s, ver:%s} due:%d/%ds schedule:%s",
+		appid, r.RemoteAddr, realIp, topic, ver, due, due-t1.Unix(), schedule)
 
 	if !Options.DisableMetrics {
 		this.pubMetrics.JobQps.Mark(1)
@@ -129,7 +160,13 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 		return
 	}
 
-	jobId, err := job.Default.Add(appid, manager.Default.KafkaTopic(appid, topic, ver), msg.Body, due)
+	var jobId string
+	rawTopic := manager.Default.KafkaTopic(appid, topic, ver)
+	if schedule != "" {
+		jobId, due, err = job.Default.AddRecurring(appid, rawTopic, msg.Body, schedule)
+	} else {
+		jobId, err = job.Default.Add(appid, rawTopic, msg.Body, due)
+	}
 	msg.Free()
 	if err != nil {
 		if !Options.DisableMetrics {
@@ -143,8 +180,8 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 	}
 
 	if Options.AuditPub {
-		this.auditor.Trace("+job[%s] %s(%s) {topic:%s ver:%s UA:%s} due:%d id:%s",
-			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), due, jobId)
+		this.auditor.Trace("+job[%s] %s(%s) {topic:%s ver:%s UA:%s} due:%d schedule:%s id:%s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), due, schedule, jobId)
 	}
 
 	w.Header().Set(HttpHeaderJobId, jobId)
@@ -162,6 +199,7 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 }
 
 // DELETE /v1/jobs/:topic/:ver?id=22323
+// cancel a pending job, the cancelled job is archived.
 func (this *pubServer) deleteJobHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	appid := r.Header.Get(HttpHeaderAppid)
 	topic := params.ByName(UrlParamTopic)
@@ -184,7 +222,13 @@ func (this *pubServer) deleteJobHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	jobId := r.URL.Query().Get("id")
+	q := r.URL.Query()
+	jobId := q.Get("id")
+	if jobId == "" && (q.Get("from") != "" || q.Get("to") != "") {
+		this.cancelJobs(w, r, appid, topic, ver)
+		return
+	}
+
 	if len(jobId) < 18 { // jobId e,g. 341647700585877504
 		writeBadRequest(w, "invalid job id")
 		return
@@ -215,3 +259,438 @@ func (this *pubServer) deleteJobHandler(w http.ResponseWriter, r *http.Request,
 
 	w.Write(ResponseOk)
 }
+
+//go:generate goannotation $GOFILE
+// @rest DELETE /v1/jobs/:topic/:ver?from=1471565204&to=1471568804&cursor=
+// cancel the pending jobs due within [from, to], at most 200 jobs per request. Repeat with
+// the returned next cursor until it is empty.
+func (this *pubServer) cancelJobs(w http.ResponseWriter, r *http.Request, appid, topic, ver string) {
+	realIp := getHttpRemoteIp(r)
+	q := r.URL.Query()
+	from, to, err := parseDueRange(q, true)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	cursor, err := job.ParseCursor(q.Get("cursor"))
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	n, next, err := job.Default.CancelRange(appid, manager.Default.KafkaTopic(appid, topic, ver),
+		from, to, cursor, bulkJobsLimit)
+	if err != nil {
+		log.Error("-jobs[%s] %s(%s) {topic:%s, ver:%s from:%d to:%d cursor:%s} %v",
+			appid, r.RemoteAddr, realIp, topic, ver, from, to, cursor, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	if Options.AuditPub {
+		this.auditor.Trace("-jobs[%s] %s(%s) {topic:%s ver:%s UA:%s from:%d to:%d} %d",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), from, to, n)
+	}
+
+	writeJobJson(w, BulkJobResult{Affected: n, Next: next.String()})
+}
+
+// Job is the state of a job, its payload is without the envelope.
+type Job struct {
+	JobId    string `json:"id"`
+	State    string `json:"state"`
+	Tag      string `json:"tag,omitempty"`
+	Payload  []byte `json:"payload"`
+	Ctime    int64  `json:"ctime"`
+	DueTime  int64  `json:"due"`
+	Etime    int64  `json:"etime,omitempty"` // when fired or cancelled
+	Schedule string `json:"schedule,omitempty"`
+	Paused   bool   `json:"paused,omitempty"`
+}
+
+func newJob(info job.JobInfo) Job {
+	j := Job{
+		JobId:    strconv.FormatInt(info.JobId, 10),
+		State:    string(info.State),
+		Payload:  info.Payload,
+		Ctime:    info.Ctime,
+		DueTime:  info.DueTime,
+		Etime:    info.Etime,
+		Schedule: info.Schedule,
+		Paused:   info.Paused,
+	}
+
+	if envelope.IsEnveloped(info.Payload) {
+		if headers, bodyIdx, err := envelope.Decode(info.Payload); err == nil {
+			j.Tag, j.Payload = headers.Get(envelope.HeaderTag), info.Payload[bodyIdx:]
+		}
+	}
+
+	return j
+}
+
+// JobPage is a page of pending jobs in due time order.
+type JobPage struct {
+	Jobs   []Job  `json:"jobs"`
+	Cursor string `json:"cursor,omitempty"` // of the next page, empty if no more jobs
+}
+
+// BulkJobResult is the result of a bulk job operation.
+type BulkJobResult struct {
+	Affected int64  `json:"affected"`
+	Next     string `json:"next,omitempty"` // cursor of the next request, empty if done
+}
+
+// RecurringJob is the state of a recurring job.
+type RecurringJob struct {
+	JobId    string `json:"id"`
+	Schedule string `json:"schedule"`
+	Ctime    int64  `json:"ctime"`
+	DueTime  int64  `json:"due"`
+	Paused   bool   `json:"paused"`
+}
+
+//go:generate goannotation $GOFILE
+// @rest GET /v1/jobs/:topic/:ver
+// list the recurring jobs of a topic.
+// With id param it gets a job, with from, to or cursor param it lists the pending jobs.
+func (this *pubServer) listJobsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	appid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+	if err := manager.Default.OwnTopic(appid, r.Header.Get(HttpHeaderPubkey), topic); err != nil {
+		log.Error("jobs[%s] %s(%s) {topic:%s, ver:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	switch q := r.URL.Query(); {
+	case q.Get("id") != "":
+		this.getJob(w, r, appid, topic, ver)
+		return
+
+	case q.Get("from") != "" || q.Get("to") != "" || q.Get("cursor") != "":
+		this.listPendingJobs(w, r, appid, topic, ver)
+		return
+	}
+
+	items, err := job.Default.ListRecurring(appid, manager.Default.KafkaTopic(appid, topic, ver))
+	if err != nil {
+		log.Error("jobs[%s] %s(%s) {topic:%s, ver:%s} %v",
+			appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	jobs := make([]RecurringJob, 0, len(items))
+	for _, item := range items {
+		jobs = append(jobs, RecurringJob{
+			JobId:    strconv.FormatInt(item.JobId, 10),
+			Schedule: item.Schedule,
+			Ctime:    item.Ctime,
+			DueTime:  item.DueTime,
+			Paused:   item.Paused,
+		})
+	}
+
+	writeJobJson(w, jobs)
+}
+
+//go:generate goannotation $GOFILE
+// @rest GET /v1/jobs/:topic/:ver?id=22323
+// get a job with its state: pending, fired or cancelled.
+func (this *pubServer) getJob(w http.ResponseWriter, r *http.Request, appid, topic, ver string) {
+	jobId := r.URL.Query().Get("id")
+	if len(jobId) < 18 { // jobId e,g. 341647700585877504
+		writeBadRequest(w, "invalid job id")
+		return
+	}
+
+	info, err := job.Default.Get(appid, manager.Default.KafkaTopic(appid, topic, ver), jobId)
+	if err != nil {
+		log.Error("job[%s] %s(%s) {topic:%s, ver:%s jid:%s} %v",
+			appid, r.RemoteAddr, getHttpRemoteIp(r), topic, ver, jobId, err)
+
+		if err == job.ErrJobNotFound {
+			this.respond4XX(appid, w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	writeJobJson(w, newJob(info))
+}
+
+//go:generate goannotation $GOFILE
+// @rest GET /v1/jobs/:topic/:ver?from=1471565204&to=1471568804&cursor=&limit=100
+// list the pending jobs due within [from, to] in due time order, page by page.
+// Both from and to are optional, cursor is the one returned by the previous page.
+func (this *pubServer) listPendingJobs(w http.ResponseWriter, r *http.Request, appid, topic, ver string) {
+	q := r.URL.Query()
+	from, to, err := parseDueRange(q, false)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	cursor, err := job.ParseCursor(q.Get("cursor"))
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	limit := jobsPageSize
+	if limitParam := q.Get("limit"); limitParam != "" {
+		if limit, err = strconv.Atoi(limitParam); err != nil || limit < 1 || limit > maxJobsPageSize {
+			writeBadRequest(w, "invalid limit param")
+			return
+		}
+	}
+
+	items, next, err := job.Default.List(appid, manager.Default.KafkaTopic(appid, topic, ver), from, to, cursor, limit)
+	if err != nil {
+		log.Error("jobs[%s] %s(%s) {topic:%s, ver:%s from:%d to:%d cursor:%s} %v",
+			appid, r.RemoteAddr, getHttpRemoteIp(r), topic, ver, from, to, cursor, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	page := JobPage{
+		Jobs:   make([]Job, 0, len(items)),
+		Cursor: next.String(),
+	}
+	for _, item := range items {
+		page.Jobs = append(page.Jobs, newJob(job.JobInfo{JobItem: item, State: job.JobPending}))
+	}
+
+	writeJobJson(w, page)
+}
+
+//go:generate goannotation $GOFILE
+// @rest PUT /v1/jobs/:topic/:ver?id=22323&action=pause|resume|reschedule|payload
+// pause or resume a recurring job, reschedule a pending job with due or delay param, or
+// replace the payload of a pending job with the request body.
+// Optional headers of payload action: X-Tag, X-Trace-Id
+func (this *pubServer) updateJobHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	appid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+	if err := manager.Default.OwnTopic(appid, r.Header.Get(HttpHeaderPubkey), topic); err != nil {
+		log.Error("~job[%s] %s(%s) {topic:%s, ver:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	action := q.Get("action")
+	if action == "shift" {
+		this.shiftJobs(w, r, appid, topic, ver)
+		return
+	}
+
+	jobId := q.Get("id")
+	if len(jobId) < 18 { // jobId e,g. 341647700585877504
+		writeBadRequest(w, "invalid job id")
+		return
+	}
+
+	var err error
+	rawTopic := manager.Default.KafkaTopic(appid, topic, ver)
+	switch action {
+	case "pause":
+		err = job.Default.Pause(appid, rawTopic, jobId, true)
+
+	case "resume":
+		err = job.Default.Pause(appid, rawTopic, jobId, false)
+
+	case "reschedule":
+		var due int64
+		if due, err = parseJobDue(q, time.Now()); err != nil {
+			writeBadRequest(w, err.Error())
+			return
+		}
+
+		err = job.Default.Reschedule(appid, rawTopic, jobId, due)
+
+	case "payload":
+		var payload []byte
+		if payload, err = readJobPayload(r); err != nil {
+			log.Warn("~job[%s] %s(%s) {topic:%s, ver:%s jid:%s} %s",
+				appid, r.RemoteAddr, realIp, topic, ver, jobId, err)
+
+			writeBadRequest(w, err.Error())
+			return
+		}
+
+		err = job.Default.UpdatePayload(appid, rawTopic, jobId, payload)
+
+	default:
+		writeBadRequest(w, "invalid action param")
+		return
+	}
+
+	if err != nil {
+		log.Error("~job[%s] %s(%s) {topic:%s, ver:%s jid:%s action:%s} %v",
+			appid, r.RemoteAddr, realIp, topic, ver, jobId, action, err)
+
+		if err == job.ErrJobNotFound {
+			this.respond4XX(appid, w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	if Options.AuditPub {
+		this.auditor.Trace("~job[%s] %s(%s) {topic:%s ver:%s UA:%s jid:%s action:%s}",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), jobId, action)
+	}
+
+	w.Write(ResponseOk)
+}
+
+//go:generate goannotation $GOFILE
+// @rest PUT /v1/jobs/:topic/:ver?action=shift&from=1471565204&to=1471568804&delay=3600&cursor=
+// move the pending jobs due within [from, to] by delay seconds, e,g. push back all the
+// reminders by an hour. Negative delay brings the jobs forward. At most 200 jobs are moved
+// per request, repeat with the returned next cursor and the same params until it is empty.
+func (this *pubServer) shiftJobs(w http.ResponseWriter, r *http.Request, appid, topic, ver string) {
+	realIp := getHttpRemoteIp(r)
+	q := r.URL.Query()
+	from, to, err := parseDueRange(q, true)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	delay, err := strconv.ParseInt(q.Get("delay"), 10, 64)
+	if err != nil || delay == 0 {
+		writeBadRequest(w, "invalid delay param")
+		return
+	}
+
+	cursor, err := job.ParseCursor(q.Get("cursor"))
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	n, next, err := job.Default.ShiftRange(appid, manager.Default.KafkaTopic(appid, topic, ver),
+		from, to, delay, cursor, bulkJobsLimit)
+	if err != nil {
+		log.Error("~jobs[%s] %s(%s) {topic:%s, ver:%s from:%d to:%d delay:%d cursor:%s} %v",
+			appid, r.RemoteAddr, realIp, topic, ver, from, to, delay, cursor, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	if Options.AuditPub {
+		this.auditor.Trace("~jobs[%s] %s(%s) {topic:%s ver:%s UA:%s from:%d to:%d delay:%d} %d",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), from, to, delay, n)
+	}
+
+	writeJobJson(w, BulkJobResult{Affected: n, Next: next.String()})
+}
+
+// parseDueRange parses the from and to params in unix seconds. They are required by bulk
+// operations and default to all the jobs otherwise.
+func parseDueRange(q url.Values, required bool) (from, to int64, err error) {
+	fromParam, toParam := q.Get("from"), q.Get("to")
+	if required && (fromParam == "" || toParam == "") {
+		return 0, 0, ErrIllegalDueRange
+	}
+
+	to = math.MaxInt32 // due_time is int column
+	if fromParam != "" {
+		if from, err = strconv.ParseInt(fromParam, 10, 64); err != nil {
+			return 0, 0, ErrIllegalDueRange
+		}
+	}
+	if toParam != "" {
+		if to, err = strconv.ParseInt(toParam, 10, 64); err != nil {
+			return 0, 0, ErrIllegalDueRange
+		}
+	}
+
+	if from < 0 || from > to {
+		return 0, 0, ErrIllegalDueRange
+	}
+
+	return
+}
+
+// parseJobDue parses the due param, or the delay param in seconds from now.
+func parseJobDue(q url.Values, now time.Time) (due int64, err error) {
+	if dueParam := q.Get("due"); dueParam != "" {
+		due, err = strconv.ParseInt(dueParam, 10, 64)
+	} else {
+		var delay int64
+		delay, err = strconv.ParseInt(q.Get("delay"), 10, 64)
+		due = now.Unix() + delay
+	}
+
+	if err != nil || due <= now.Unix() {
+		return 0, ErrIllegalDue
+	}
+
+	return
+}
+
+// readJobPayload reads the request body into an enveloped job payload as addJobHandler.
+func readJobPayload(r *http.Request) ([]byte, error) {
+	msgLen := int(r.ContentLength)
+	switch {
+	case msgLen == -1:
+		return nil, ErrInvalidContentLength
+
+	case int64(msgLen) > Options.MaxJobSize:
+		return nil, ErrTooBigMessage
+
+	case msgLen < Options.MinPubSize:
+		return nil, ErrTooSmallMessage
+	}
+
+	tag := r.Header.Get(HttpHeaderMsgTag)
+	if len(tag) > Options.MaxMsgTagLen {
+		return nil, ErrTooBigTag
+	}
+
+	headers, err := pubMessageHeaders(tag, r.Header.Get(HttpHeaderTraceId), "", time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	envelopeLen := headers.Len()
+	payload := make([]byte, envelopeLen+msgLen)
+	lbr := io.LimitReader(r.Body, Options.MaxJobSize+1)
+	if _, err = io.ReadFull(lbr, payload[envelopeLen:]); err != nil {
+		return nil, ErrTooBigMessage
+	}
+
+	if !needsEnvelope(headers, payload[envelopeLen:]) {
+		return payload[envelopeLen:], nil
+	}
+
+	headers.WriteTo(payload)
+	return payload, nil
+}
+
+func writeJobJson(w http.ResponseWriter, v interface{}) {
+	b, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	w.Write(b)
+}
@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"math"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/job"
+)
+
+func TestParseDueRange(t *testing.T) {
+	from, to, err := parseDueRange(url.Values{}, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), from)
+	assert.Equal(t, int64(math.MaxInt32), to)
+
+	from, to, err = parseDueRange(url.Values{"from": {"1471565204"}, "to": {"1471568804"}}, true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1471565204), from)
+	assert.Equal(t, int64(1471568804), to)
+
+	_, _, err = parseDueRange(url.Values{"from": {"1471565204"}}, true)
+	assert.Equal(t, ErrIllegalDueRange, err)
+	_, _, err = parseDueRange(url.Values{"from": {"1471568804"}, "to": {"1471565204"}}, false)
+	assert.Equal(t, ErrIllegalDueRange, err)
+	_, _, err = parseDueRange(url.Values{"from": {"-1"}}, false)
+	assert.Equal(t, ErrIllegalDueRange, err)
+	_, _, err = parseDueRange(url.Values{"to": {"tomorrow"}}, false)
+	assert.Equal(t, ErrIllegalDueRange, err)
+}
+
+func TestParseJobDue(t *testing.T) {
+	now := time.Unix(1471565204, 0)
+
+	due, err := parseJobDue(url.Values{"delay": {"3600"}}, now)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1471568804), due)
+
+	// due has higher priority than delay
+	due, err = parseJobDue(url.Values{"due": {"1471565300"}, "delay": {"3600"}}, now)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1471565300), due)
+
+	_, err = parseJobDue(url.Values{"due": {"1471565204"}}, now)
+	assert.Equal(t, ErrIllegalDue, err)
+	_, err = parseJobDue(url.Values{"delay": {"-1"}}, now)
+	assert.Equal(t, ErrIllegalDue, err)
+	_, err = parseJobDue(url.Values{}, now)
+	assert.Equal(t, ErrIllegalDue, err)
+}
+
+func TestNewJob(t *testing.T) {
+	headers, err := pubMessageHeaders("a;b", "", "", time.Now())
+	assert.Equal(t, nil, err)
+	payload := make([]byte, headers.Len()+5)
+	copy(payload[headers.Len():], "hello")
+	headers.WriteTo(payload)
+
+	info := job.JobInfo{
+		JobItem: job.JobItem{JobId: 341647700585877504, Payload: payload, DueTime: 1471565204},
+		State:   job.JobFired,
+		Etime:   1471565205,
+	}
+	j := newJob(info)
+	assert.Equal(t, "341647700585877504", j.JobId)
+	assert.Equal(t, "fired", j.State)
+	assert.Equal(t, "a;b", j.Tag)
+	assert.Equal(t, "hello", string(j.Payload))
+	assert.Equal(t, int64(1471565205), j.Etime)
+
+	// payload without envelope is returned as is
+	info.Payload = []byte("hello")
+	j = newJob(info)
+	assert.Equal(t, "", j.Tag)
+	assert.Equal(t, "hello", string(j.Payload))
+}
@@ -469,7 +469,7 @@ func (this *manServer) createJobHandler(w http.ResponseWriter, r *http.Request,
 	w.Write(ResponseOk)
 }
 
-// @rest POST /v1/topics/:appid/:topic/:ver?partitions=1&replicas=2&retention.hours=72&retention.bytes=-1
+// @rest POST /v1/topics/:appid/:topic/:ver?partitions=1&replicas=2&retention.hours=72&retention.bytes=-1&archive=1
 func (this *manServer) createTopicHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	topic := params.ByName(UrlParamTopic)
 	if !manager.Default.ValidateTopicName(topic) {
@@ -537,6 +537,7 @@ func (this *manServer) createTopicHandler(w http.ResponseWriter, r *http.Request
 		ts.RetentionBytes, _ = strconv.Atoi(retentionBytes)
 	}
 	ts.ParseRetentionHours(query.Get(sla.SlaKeyRetentionHours))
+	ts.ParseArchive(query.Get(sla.SlaKeyArchive))
 
 	// validate the sla
 	if err := ts.Validate(); err != nil {
@@ -568,6 +569,15 @@ func (this *manServer) createTopicHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	if createdOk {
+		if ts.Archive {
+			if err = zkcluster.ZkZone().SetTopicArchive(cluster, rawTopic, true); err != nil {
+				log.Error("app[%s] %s(%s) archive topic[%s]: %v", appid, r.RemoteAddr, realIp, rawTopic, err)
+
+				writeServerError(w, err.Error())
+				return
+			}
+		}
+
 		alterConfig := ts.DumpForAlterTopic()
 		if len(alterConfig) == 0 {
 			w.Write(ResponseOk)
@@ -592,7 +602,7 @@ func (this *manServer) createTopicHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// @rest PUT /v1/topics/:appid/:topic/:ver?partitions=1&retention.hours=72&retention.bytes=-1
+// @rest PUT /v1/topics/:appid/:topic/:ver?partitions=1&retention.hours=72&retention.bytes=-1&archive=0
 func (this *manServer) alterTopicHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	topic := params.ByName(UrlParamTopic)
 	if !manager.Default.ValidateTopicName(topic) {
@@ -669,7 +679,27 @@ func (this *manServer) alterTopicHandler(w http.ResponseWriter, r *http.Request,
 		appid, r.RemoteAddr, realIp, hisAppid, cluster, topic, ver, query.Encode())
 
 	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
+	archiveArg := query.Get(sla.SlaKeyArchive)
+	if archiveArg != "" {
+		if err := ts.ParseArchive(archiveArg); err != nil {
+			writeBadRequest(w, err.Error())
+			return
+		}
+
+		if err := zkcluster.ZkZone().SetTopicArchive(cluster, rawTopic, ts.Archive); err != nil {
+			log.Error("app[%s] from %s(%s) archive topic: {appid:%s cluster:%s topic:%s ver:%s} %v",
+				appid, r.RemoteAddr, realIp, hisAppid, cluster, topic, ver, err)
+
+			writeServerError(w, err.Error())
+			return
+		}
+	}
+
 	alterConfig := ts.DumpForAlterTopic()
+	if len(alterConfig) == 0 && archiveArg != "" {
+		w.Write(ResponseOk)
+		return
+	}
 	if len(alterConfig) == 0 {
 		log.Warn("app[%s] from %s(%s) alter topic: {appid:%s cluster:%s topic:%s ver:%s query:%s} nothing updated",
 			appid, r.RemoteAddr, realIp, hisAppid, cluster, topic, ver, query.Encode())
@@ -0,0 +1,490 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/meta"
+	gzk "github.com/funkygao/gafka/zk"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	searchDefaultLimit   = 100
+	searchMaxLimit       = 1000
+	searchDefaultBudget  = 100000   // max messages to scan of a search
+	searchMaxBudget      = 10000000 // 10M
+	searchDefaultTimeout = time.Second * 10
+	searchTimeoutMargin  = time.Second * 5 // end the stream before http write timeout kills it
+)
+
+// searchQuery is the predicate of message search, all the given conditions must match.
+type searchQuery struct {
+	key    []byte         // exact kafka message key
+	filter tagFilter      // tag filter expression on envelope headers
+	substr []byte         // substring of the body
+	re     *regexp.Regexp // regexp of the body
+
+	from, to int64 // unix ms of [from, to), 0 means unbounded
+}
+
+func parseSearchQuery(q url.Values) (*searchQuery, error) {
+	sq := &searchQuery{}
+	if key := q.Get("key"); key != "" {
+		sq.key = []byte(key)
+	}
+	if tag := q.Get("tag"); tag != "" {
+		filter, err := parseTagFilter(tag)
+		if err != nil {
+			return nil, err
+		}
+		sq.filter = filter
+	}
+	if substr := q.Get("q"); substr != "" {
+		sq.substr = []byte(substr)
+	}
+	if expr := q.Get("re"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		sq.re = re
+	}
+	if sq.key == nil && sq.filter == nil && sq.substr == nil && sq.re == nil {
+		return nil, ErrEmptySearchPredicate
+	}
+
+	if from := q.Get("from"); from != "" {
+		t, err := gzk.ParseOffsetTime(from)
+		if err != nil {
+			return nil, err
+		}
+		sq.from = t.UnixNano() / int64(time.Millisecond)
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := gzk.ParseOffsetTime(to)
+		if err != nil {
+			return nil, err
+		}
+		sq.to = t.UnixNano() / int64(time.Millisecond)
+	}
+	if sq.to > 0 && sq.from >= sq.to {
+		return nil, ErrIllegalSearchRange
+	}
+
+	return sq, nil
+}
+
+func (this *searchQuery) fromTime() time.Time {
+	return time.Unix(0, this.from*int64(time.Millisecond))
+}
+
+func (this *searchQuery) toTime() time.Time {
+	return time.Unix(0, this.to*int64(time.Millisecond))
+}
+
+// Match checks a kafka message against the query and returns the tags and body of
+// the enveloped message. ts is zero if the message format has no timestamp, in which
+// case the time range is enforced only by the offsets.
+func (this *searchQuery) Match(key, value []byte, ts time.Time) (tags []string, body []byte, ok bool) {
+	if !ts.IsZero() {
+		ms := ts.UnixNano() / int64(time.Millisecond)
+		if ms < this.from || (this.to > 0 && ms >= this.to) {
+			return
+		}
+	}
+
+	if this.key != nil && !bytes.Equal(this.key, key) {
+		return
+	}
+
+	body = value
+	if envelope.IsEnveloped(value) {
+		headers, bodyIdx, err := envelope.Decode(value)
+		if err != nil {
+			return nil, nil, false
+		}
+
+		tags, body = headers.Tags(), value[bodyIdx:]
+	}
+
+	if this.filter != nil && !this.filter.Match(tags) {
+		return nil, nil, false
+	}
+	if this.substr != nil && !bytes.Contains(body, this.substr) {
+		return nil, nil, false
+	}
+	if this.re != nil && !this.re.Match(body) {
+		return nil, nil, false
+	}
+
+	return tags, body, true
+}
+
+// searchHit is a matched message, body is base64 encoded as peek.
+type searchHit struct {
+	Partition int32    `json:"partition"`
+	Offset    int64    `json:"offset"`
+	Timestamp int64    `json:"ts,omitempty"` // unix ms
+	Key       string   `json:"key,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Body      []byte   `json:"body"`
+}
+
+// searchSummary is the last line of the search stream.
+type searchSummary struct {
+	Scanned    int64  `json:"scanned"`
+	Matched    int    `json:"matched"`
+	Partitions int    `json:"partitions"`
+	Complete   bool   `json:"complete"`          // all the messages in range are scanned
+	Stopped    string `json:"stopped,omitempty"` // limit|budget|timeout|shutdown|error
+	Error      string `json:"error,omitempty"`
+}
+
+// @rest GET /v1/search/:appid/:topic/:ver?from=&to=&key=&tag=&q=&re=&limit=100&budget=100000&timeout=10s
+// scan the partitions in parallel from the offsets of from time and stream matched messages
+// as ndjson, the last line is the summary
+func (this *manServer) searchHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var (
+		myAppid  string
+		hisAppid string
+		topic    string
+		ver      string
+		rawTopic string
+		limit    int
+		budget   int64
+		timeout  time.Duration
+		realIp   = getHttpRemoteIp(r)
+	)
+
+	if !this.throttleSearch.Pour(realIp, 1) {
+		writeQuotaExceeded(w)
+		return
+	}
+
+	ver = params.ByName(UrlParamVersion)
+	topic = params.ByName(UrlParamTopic)
+	hisAppid = params.ByName(UrlParamAppid)
+	myAppid = r.Header.Get(HttpHeaderAppid)
+
+	q := r.URL.Query()
+	sq, err := parseSearchQuery(q)
+	if err != nil {
+		log.Error("search[%s] %s(%s) {app:%s topic:%s ver:%s} %s: %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, r.URL.RawQuery, err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	limit, _ = strconv.Atoi(q.Get("limit"))
+	if limit <= 0 {
+		limit = searchDefaultLimit
+	} else if limit > searchMaxLimit {
+		limit = searchMaxLimit
+	}
+	budget, _ = strconv.ParseInt(q.Get("budget"), 10, 64)
+	if budget <= 0 {
+		budget = searchDefaultBudget
+	} else if budget > searchMaxBudget {
+		budget = searchMaxBudget
+	}
+	timeout, _ = time.ParseDuration(q.Get("timeout"))
+	if timeout < time.Second {
+		timeout = searchDefaultTimeout
+	}
+	if maxTimeout := Options.HttpWriteTimeout - searchTimeoutMargin; maxTimeout > time.Second && timeout > maxTimeout {
+		timeout = maxTimeout
+	}
+
+	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey), hisAppid, topic, ""); err != nil {
+		log.Error("search[%s] %s(%s) {app:%s topic:%s ver:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	cluster, found := manager.Default.LookupCluster(hisAppid)
+	if !found {
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	log.Info("search[%s] %s(%s) {app:%s topic:%s ver:%s limit:%d budget:%d timeout:%s} %s",
+		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, limit, budget, timeout, r.URL.RawQuery)
+
+	rawTopic = manager.Default.KafkaTopic(hisAppid, topic, ver)
+	zkcluster := meta.Default.ZkCluster(cluster)
+
+	kfk, begins, ends, err := openSearch(zkcluster, rawTopic, sq)
+	if err != nil {
+		log.Error("search[%s] %s(%s) {app:%s topic:%s ver:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+	defer kfk.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(kfk)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+	defer consumer.Close()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeServerError(w, ErrBadResponseWriter.Error())
+		return
+	}
+	var clientGoneCh <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		clientGoneCh = cn.CloseNotify()
+	}
+
+	var (
+		summary = searchSummary{Partitions: len(begins)}
+		hits    = make(chan searchHit)
+		errs    = make(chan error, len(begins))
+		stopCh  = make(chan struct{})
+		doneCh  = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	for partitionId, begin := range begins {
+		wg.Add(1)
+		go func(partitionId int32, begin, end int64) {
+			defer wg.Done()
+
+			if err := scanPartition(consumer, rawTopic, partitionId, begin, end, sq,
+				&summary.Scanned, budget, hits, stopCh); err != nil {
+				errs <- err
+			}
+		}(partitionId, begin, ends[partitionId])
+	}
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson") // override middleware header
+	w.Header().Set("X-Accel-Buffering", "no")              // disable nginx proxy buffering
+	w.WriteHeader(http.StatusOK)
+
+	var (
+		enc      = json.NewEncoder(w)
+		deadline = time.After(timeout)
+		gone     bool
+	)
+LOOP:
+	for {
+		select {
+		case <-doneCh:
+			select {
+			case err = <-errs:
+				// failed along with the other scanners done, select picked doneCh first
+				log.Error("search[%s] %s(%s) {app:%s topic:%s ver:%s} %v",
+					myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, err)
+
+				summary.Stopped = "error"
+				summary.Error = err.Error()
+
+			default:
+				summary.Complete = atomic.LoadInt64(&summary.Scanned) < budget
+				if !summary.Complete {
+					summary.Stopped = "budget"
+				}
+			}
+			break LOOP
+
+		case <-deadline:
+			summary.Stopped = "timeout"
+			break LOOP
+
+		case <-clientGoneCh:
+			log.Warn("search[%s] %s(%s) {app:%s topic:%s ver:%s} %v",
+				myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, ErrClientGone)
+			gone = true
+			break LOOP
+
+		case <-this.gw.shutdownCh:
+			summary.Stopped = "shutdown"
+			break LOOP
+
+		case err = <-errs:
+			log.Error("search[%s] %s(%s) {app:%s topic:%s ver:%s} %v",
+				myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, err)
+
+			summary.Stopped = "error"
+			summary.Error = err.Error()
+			break LOOP
+
+		case hit := <-hits:
+			if err = enc.Encode(hit); err != nil {
+				gone = true
+				break LOOP
+			}
+			flusher.Flush()
+
+			summary.Matched++
+			if summary.Matched >= limit {
+				summary.Stopped = "limit"
+				break LOOP
+			}
+		}
+	}
+
+	close(stopCh) // stop all the scanners
+	wg.Wait()
+	if gone {
+		return
+	}
+
+	summary.Scanned = atomic.LoadInt64(&summary.Scanned)
+	enc.Encode(summary)
+
+	log.Info("search[%s] %s(%s) {app:%s topic:%s ver:%s} %+v",
+		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, summary)
+}
+
+// openSearch connects the cluster with message timestamps and returns the offset ranges to
+// scan. Like OffsetsOfTime, it falls back to the default version for the legacy brokers.
+func openSearch(zkcluster *gzk.ZkCluster, topic string, sq *searchQuery) (kfk sarama.Client,
+	begins, ends map[int32]int64, err error) {
+	cf := sarama.NewConfig()
+	cf.Version = sarama.V0_10_1_0
+	if kfk, begins, ends, err = openSearchWith(zkcluster, topic, sq, cf); err != nil {
+		log.Warn("search cluster[%s] topic[%s] message timestamp: %v, fallback to default version",
+			zkcluster.Name(), topic, err)
+		kfk, begins, ends, err = openSearchWith(zkcluster, topic, sq, sarama.NewConfig())
+	}
+
+	return
+}
+
+func openSearchWith(zkcluster *gzk.ZkCluster, topic string, sq *searchQuery, cf *sarama.Config) (kfk sarama.Client,
+	begins, ends map[int32]int64, err error) {
+	if kfk, err = sarama.NewClient(zkcluster.BrokerList(), cf); err != nil {
+		return
+	}
+
+	// the metadata request works whatever the version, probe with the versioned one
+	if _, err = kfk.GetOffset(topic, 0, sarama.OffsetNewest); err == nil {
+		begins, ends, err = searchOffsets(kfk, zkcluster, topic, sq)
+	}
+	if err != nil {
+		kfk.Close()
+		kfk = nil
+	}
+
+	return
+}
+
+// searchOffsets returns the offset ranges [begin, end) of each partition to scan.
+func searchOffsets(kfk sarama.Client, zkcluster *gzk.ZkCluster, topic string,
+	sq *searchQuery) (begins, ends map[int32]int64, err error) {
+	partitions, err := kfk.Partitions(topic)
+	if err != nil {
+		return
+	}
+
+	if sq.from > 0 {
+		if begins, err = zkcluster.OffsetsOfTime(topic, sq.fromTime()); err != nil {
+			return
+		}
+	} else {
+		begins = make(map[int32]int64, len(partitions))
+		for _, p := range partitions {
+			if begins[p], err = kfk.GetOffset(topic, p, sarama.OffsetOldest); err != nil {
+				return
+			}
+		}
+	}
+
+	if sq.to > 0 {
+		if ends, err = zkcluster.OffsetsOfTime(topic, sq.toTime()); err != nil {
+			return
+		}
+	} else {
+		ends = make(map[int32]int64, len(partitions))
+		for _, p := range partitions {
+			if ends[p], err = kfk.GetOffset(topic, p, sarama.OffsetNewest); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// scanPartition sends the matched messages of [begin, end) to hits until the range is
+// exhausted, the shared budget is used up or stopped.
+func scanPartition(consumer sarama.Consumer, topic string, partitionId int32, begin, end int64,
+	sq *searchQuery, scanned *int64, budget int64, hits chan<- searchHit, stopCh <-chan struct{}) error {
+	if begin >= end {
+		return nil
+	}
+
+	pc, err := consumer.ConsumePartition(topic, partitionId, begin)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	for offset := begin; offset < end; {
+		select {
+		case <-stopCh:
+			return nil
+
+		case err := <-pc.Errors():
+			return err
+
+		case msg := <-pc.Messages():
+			offset = msg.Offset + 1
+			if msg.Offset >= end {
+				return nil
+			}
+
+			if atomic.AddInt64(scanned, 1) > budget {
+				atomic.AddInt64(scanned, -1)
+				return nil
+			}
+
+			tags, body, ok := sq.Match(msg.Key, msg.Value, msg.Timestamp)
+			if !ok {
+				continue
+			}
+
+			hit := searchHit{
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+				Key:       string(msg.Key),
+				Tags:      tags,
+				Body:      body,
+			}
+			if !msg.Timestamp.IsZero() {
+				hit.Timestamp = msg.Timestamp.UnixNano() / int64(time.Millisecond)
+			}
+
+			select {
+			case hits <- hit:
+			case <-stopCh:
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	_, err := parseSearchQuery(url.Values{"from": {"1483286400"}})
+	assert.Equal(t, ErrEmptySearchPredicate, err)
+
+	_, err = parseSearchQuery(url.Values{"q": {"foo"}, "from": {"1483372800"}, "to": {"1483286400"}})
+	assert.Equal(t, ErrIllegalSearchRange, err)
+
+	_, err = parseSearchQuery(url.Values{"tag": {"a &&"}})
+	assert.Equal(t, ErrIllegalTagFilter, err)
+
+	_, err = parseSearchQuery(url.Values{"re": {"("}})
+	assert.NotEqual(t, nil, err)
+
+	sq, err := parseSearchQuery(url.Values{"key": {"uid1"}, "from": {"2017-01-02T00:00:00Z"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1483315200000), sq.from)
+	assert.Equal(t, int64(0), sq.to)
+}
+
+func TestSearchQueryMatch(t *testing.T) {
+	sq, err := parseSearchQuery(url.Values{"tag": {"city=bj"}, "re": {`"amount":\d{3,}`},
+		"from": {"1483286400"}, "to": {"1483372800"}})
+	assert.Equal(t, nil, err)
+
+	msg, err := envelope.Encode(envelope.Headers{{Key: envelope.HeaderTag, Value: "city=bj;vip"}},
+		[]byte(`{"amount":1200}`))
+	assert.Equal(t, nil, err)
+
+	inRange := time.Unix(1483300000, 0)
+	tags, body, ok := sq.Match(nil, msg, inRange)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []string{"city=bj", "vip"}, tags)
+	assert.Equal(t, `{"amount":1200}`, string(body))
+
+	// no timestamp before kafka 0.10, range is enforced by offsets
+	_, _, ok = sq.Match(nil, msg, time.Time{})
+	assert.Equal(t, true, ok)
+
+	_, _, ok = sq.Match(nil, msg, time.Unix(1483372800, 0))
+	assert.Equal(t, false, ok)
+
+	_, _, ok = sq.Match(nil, []byte(`{"amount":1200}`), inRange) // untagged
+	assert.Equal(t, false, ok)
+
+	// key and substring on plain message
+	sq, err = parseSearchQuery(url.Values{"key": {"uid1"}, "q": {"refund"}})
+	assert.Equal(t, nil, err)
+	_, body, ok = sq.Match([]byte("uid1"), []byte("order refund"), inRange)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "order refund", string(body))
+	_, _, ok = sq.Match([]byte("uid2"), []byte("order refund"), inRange)
+	assert.Equal(t, false, ok)
+	_, _, ok = sq.Match([]byte("uid1"), []byte("order paid"), inRange)
+	assert.Equal(t, false, ok)
+}
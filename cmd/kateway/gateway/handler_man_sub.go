@@ -8,10 +8,12 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/cmd/kateway/meta"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/gafka/sla"
+	gzk "github.com/funkygao/gafka/zk"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
 	"github.com/samuel/go-zookeeper/zk"
@@ -225,7 +227,11 @@ LOOP:
 			return
 
 		case msg := <-msgChan:
-			msgs = append(msgs, msg.Value)
+			if body, e := envelope.Body(msg.Value); e == nil {
+				msgs = append(msgs, body)
+			} else {
+				msgs = append(msgs, msg.Value)
+			}
 
 			n++
 			if n >= lastN {
@@ -321,6 +327,83 @@ func (this *manServer) resetSubOffsetHandler(w http.ResponseWriter, r *http.Requ
 	w.Write(ResponseOk)
 }
 
+// @rest PUT /v1/offsets/:appid/:topic/:ver/:group?at=<unix|RFC3339>
+// reset all partitions of the group to the time, the consumers of the group should be stopped first
+func (this *manServer) resetSubOffsetsOfTimeHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var (
+		topic    string
+		ver      string
+		myAppid  string
+		hisAppid string
+		at       string
+		group    string
+		err      error
+		realIp   = getHttpRemoteIp(r)
+	)
+
+	if !this.throttleSubStatus.Pour(realIp, 1) {
+		writeQuotaExceeded(w)
+		return
+	}
+
+	at = r.URL.Query().Get("at")
+	group = params.ByName(UrlParamGroup)
+	ver = params.ByName(UrlParamVersion)
+	topic = params.ByName(UrlParamTopic)
+	hisAppid = params.ByName(UrlParamAppid)
+	myAppid = r.Header.Get(HttpHeaderAppid)
+
+	t, err := gzk.ParseOffsetTime(at)
+	if err != nil {
+		log.Error("sub reset offset[%s] %s(%s) {app:%s topic:%s ver:%s group:%s at:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, at, err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
+		hisAppid, topic, group); err != nil {
+		log.Error("sub reset offset[%s] %s(%s) {app:%s topic:%s ver:%s group:%s at:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, at, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	cluster, found := manager.Default.LookupCluster(hisAppid)
+	if !found {
+		log.Error("sub reset offset[%s] %s(%s) {app:%s topic:%s ver:%s group:%s at:%s} cluster not found",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, at)
+
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	zkcluster := meta.Default.ZkCluster(cluster)
+	realGroup := myAppid + "." + group
+	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
+	offsets, err := zkcluster.ResetConsumerGroupOffsetsOfTime(rawTopic, realGroup, t)
+	if err != nil {
+		log.Error("sub reset offset[%s] %s(%s) {app:%s topic:%s ver:%s group:%s at:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, at, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	log.Info("sub reset offset[%s] %s(%s) {app:%s topic:%s ver:%s group:%s at:%s} %+v",
+		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, at, offsets)
+
+	// {partitionId: offset}
+	out := make(map[string]int64, len(offsets))
+	for partitionId, offset := range offsets {
+		out[strconv.Itoa(int(partitionId))] = offset
+	}
+	b, _ := json.Marshal(out)
+	w.Write(b)
+}
+
 // @rest DELETE /v1/groups/:appid/:topic/:ver/:group
 // TODO delete shadow consumers too
 func (this *manServer) delSubGroupHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
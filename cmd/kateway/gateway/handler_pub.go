@@ -8,16 +8,20 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 	"github.com/funkygao/gafka/cmd/kateway/hh"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/cmd/kateway/store"
-	"github.com/funkygao/gafka/mpool"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
 )
 
 //go:generate goannotation $GOFILE
 // @rest POST /v1/msgs/:topic/:ver?key=mykey&async=1&ack=all&hh=n
+// Optional headers: X-Tag, X-Ttl(in sec), X-Trace-Id, X-Message-Id
+// Pub with the same X-Message-Id within the dedup window returns the original partition/offset,
+// 409 if the same X-Message-Id is being published.
 func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		appid        string
@@ -88,27 +92,79 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
-	var msg *mpool.Message
 	tag = r.Header.Get(HttpHeaderMsgTag)
-	if tag != "" {
-		if len(tag) > Options.MaxMsgTagLen {
-			this.respond4XX(appid, w, "too big tag", http.StatusBadRequest)
+	if len(tag) > Options.MaxMsgTagLen {
+		this.respond4XX(appid, w, "too big tag", http.StatusBadRequest)
+		return
+	}
+
+	msgId := r.Header.Get(HttpHeaderMsgId)
+	if len(msgId) > MaxMessageIdLen {
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "too big message id", http.StatusBadRequest)
+		return
+	}
+
+	if msgId != "" {
+		pos, reserved, err := dedup.Default.Reserve(appid, topic, ver, msgId)
+		switch {
+		case err == dedup.ErrInFlight:
+			log.Warn("pub[%s] %s(%s) {topic:%s ver:%s msgid:%s} in flight",
+				appid, r.RemoteAddr, realIp, topic, ver, msgId)
+
+			this.pubMetrics.ClientError.Inc(1)
+			this.respond4XX(appid, w, err.Error(), http.StatusConflict)
 			return
+
+		case err != nil:
+			// dedup is best effort, never block the Pub
+			log.Error("pub[%s] %s(%s) {topic:%s ver:%s msgid:%s} dedup: %v",
+				appid, r.RemoteAddr, realIp, topic, ver, msgId, err)
+			msgId = ""
+
+		case !reserved:
+			log.Debug("pub[%s] %s(%s) {topic:%s ver:%s msgid:%s} duplicated {P:%d O:%d}",
+				appid, r.RemoteAddr, realIp, topic, ver, msgId, pos.Partition, pos.Offset)
+
+			w.Header().Set(HttpHeaderPartition, strconv.FormatInt(int64(pos.Partition), 10))
+			w.Header().Set(HttpHeaderOffset, strconv.FormatInt(pos.Offset, 10))
+			if pos.Offset < 0 {
+				w.WriteHeader(http.StatusAccepted)
+			} else {
+				w.WriteHeader(http.StatusCreated)
+			}
+			w.Write(ResponseOk)
+			return
+
+		default:
+			// release the reservation unless published, so that the client can retry
+			defer func() {
+				if msgId == "" {
+					return
+				}
+
+				if err := dedup.Default.Release(appid, topic, ver, msgId); err != nil {
+					log.Error("pub[%s] %s(%s) {topic:%s ver:%s msgid:%s} dedup release: %v",
+						appid, r.RemoteAddr, realIp, topic, ver, msgId, err)
+				}
+			}()
 		}
+	}
 
-		msgSz := tagLen(tag) + msgLen
-		msg = mpool.NewMessage(msgSz)
-		msg.Body = msg.Body[0:msgSz]
-	} else {
-		msg = mpool.NewMessage(msgLen)
-		msg.Body = msg.Body[0:msgLen]
+	headers, err := pubMessageHeaders(tag, r.Header.Get(HttpHeaderTraceId), r.Header.Get(HttpHeaderMsgTTL), t1)
+	if err != nil {
+		log.Warn("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// get the raw POST message, if body more than content-length ignore the extra payload
 	lbr := io.LimitReader(r.Body, Options.MaxPubSize+1)
-	if _, err := io.ReadAtLeast(lbr, msg.Body, msgLen); err != nil {
-		msg.Free()
-
+	msg, envelopeLen, err := readEnvelopedMessage(lbr, headers, msgLen)
+	if err != nil {
 		log.Error("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
 			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
 
@@ -117,16 +173,12 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
-	if tag != "" {
-		AddTagToMessage(msg, tag)
-	}
-
 	if !Options.DisableMetrics {
 		this.pubMetrics.PubQps.Mark(1)
 		this.pubMetrics.PubMsgSize.Update(int64(len(msg.Body)))
 	}
 
-	cluster, found := manager.Default.LookupCluster(appid)
+	cluster, secondary, found := this.gw.migrator.PubClusters(appid)
 	if !found {
 		log.Warn("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} cluster not found",
 			appid, r.RemoteAddr, realIp, topic, r.Header.Get("User-Agent"), ver)
@@ -139,8 +191,7 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 	var (
 		partition int32
 		offset    int64 = -1
-		err       error
-		rawTopic  = manager.Default.KafkaTopic(appid, topic, ver)
+		rawTopic        = manager.Default.KafkaTopic(appid, topic, ver)
 	)
 
 	pubMethod := store.DefaultPubStore.SyncPub
@@ -157,9 +208,24 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 	hhDisabled = query.Get("hh") == "n" // yes | no
 
 	msgKey := []byte(partitionKey)
-	if ackAll {
+	failover := this.gw.failover.Cluster(appid)
+	if failover != "" && !this.gw.failover.Allow(cluster) {
+		// the cluster is down, bypass hh to keep the messages consumable
+		partition, offset, err = this.pubFailover(cluster, failover, rawTopic, msgKey, headers, msg.Body[envelopeLen:])
+	} else if failover != "" && this.gw.failover.Down(cluster) {
+		// the probe of a down cluster is sync whatever the pub mode so that its result is observed,
+		// otherwise an async only app would stay on the failover cluster forever
+		partition, offset, err = store.DefaultPubStore.SyncPub(cluster, rawTopic, msgKey, msg.Body)
+		this.gw.failover.Observe(cluster, err)
+		if err != nil && store.DefaultPubStore.IsSystemError(err) {
+			partition, offset, err = this.pubFailover(cluster, failover, rawTopic, msgKey, headers, msg.Body[envelopeLen:])
+		}
+	} else if ackAll {
 		// hh not applied
 		partition, offset, err = pubMethod(cluster, rawTopic, msgKey, msg.Body)
+		if failover != "" {
+			this.gw.failover.Observe(cluster, err)
+		}
 	} else if Options.AllwaysHintedHandoff {
 		err = hh.Default.Append(cluster, rawTopic, msgKey, msg.Body)
 	} else if !hhDisabled && Options.EnableHintedHandoff && !hh.Default.Empty(cluster, rawTopic) {
@@ -174,6 +240,9 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 			body := make([]byte, 0, len(msg.Body))
 			copy(body, msg.Body)
 			partition, offset, err = pubMethod(cluster, rawTopic, msgKey, body)
+			if failover != "" {
+				this.gw.failover.Observe(cluster, err)
+			}
 		}
 	} else {
 		// hack byte string conv TODO
@@ -182,7 +251,15 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 			// sarama didn't reset this, so I have to handle it
 			offset = -1
 		}
-		if err != nil && store.DefaultPubStore.IsSystemError(err) && !hhDisabled && Options.EnableHintedHandoff {
+		if failover != "" {
+			this.gw.failover.Observe(cluster, err)
+		}
+		if err != nil && store.DefaultPubStore.IsSystemError(err) && failover != "" {
+			log.Warn("pub[%s] %s(%s) {%s.%s.%s UA:%s} resort failover %s for: %v", appid, r.RemoteAddr, realIp,
+				appid, topic, ver, r.Header.Get("User-Agent"), failover, err)
+
+			partition, offset, err = this.pubFailover(cluster, failover, rawTopic, msgKey, headers, msg.Body[envelopeLen:])
+		} else if err != nil && store.DefaultPubStore.IsSystemError(err) && !hhDisabled && Options.EnableHintedHandoff {
 			log.Warn("pub[%s] %s(%s) {%s.%s.%s UA:%s} resort hh for: %v", appid, r.RemoteAddr, realIp,
 				appid, topic, ver, r.Header.Get("User-Agent"), err)
 
@@ -198,6 +275,19 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 			appid, r.RemoteAddr, realIp, appid, topic, ver, r.Header.Get("User-Agent"), partition, offset, async)
 	}
 
+	if err == nil && secondary != "" {
+		// dual write during migration in the same pub mode, the target cluster is best effort
+		body := msg.Body
+		if async && !ackAll {
+			// async pub holds the body after msg is recycled
+			body = append([]byte(nil), msg.Body...)
+		}
+		if _, _, err := pubMethod(secondary, rawTopic, msgKey, body); err != nil {
+			log.Error("pub[%s] %s(%s) {%s.%s.%s} dual write %s: %v",
+				appid, r.RemoteAddr, realIp, appid, topic, ver, secondary, err)
+		}
+	}
+
 	msg.Free()
 
 	if err != nil {
@@ -214,6 +304,15 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
+	if msgId != "" {
+		if err = dedup.Default.Record(appid, topic, ver, msgId, dedup.Position{Partition: partition, Offset: offset}); err != nil {
+			log.Error("pub[%s] %s(%s) {topic:%s ver:%s msgid:%s} dedup: %v",
+				appid, r.RemoteAddr, realIp, topic, ver, msgId, err)
+		} else {
+			msgId = "" // keep the record, not to release it
+		}
+	}
+
 	w.Header().Set(HttpHeaderPartition, strconv.FormatInt(int64(partition), 10))
 	w.Header().Set(HttpHeaderOffset, strconv.FormatInt(offset, 10))
 	if async {
@@ -233,3 +332,20 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 	}
 
 }
+
+// pubFailover publishes the message to the failover cluster tagged with its origin
+// cluster, which is used by 'gk failover -reconcile' to move it back.
+func (this *pubServer) pubFailover(cluster, failover, rawTopic string, key []byte,
+	headers envelope.Headers, payload []byte) (partition int32, offset int64, err error) {
+	headers = append(headers.Without(envelope.HeaderOrigin), envelope.Header{Key: envelope.HeaderOrigin, Value: cluster})
+	body, err := envelope.Encode(headers, payload)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	partition, offset, err = store.DefaultPubStore.SyncPub(failover, rawTopic, key, body)
+	if err != nil {
+		offset = -1
+	}
+	return
+}
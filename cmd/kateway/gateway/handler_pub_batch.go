@@ -0,0 +1,245 @@
+// +build !fasthttp
+
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/cmd/kateway/hh"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/gafka/mpool"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+//go:generate goannotation $GOFILE
+// @rest POST /v1/msgs/:topic/:ver/batch?hh=n
+// Content-Type: application/x-kateway-batch | application/x-ndjson
+func (this *pubServer) pubBatchHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var (
+		appid      string
+		topic      string
+		ver        string
+		hhDisabled bool // hh enabled by default
+		t1         = time.Now()
+	)
+
+	if !Options.DisableMetrics {
+		this.pubMetrics.PubTryQps.Mark(1)
+	}
+
+	realIp := getHttpRemoteIp(r)
+	if Options.Ratelimit && !this.throttlePub.Pour(realIp, 1) {
+		log.Warn("pub batch[%s] %s(%s) rate limit reached: %d/s", appid, r.RemoteAddr, realIp, Options.PubQpsLimit)
+
+		this.pubMetrics.ClientError.Inc(1)
+		writeQuotaExceeded(w)
+		return
+	}
+
+	appid = r.Header.Get(HttpHeaderAppid)
+	topic = params.ByName(UrlParamTopic)
+	ver = params.ByName(UrlParamVersion)
+
+	if err := manager.Default.OwnTopic(appid, r.Header.Get(HttpHeaderPubkey), topic); err != nil {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if r.ContentLength > Options.MaxPubBatchSize {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} too big content length: %d",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), r.ContentLength)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, ErrTooBigMessage.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 4<<10))
+	if _, err := buf.ReadFrom(io.LimitReader(r.Body, Options.MaxPubBatchSize+1)); err != nil {
+		log.Error("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(buf.Len()) > Options.MaxPubBatchSize {
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, ErrTooBigMessage.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	msgs, values, err := DecodeBatch(r.Header.Get("Content-Type"), buf.Bytes())
+	if err != nil {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// validate each message the same way as single message pub
+	keys := make([][]byte, len(msgs))
+	for i, m := range msgs {
+		var reason string
+		switch {
+		case int64(len(values[i])) > Options.MaxPubSize:
+			reason = ErrTooBigMessage.Error()
+		case len(values[i]) < Options.MinPubSize:
+			reason = ErrTooSmallMessage.Error()
+		case len(m.Key) > MaxPartitionKeyLen:
+			reason = "too big key"
+		case len(m.Tag) > Options.MaxMsgTagLen:
+			reason = "too big tag"
+		}
+
+		if reason != "" {
+			log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} #%d %s",
+				appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), i, reason)
+
+			this.pubMetrics.ClientError.Inc(1)
+			this.respond4XX(appid, w, reason, http.StatusBadRequest)
+			return
+		}
+
+		if m.Key != "" {
+			keys[i] = []byte(m.Key)
+		}
+	}
+
+	// tagged messages are rebuilt with the envelope
+	var tagged []*mpool.Message
+	for i, m := range msgs {
+		var headers envelope.Headers
+		if m.Tag != "" {
+			headers = envelope.Headers{{Key: envelope.HeaderTag, Value: m.Tag}}
+		}
+		if !needsEnvelope(headers, values[i]) {
+			continue
+		}
+
+		envelopeLen := headers.Len()
+		msgSz := envelopeLen + len(values[i])
+		msg := mpool.NewMessage(msgSz)
+		msg.Body = msg.Body[0:msgSz]
+		headers.WriteTo(msg.Body)
+		copy(msg.Body[envelopeLen:], values[i])
+		values[i] = msg.Body
+		tagged = append(tagged, msg)
+	}
+	defer func() {
+		for _, msg := range tagged {
+			msg.Free()
+		}
+	}()
+
+	if !Options.DisableMetrics {
+		this.pubMetrics.PubQps.Mark(int64(len(msgs)))
+		for _, v := range values {
+			this.pubMetrics.PubMsgSize.Update(int64(len(v)))
+		}
+	}
+
+	cluster, secondary, found := this.gw.migrator.PubClusters(appid)
+	if !found {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} cluster not found",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"))
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "invalid appid", http.StatusBadRequest)
+		return
+	}
+
+	rawTopic := manager.Default.KafkaTopic(appid, topic, ver)
+	hhDisabled = r.URL.Query().Get("hh") == "n"
+
+	results, err := store.DefaultPubStore.SyncBatchPub(cluster, rawTopic, keys, values)
+	if err != nil {
+		if store.DefaultPubStore.IsSystemError(err) && !hhDisabled && Options.EnableHintedHandoff {
+			log.Warn("pub batch[%s] %s(%s) {%s.%s.%s UA:%s} resort hh for: %v", appid, r.RemoteAddr, realIp,
+				appid, topic, ver, r.Header.Get("User-Agent"), err)
+
+			// fallback each message to hh
+			results = make([]store.PubResult, len(values))
+			for i := range results {
+				results[i].Err = err
+			}
+		} else {
+			log.Error("pub batch[%s] %s(%s) {topic:%s.%s err:%s} %d messages", appid, r.RemoteAddr, realIp,
+				topic, ver, err, len(msgs))
+
+			if !Options.DisableMetrics {
+				this.pubMetrics.PubFail(appid, topic, ver)
+			}
+
+			if store.DefaultPubStore.IsSystemError(err) {
+				this.pubMetrics.InternalErr.Inc(1)
+				writeServerError(w, err.Error())
+			} else {
+				this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+	}
+
+	if secondary != "" {
+		// dual write during migration, the target cluster is best effort
+		if _, err := store.DefaultPubStore.SyncBatchPub(secondary, rawTopic, keys, values); err != nil {
+			log.Error("pub batch[%s] %s(%s) {%s.%s.%s} dual write %s: %v",
+				appid, r.RemoteAddr, realIp, appid, topic, ver, secondary, err)
+		}
+	}
+
+	out := make([]BatchPubResult, len(results))
+	for i, res := range results {
+		out[i].Partition = res.Partition
+		out[i].Offset = res.Offset
+
+		if res.Err != nil && store.DefaultPubStore.IsSystemError(res.Err) && !hhDisabled && Options.EnableHintedHandoff {
+			if res.Err = hh.Default.Append(cluster, rawTopic, keys[i], values[i]); res.Err == nil {
+				out[i].Offset = -1 // accepted by hh, offset unknown yet
+			}
+		}
+
+		if res.Err != nil {
+			out[i].Err = res.Err.Error()
+			if !Options.DisableMetrics {
+				this.pubMetrics.PubFail(appid, topic, ver)
+			}
+			continue
+		}
+
+		if !Options.DisableMetrics {
+			this.pubMetrics.PubOk(appid, topic, ver)
+		}
+		if Options.AuditPub && out[i].Offset > -1 {
+			this.auditor.Trace("pub batch[%s] %s(%s) {%s.%s.%s UA:%s} {P:%d O:%d}",
+				appid, r.RemoteAddr, realIp, appid, topic, ver, r.Header.Get("User-Agent"), out[i].Partition, out[i].Offset)
+		}
+	}
+
+	b, _ := json.Marshal(out)
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	w.WriteHeader(http.StatusCreated)
+	if _, err = w.Write(b); err != nil {
+		log.Error("%s: %v", r.RemoteAddr, err)
+		this.pubMetrics.ClientError.Inc(1)
+	}
+
+	if !Options.DisableMetrics {
+		this.pubMetrics.PubLatency.Update(time.Since(t1).Nanoseconds() / 1e6) // in ms
+	}
+
+}
@@ -3,10 +3,12 @@ package gateway
 import (
 	"compress/gzip"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/gafka/sla"
@@ -15,7 +17,7 @@ import (
 )
 
 //go:generate goannotation $GOFILE
-// @rest GET /v1/msgs/:appid/:topic/:ver?group=xx&batch=10&mux=1&reset=<newest|oldest>&ack=1&q=<dead|retry>
+// @rest GET /v1/msgs/:appid/:topic/:ver?group=xx&batch=10&mux=1&reset=<newest|oldest|time:<unix|RFC3339>>&ack=1&q=<dead|retry>&scan=1000
 func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		topic      string
@@ -32,7 +34,9 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		offset     string
 		offsetN    int64 = -1
 		limit      int   // max messages to include in the message set
+		scanBudget int   // max messages to scan when tag filter applied
 		delayedAck bool  // last acked partition/offset piggybacked on this request
+		filter     tagFilter
 		err        error
 	)
 
@@ -54,6 +58,13 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
+	if _, _, err = store.ResetOffsetTime(reset); err != nil {
+		log.Error("sub -(%s): illegal reset: %s", realIp, reset)
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, "illegal reset")
+		return
+	}
+
 	if Options.BadGroupRateLimit && !this.badGroupBudget.Pour(realGroup, 0) {
 		this.goodGroupLock.RLock()
 		_, good := this.goodGroupClients[r.RemoteAddr]
@@ -79,6 +90,23 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		limit = Options.MaxSubBatchSize
 	}
 
+	// parse http tag header as filter condition
+	if tagExpr := r.Header.Get(HttpHeaderMsgTag); tagExpr != "" {
+		if filter, err = parseTagFilter(tagExpr); err != nil {
+			log.Error("sub -(%s): illegal tag filter: %s", realIp, tagExpr)
+			this.subMetrics.ClientError.Mark(1)
+			writeBadRequest(w, err.Error())
+			return
+		}
+	}
+
+	if scanBudget, err = subScanBudget(query, filter); err != nil {
+		log.Error("sub -(%s): illegal scan: %s", realIp, query.Get("scan"))
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, err.Error())
+		return
+	}
+
 	ver = params.ByName(UrlParamVersion)
 	topic = params.ByName(UrlParamTopic)
 	hisAppid = params.ByName(UrlParamAppid)
@@ -171,7 +199,7 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		rawTopic = manager.Default.KafkaTopic(hisAppid, topic, ver)
 	}
 
-	cluster, found := manager.Default.LookupCluster(hisAppid)
+	cluster, found := this.gw.migrator.SubCluster(hisAppid, rawTopic, realGroup)
 	if !found {
 		log.Error("sub[%s/%s] %s(%s) {%s.%s.%s UA:%s} cluster not found",
 			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
@@ -210,7 +238,12 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 
 	// commit the acked offset
 	if delayedAck && partitionN >= 0 && offsetN >= 0 {
-		if err = fetcher.CommitUpto(&sarama.ConsumerMessage{
+		if ackCluster := r.Header.Get(HttpHeaderCluster); ackCluster != "" && ackCluster != cluster {
+			// the group moved only after the source cluster was consumed up
+			log.Warn("sub land[%s/%s] %s(%s) {%s/%s ack:1 O:%s UA:%s} acked %s, now on %s",
+				myAppid, group, r.RemoteAddr, realIp, rawTopic, partition, offset, r.Header.Get("User-Agent"),
+				ackCluster, cluster)
+		} else if err = fetcher.CommitUpto(&sarama.ConsumerMessage{
 			Topic:     rawTopic,
 			Partition: int32(partitionN),
 			Offset:    offsetN,
@@ -224,9 +257,12 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		}
 	}
 
+	// the client acks with it, the group might move to another cluster meanwhile
+	w.Header().Set(HttpHeaderCluster, cluster)
+
 	var gz *gzip.Writer
 	w, gz = gzipWriter(w, r)
-	err = this.pumpMessages(w, r, realIp, fetcher, limit, myAppid, hisAppid, topic, ver, group, delayedAck)
+	err = this.pumpMessages(w, r, realIp, fetcher, limit, filter, scanBudget, myAppid, hisAppid, topic, ver, group, delayedAck)
 	if err != nil {
 		// e,g. broken pipe, io timeout, client gone
 		// e,g. kafka: error while consuming app1.foobar.v1/0: EOF (kafka was shutdown)
@@ -277,43 +313,78 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 	}
 }
 
+// subScanBudget returns the max unmatched messages to scan per sub with tag filter,
+// 0 means unlimited. Without tag filter, nothing is skipped and scan param is ignored.
+func subScanBudget(query url.Values, filter tagFilter) (int, error) {
+	if filter == nil {
+		return 0, nil
+	}
+
+	budget, err := getHttpQueryInt(&query, "scan", Options.MaxSubScan)
+	if err != nil || budget < 0 {
+		return 0, ErrIllegalScan
+	}
+
+	if Options.MaxSubScan > 0 && (budget == 0 || budget > Options.MaxSubScan) {
+		budget = Options.MaxSubScan
+	}
+
+	return budget, nil
+}
+
+// reportScan tells client how many messages are scanned and skipped by the tag filter.
+// After the response body is written, they are sent as http trailers if declared by
+// declareScanTrailers before the body.
+func reportScan(w http.ResponseWriter, scanned, skipped int) {
+	w.Header().Set(HttpHeaderScanned, strconv.Itoa(scanned))
+	w.Header().Set(HttpHeaderSkipped, strconv.Itoa(skipped))
+}
+
+// declareScanTrailers must be called before the response body is written.
+func declareScanTrailers(w http.ResponseWriter) {
+	w.Header().Set("Trailer", HttpHeaderScanned+", "+HttpHeaderSkipped)
+}
+
 func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, realIp string,
-	fetcher store.Fetcher, limit int, myAppid, hisAppid, topic, ver, group string, delayedAck bool) error {
+	fetcher store.Fetcher, limit int, filter tagFilter, scanBudget int,
+	myAppid, hisAppid, topic, ver, group string, delayedAck bool) error {
 	cn, ok := w.(http.CloseNotifier)
 	if !ok {
 		return ErrBadResponseWriter
 	}
 
 	var (
-		metaBuf       []byte = nil
-		n                    = 0
-		idleTimeout          = Options.SubTimeout
-		chunkedEver          = false
-		tagConditions        = make(map[string]struct{})
-		clientGoneCh         = cn.CloseNotify()
-		startedAt            = time.Now()
+		metaBuf      []byte = nil
+		n                   = 0
+		scanned             = 0
+		skipped             = 0
+		idleTimeout         = Options.SubTimeout
+		chunkedEver         = false
+		takenOff            = make(map[int32]struct{}) // partitions that has message delivered
+		clientGoneCh        = cn.CloseNotify()
+		startedAt           = time.Now()
 	)
 
-	// parse http tag header as filter condition
-	if tagFilter := r.Header.Get(HttpHeaderMsgTag); tagFilter != "" {
-		for _, t := range parseMessageTag(tagFilter) {
-			if t != "" {
-				tagConditions[t] = struct{}{}
+	if filter != nil {
+		defer func() {
+			if chunkedEver {
+				reportScan(w, scanned, skipped)
 			}
-		}
+		}()
 	}
 
 	for {
-		if len(tagConditions) > 0 && time.Since(startedAt) > idleTimeout {
+		if filter != nil && !chunkedEver && (time.Since(startedAt) > idleTimeout || (scanBudget > 0 && skipped >= scanBudget)) {
 			// e,g. tag filter got 1000 msgs, but no tag hit after timeout, we'll return 204
-			if chunkedEver {
-				return nil
-			}
-
+			// the skipped messages are already committed, so next sub will not be stuck behind them
+			reportScan(w, scanned, skipped)
 			w.WriteHeader(http.StatusNoContent)
 			w.Write([]byte{})
 			return nil
 		}
+		if filter != nil && chunkedEver && time.Since(startedAt) > idleTimeout {
+			return nil
+		}
 
 		select {
 		case <-clientGoneCh:
@@ -339,6 +410,9 @@ func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, real
 				return nil
 			}
 
+			if filter != nil {
+				reportScan(w, scanned, skipped)
+			}
 			w.WriteHeader(http.StatusNoContent)
 			w.Write([]byte{}) // without this, client cant get response
 			return nil
@@ -361,53 +435,65 @@ func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, real
 					myAppid, group, r.RemoteAddr, realIp, msg.Topic, msg.Partition, msg.Offset, delayedAck)
 			}
 
-			partition := strconv.FormatInt(int64(msg.Partition), 10)
+			headers, bodyIdx, err := envelope.Decode(msg.Value)
+			if err != nil {
+				// always move offset cursor ahead, otherwise will be blocked forever
+				fetcher.CommitUpto(msg)
 
-			if limit == 1 {
-				w.Header().Set("Content-Type", "text/plain; charset=utf8") // override middleware header
-				w.Header().Set(HttpHeaderMsgKey, string(msg.Key))
-				w.Header().Set(HttpHeaderPartition, partition)
-				w.Header().Set(HttpHeaderOffset, strconv.FormatInt(msg.Offset, 10))
+				return ErrIllegalTaggedMessage
 			}
 
-			var (
-				tags    []string
-				bodyIdx int
-				err     error
-			)
-			if IsTaggedMessage(msg.Value) {
-				tags, bodyIdx, err = ExtractMessageTag(msg.Value)
-				if err != nil {
-					// always move offset cursor ahead, otherwise will be blocked forever
-					fetcher.CommitUpto(msg)
+			// with delayed ack, we can't commit beyond a taken off but unacked message
+			// of the same partition; before that, skipping is always safe
+			_, taken := takenOff[msg.Partition]
+			canSkip := !delayedAck || !taken
 
-					return err
+			if headers.Expired(time.Now()) {
+				log.Debug("sub discard expired %s(%s) {G:%s, T:%s/%d, O:%d}",
+					r.RemoteAddr, realIp, group, msg.Topic, msg.Partition, msg.Offset)
+
+				if canSkip {
+					fetcher.CommitUpto(msg)
 				}
+				continue
 			}
 
-			// assert tag conditions are satisfied. if empty, feed all messages
-			if len(tagConditions) > 0 {
-				tagSatisfied := false
-				for _, t := range tags {
-					if _, present := tagConditions[t]; present {
-						tagSatisfied = true
-						break
-					}
-				}
+			// assert tag filter is satisfied. if empty, feed all messages
+			if filter != nil {
+				scanned++
+				tags := headers.Tags()
+				if !filter.Match(tags) {
+					skipped++
 
-				if !tagSatisfied {
-					if !delayedAck {
-						log.Debug("sub auto commit offset with tag unmatched %s(%s) {G:%s, T:%s/%d, O:%d} %+v/%+v",
-							r.RemoteAddr, realIp, group, msg.Topic, msg.Partition, msg.Offset, tagConditions, tags)
+					if canSkip {
+						log.Debug("sub auto commit offset with tag unmatched %s(%s) {G:%s, T:%s/%d, O:%d} %+v",
+							r.RemoteAddr, realIp, group, msg.Topic, msg.Partition, msg.Offset, tags)
 
 						fetcher.CommitUpto(msg)
 					}
 
 					continue
 				}
+
+				if limit == 1 {
+					reportScan(w, scanned, skipped)
+				}
 			}
 
+			takenOff[msg.Partition] = struct{}{}
+
 			if limit == 1 {
+				w.Header().Set("Content-Type", "text/plain; charset=utf8") // override middleware header
+				w.Header().Set(HttpHeaderMsgKey, string(msg.Key))
+				w.Header().Set(HttpHeaderPartition, strconv.FormatInt(int64(msg.Partition), 10))
+				w.Header().Set(HttpHeaderOffset, strconv.FormatInt(msg.Offset, 10))
+				if tag := headers.Get(envelope.HeaderTag); tag != "" {
+					w.Header().Set(HttpHeaderMsgTag, tag)
+				}
+				if traceId := headers.Get(envelope.HeaderTraceId); traceId != "" {
+					w.Header().Set(HttpHeaderTraceId, traceId)
+				}
+
 				// non-batch mode, just the message itself without meta
 				if _, err = w.Write(msg.Value[bodyIdx:]); err != nil {
 					// when remote close silently, the write still ok
@@ -422,6 +508,9 @@ func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, real
 
 					// override the middleware added header
 					w.Header().Set("Content-Type", "application/octet-stream")
+					if filter != nil {
+						declareScanTrailers(w)
+					}
 				}
 
 				if err = writeI32(w, metaBuf, msg.Partition); err != nil {
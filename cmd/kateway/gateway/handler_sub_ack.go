@@ -25,6 +25,7 @@ type ackOffsets []ackOffset
 
 //go:generate goannotation $GOFILE
 // @rest PUT /v1/offsets/:appid/:topic/:ver/:group with json body
+// Optional header: X-Cluster of the Sub response the acked messages came from
 func (this *subServer) ackHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		topic    string
@@ -47,9 +48,11 @@ func (this *subServer) ackHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
-	cluster, found := manager.Default.LookupCluster(hisAppid)
+	realGroup := myAppid + "." + group
+	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
+	cluster, found := this.gw.migrator.AckCluster(hisAppid, rawTopic, realGroup, r.Header.Get(HttpHeaderCluster))
 	if !found {
-		writeBadRequest(w, "invalid appid")
+		writeBadRequest(w, "invalid appid or cluster")
 		return
 	}
 
@@ -86,8 +89,6 @@ func (this *subServer) ackHandler(w http.ResponseWriter, r *http.Request, params
 	msg.Free()
 
 	realIp := getHttpRemoteIp(r)
-	realGroup := myAppid + "." + group
-	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
 	for i := 0; i < len(acks); i++ {
 		acks[i].cluster = cluster
 		acks[i].topic = rawTopic
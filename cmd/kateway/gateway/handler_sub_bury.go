@@ -107,15 +107,6 @@ func (this *subServer) buryHandler(w http.ResponseWriter, r *http.Request, param
 		return
 	}
 
-	cluster, found := manager.Default.LookupCluster(hisAppid)
-	if !found {
-		log.Error("bury[%s/%s] %s(%s) {%s.%s.%s UA:%s} invalid appid:%s",
-			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), hisAppid)
-
-		writeBadRequest(w, "invalid appid")
-		return
-	}
-
 	// calculate raw topic according to shadow
 	if shadow != "" {
 		if !sla.ValidateShadowName(shadow) {
@@ -139,6 +130,15 @@ func (this *subServer) buryHandler(w http.ResponseWriter, r *http.Request, param
 		rawTopic = manager.Default.KafkaTopic(hisAppid, topic, ver)
 	}
 
+	cluster, found := this.gw.migrator.AckCluster(hisAppid, rawTopic, myAppid+"."+group, r.Header.Get(HttpHeaderCluster))
+	if !found {
+		log.Error("bury[%s/%s] %s(%s) {%s.%s.%s UA:%s} invalid appid:%s",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), hisAppid)
+
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
 	fetcher, err := store.DefaultSubStore.Fetch(cluster, rawTopic,
 		myAppid+"."+group, r.RemoteAddr, realIp, "", Options.PermitStandbySub, query.Get("mux") == "1")
 	if err != nil {
@@ -12,7 +12,7 @@ import (
 )
 
 //go:generate goannotation $GOFILE
-// @rest GET /v1/raw/msgs/:cluster/:topic?group=xx&batch=10&mux=1&reset=<newest|oldest>
+// @rest GET /v1/raw/msgs/:cluster/:topic?group=xx&batch=10&mux=1&reset=<newest|oldest|time:<unix|RFC3339>>
 func (this *subServer) subRawHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		cluster string
@@ -40,6 +40,13 @@ func (this *subServer) subRawHandler(w http.ResponseWriter, r *http.Request, par
 		return
 	}
 
+	if _, _, err = store.ResetOffsetTime(reset); err != nil {
+		log.Error("sub raw -(%s): illegal reset: %s", realIp, reset)
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, "illegal reset")
+		return
+	}
+
 	limit, err = getHttpQueryInt(&query, "batch", 1)
 	if err != nil {
 		log.Error("sub raw -(%s): illegal batch: %v", realIp, err)
@@ -0,0 +1,319 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	sseHeartbeatInterval = time.Second * 15
+	sseRetry             = 1000 // in ms, EventSource reconnect delay
+)
+
+//go:generate goannotation $GOFILE
+// @rest GET /v1/sse/msgs/:appid/:topic/:ver?group=xx&tag=expr&reset=<newest|oldest|time:<unix|RFC3339>>&mux=1
+// Streams text/event-stream with event id 'partition:offset[,partition:offset...]', the cursor of each partition.
+// Nothing is committed until the client reconnects with Last-Event-ID header, which commits the received events
+// and resumes after them: the delivery is at least once.
+// The tag filter is either X-Tag header or tag param because EventSource can't send custom headers.
+func (this *subServer) subSseHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var (
+		topic     string
+		ver       string
+		myAppid   string
+		hisAppid  string
+		reset     string
+		group     string
+		realGroup string
+		rawTopic  string
+		filter    tagFilter
+		err       error
+	)
+
+	if !Options.DisableMetrics {
+		this.subMetrics.SubTryQps.Mark(1)
+	}
+
+	query := r.URL.Query()
+	group = query.Get("group")
+	myAppid = r.Header.Get(HttpHeaderAppid)
+	realGroup = myAppid + "." + group
+	reset = query.Get("reset")
+	realIp := getHttpRemoteIp(r)
+
+	if !manager.Default.ValidateGroupName(r.Header, group) {
+		log.Error("sse -(%s): illegal group: %s", realIp, group)
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, "illegal group")
+		return
+	}
+
+	if _, _, err = store.ResetOffsetTime(reset); err != nil {
+		log.Error("sse -(%s): illegal reset: %s", realIp, reset)
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, "illegal reset")
+		return
+	}
+
+	tagExpr := r.Header.Get(HttpHeaderMsgTag)
+	if tagExpr == "" {
+		tagExpr = query.Get("tag")
+	}
+	if tagExpr != "" {
+		if filter, err = parseTagFilter(tagExpr); err != nil {
+			log.Error("sse -(%s): illegal tag filter: %s", realIp, tagExpr)
+			this.subMetrics.ClientError.Mark(1)
+			writeBadRequest(w, err.Error())
+			return
+		}
+	}
+
+	// the last event the client received before reconnect
+	lastEventId := r.Header.Get("Last-Event-ID")
+	cursor, err := parseSseEventId(lastEventId)
+	if err != nil {
+		log.Error("sse -(%s): illegal Last-Event-ID: %s", realIp, lastEventId)
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, "illegal Last-Event-ID")
+		return
+	}
+
+	ver = params.ByName(UrlParamVersion)
+	topic = params.ByName(UrlParamTopic)
+	hisAppid = params.ByName(UrlParamAppid)
+
+	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
+		hisAppid, topic, group); err != nil {
+		log.Error("sse[%s/%s] -(%s): {%s.%s.%s UA:%s} %v",
+			myAppid, group, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.subMetrics.ClientError.Mark(1)
+		writeAuthFailure(w, err)
+		return
+	}
+
+	log.Debug("sse[%s/%s] %s(%s) {%s.%s.%s last:%s UA:%s}",
+		myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, lastEventId, r.Header.Get("User-Agent"))
+
+	if !Options.DisableMetrics {
+		this.subMetrics.SubQps.Mark(1)
+	}
+
+	rawTopic = manager.Default.KafkaTopic(hisAppid, topic, ver)
+	cluster, found := this.gw.migrator.SubCluster(hisAppid, rawTopic, realGroup)
+	if !found {
+		log.Error("sse[%s/%s] %s(%s) {%s.%s.%s UA:%s} cluster not found",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
+
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	fetcher, err := store.DefaultSubStore.Fetch(cluster, rawTopic,
+		realGroup, r.RemoteAddr, realIp, reset, Options.PermitStandbySub, query.Get("mux") == "1")
+	if err != nil {
+		log.Error("sse[%s/%s] -(%s): {%s.%s.%s UA:%s} %v",
+			myAppid, group, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
+
+		if store.DefaultSubStore.IsSystemError(err) {
+			this.subMetrics.ServerError.Mark(1)
+			this.subMetrics.InternalErr.Inc(1)
+			writeServerError(w, err.Error())
+		} else {
+			this.subMetrics.ClientError.Mark(1)
+			writeBadRequest(w, err.Error())
+		}
+		return
+	}
+
+	// the events up to Last-Event-ID were received, move the group cursor ahead of them
+	for partitionN, offsetN := range cursor {
+		if err = fetcher.CommitUpto(&sarama.ConsumerMessage{
+			Topic:     rawTopic,
+			Partition: partitionN,
+			Offset:    offsetN,
+		}); err != nil {
+			// during rebalance, this might happen, but with no bad effects
+			log.Trace("sse land[%s/%s] %s(%s) {%s/%d O:%d} %v",
+				myAppid, group, r.RemoteAddr, realIp, rawTopic, partitionN, offsetN, err)
+		}
+	}
+
+	if err = this.pumpSseEvents(w, r, fetcher, filter, cursor); err != nil && err != ErrClientGone {
+		log.Error("sse[%s/%s] %s(%s) {%s UA:%s} %v",
+			myAppid, group, r.RemoteAddr, realIp, rawTopic, r.Header.Get("User-Agent"), err)
+
+		if store.DefaultSubStore.IsSystemError(err) {
+			this.subMetrics.ServerError.Mark(1)
+			this.subMetrics.InternalErr.Inc(1)
+		}
+	}
+
+	if err = fetcher.Close(); err != nil {
+		log.Error("sse[%s/%s] %s(%s) %s %v", myAppid, group, r.RemoteAddr, realIp, rawTopic, err)
+	}
+}
+
+// pumpSseEvents streams messages until client gone or the stream lasts longer than
+// http write timeout, after which EventSource reconnects with Last-Event-ID.
+// No offset is committed here: the events might be buffered and lost when the connection
+// drops, they are committed only when confirmed by Last-Event-ID on reconnect.
+func (this *subServer) pumpSseEvents(w http.ResponseWriter, r *http.Request,
+	fetcher store.Fetcher, filter tagFilter, cursor sseCursor) error {
+	cn, ok := w.(http.CloseNotifier)
+	if !ok {
+		return ErrBadResponseWriter
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return ErrBadResponseWriter
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream") // override middleware header
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx proxy buffering
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(w, "retry: "+strconv.Itoa(sseRetry)+"\n\n"); err != nil {
+		return err
+	}
+	flusher.Flush()
+
+	// end the stream before the http server write timeout kills it
+	streamTimeout := Options.HttpWriteTimeout - sseHeartbeatInterval
+	if streamTimeout < sseHeartbeatInterval {
+		streamTimeout = sseHeartbeatInterval
+	}
+
+	var (
+		clientGoneCh = cn.CloseNotify()
+		deadline     = this.timer.After(streamTimeout)
+	)
+	for {
+		select {
+		case <-clientGoneCh:
+			return ErrClientGone
+
+		case <-this.gw.shutdownCh:
+			return nil
+
+		case <-deadline:
+			return nil
+
+		case <-this.timer.After(sseHeartbeatInterval):
+			// comment line keeps the proxies from closing the idle stream
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return err
+			}
+			flusher.Flush()
+
+		case err := <-fetcher.Errors():
+			return err
+
+		case msg, ok := <-fetcher.Messages():
+			if !ok {
+				return ErrClientKilled
+			}
+
+			if offset, present := cursor[msg.Partition]; present && msg.Offset <= offset {
+				// received by client before reconnect
+				continue
+			}
+
+			// the skipped messages are confirmed along with the next event
+			cursor[msg.Partition] = msg.Offset
+
+			headers, bodyIdx, err := envelope.Decode(msg.Value)
+			if err != nil {
+				// skip the corrupted message, otherwise will be blocked forever
+				log.Error("sse %s: %s/%d %d %v", r.RemoteAddr, msg.Topic, msg.Partition, msg.Offset, err)
+				continue
+			}
+
+			if headers.Expired(time.Now()) || (filter != nil && !filter.Match(headers.Tags())) {
+				continue
+			}
+
+			if err = writeSseEvent(w, cursor.String(), msg.Value[bodyIdx:]); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// sseCursor is the offset of the last message streamed of each partition, used as event id.
+type sseCursor map[int32]int64
+
+// String returns the 'partition:offset[,partition:offset...]' event id ordered by partition.
+func (c sseCursor) String() string {
+	partitions := make([]int, 0, len(c))
+	for p := range c {
+		partitions = append(partitions, int(p))
+	}
+	sort.Ints(partitions)
+
+	tuples := make([]string, 0, len(partitions))
+	for _, p := range partitions {
+		tuples = append(tuples, strconv.Itoa(p)+":"+strconv.FormatInt(c[int32(p)], 10))
+	}
+	return strings.Join(tuples, ",")
+}
+
+// parseSseEventId parses the 'partition:offset[,partition:offset...]' event id, the cursor
+// is empty if id is empty.
+func parseSseEventId(id string) (sseCursor, error) {
+	cursor := make(sseCursor)
+	if id == "" {
+		return cursor, nil
+	}
+
+	for _, t := range strings.Split(id, ",") {
+		tuple := strings.SplitN(t, ":", 2)
+		if len(tuple) != 2 {
+			return nil, ErrInvalidEventId
+		}
+
+		p, err := strconv.ParseInt(tuple[0], 10, 32)
+		if err != nil || p < 0 {
+			return nil, ErrInvalidEventId
+		}
+		offset, err := strconv.ParseInt(tuple[1], 10, 64)
+		if err != nil || offset < 0 {
+			return nil, ErrInvalidEventId
+		}
+
+		cursor[int32(p)] = offset
+	}
+
+	return cursor, nil
+}
+
+// writeSseEvent writes an event, each line of data is written as a data field.
+func writeSseEvent(w io.Writer, id string, data []byte) error {
+	var buf bytes.Buffer
+	buf.WriteString("id: ")
+	buf.WriteString(id)
+	buf.WriteByte('\n')
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte{'\r'}))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
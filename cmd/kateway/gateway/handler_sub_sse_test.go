@@ -0,0 +1,39 @@
+package gateway
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestParseSseEventId(t *testing.T) {
+	c, err := parseSseEventId("")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(c))
+
+	c, err = parseSseEventId(sseCursor{3: 1024}.String())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, sseCursor{3: 1024}, c)
+
+	c = sseCursor{3: 1024, 0: 5, 12: 0}
+	assert.Equal(t, "0:5,3:1024,12:0", c.String())
+	c1, err := parseSseEventId(c.String())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, c, c1)
+
+	for _, id := range []string{"3", "a:1", "3:b", "-1:5", "3:-5", ":", "0:5,", "0:5,3"} {
+		_, err = parseSseEventId(id)
+		assert.Equal(t, ErrInvalidEventId, err)
+	}
+}
+
+func TestWriteSseEvent(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Equal(t, nil, writeSseEvent(&buf, "0:12", []byte("hello")))
+	assert.Equal(t, "id: 0:12\ndata: hello\n\n", buf.String())
+
+	buf.Reset()
+	assert.Equal(t, nil, writeSseEvent(&buf, "1:5", []byte("a\r\nb\n")))
+	assert.Equal(t, "id: 1:5\ndata: a\ndata: b\ndata: \n\n", buf.String())
+}
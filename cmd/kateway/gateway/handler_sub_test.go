@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
+	"github.com/funkygao/go-metrics"
+	"github.com/funkygao/golib/timewheel"
+)
+
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (this closeNotifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+type chanFetcher struct {
+	ch        chan *sarama.ConsumerMessage
+	committed []int64
+}
+
+func (this *chanFetcher) Messages() <-chan *sarama.ConsumerMessage { return this.ch }
+func (this *chanFetcher) Errors() <-chan *sarama.ConsumerError     { return nil }
+func (this *chanFetcher) Close() error                             { return nil }
+
+func (this *chanFetcher) CommitUpto(msg *sarama.ConsumerMessage) error {
+	this.committed = append(this.committed, msg.Offset)
+	return nil
+}
+
+func newTestSubServer() *subServer {
+	return &subServer{
+		webServer: &webServer{gw: &Gateway{shutdownCh: make(chan struct{})}},
+		timer:     timewheel.NewTimeWheel(time.Second, 120),
+		subMetrics: &subMetrics{
+			ConsumeMap:  make(map[string]metrics.Counter),
+			ConsumedMap: make(map[string]metrics.Counter),
+		},
+	}
+}
+
+func taggedMessage(t *testing.T, offset int64, tag, body string) *sarama.ConsumerMessage {
+	headers, err := pubMessageHeaders(tag, "", "", time.Now())
+	assert.Equal(t, nil, err)
+	value, err := envelope.Encode(headers, []byte(body))
+	assert.Equal(t, nil, err)
+	return &sarama.ConsumerMessage{Topic: "app1.foobar.v1", Offset: offset, Value: value}
+}
+
+func TestSubScanBudget(t *testing.T) {
+	defer func(max int) { Options.MaxSubScan = max }(Options.MaxSubScan)
+	filter, _ := parseTagFilter("city=bj")
+
+	// scan is ignored without tag filter
+	Options.MaxSubScan = 0
+	budget, err := subScanBudget(url.Values{"scan": {"xx"}}, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, budget)
+
+	budget, err = subScanBudget(url.Values{}, filter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, budget) // unlimited
+	budget, err = subScanBudget(url.Values{"scan": {"50000"}}, filter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 50000, budget)
+
+	Options.MaxSubScan = 10000
+	budget, err = subScanBudget(url.Values{}, filter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 10000, budget)
+	budget, err = subScanBudget(url.Values{"scan": {"50000"}}, filter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 10000, budget)
+	budget, err = subScanBudget(url.Values{"scan": {"100"}}, filter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 100, budget)
+
+	_, err = subScanBudget(url.Values{"scan": {"-1"}}, filter)
+	assert.Equal(t, ErrIllegalScan, err)
+	_, err = subScanBudget(url.Values{"scan": {"xx"}}, filter)
+	assert.Equal(t, ErrIllegalScan, err)
+}
+
+func TestPumpMessagesScanBudgetExhausted(t *testing.T) {
+	defer func(timeout time.Duration) { Options.SubTimeout = timeout }(Options.SubTimeout)
+	Options.SubTimeout = time.Minute
+
+	fetcher := &chanFetcher{ch: make(chan *sarama.ConsumerMessage, 10)}
+	for i := int64(0); i < 5; i++ {
+		fetcher.ch <- taggedMessage(t, i, "city=sh", "hello")
+	}
+
+	filter, _ := parseTagFilter("city=bj")
+	r, _ := http.NewRequest("GET", "/v1/msgs/app1/foobar/v1", nil)
+	w := closeNotifyRecorder{httptest.NewRecorder()}
+	err := newTestSubServer().pumpMessages(w, r, "127.0.0.1", fetcher, 1, filter, 3,
+		"app2", "app1", "foobar", "v1", "group1", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "3", w.Header().Get(HttpHeaderScanned))
+	assert.Equal(t, "3", w.Header().Get(HttpHeaderSkipped))
+	assert.Equal(t, []int64{0, 1, 2}, fetcher.committed)
+}
+
+func TestPumpMessagesUnlimitedScan(t *testing.T) {
+	defer func(timeout time.Duration) { Options.SubTimeout = timeout }(Options.SubTimeout)
+	Options.SubTimeout = time.Minute
+
+	fetcher := &chanFetcher{ch: make(chan *sarama.ConsumerMessage, 10)}
+	for i := int64(0); i < 5; i++ {
+		fetcher.ch <- taggedMessage(t, i, "city=sh", "hello")
+	}
+	fetcher.ch <- taggedMessage(t, 5, "city=bj", "hello bj")
+
+	filter, _ := parseTagFilter("city=bj")
+	r, _ := http.NewRequest("GET", "/v1/msgs/app1/foobar/v1", nil)
+	w := closeNotifyRecorder{httptest.NewRecorder()}
+	err := newTestSubServer().pumpMessages(w, r, "127.0.0.1", fetcher, 1, filter, 0,
+		"app2", "app1", "foobar", "v1", "group1", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello bj", w.Body.String())
+	assert.Equal(t, "6", w.Header().Get(HttpHeaderScanned))
+	assert.Equal(t, "5", w.Header().Get(HttpHeaderSkipped))
+	assert.Equal(t, "5", w.Header().Get(HttpHeaderOffset))
+}
+
+func TestPumpMessagesWithoutFilter(t *testing.T) {
+	defer func(timeout time.Duration) { Options.SubTimeout = timeout }(Options.SubTimeout)
+	Options.SubTimeout = time.Minute
+
+	fetcher := &chanFetcher{ch: make(chan *sarama.ConsumerMessage, 10)}
+	fetcher.ch <- taggedMessage(t, 0, "", "hello")
+
+	r, _ := http.NewRequest("GET", "/v1/msgs/app1/foobar/v1", nil)
+	w := closeNotifyRecorder{httptest.NewRecorder()}
+	err := newTestSubServer().pumpMessages(w, r, "127.0.0.1", fetcher, 1, nil, 0,
+		"app2", "app1", "foobar", "v1", "group1", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Equal(t, "", w.Header().Get(HttpHeaderScanned))
+}
+
+func TestPubSubEnvelopeLookalike(t *testing.T) {
+	defer func(timeout time.Duration) { Options.SubTimeout = timeout }(Options.SubTimeout)
+	Options.SubTimeout = time.Minute
+
+	// untagged bodies that happen to look enveloped
+	for _, body := range []string{"\x00KW\x01\x00hello", "\x01a=b\x02hello"} {
+		msg, envelopeLen, err := readEnvelopedMessage(strings.NewReader(body), nil, len(body))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, envelope.Headers(nil).Len(), envelopeLen)
+
+		fetcher := &chanFetcher{ch: make(chan *sarama.ConsumerMessage, 1)}
+		fetcher.ch <- &sarama.ConsumerMessage{Topic: "app1.foobar.v1", Value: msg.Body}
+
+		r, _ := http.NewRequest("GET", "/v1/msgs/app1/foobar/v1", nil)
+		w := closeNotifyRecorder{httptest.NewRecorder()}
+		err = newTestSubServer().pumpMessages(w, r, "127.0.0.1", fetcher, 1, nil, 0,
+			"app2", "app1", "foobar", "v1", "group1", false)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, body, w.Body.String())
+	}
+
+	// plain body goes out as is
+	msg, envelopeLen, err := readEnvelopedMessage(strings.NewReader("hello"), nil, 5)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, envelopeLen)
+	assert.Equal(t, "hello", string(msg.Body))
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/httprouter"
@@ -49,6 +50,10 @@ func (this *subServer) subWsHandler(w http.ResponseWriter, r *http.Request, para
 			r.RemoteAddr, topic, ver, group, limit)
 		return
 	}
+	if _, _, err = store.ResetOffsetTime(resetOffset); err != nil {
+		writeWsError(ws, "illegal reset")
+		return
+	}
 
 	ver = params.ByName(UrlParamVersion)
 	topic = params.ByName(UrlParamTopic)
@@ -67,7 +72,7 @@ func (this *subServer) subWsHandler(w http.ResponseWriter, r *http.Request, para
 	log.Debug("sub[%s] %s: %+v", myAppid, r.RemoteAddr, params)
 
 	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
-	cluster, found := manager.Default.LookupCluster(hisAppid)
+	cluster, found := this.gw.migrator.SubCluster(hisAppid, rawTopic, myAppid+"."+group)
 	if !found {
 		log.Error("cluster not found for subd app: %s", hisAppid)
 
@@ -138,7 +143,15 @@ func (this *subServer) wsWritePump(clientGone chan struct{}, ws *websocket.Conn,
 			ws.SetWriteDeadline(time.Now().Add(time.Second * 10))
 			// FIXME because of buffer, client recv 10, but kateway written 100, then
 			// client quit...
-			if err = ws.WriteMessage(websocket.BinaryMessage, msg.Value); err != nil {
+			body, e := envelope.Body(msg.Value)
+			if e != nil {
+				// skip the corrupted message, otherwise will be blocked forever
+				log.Error("%s: %s/%d %d %v", ws.RemoteAddr(), msg.Topic, msg.Partition, msg.Offset, e)
+				fetcher.CommitUpto(msg)
+				continue
+			}
+
+			if err = ws.WriteMessage(websocket.BinaryMessage, body); err != nil {
 				log.Error("%s: %v", ws.RemoteAddr(), err)
 				return
 			}
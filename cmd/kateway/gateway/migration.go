@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/meta"
+	gzk "github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+	zklib "github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	migrationRefreshInterval = time.Second * 5
+	drainCheckInterval       = time.Second * 10
+)
+
+// migrator routes Pub/Sub of the apps being migrated across clusters.
+//
+// The migration state machine is driven by 'gk appmigrate' and stored in zk:
+//   - dualwrite: Pub writes both clusters, Sub stays on the source cluster
+//   - cutover: Pub writes the target cluster, each consumer group drains the source
+//     cluster then moves to the target with offsets of the cutover time
+//   - done: Pub/Sub are on the target cluster until manager maps the app to it
+type migrator struct {
+	gw *Gateway
+
+	mu         sync.RWMutex
+	migrations map[string]*gzk.MigrationMeta // appid:migration
+	drained    map[string]bool               // appid:topic:group:drained
+	checked    map[string]time.Time          // appid:topic:group:last drain check
+}
+
+func newMigrator(gw *Gateway) *migrator {
+	return &migrator{
+		gw:         gw,
+		migrations: make(map[string]*gzk.MigrationMeta),
+		drained:    make(map[string]bool),
+		checked:    make(map[string]time.Time),
+	}
+}
+
+func (this *migrator) Start() {
+	ticker := time.NewTicker(migrationRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.gw.shutdownCh:
+			return
+
+		case <-ticker.C:
+			this.refresh()
+		}
+	}
+}
+
+func (this *migrator) refresh() {
+	migrations := this.gw.zkzone.Migrations()
+
+	this.mu.Lock()
+	for appid, m := range migrations {
+		if old, present := this.migrations[appid]; !present || old.State != m.State {
+			log.Info("migration[%s] %s -> %s %s", appid, m.Source, m.Target, m.State)
+		}
+	}
+	for appid := range this.migrations {
+		if _, present := migrations[appid]; !present {
+			log.Info("migration[%s] finished", appid)
+		}
+	}
+	this.migrations = migrations
+
+	// a rolled back migration might restart
+	for key := range this.drained {
+		if _, present := migrations[key[:strings.IndexByte(key, ':')]]; !present {
+			delete(this.drained, key)
+			delete(this.checked, key)
+		}
+	}
+	this.mu.Unlock()
+}
+
+func (this *migrator) migration(appid string) *gzk.MigrationMeta {
+	this.mu.RLock()
+	m := this.migrations[appid]
+	this.mu.RUnlock()
+	return m
+}
+
+// PubClusters returns the cluster whose offset is replied to the Pub client and
+// the optional cluster the message is duplicated to.
+func (this *migrator) PubClusters(appid string) (primary, secondary string, found bool) {
+	m := this.migration(appid)
+	if m == nil {
+		primary, found = manager.Default.LookupCluster(appid)
+		return
+	}
+
+	if m.State == gzk.MigrationDualWrite {
+		return m.Source, m.Target, true
+	}
+
+	return m.Target, "", true
+}
+
+// SubCluster returns the cluster a consumer group of a topic consumes from.
+func (this *migrator) SubCluster(appid, rawTopic, group string) (cluster string, found bool) {
+	m := this.migration(appid)
+	if m == nil {
+		return manager.Default.LookupCluster(appid)
+	}
+
+	switch m.State {
+	case gzk.MigrationDualWrite:
+		return m.Source, true
+
+	case gzk.MigrationCutover:
+		if this.sourceDrained(m, rawTopic, group) {
+			return m.Target, true
+		}
+		return m.Source, true
+
+	default:
+		return m.Target, true
+	}
+}
+
+// AckCluster returns the cluster an ack of a consumer group commits to.
+// fetchedFrom is the cluster of the acked messages echoed back by the client, the
+// group might have moved to the target cluster since then.
+func (this *migrator) AckCluster(appid, rawTopic, group, fetchedFrom string) (cluster string, found bool) {
+	if fetchedFrom == "" {
+		// legacy client
+		return this.SubCluster(appid, rawTopic, group)
+	}
+
+	m := this.migration(appid)
+	if m == nil {
+		cluster, found = manager.Default.LookupCluster(appid)
+		return cluster, found && cluster == fetchedFrom
+	}
+
+	return fetchedFrom, fetchedFrom == m.Source || fetchedFrom == m.Target
+}
+
+// sourceDrained checks whether a consumer group has consumed up the topic on the
+// source cluster, and if so the group is moved to the target cluster.
+func (this *migrator) sourceDrained(m *gzk.MigrationMeta, rawTopic, group string) bool {
+	key := m.Appid + ":" + rawTopic + ":" + group
+
+	this.mu.RLock()
+	drained, lastCheck := this.drained[key], this.checked[key]
+	this.mu.RUnlock()
+	if drained || time.Since(lastCheck) < drainCheckInterval {
+		return drained
+	}
+
+	this.mu.Lock()
+	this.checked[key] = time.Now()
+	this.mu.Unlock()
+
+	drained, err := this.gw.zkzone.MigrationDrained(m.Appid, rawTopic, group)
+	if err != nil {
+		log.Error("migration[%s] %s/%s: %v", m.Appid, rawTopic, group, err)
+		return false
+	}
+
+	if !drained {
+		if drained = this.drain(m, rawTopic, group); !drained {
+			return false
+		}
+	}
+
+	this.mu.Lock()
+	this.drained[key] = true
+	this.mu.Unlock()
+	return true
+}
+
+func (this *migrator) drain(m *gzk.MigrationMeta, rawTopic, group string) bool {
+	lag, err := meta.Default.ZkCluster(m.Source).ConsumerGroupLag(rawTopic, group)
+	if err != nil {
+		log.Error("migration[%s] %s/%s lag: %v", m.Appid, rawTopic, group, err)
+		return false
+	}
+	if lag > 0 {
+		log.Trace("migration[%s] %s/%s draining %s, lag:%d", m.Appid, rawTopic, group, m.Source, lag)
+		return false
+	}
+
+	// only the lock winner translates the offsets
+	if err = this.gw.zkzone.LockMigrationDrain(m.Appid, rawTopic, group); err != nil {
+		if err != zklib.ErrNodeExists {
+			log.Error("migration[%s] %s/%s lock: %v", m.Appid, rawTopic, group, err)
+		}
+		return false
+	}
+	defer this.gw.zkzone.UnlockMigrationDrain(m.Appid, rawTopic, group)
+
+	// the previous lock winner might have moved the group
+	drained, err := this.gw.zkzone.MigrationDrained(m.Appid, rawTopic, group)
+	if err != nil {
+		log.Error("migration[%s] %s/%s: %v", m.Appid, rawTopic, group, err)
+		return false
+	} else if drained {
+		return true
+	}
+
+	// messages before cutover are already consumed from the source cluster
+	offsets, err := meta.Default.ZkCluster(m.Target).ResetConsumerGroupOffsetsOfTime(rawTopic, group, m.CutoverTime())
+	if err != nil {
+		log.Error("migration[%s] %s/%s reset %s to %s: %v", m.Appid, rawTopic, group,
+			m.Target, m.CutoverTime(), err)
+		return false
+	}
+
+	// mark after the offsets are ready, the group then consumes from the target cluster
+	if err = this.gw.zkzone.MarkMigrationDrained(m.Appid, rawTopic, group); err != nil && err != zklib.ErrNodeExists {
+		log.Error("migration[%s] %s/%s mark drained: %v", m.Appid, rawTopic, group, err)
+		return false
+	}
+
+	log.Info("migration[%s] %s/%s drained %s, moved to %s %+v", m.Appid, rawTopic, group,
+		m.Source, m.Target, offsets)
+	return true
+}
@@ -37,6 +37,8 @@ var (
 		KillFile                   string
 		HintedHandoffType          string
 		HintedHandoffDir           string
+		DedupStore                 string
+		DedupRedisAddr             string
 		AllwaysHintedHandoff       bool
 		ShowVersion                bool
 		Ratelimit                  bool
@@ -63,12 +65,15 @@ var (
 		HttpHeaderMaxBytes         int
 		MaxPubSize                 int64
 		MaxJobSize                 int64
+		MaxPubBatchSize            int64
 		LogRotateSize              int
 		MaxMsgTagLen               int
 		MinPubSize                 int
 		PubQpsLimit                int64
 		MaxSubBatchSize            int
+		MaxSubScan                 int
 		MaxClients                 int
+		DedupCapacity              int
 		MaxRequestPerConn          int // to make load balancer distribute request even for persistent conn
 		PubPoolCapcity             int
 		AssignJobShardId           int // how to assign shard id for new app
@@ -83,6 +88,7 @@ var (
 		HttpReadTimeout            time.Duration
 		HttpWriteTimeout           time.Duration
 		MaxWaitBeforeForceClose    time.Duration
+		DedupWindow                time.Duration
 	}
 )
 
@@ -127,6 +133,10 @@ func ParseFlags() {
 	flag.StringVar(&Options.HintedHandoffType, "hhtype", "disk", "underlying hinted handoff")
 	flag.StringVar(&Options.HintedHandoffDir, "hhdirs", "hhdata", "hinted handoff dirs separated by comma")
 	flag.BoolVar(&Options.FlushHintedOffOnly, "hhflush", false, "flush hinted handoff and exit")
+	flag.StringVar(&Options.DedupStore, "dedup", "", "Pub dedup index store <mem|redis>, empty to disable X-Message-Id dedup")
+	flag.StringVar(&Options.DedupRedisAddr, "dedupredis", "127.0.0.1:6379", "redis addr of Pub dedup index store")
+	flag.IntVar(&Options.DedupCapacity, "dedupsz", 100000, "max message ids per topic of mem Pub dedup index")
+	flag.DurationVar(&Options.DedupWindow, "dedupwindow", time.Minute*5, "Pub dedup window of the same message id")
 	flag.StringVar(&Options.JobStore, "jstore", "mysql", "job underlying store")
 	flag.StringVar(&Options.DummyCluster, "dummycluster", "me", "dummy store's cluster name")
 	flag.StringVar(&Options.ManagerStore, "mstore", "mysql", "store integration with manager")
@@ -159,12 +169,14 @@ func ParseFlags() {
 	flag.IntVar(&Options.HttpHeaderMaxBytes, "maxheader", 4<<10, "http header max size in bytes")
 	flag.Int64Var(&Options.MaxPubSize, "maxpub", 512<<10, "max Pub message size")
 	flag.Int64Var(&Options.MaxJobSize, "maxjob", 16<<10, "max Pub job size")
+	flag.Int64Var(&Options.MaxPubBatchSize, "maxpubbatch", 8<<20, "max batch Pub body size")
 	flag.IntVar(&Options.MinPubSize, "minpub", 1, "min Pub message size")
 	flag.IntVar(&Options.MaxRequestPerConn, "maxreq", -1, "max request per connection")
 	flag.IntVar(&Options.AssignJobShardId, "shardid", 1, "how to assign shard id for new app")
 	flag.IntVar(&Options.MaxMsgTagLen, "tagsz", 1024, "max message tag length permitted")
 	// kafka Fetch maxFetchSize=1MB, so if our msg agv size is 250B, batch size can be 4000
 	flag.IntVar(&Options.MaxSubBatchSize, "maxbatch", 4000, "max sub batch size")
+	flag.IntVar(&Options.MaxSubScan, "maxscan", 10000, "max unmatched messages scanned per sub with tag filter, 0 means unlimited")
 	flag.IntVar(&Options.LogRotateSize, "logsize", 10<<30, "max unrotated log file size")
 	flag.Int64Var(&Options.PubQpsLimit, "publimit", 60*10000, "pub qps limit per minute per ip")
 	flag.IntVar(&Options.PubPoolCapcity, "pubpool", 100, "pub connection pool capacity")
@@ -51,6 +51,8 @@ func (this *Gateway) buildRouting() {
 			m(this.manServer.subRawHandler))
 		this.manServer.Router().GET("/v1/peek/:appid/:topic/:ver",
 			m(this.manServer.peekHandler))
+		this.manServer.Router().GET("/v1/search/:appid/:topic/:ver",
+			m(this.manServer.searchHandler))
 		this.manServer.Router().POST("/v1/shadow/:appid/:topic/:ver/:group",
 			m(this.manServer.addTopicShadowHandler))
 		this.manServer.Router().GET("/v1/subd/:topic/:ver",
@@ -63,6 +65,8 @@ func (this *Gateway) buildRouting() {
 			m(this.manServer.delSubGroupHandler))
 		this.manServer.Router().PUT("/v1/offset/:appid/:topic/:ver/:group/:partition",
 			m(this.manServer.resetSubOffsetHandler))
+		this.manServer.Router().PUT("/v1/offsets/:appid/:topic/:ver/:group",
+			m(this.manServer.resetSubOffsetsOfTimeHandler))
 	}
 
 	if this.pubServer != nil {
@@ -74,9 +78,12 @@ func (this *Gateway) buildRouting() {
 
 		this.pubServer.Router().POST("/v1/raw/msgs/:cluster/:topic", m(this.pubServer.pubRawHandler))
 		this.pubServer.Router().POST("/v1/msgs/:topic/:ver", m(this.pubServer.pubHandler))
+		this.pubServer.Router().POST("/v1/msgs/:topic/:ver/batch", m(this.pubServer.pubBatchHandler))
 		this.pubServer.Router().POST("/v1/ws/msgs/:topic/:ver", m(this.pubServer.pubWsHandler))
 		this.pubServer.Router().POST("/v1/jobs/:topic/:ver", m(this.pubServer.addJobHandler))
 		this.pubServer.Router().DELETE("/v1/jobs/:topic/:ver", m(this.pubServer.deleteJobHandler))
+		this.pubServer.Router().GET("/v1/jobs/:topic/:ver", m(this.pubServer.listJobsHandler))
+		this.pubServer.Router().PUT("/v1/jobs/:topic/:ver", m(this.pubServer.updateJobHandler))
 
 		// pubServer acts as a XA compliant RM(resource manager)
 		this.pubServer.Router().POST("/v1/xa/prepare/:topic/:ver", m(this.pubServer.xa_prepare))
@@ -98,6 +105,7 @@ func (this *Gateway) buildRouting() {
 		this.subServer.Router().GET("/v1/msgs/:appid/:topic/:ver", m(this.subServer.subHandler))
 		this.subServer.Router().PUT("/v1/msgs/:appid/:topic/:ver", m(this.subServer.buryHandler))
 		this.subServer.Router().GET("/v1/ws/msgs/:appid/:topic/:ver", m(this.subServer.subWsHandler))
+		this.subServer.Router().GET("/v1/sse/msgs/:appid/:topic/:ver", m(this.subServer.subSseHandler))
 		this.subServer.Router().PUT("/v1/offsets/:appid/:topic/:ver/:group", m(this.subServer.ackHandler))
 		this.subServer.Router().PUT("/v1/raw/offsets/:cluster/:topic/:group", m(this.subServer.ackRawHandler))
 
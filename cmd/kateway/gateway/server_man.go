@@ -12,6 +12,7 @@ type manServer struct {
 
 	throttleAddTopic  *ratelimiter.LeakyBuckets
 	throttleSubStatus *ratelimiter.LeakyBuckets
+	throttleSearch    *ratelimiter.LeakyBuckets
 }
 
 func newManServer(httpAddr, httpsAddr string, maxClients int, gw *Gateway) *manServer {
@@ -19,6 +20,7 @@ func newManServer(httpAddr, httpsAddr string, maxClients int, gw *Gateway) *manS
 		webServer:         newWebServer("man_server", httpAddr, httpsAddr, maxClients, time.Minute, gw),
 		throttleAddTopic:  ratelimiter.NewLeakyBuckets(60, time.Minute),
 		throttleSubStatus: ratelimiter.NewLeakyBuckets(60, time.Minute),
+		throttleSearch:    ratelimiter.NewLeakyBuckets(10, time.Minute),
 	}
 
 	return this
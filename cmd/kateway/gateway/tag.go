@@ -1,57 +1,110 @@
 package gateway
 
 import (
-	"bytes"
-	"strings"
+	"io"
+	"strconv"
+	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 	"github.com/funkygao/gafka/mpool"
 )
 
 const (
-	TagMarkStart = byte(1) // FIXME conflicts with ProtocolBuffer
-	TagMarkEnd   = byte(2)
-	TagSeperator = ";" // follow cookie rules a=b;c=d
+	TagSeperator = envelope.TagSeperator // follow cookie rules a=b;c=d
 )
 
+// IsTaggedMessage checks whether the message is wrapped in envelope.
+// Messages tagged with the legacy 0x01 tag 0x02 format are also recognized.
 func IsTaggedMessage(msg []byte) bool {
-	return msg[0] == TagMarkStart
+	return envelope.IsEnveloped(msg)
 }
 
-// ┌────────────────────────────┐ ┌────────┐
-// │TagMarkStart Tag TagMarkEnd │ │Message │
-// └────────────────────────────┘ └────────┘
+// ┌──────────────────────┐ ┌────────┐
+// │Envelope with tag hdr │ │Message │
+// └──────────────────────┘ └────────┘
+// AddTagToMessage shifts the body and prepends the tag envelope,
+// m.Body must be sized with tagLen(tag) extra bytes.
 func AddTagToMessage(m *mpool.Message, tag string) {
-	shift := tagLen(tag)
+	addEnvelopeToMessage(m, envelope.Headers{{Key: envelope.HeaderTag, Value: tag}})
+}
+
+func addEnvelopeToMessage(m *mpool.Message, h envelope.Headers) {
+	shift := h.Len()
 	for i := len(m.Body) - 1; i >= shift; i-- {
 		m.Body[i] = m.Body[i-shift]
 	}
 
-	i := 0
-	m.Body[i] = TagMarkStart
-	i++
-	for _, b := range tag {
-		m.Body[i] = byte(b)
-		i++
+	h.WriteTo(m.Body)
+}
+
+// needsEnvelope checks whether body must be wrapped in the envelope of h: an untagged body
+// that happens to look enveloped is wrapped in an empty envelope, otherwise sub would strip
+// its front as envelope.
+func needsEnvelope(h envelope.Headers, body []byte) bool {
+	return len(h) > 0 || envelope.IsEnveloped(body)
+}
+
+// readEnvelopedMessage reads the msgLen bytes body from r into a message behind the envelope
+// of h, which is written in front of the body without memory move if needed.
+// It returns the message and its envelope length.
+func readEnvelopedMessage(r io.Reader, h envelope.Headers, msgLen int) (*mpool.Message, int, error) {
+	envelopeLen := h.Len()
+	msg := mpool.NewMessage(envelopeLen + msgLen)
+	msg.Body = msg.Body[0 : envelopeLen+msgLen]
+	if _, err := io.ReadAtLeast(r, msg.Body[envelopeLen:], msgLen); err != nil {
+		msg.Free()
+		return nil, 0, err
+	}
+
+	if !needsEnvelope(h, msg.Body[envelopeLen:]) {
+		msg.Body = msg.Body[envelopeLen:]
+		return msg, 0, nil
 	}
-	m.Body[i] = TagMarkEnd
+
+	h.WriteTo(msg.Body)
+	return msg, envelopeLen, nil
 }
 
 func ExtractMessageTag(msg []byte) ([]string, int, error) {
-	tagEnd := bytes.IndexByte(msg, TagMarkEnd)
-	if tagEnd == -1 {
-		// not a tagged message
+	h, bodyIdx, err := envelope.Decode(msg)
+	if err != nil {
 		return nil, 0, ErrIllegalTaggedMessage
 	}
 
-	tag := string(msg[1:tagEnd]) // discard the tag mark start
-	tags := parseMessageTag(tag)
-	return tags, tagEnd + 1, nil
+	return h.Tags(), bodyIdx, nil
+}
+
+// pubMessageHeaders builds the envelope headers from the pub http request headers.
+func pubMessageHeaders(tag, traceId, ttl string, now time.Time) (envelope.Headers, error) {
+	var h envelope.Headers
+	if tag != "" {
+		h = append(h, envelope.Header{Key: envelope.HeaderTag, Value: tag})
+	}
+	if traceId != "" {
+		h = append(h, envelope.Header{Key: envelope.HeaderTraceId, Value: traceId})
+	}
+	if ttl != "" {
+		seconds, err := strconv.ParseInt(ttl, 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, ErrIllegalTTL
+		}
+
+		// stored as absolute expiration time so that hh/mirror delay doesn't extend it
+		h = append(h, envelope.Header{Key: envelope.HeaderTTL,
+			Value: strconv.FormatInt(now.Unix()+seconds, 10)})
+	}
+
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
 }
 
 func tagLen(tag string) int {
-	return 2 + len(tag) // TagMarkStart tag TagMarkEnd
+	return envelope.Headers{{Key: envelope.HeaderTag, Value: tag}}.Len()
 }
 
 func parseMessageTag(tag string) []string {
-	return strings.Split(strings.TrimSuffix(tag, TagSeperator), TagSeperator)
+	return envelope.ParseTag(tag)
 }
@@ -0,0 +1,227 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrIllegalTagFilter = errors.New("illegal tag filter expression")
+
+// tagFilter is a boolean expression evaluated against the tags of a message.
+//
+// Grammar:
+//
+//     expr   := and { ("||" | ";") and }
+//     and    := unary { "&&" unary }
+//     unary  := "!" unary | "(" expr ")" | term
+//     term   := key | key "=" value
+//
+// A bare key matches tag 'key' and any 'key=xxx', while 'key=value'
+// matches exactly. ';' is kept as OR for the legacy 'a;b' tag filter.
+type tagFilter interface {
+	Match(tags []string) bool
+}
+
+type tagTerm struct {
+	key, value string
+	kv         bool
+}
+
+func (this tagTerm) Match(tags []string) bool {
+	for _, t := range tags {
+		if this.kv {
+			if t == this.key+"="+this.value {
+				return true
+			}
+
+			continue
+		}
+
+		if t == this.key || strings.HasPrefix(t, this.key+"=") {
+			return true
+		}
+	}
+
+	return false
+}
+
+type tagNot struct {
+	f tagFilter
+}
+
+func (this tagNot) Match(tags []string) bool {
+	return !this.f.Match(tags)
+}
+
+type tagAnd []tagFilter
+
+func (this tagAnd) Match(tags []string) bool {
+	for _, f := range this {
+		if !f.Match(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+type tagOr []tagFilter
+
+func (this tagOr) Match(tags []string) bool {
+	for _, f := range this {
+		if f.Match(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTagFilter compiles the X-Tag sub header into a tagFilter.
+func parseTagFilter(expr string) (tagFilter, error) {
+	p := &tagFilterParser{tokens: tokenizeTagFilter(expr)}
+	if len(p.tokens) == 0 {
+		return nil, ErrIllegalTagFilter
+	}
+
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, ErrIllegalTagFilter
+	}
+
+	return f, nil
+}
+
+func tokenizeTagFilter(expr string) []string {
+	var (
+		tokens []string
+		term   []byte
+	)
+	flush := func() {
+		if t := strings.TrimSpace(string(term)); t != "" {
+			tokens = append(tokens, t)
+		}
+		term = term[:0]
+	}
+
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; c {
+		case '(', ')', '!', ';':
+			flush()
+			tokens = append(tokens, string(c))
+
+		case '&', '|':
+			flush()
+			if i+1 < len(expr) && expr[i+1] == c {
+				i++
+			}
+			tokens = append(tokens, string([]byte{c, c}))
+
+		case ' ', '\t':
+			flush()
+
+		default:
+			term = append(term, c)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+type tagFilterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (this *tagFilterParser) peek() string {
+	if this.pos < len(this.tokens) {
+		return this.tokens[this.pos]
+	}
+	return ""
+}
+
+func (this *tagFilterParser) parseOr() (tagFilter, error) {
+	var or tagOr
+	for {
+		f, err := this.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, f)
+
+		if t := this.peek(); t != "||" && t != ";" {
+			break
+		}
+		this.pos++
+
+		if this.peek() == "" {
+			// tolerate the trailing ';' of legacy filter 'a;b;'
+			break
+		}
+	}
+
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (this *tagFilterParser) parseAnd() (tagFilter, error) {
+	var and tagAnd
+	for {
+		f, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, f)
+
+		if this.peek() != "&&" {
+			break
+		}
+		this.pos++
+	}
+
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (this *tagFilterParser) parseUnary() (tagFilter, error) {
+	switch t := this.peek(); t {
+	case "!":
+		this.pos++
+		f, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{f}, nil
+
+	case "(":
+		this.pos++
+		f, err := this.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if this.peek() != ")" {
+			return nil, ErrIllegalTagFilter
+		}
+		this.pos++
+		return f, nil
+
+	case "", ")", "&&", "||", ";":
+		return nil, ErrIllegalTagFilter
+
+	default:
+		this.pos++
+		if idx := strings.IndexByte(t, '='); idx != -1 {
+			if idx == 0 {
+				return nil, ErrIllegalTagFilter
+			}
+			return tagTerm{key: t[:idx], value: t[idx+1:], kv: true}, nil
+		}
+		return tagTerm{key: t}, nil
+	}
+}
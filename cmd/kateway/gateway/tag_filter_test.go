@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestParseTagFilterLegacy(t *testing.T) {
+	f, err := parseTagFilter("a;y_;")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, f.Match([]string{"y_"}))
+	assert.Equal(t, true, f.Match([]string{"x", "a"}))
+	assert.Equal(t, false, f.Match([]string{"b"}))
+	assert.Equal(t, false, f.Match(nil))
+}
+
+func TestParseTagFilterExpression(t *testing.T) {
+	f, err := parseTagFilter("(city=bj || city=sh) && !vip")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, f.Match([]string{"city=bj", "level=1"}))
+	assert.Equal(t, true, f.Match([]string{"city=sh"}))
+	assert.Equal(t, false, f.Match([]string{"city=gz"}))
+	assert.Equal(t, false, f.Match([]string{"city=bj", "vip"}))
+	assert.Equal(t, false, f.Match([]string{"city=bj", "vip=1"}))
+
+	// bare key matches key=value
+	f, err = parseTagFilter("city")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, f.Match([]string{"city=bj"}))
+	assert.Equal(t, false, f.Match([]string{"cityx=bj"}))
+
+	// NOT on untagged message
+	f, err = parseTagFilter("!debug")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, f.Match(nil))
+}
+
+func TestParseTagFilterIllegal(t *testing.T) {
+	for _, expr := range []string{"", "(a", "a &&", "a b", "=b", "a || )", "!"} {
+		_, err := parseTagFilter(expr)
+		assert.Equal(t, ErrIllegalTagFilter, err)
+	}
+}
@@ -3,8 +3,10 @@ package gateway
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/envelope"
 	"github.com/funkygao/gafka/mpool"
 )
 
@@ -20,7 +22,7 @@ func TestAddAndExtractMessageTag(t *testing.T) {
 	t.Logf("%s  %+v %d/%d", string(m.Body), m.Body, len(body), len(m.Body))
 	AddTagToMessage(m, tag)
 	t.Logf("%s  %+v %d", string(m.Body), m.Body, len(m.Body))
-	assert.Equal(t, TagMarkStart, m.Body[0])
+	assert.Equal(t, true, IsTaggedMessage(m.Body))
 	t.Logf("%s", string(m.Body))
 
 	// extract tag
@@ -31,6 +33,40 @@ func TestAddAndExtractMessageTag(t *testing.T) {
 	t.Logf("%+v", tags)
 }
 
+func TestExtractLegacyMessageTag(t *testing.T) {
+	msg := append([]byte{1}, []byte("a=b;c=d\x02hello world")...)
+	assert.Equal(t, true, IsTaggedMessage(msg))
+	tags, i, err := ExtractMessageTag(msg)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello world", string(msg[i:]))
+	assert.Equal(t, []string{"a=b", "c=d"}, tags)
+
+	// binary message that happens to start with 0x01 is not misparsed
+	msg = []byte{1, 0x8a, 0x00, 2, 0xff}
+	assert.Equal(t, false, IsTaggedMessage(msg))
+	_, i, err = ExtractMessageTag(msg)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, i)
+}
+
+func TestPubMessageHeaders(t *testing.T) {
+	now := time.Unix(1000, 0)
+	h, err := pubMessageHeaders("a=b", "trace1", "10", now)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(h))
+	assert.Equal(t, "1010", h.Get(envelope.HeaderTTL))
+	assert.Equal(t, false, h.Expired(now.Add(time.Second*10)))
+	assert.Equal(t, true, h.Expired(now.Add(time.Second*11)))
+
+	h, err = pubMessageHeaders("", "", "", now)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(h))
+	assert.Equal(t, false, h.Expired(now))
+
+	_, err = pubMessageHeaders("", "", "-1", now)
+	assert.Equal(t, ErrIllegalTTL, err)
+}
+
 func TestParseMessageTag(t *testing.T) {
 	tags := parseMessageTag("a;y_;")
 	assert.Equal(t, 2, len(tags))
@@ -0,0 +1,53 @@
+package job
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Cursor is the position of a job in (due time, job id) order, used to page through the
+// pending jobs. The zero Cursor starts from the beginning.
+type Cursor struct {
+	DueTime int64
+	JobId   int64
+}
+
+// CursorOf returns the cursor right after the job.
+func CursorOf(item JobItem) Cursor {
+	return Cursor{DueTime: item.DueTime, JobId: item.JobId}
+}
+
+func (this Cursor) IsZero() bool {
+	return this.DueTime == 0 && this.JobId == 0
+}
+
+// String encodes the cursor as 'due-jobId', empty for the zero Cursor.
+func (this Cursor) String() string {
+	if this.IsZero() {
+		return ""
+	}
+
+	return fmt.Sprintf("%d-%d", this.DueTime, this.JobId)
+}
+
+// ParseCursor decodes a cursor encoded by Cursor.String.
+func ParseCursor(s string) (c Cursor, err error) {
+	if s == "" {
+		return
+	}
+
+	tuples := strings.SplitN(s, "-", 2)
+	if len(tuples) != 2 {
+		return c, ErrIllegalCursor
+	}
+
+	if c.DueTime, err = strconv.ParseInt(tuples[0], 10, 64); err != nil || c.DueTime < 0 {
+		return Cursor{}, ErrIllegalCursor
+	}
+	if c.JobId, err = strconv.ParseInt(tuples[1], 10, 64); err != nil || c.JobId < 0 {
+		return Cursor{}, ErrIllegalCursor
+	}
+
+	return
+}
@@ -0,0 +1,31 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestCursor(t *testing.T) {
+	var c Cursor
+	assert.Equal(t, true, c.IsZero())
+	assert.Equal(t, "", c.String())
+
+	c = CursorOf(JobItem{JobId: 341647700585877504, DueTime: 1471565204})
+	assert.Equal(t, "1471565204-341647700585877504", c.String())
+
+	parsed, err := ParseCursor(c.String())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, c, parsed)
+
+	parsed, err = ParseCursor("")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, parsed.IsZero())
+}
+
+func TestParseCursorIllegal(t *testing.T) {
+	for _, s := range []string{"1471565204", "a-1", "1-b", "-1-2", "1-", "1471565204-341647700585877504-1"} {
+		_, err := ParseCursor(s)
+		assert.Equal(t, ErrIllegalCursor, err)
+	}
+}
@@ -14,10 +14,46 @@ func (this *dummy) Add(appid, topic string, payload []byte, due int64) (jobId st
 	return
 }
 
+func (this *dummy) AddRecurring(appid, topic string, payload []byte, schedule string) (jobId string, due int64, err error) {
+	return
+}
+
+func (this *dummy) ListRecurring(appid, topic string) (jobs []job.JobItem, err error) {
+	return
+}
+
+func (this *dummy) Pause(appid, topic, jobId string, paused bool) (err error) {
+	return
+}
+
 func (this *dummy) Delete(appid, topic, jobId string) (err error) {
 	return
 }
 
+func (this *dummy) Get(appid, topic, jobId string) (info job.JobInfo, err error) {
+	return
+}
+
+func (this *dummy) List(appid, topic string, from, to int64, cursor job.Cursor, limit int) (jobs []job.JobItem, next job.Cursor, err error) {
+	return
+}
+
+func (this *dummy) Reschedule(appid, topic, jobId string, due int64) (err error) {
+	return
+}
+
+func (this *dummy) UpdatePayload(appid, topic, jobId string, payload []byte) (err error) {
+	return
+}
+
+func (this *dummy) ShiftRange(appid, topic string, from, to, delta int64, cursor job.Cursor, limit int) (n int64, next job.Cursor, err error) {
+	return
+}
+
+func (this *dummy) CancelRange(appid, topic string, from, to int64, cursor job.Cursor, limit int) (n int64, next job.Cursor, err error) {
+	return
+}
+
 func (this *dummy) CreateJobQueue(shardId int, appid, topic string) (err error) {
 	return
 }
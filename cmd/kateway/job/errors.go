@@ -4,4 +4,6 @@ import "errors"
 
 var (
 	ErrNothingDeleted = errors.New("nothing deleted")
+	ErrJobNotFound    = errors.New("job not found")
+	ErrIllegalCursor  = errors.New("illegal cursor")
 )
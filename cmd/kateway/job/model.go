@@ -10,12 +10,24 @@ type JobItem struct {
 	Payload []byte
 	Ctime   int64
 	DueTime int64
+
+	// Schedule is the recurrence spec of a recurring job, empty for one-shot job.
+	Schedule string
+	Paused   bool
 }
 
 func (this JobItem) String() string {
+	if this.Schedule != "" {
+		return fmt.Sprintf("{%d:%d@%s %s}", this.JobId, this.DueTime, this.Schedule, string(this.Payload))
+	}
 	return fmt.Sprintf("{%d:%d %s}", this.JobId, this.DueTime, string(this.Payload))
 }
 
+// Recurring returns whether this job fires repeatedly.
+func (this JobItem) Recurring() bool {
+	return this.Schedule != ""
+}
+
 func (this JobItem) PayloadString(limit int) string {
 	if limit > 0 && len(this.Payload) > limit {
 		return string(this.Payload[:limit+1])
@@ -23,3 +35,21 @@ func (this JobItem) PayloadString(limit int) string {
 
 	return string(this.Payload)
 }
+
+// JobState is the state of a job: a pending job stays in the job table while fired and
+// cancelled jobs are archived in the history table.
+type JobState string
+
+const (
+	JobPending   JobState = "pending"
+	JobFired     JobState = "fired"
+	JobCancelled JobState = "cancelled"
+)
+
+// JobInfo is a job with its state.
+type JobInfo struct {
+	JobItem
+
+	State JobState
+	Etime int64 // when fired or cancelled, 0 for pending job
+}
@@ -0,0 +1,23 @@
+-- Migrates the job queues created before recurring job, run on each AppShard database.
+-- Each SELECT prints the ALTER TABLE statements of the tables not migrated yet, run them after review.
+
+-- job table: schedule and paused columns
+SELECT CONCAT('ALTER TABLE ', t.TABLE_NAME,
+    ' ADD COLUMN schedule varchar(128) NOT NULL DEFAULT '''', ADD COLUMN paused tinyint NOT NULL DEFAULT 0;')
+FROM information_schema.TABLES t
+WHERE t.TABLE_SCHEMA = DATABASE()
+    AND t.TABLE_NAME LIKE 'job\_%'
+    AND t.TABLE_NAME NOT LIKE '%\_archive'
+    AND NOT EXISTS (
+        SELECT 1 FROM information_schema.COLUMNS c
+        WHERE c.TABLE_SCHEMA = t.TABLE_SCHEMA AND c.TABLE_NAME = t.TABLE_NAME AND c.COLUMN_NAME = 'schedule'
+    );
+
+-- history table: each execution of a recurring job is archived, keyed by (job_id, due_time)
+SELECT CONCAT('ALTER TABLE ', k.TABLE_NAME, ' DROP PRIMARY KEY, ADD PRIMARY KEY (job_id, due_time);')
+FROM information_schema.KEY_COLUMN_USAGE k
+WHERE k.TABLE_SCHEMA = DATABASE()
+    AND k.TABLE_NAME LIKE 'job\_%\_archive'
+    AND k.CONSTRAINT_NAME = 'PRIMARY'
+GROUP BY k.TABLE_NAME
+HAVING COUNT(*) = 1;
@@ -3,6 +3,7 @@ package mysql
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/funkygao/fae/config"
@@ -17,6 +18,10 @@ const (
 	AppPool        = "AppShard"
 
 	sqlInsertAppLookup = "INSERT IGNORE INTO AppLookup(entityId, shardId, name, ctime) VALUES(?,?,?,?)"
+
+	// ActorCancelled is the actor_id of jobs archived by client cancellation, the jobs
+	// fired by actord are archived with the actor id instead.
+	ActorCancelled = "_cancel"
 )
 
 type mysqlStore struct {
@@ -56,6 +61,7 @@ func (this *mysqlStore) CreateJobQueue(shardId int, appid, topic string) (err er
 	}
 
 	// create the job table and job histrory table
+	// each execution of a recurring job is archived, thus history table is keyed by (job_id, due_time)
 	// in mysql InnoDB, blob is []byte while text is string, both length limit 1<<16(64KB)
 	sql := fmt.Sprintf(`
 CREATE TABLE %s (
@@ -64,6 +70,8 @@ CREATE TABLE %s (
     ctime int NOT NULL DEFAULT 0,
     mtime int NOT NULL DEFAULT 0,
     due_time int NOT NULL,
+    schedule varchar(128) NOT NULL DEFAULT '',
+    paused tinyint NOT NULL DEFAULT 0,
     PRIMARY KEY (job_id),
     KEY(due_time)
 ) ENGINE = INNODB DEFAULT CHARSET utf8
@@ -82,7 +90,7 @@ CREATE TABLE %s (
     due_time int NOT NULL,
     etime int NOT NULL DEFAULT 0,
     actor_id char(64) NOT NULL,
-    PRIMARY KEY (job_id),
+    PRIMARY KEY (job_id, due_time),
     KEY(due_time)
 ) ENGINE = INNODB DEFAULT CHARSET utf8
 		`, historyTable)
@@ -100,6 +108,104 @@ func (this *mysqlStore) Add(appid, topic string, payload []byte, due int64) (job
 	return
 }
 
+func (this *mysqlStore) AddRecurring(appid, topic string, payload []byte, schedule string) (jobId string, due int64, err error) {
+	var sched job.Schedule
+	if sched, err = job.ParseSchedule(schedule); err != nil {
+		return
+	}
+
+	now := time.Now()
+	next := sched.Next(now)
+	if next.IsZero() {
+		err = job.ErrIllegalSchedule
+		return
+	}
+
+	due = next.Unix()
+	jid := this.nextId()
+	table, aid := JobTable(topic), App_id(appid)
+	sql := fmt.Sprintf("INSERT INTO %s(job_id, payload, ctime, due_time, schedule) VALUES(?,?,?,?,?)", table)
+	_, _, err = this.mc.Exec(AppPool, table, aid, sql,
+		jid, payload, now.Unix(), due, schedule)
+	jobId = strconv.FormatInt(jid, 10)
+	return
+}
+
+func (this *mysqlStore) ListRecurring(appid, topic string) (jobs []job.JobItem, err error) {
+	table, aid := JobTable(topic), App_id(appid)
+	sql := fmt.Sprintf("SELECT job_id,payload,ctime,due_time,schedule,paused FROM %s WHERE schedule<>''", table)
+	rows, err := this.mc.Query(AppPool, table, aid, sql)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item job.JobItem
+		if err = rows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.DueTime, &item.Schedule, &item.Paused); err != nil {
+			return
+		}
+
+		jobs = append(jobs, item)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (this *mysqlStore) Pause(appid, topic, jobId string, paused bool) (err error) {
+	var jid int64
+	jid, err = strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	var affectedRows int64
+	table, aid := JobTable(topic), App_id(appid)
+	if paused {
+		sql := fmt.Sprintf("UPDATE %s SET paused=1,mtime=? WHERE job_id=? AND schedule<>''", table)
+		affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, time.Now().Unix(), jid)
+	} else {
+		// resume from now on: the activations missed during pause are skipped
+		var sched job.Schedule
+		if sched, err = this.recurringSchedule(table, aid, jid); err != nil {
+			return
+		}
+
+		now := time.Now()
+		sql := fmt.Sprintf("UPDATE %s SET paused=0,mtime=?,due_time=? WHERE job_id=? AND schedule<>''", table)
+		affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, now.Unix(), sched.Next(now).Unix(), jid)
+	}
+	if err == nil && affectedRows == 0 {
+		err = job.ErrJobNotFound
+	}
+
+	return
+}
+
+func (this *mysqlStore) recurringSchedule(table string, aid int, jid int64) (job.Schedule, error) {
+	sql := fmt.Sprintf("SELECT schedule FROM %s WHERE job_id=? AND schedule<>''", table)
+	rows, err := this.mc.Query(AppPool, table, aid, sql, jid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, job.ErrJobNotFound
+	}
+
+	var schedule string
+	if err = rows.Scan(&schedule); err != nil {
+		return nil, err
+	}
+
+	return job.ParseSchedule(schedule)
+}
+
 func (this *mysqlStore) Delete(appid, topic, jobId string) (err error) {
 	var jid int64
 	jid, err = strconv.ParseInt(jobId, 10, 64)
@@ -107,12 +213,255 @@ func (this *mysqlStore) Delete(appid, topic, jobId string) (err error) {
 		return
 	}
 
+	// a recurring job might move on to its next activation by actord between pendingJob
+	// and cancel, retry with the activation still pending
+	var (
+		item             job.JobItem
+		found, cancelled bool
+	)
+	table, aid := JobTable(topic), App_id(appid)
+	for {
+		if item, found, err = this.pendingJob(table, aid, jid); err != nil {
+			return
+		}
+		if !found {
+			return job.ErrNothingDeleted
+		}
+
+		if cancelled, err = this.cancel(topic, aid, item); err != nil || cancelled {
+			return
+		}
+	}
+}
+
+// cancel removes a pending job and archives it as cancelled.
+// It returns false if the job is fired, rescheduled or cancelled meanwhile.
+func (this *mysqlStore) cancel(topic string, aid int, item job.JobItem) (cancelled bool, err error) {
+	table, historyTable := JobTable(topic), HistoryTable(topic)
+	sql := fmt.Sprintf("DELETE FROM %s WHERE job_id=? AND due_time=?", table)
+	affectedRows, _, err := this.mc.Exec(AppPool, table, aid, sql, item.JobId, item.DueTime)
+	if err != nil || affectedRows == 0 {
+		return false, err
+	}
+
+	// actord might race to archive the same activation after its liveJobs check
+	sql = fmt.Sprintf("INSERT IGNORE INTO %s(job_id,payload,ctime,due_time,etime,actor_id) VALUES(?,?,?,?,?,?)", historyTable)
+	_, _, err = this.mc.Exec(AppPool, historyTable, aid, sql,
+		item.JobId, item.Payload, item.Ctime, item.DueTime, time.Now().Unix(), ActorCancelled)
+	return true, err
+}
+
+// mustExist returns ErrJobNotFound if the job is not pending in the job table.
+func (this *mysqlStore) mustExist(table string, aid int, jid int64) error {
+	_, found, err := this.pendingJob(table, aid, jid)
+	if err == nil && !found {
+		err = job.ErrJobNotFound
+	}
+	return err
+}
+
+func (this *mysqlStore) pendingJob(table string, aid int, jid int64) (item job.JobItem, found bool, err error) {
+	sql := fmt.Sprintf("SELECT job_id,payload,ctime,due_time,schedule,paused FROM %s WHERE job_id=?", table)
+	rows, err := this.mc.Query(AppPool, table, aid, sql, jid)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		err = rows.Err()
+		return
+	}
+
+	if err = rows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.DueTime, &item.Schedule, &item.Paused); err != nil {
+		return
+	}
+
+	found = true
+	return
+}
+
+func (this *mysqlStore) Get(appid, topic, jobId string) (info job.JobInfo, err error) {
+	var jid int64
+	jid, err = strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	table, aid := JobTable(topic), App_id(appid)
+	var found bool
+	if info.JobItem, found, err = this.pendingJob(table, aid, jid); err != nil {
+		return
+	}
+	if found {
+		info.State = job.JobPending
+		return
+	}
+
+	// each activation of a recurring job is archived, the latest one tells the state
+	historyTable := HistoryTable(topic)
+	sql := fmt.Sprintf("SELECT job_id,payload,ctime,due_time,etime,actor_id FROM %s WHERE job_id=? ORDER BY due_time DESC LIMIT 1", historyTable)
+	rows, err := this.mc.Query(AppPool, historyTable, aid, sql, jid)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = job.ErrJobNotFound
+		}
+		return
+	}
+
+	var actorId string
+	if err = rows.Scan(&info.JobId, &info.Payload, &info.Ctime, &info.DueTime, &info.Etime, &actorId); err != nil {
+		return
+	}
+
+	if actorId == ActorCancelled {
+		info.State = job.JobCancelled
+	} else {
+		info.State = job.JobFired
+	}
+	return
+}
+
+func (this *mysqlStore) List(appid, topic string, from, to int64, cursor job.Cursor, limit int) (jobs []job.JobItem, next job.Cursor, err error) {
+	table, aid := JobTable(topic), App_id(appid)
+	sql := fmt.Sprintf("SELECT job_id,payload,ctime,due_time,schedule,paused FROM %s WHERE due_time>=? AND due_time<=? AND (due_time>? OR (due_time=? AND job_id>?)) ORDER BY due_time,job_id LIMIT %d",
+		table, limit)
+	rows, err := this.mc.Query(AppPool, table, aid, sql,
+		from, to, cursor.DueTime, cursor.DueTime, cursor.JobId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item job.JobItem
+		if err = rows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.DueTime, &item.Schedule, &item.Paused); err != nil {
+			return
+		}
+
+		jobs = append(jobs, item)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	if len(jobs) == limit {
+		next = job.CursorOf(jobs[len(jobs)-1])
+	}
+	return
+}
+
+func (this *mysqlStore) Reschedule(appid, topic, jobId string, due int64) (err error) {
+	var jid int64
+	jid, err = strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
 	var affectedRows int64
 	table, aid := JobTable(topic), App_id(appid)
-	sql := fmt.Sprintf("DELETE FROM %s WHERE job_id=?", table)
-	affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, jid)
+	sql := fmt.Sprintf("UPDATE %s SET due_time=?,mtime=? WHERE job_id=?", table)
+	affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, due, time.Now().Unix(), jid)
 	if err == nil && affectedRows == 0 {
-		err = job.ErrNothingDeleted
+		// nothing changed if the job is already as is
+		err = this.mustExist(table, aid, jid)
+	}
+
+	return
+}
+
+func (this *mysqlStore) UpdatePayload(appid, topic, jobId string, payload []byte) (err error) {
+	var jid int64
+	jid, err = strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	var affectedRows int64
+	table, aid := JobTable(topic), App_id(appid)
+	sql := fmt.Sprintf("UPDATE %s SET payload=?,mtime=? WHERE job_id=?", table)
+	affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, payload, time.Now().Unix(), jid)
+	if err == nil && affectedRows == 0 {
+		// nothing changed if the job is already as is
+		err = this.mustExist(table, aid, jid)
+	}
+
+	return
+}
+
+// ShiftRange moves at most limit jobs per call. Jobs are visited against the shift
+// direction so that a moved job never falls into the pages ahead of the cursor.
+func (this *mysqlStore) ShiftRange(appid, topic string, from, to, delta int64, cursor job.Cursor, limit int) (n int64, next job.Cursor, err error) {
+	table, aid := JobTable(topic), App_id(appid)
+	order, cmp := "", ">"
+	if delta > 0 {
+		order, cmp = " DESC", "<"
+		if cursor.IsZero() {
+			cursor.DueTime = to + 1
+		}
+	}
+	sql := fmt.Sprintf("SELECT job_id,due_time FROM %s WHERE due_time>=? AND due_time<=? AND (due_time%s? OR (due_time=? AND job_id%s?)) ORDER BY due_time%s,job_id%s LIMIT %d",
+		table, cmp, cmp, order, order, limit)
+	rows, err := this.mc.Query(AppPool, table, aid, sql,
+		from, to, cursor.DueTime, cursor.DueTime, cursor.JobId)
+	if err != nil {
+		return
+	}
+
+	var jobs []job.JobItem
+	for rows.Next() {
+		var item job.JobItem
+		if err = rows.Scan(&item.JobId, &item.DueTime); err != nil {
+			rows.Close()
+			return
+		}
+
+		jobs = append(jobs, item)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil || len(jobs) == 0 {
+		return
+	}
+
+	args := []interface{}{delta, time.Now().Unix(), from, to}
+	for _, item := range jobs {
+		args = append(args, item.JobId)
+	}
+	sql = fmt.Sprintf("UPDATE %s SET due_time=due_time+?,mtime=? WHERE due_time>=? AND due_time<=? AND job_id IN (?%s)",
+		table, strings.Repeat(",?", len(jobs)-1))
+	if n, _, err = this.mc.Exec(AppPool, table, aid, sql, args...); err != nil {
+		return
+	}
+
+	if len(jobs) == limit {
+		next = job.CursorOf(jobs[len(jobs)-1])
+	}
+	return
+}
+
+// CancelRange cancels at most limit jobs per call, each job is archived as cancelled only
+// if it is not fired meanwhile.
+func (this *mysqlStore) CancelRange(appid, topic string, from, to int64, cursor job.Cursor, limit int) (n int64, next job.Cursor, err error) {
+	var jobs []job.JobItem
+	if jobs, next, err = this.List(appid, topic, from, to, cursor, limit); err != nil {
+		return
+	}
+
+	aid := App_id(appid)
+	for _, item := range jobs {
+		var cancelled bool
+		if cancelled, err = this.cancel(topic, aid, item); err != nil {
+			return
+		}
+		if cancelled {
+			n++
+		}
 	}
 
 	return
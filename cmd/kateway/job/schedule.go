@@ -0,0 +1,197 @@
+package job
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrIllegalSchedule = errors.New("illegal job schedule")
+
+const (
+	// MinEvery is the minimum interval of a fixed interval recurring job.
+	MinEvery = time.Second
+
+	scheduleEveryPrefix = "@every "
+)
+
+// Schedule is the recurrence of a recurring job.
+type Schedule interface {
+	// Next returns the next activation time later than t.
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses a schedule spec, which is either a fixed interval
+// '@every 30s', a predefined '@hourly|@daily|@weekly|@monthly|@yearly' or a
+// standard 5 fields cron expression 'minute hour dom month dow'.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, scheduleEveryPrefix) {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len(scheduleEveryPrefix):]))
+		if err != nil || d < MinEvery {
+			return nil, ErrIllegalSchedule
+		}
+
+		return everySchedule(d - d%time.Second), nil
+	}
+
+	switch spec {
+	case "@yearly", "@annually":
+		spec = "0 0 1 1 *"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@hourly":
+		spec = "0 * * * *"
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, ErrIllegalSchedule
+	}
+
+	var (
+		s   cronSchedule
+		err error
+	)
+	for i, b := range cronBounds {
+		if s[i], err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return nil, err
+		}
+	}
+	if s[4]&(1<<7) != 0 {
+		// 7 is also sunday
+		s[4] |= 1
+	}
+	return s, nil
+}
+
+type everySchedule time.Duration
+
+func (this everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(time.Second).Add(time.Duration(this))
+}
+
+// cronSchedule is the bitset of minute, hour, dom, month and dow.
+type cronSchedule [5]uint64
+
+var cronBounds = [5]struct{ min, max int }{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week
+}
+
+func (this cronSchedule) Next(t time.Time) time.Time {
+	// step by time.Date in t's location: Truncate works in UTC and misaligns zones with
+	// a non whole hour offset, e,g. +05:30
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	deadline := t.AddDate(5, 0, 0) // e,g. '0 0 30 2 *' never fires
+
+	for t.Before(deadline) {
+		if this[3]&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !this.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if this[1]&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if this[0]&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches follows the cron convention: if both dom and dow are restricted,
+// either of them matches.
+func (this cronSchedule) dayMatches(t time.Time) bool {
+	domStar := this[2] == cronFieldAll(1, 31)
+	dowStar := this[4]&cronFieldAll(0, 6) == cronFieldAll(0, 6)
+	domOk := this[2]&(1<<uint(t.Day())) != 0
+	dowOk := this[4]&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case domStar && dowStar:
+		return true
+	case domStar:
+		return dowOk
+	case dowStar:
+		return domOk
+	default:
+		return domOk || dowOk
+	}
+}
+
+func cronFieldAll(min, max int) uint64 {
+	var bits uint64
+	for i := min; i <= max; i++ {
+		bits |= 1 << uint(i)
+	}
+	return bits
+}
+
+// parseCronField parses a comma separated list of '*', 'a', 'a-b' with optional '/step'.
+func parseCronField(field string, min, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		var (
+			lo, hi = min, max
+			step   = 1
+		)
+
+		if idx := strings.IndexByte(part, '/'); idx != -1 {
+			if step, err = strconv.Atoi(part[idx+1:]); err != nil || step < 1 {
+				return 0, ErrIllegalSchedule
+			}
+			part = part[:idx]
+		}
+
+		switch {
+		case part == "*":
+
+		case strings.IndexByte(part, '-') != -1:
+			p := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(p[0]); err != nil {
+				return 0, ErrIllegalSchedule
+			}
+			if hi, err = strconv.Atoi(p[1]); err != nil {
+				return 0, ErrIllegalSchedule
+			}
+
+		default:
+			if lo, err = strconv.Atoi(part); err != nil {
+				return 0, ErrIllegalSchedule
+			}
+			if step == 1 {
+				hi = lo
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, ErrIllegalSchedule
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
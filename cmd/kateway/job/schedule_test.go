@@ -0,0 +1,80 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestParseScheduleEvery(t *testing.T) {
+	s, err := ParseSchedule("@every 30s")
+	assert.Equal(t, nil, err)
+	now := time.Date(2016, 8, 19, 10, 0, 5, 300, time.UTC)
+	assert.Equal(t, time.Date(2016, 8, 19, 10, 0, 35, 0, time.UTC), s.Next(now))
+
+	_, err = ParseSchedule("@every 100ms")
+	assert.Equal(t, ErrIllegalSchedule, err)
+	_, err = ParseSchedule("@every xx")
+	assert.Equal(t, ErrIllegalSchedule, err)
+}
+
+func TestParseScheduleCron(t *testing.T) {
+	now := time.Date(2016, 8, 19, 10, 7, 5, 0, time.UTC) // Friday
+
+	s, err := ParseSchedule("*/5 * * * *")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Date(2016, 8, 19, 10, 10, 0, 0, time.UTC), s.Next(now))
+
+	s, err = ParseSchedule("30 2 * * *")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Date(2016, 8, 20, 2, 30, 0, 0, time.UTC), s.Next(now))
+
+	s, err = ParseSchedule("0 9 * * 1-5")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Date(2016, 8, 22, 9, 0, 0, 0, time.UTC), s.Next(now))
+
+	s, err = ParseSchedule("0 0 1 1,7 *")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), s.Next(now))
+
+	s, err = ParseSchedule("@hourly")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Date(2016, 8, 19, 11, 0, 0, 0, time.UTC), s.Next(now))
+
+	// sunday as 7
+	s, err = ParseSchedule("0 0 * * 7")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Date(2016, 8, 21, 0, 0, 0, 0, time.UTC), s.Next(now))
+
+	// never fires
+	s, err = ParseSchedule("0 0 30 2 *")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, s.Next(now).IsZero())
+}
+
+func TestParseScheduleCronHalfHourZone(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800) // +05:30
+	now := time.Date(2016, 8, 19, 10, 7, 5, 0, ist)
+
+	// stepping hours in UTC lands on half past and never matches minute 0
+	s, err := ParseSchedule("0 2 * * *")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Date(2016, 8, 20, 2, 0, 0, 0, ist), s.Next(now))
+
+	s, err = ParseSchedule("15 * * * *")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Date(2016, 8, 19, 10, 15, 0, 0, ist), s.Next(now))
+
+	s, err = ParseSchedule("@hourly")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Date(2016, 8, 19, 11, 0, 0, 0, ist), s.Next(now))
+	assert.Equal(t, true, s.Next(now).After(now))
+}
+
+func TestParseScheduleIllegal(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := ParseSchedule(spec)
+		assert.Equal(t, ErrIllegalSchedule, err)
+	}
+}
@@ -16,8 +16,38 @@ type JobStore interface {
 	// Add pubs a schedulable message(job) synchronously.
 	Add(appid, topic string, payload []byte, due int64) (jobId string, err error)
 
-	// Delete removes a job by jobId.
+	// AddRecurring creates a job that fires repeatedly according to the schedule spec.
+	AddRecurring(appid, topic string, payload []byte, schedule string) (jobId string, due int64, err error)
+
+	// ListRecurring returns all the recurring jobs of a topic.
+	ListRecurring(appid, topic string) (jobs []JobItem, err error)
+
+	// Pause pauses or resumes a recurring job.
+	Pause(appid, topic, jobId string, paused bool) (err error)
+
+	// Delete cancels a pending job by jobId.
 	Delete(appid, topic, jobId string) (err error)
+
+	// Get returns a job with its state, pending or archived as fired or cancelled.
+	Get(appid, topic, jobId string) (info JobInfo, err error)
+
+	// List returns at most limit pending jobs due within [from, to] in due time order,
+	// starting after the cursor. The next cursor is zero if there are no more jobs.
+	List(appid, topic string, from, to int64, cursor Cursor, limit int) (jobs []JobItem, next Cursor, err error)
+
+	// Reschedule changes the due time of a pending job.
+	Reschedule(appid, topic, jobId string, due int64) (err error)
+
+	// UpdatePayload replaces the payload of a pending job.
+	UpdatePayload(appid, topic, jobId string, payload []byte) (err error)
+
+	// ShiftRange moves at most limit pending jobs due within [from, to] by delta seconds,
+	// continuing from the cursor of the previous call. The next cursor is zero when done.
+	ShiftRange(appid, topic string, from, to, delta int64, cursor Cursor, limit int) (n int64, next Cursor, err error)
+
+	// CancelRange cancels at most limit pending jobs due within [from, to], continuing
+	// from the cursor of the previous call. The next cursor is zero when done.
+	CancelRange(appid, topic string, from, to int64, cursor Cursor, limit int) (n int64, next Cursor, err error)
 }
 
 var Default JobStore
@@ -31,6 +31,16 @@ curl -i -XPOST -H'Appid: app2' -H'Subkey: mysubkey' 'http://localhost:9193/v1/sh
 curl -XPOST -H'Appid: app1' -H'Pubkey: mypubkey' -d 'hhhhhhhello world!' 'http://localhost:9191/v1/jobs/foobar/v1?delay=20'
 # del a job
 curl -XDELETE -H'Appid: app1' -H'Pubkey: mypubkey' 'http://localhost:9191/v1/jobs/foobar/v1?id=341659367487049728'
+# get a job and its state
+curl -H'Appid: app1' -H'Pubkey: mypubkey' 'http://localhost:9191/v1/jobs/foobar/v1?id=341659367487049728'
+# list pending jobs
+curl -H'Appid: app1' -H'Pubkey: mypubkey' 'http://localhost:9191/v1/jobs/foobar/v1?from=1471565204&to=1471568804&limit=100'
+# reschedule a job, replace its payload
+curl -XPUT -H'Appid: app1' -H'Pubkey: mypubkey' 'http://localhost:9191/v1/jobs/foobar/v1?id=341659367487049728&action=reschedule&delay=60'
+curl -XPUT -H'Appid: app1' -H'Pubkey: mypubkey' -d 'hello again!' 'http://localhost:9191/v1/jobs/foobar/v1?id=341659367487049728&action=payload'
+# push back the jobs due within the range by an hour, cancel the jobs due within the range
+curl -XPUT -H'Appid: app1' -H'Pubkey: mypubkey' 'http://localhost:9191/v1/jobs/foobar/v1?action=shift&from=1471565204&to=1471568804&delay=3600'
+curl -XDELETE -H'Appid: app1' -H'Pubkey: mypubkey' 'http://localhost:9191/v1/jobs/foobar/v1?from=1471565204&to=1471568804'
 
 # pub a topic
 curl -XPOST -H'Appid: app1' -H'Pubkey: mypubkey' -d 'hhhhhhhello world!' 'http://localhost:9191/v1/msgs/foobar/v1'
@@ -66,6 +76,9 @@ curl -XPUT -H'Appid: app2' -H'Subkey: mysubkey' -d '[{"partition":0,"offset":5}]
 # peek
 curl -XGET -H'Appid: app1' -H'Subkey: mysubkey' 'http://localhost:9193/v1/peek/app1/foobar/v1?n=10&wait=5s'
 
+# search
+curl -XGET -H'Appid: app1' -H'Subkey: mysubkey' 'http://localhost:9193/v1/search/app1/foobar/v1?from=1483286400&key=order123&q=paid&limit=10&budget=100000'
+
 # sub status
 curl -XGET -H'Appid: app2' -H'Subkey: mysubkey' 'http://localhost:9193/v1/status/app1/foobar/v1?group=group1'
 
@@ -1,5 +1,9 @@
 package dummy
 
+import (
+	"github.com/funkygao/gafka/cmd/kateway/store"
+)
+
 type pubStore struct {
 }
 
@@ -36,3 +40,9 @@ func (this *pubStore) AsyncPub(cluster string, topic string, key,
 
 	return
 }
+
+func (this *pubStore) SyncBatchPub(cluster string, topic string, keys,
+	msgs [][]byte) (results []store.PubResult, err error) {
+	results = make([]store.PubResult, len(msgs))
+	return
+}
@@ -120,6 +120,102 @@ func (this *pubStore) SyncPub(cluster, topic string, key, msg []byte) (partition
 	return this.doSyncPub(false, cluster, topic, key, msg)
 }
 
+func (this *pubStore) SyncBatchPub(cluster, topic string, keys,
+	msgs [][]byte) (results []store.PubResult, err error) {
+	this.pubPoolsLock.RLock()
+	pool, present := this.pubPools[cluster]
+	this.pubPoolsLock.RUnlock()
+	if !present {
+		err = store.ErrInvalidCluster
+		return
+	}
+
+	if pool.breaker.Open() {
+		err = store.ErrCircuitOpen
+		return
+	}
+
+	producerMsgs := make([]*sarama.ProducerMessage, len(msgs))
+	for i, msg := range msgs {
+		var keyEncoder sarama.Encoder = nil // will use random partitioner
+		if len(keys[i]) > 0 {
+			keyEncoder = sarama.ByteEncoder(keys[i]) // will use hash partition
+		}
+
+		producerMsgs[i] = &sarama.ProducerMessage{
+			Topic:    topic,
+			Key:      keyEncoder,
+			Value:    sarama.ByteEncoder(msg),
+			Metadata: i, // correlate the produce errors back to the batch
+		}
+	}
+
+	results = make([]store.PubResult, len(msgs))
+	if this.dryRun {
+		// ignore kafka I/O
+		return
+	}
+
+	producer, err := pool.GetSyncProducer()
+	if err != nil {
+		pool.breaker.Fail()
+
+		if producer != nil {
+			// should never happen
+			producer.CloseAndRecycle()
+		}
+
+		return nil, err
+	}
+
+	// all the messages are sent in a single produce request per broker
+	sendErr := producer.SendMessages(producerMsgs)
+	for i, pm := range producerMsgs {
+		results[i].Partition = pm.Partition
+		results[i].Offset = pm.Offset
+	}
+
+	if sendErr == nil {
+		pool.breaker.Succeed()
+		producer.Recycle()
+		return
+	}
+
+	produceErrs, ok := sendErr.(sarama.ProducerErrors)
+	if !ok {
+		// should never happen
+		pool.breaker.Fail()
+		producer.CloseAndRecycle()
+		return nil, sendErr
+	}
+
+	log.Error("cluster[%s] topic:%s batch %d/%d failed: %v", cluster, topic,
+		len(produceErrs), len(msgs), produceErrs[0].Err)
+
+	for _, pe := range produceErrs {
+		i := pe.Msg.Metadata.(int)
+		results[i].Offset = -1 // sarama didn't reset this
+		switch pe.Err {
+		case sarama.ErrUnknownTopicOrPartition, sarama.ErrInvalidTopic:
+			results[i].Err = store.ErrInvalidTopic
+
+		default:
+			results[i].Err = pe.Err
+		}
+	}
+
+	if len(produceErrs) == len(msgs) && results[0].Err != store.ErrInvalidTopic {
+		// the whole batch failed, the conn is suspicious
+		pool.breaker.Fail()
+		producer.CloseAndRecycle()
+	} else {
+		pool.breaker.Succeed()
+		producer.Recycle()
+	}
+
+	return
+}
+
 // FIXME not fully fault tolerant like SyncPub.
 func (this *pubStore) AsyncPub(cluster string, topic string, key []byte,
 	msg []byte) (partition int32, offset int64, err error) {
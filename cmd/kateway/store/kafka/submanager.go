@@ -14,6 +14,7 @@ import (
 
 type subManager struct {
 	clientMap     map[string]*consumergroup.ConsumerGroup // key is client remote addr, a client can only sub 1 topic
+	clientCluster map[string]string                       // key is client remote addr, value is cluster of the consumer group
 	clientMapLock sync.RWMutex                            // TODO the lock is too big
 
 	mux *subMux
@@ -21,8 +22,9 @@ type subManager struct {
 
 func newSubManager() *subManager {
 	return &subManager{
-		clientMap: make(map[string]*consumergroup.ConsumerGroup, 500),
-		mux:       newSubMux(),
+		clientMap:     make(map[string]*consumergroup.ConsumerGroup, 500),
+		clientCluster: make(map[string]string, 500),
+		mux:           newSubMux(),
 	}
 }
 
@@ -32,11 +34,18 @@ func (this *subManager) PickConsumerGroup(cluster, topic, group, remoteAddr, rea
 	var present bool
 	this.clientMapLock.RLock()
 	cg, present = this.clientMap[remoteAddr]
+	moved := present && this.clientCluster[remoteAddr] != cluster
 	this.clientMapLock.RUnlock()
-	if present {
+	if present && !moved {
 		return
 	}
 
+	if moved {
+		// e,g. the app is migrated to another cluster, the client consumes from the new cluster
+		log.Info("cg[%s] %s moved to cluster %s", group, remoteAddr, cluster)
+		this.killClient(remoteAddr)
+	}
+
 	this.clientMapLock.Lock()
 	defer this.clientMapLock.Unlock()
 
@@ -74,12 +83,25 @@ func (this *subManager) PickConsumerGroup(cluster, topic, group, remoteAddr, rea
 	default:
 		cf.Offsets.ResetOffsets = false
 		cf.Offsets.Initial = sarama.OffsetOldest
+
+		if t, ok, e := store.ResetOffsetTime(resetOffset); ok && e == nil {
+			// commit the offsets of the time before joining, the group then starts from them
+			var offsets map[int32]int64
+			offsets, err = meta.Default.ZkCluster(cluster).ResetConsumerGroupOffsetsOfTime(topic, group, t)
+			if err != nil {
+				log.Error("cg[%s] %s reset to %s: %v", group, remoteAddr, t, err)
+				return
+			}
+
+			log.Info("cg[%s] %s reset to %s: %+v", group, remoteAddr, t, offsets)
+		}
 	}
 
 	// runs in serial
 	cg, err = consumergroup.JoinConsumerGroupRealIp(realIp, group, []string{topic}, meta.Default.ZkAddrs(), cf)
 	if err == nil {
 		this.clientMap[remoteAddr] = cg
+		this.clientCluster[remoteAddr] = cluster
 
 		if mux {
 			this.mux.register(remoteAddr, cg)
@@ -87,6 +109,7 @@ func (this *subManager) PickConsumerGroup(cluster, topic, group, remoteAddr, rea
 	} else if mux && (err == consumergroup.ErrTooManyConsumers || err == store.ErrTooManyConsumers) {
 		if cg, err = this.mux.claim(group, remoteAddr); err == nil && cg != nil {
 			this.clientMap[remoteAddr] = cg
+			this.clientCluster[remoteAddr] = cluster
 		}
 
 	}
@@ -102,6 +125,7 @@ func (this *subManager) killClient(remoteAddr string) (err error) {
 	cg, present := this.clientMap[remoteAddr]
 	if present {
 		delete(this.clientMap, remoteAddr)
+		delete(this.clientCluster, remoteAddr)
 	}
 	this.clientMapLock.Unlock()
 
@@ -17,7 +17,20 @@ type PubStore interface {
 	// AsyncPub pub a keyed message to a topic of a cluster asynchronously.
 	AsyncPub(cluster, topic string, key, msg []byte) (partition int32, offset int64, err error)
 
+	// SyncBatchPub pub a batch of keyed messages to a topic of a cluster in a single round trip.
+	// keys[i] is the key of msgs[i] and can be nil.
+	// err is returned only when the batch as a whole failed, otherwise each message
+	// reports its own outcome in results.
+	SyncBatchPub(cluster, topic string, keys, msgs [][]byte) (results []PubResult, err error)
+
 	IsSystemError(error) bool
 }
 
+// PubResult is the outcome of a single message within a batch pub.
+type PubResult struct {
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
 var DefaultPubStore PubStore
@@ -1,9 +1,18 @@
 package store
 
 import (
+	"strings"
+	"time"
+
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/zk"
 )
 
+// ResetOffsetTimePrefix is the prefix of resetOffset to reset the consumer
+// group to a timestamp, e,g. time:1471565204 or time:2016-08-19T14:05:00+08:00
+// Like newest and oldest, it takes effect when the subscriber joins the group.
+const ResetOffsetTimePrefix = "time:"
+
 // A Fetcher is a generic high level streamed consumer.
 type Fetcher interface {
 	// Messages returns a stream messages being consumed.
@@ -34,3 +43,14 @@ type SubStore interface {
 }
 
 var DefaultSubStore SubStore
+
+// ResetOffsetTime returns the time of resetOffset, ok is false if it does
+// not reset to a timestamp.
+func ResetOffsetTime(resetOffset string) (t time.Time, ok bool, err error) {
+	if !strings.HasPrefix(resetOffset, ResetOffsetTimePrefix) {
+		return
+	}
+
+	t, err = zk.ParseOffsetTime(resetOffset[len(ResetOffsetTimePrefix):])
+	return t, true, err
+}
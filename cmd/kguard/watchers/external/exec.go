@@ -1,15 +1,18 @@
 package external
 
 import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"sync"
-	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/funkygao/gafka/cmd/kguard/monitor"
-	//"github.com/funkygao/go-metrics"
-	//"github.com/fsnotify/fsnotify"
 	log "github.com/funkygao/log4go"
 )
 
+const confSuffix = ".json"
+
 func init() {
 	monitor.RegisterWatcher("external.exec", func() monitor.Watcher {
 		return &WatchExec{}
@@ -17,17 +20,24 @@ func init() {
 }
 
 // WatchExec watches external scripts stdout and feeds into influxdb.
+//
+// Each *.json file in confd defines a script, and the scripts are hot reloaded
+// when their config files change.
 type WatchExec struct {
 	Stop <-chan struct{}
 	Wg   *sync.WaitGroup
 
 	confDir string
+
+	scriptsWg sync.WaitGroup
+	scripts   map[string]*script // key is config filename
 }
 
 func (this *WatchExec) Init(ctx monitor.Context) {
 	this.Stop = ctx.StopChan()
 	this.Wg = ctx.Inflight()
 	this.confDir = ctx.ExternalDir()
+	this.scripts = make(map[string]*script)
 }
 
 func (this *WatchExec) Run() {
@@ -38,61 +48,90 @@ func (this *WatchExec) Run() {
 		return
 	}
 
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error("external.exec: %v", err)
+		return
+	}
+	defer watcher.Close()
+
+	if err = watcher.Add(this.confDir); err != nil {
+		log.Error("external.exec %s: %v", this.confDir, err)
+		return
+	}
 
-	if err := this.watchConfigDir(); err != nil {
-		log.Error("%v", err)
+	if err = this.loadConfigDir(); err != nil {
+		log.Error("external.exec %s: %v", this.confDir, err)
 		return
 	}
 
 	for {
 		select {
 		case <-this.Stop:
+			for fn := range this.scripts {
+				this.unloadScript(fn)
+			}
+			this.scriptsWg.Wait()
 			log.Info("external.exec stopped")
 			return
 
-		case <-ticker.C:
+		case err := <-watcher.Errors:
+			log.Error("inotify %s: %v", this.confDir, err)
+
+		case event := <-watcher.Events:
+			if !strings.HasSuffix(event.Name, confSuffix) {
+				continue
+			}
+
+			switch {
+			case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+				// file added or modified
+				this.loadScript(event.Name)
 
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				// file deleted
+				this.unloadScript(event.Name)
+			}
 		}
 	}
 }
 
-func (this *WatchExec) watchConfigDir() error {
-	/*
-		watcher, err := fsnotify.NewWatcher()
-		if err != nil {
-			return err
-		}
+func (this *WatchExec) loadConfigDir() error {
+	files, err := ioutil.ReadDir(this.confDir)
+	if err != nil {
+		return err
+	}
 
-		err = watcher.Add(this.confDir)
-		if err != nil {
-			return err
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), confSuffix) {
+			this.loadScript(filepath.Join(this.confDir, f.Name()))
 		}
+	}
 
-		go func() {
-			for {
-				select {
-				case <-this.Stop:
-					watcher.Close()
-					return
-
-				case err := <-watcher.Errors:
-					log.Error("inotify %s: %v", this.confDir, err)
-
-				case event := <-watcher.Events:
-					if event.Op&fsnotify.Write == fsnotify.Write {
-						// file modified
-					}
-					if event.Op&fsnotify.Remove == fsnotify.Remove {
-						// file deleted
-					}
-					if event.Op&fsnotify.Create == fsnotify.Create {
-						// file added
-					}
-				}
-			}
-		}()*/
-
+	log.Info("external.exec loaded %d scripts from %s", len(this.scripts), this.confDir)
 	return nil
 }
+
+// loadScript starts or restarts the script defined in the config file.
+func (this *WatchExec) loadScript(fn string) {
+	cf, err := loadScriptConfig(fn)
+	if err != nil {
+		// keep the running script if the new config is broken
+		log.Error("external.exec[%s] %v", fn, err)
+		return
+	}
+
+	this.unloadScript(fn)
+
+	s := newScript(fn, cf)
+	this.scripts[fn] = s
+	this.scriptsWg.Add(1)
+	go s.run(&this.scriptsWg)
+}
+
+func (this *WatchExec) unloadScript(fn string) {
+	if s, present := this.scripts[fn]; present {
+		s.stop()
+		delete(this.scripts, fn)
+	}
+}
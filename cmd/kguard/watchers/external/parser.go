@@ -0,0 +1,110 @@
+package external
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sample is a metric point emitted by an external script.
+type sample struct {
+	name  string
+	tags  []string // tag values ordered by tag key
+	value float64
+}
+
+// parseOutput parses script stdout of either InfluxDB line protocol or
+// 'key value' lines. Unparsable lines are returned as bad lines count.
+//
+//     disk.used,mount=/data value=81.5 1471565204000000000
+//     disk.inode used=12,free=88
+//     load.avg1 0.75
+func parseOutput(out []byte) (samples []sample, badLines int) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		var ss []sample
+		var ok bool
+		if len(fields) == 2 && strings.IndexByte(fields[1], '=') == -1 {
+			ss, ok = parseKeyValueLine(fields)
+		} else {
+			ss, ok = parseInfluxLine(fields)
+		}
+		if !ok {
+			badLines++
+			continue
+		}
+
+		samples = append(samples, ss...)
+	}
+
+	return
+}
+
+func parseKeyValueLine(fields []string) ([]sample, bool) {
+	v, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return nil, false
+	}
+
+	return []sample{{name: fields[0], value: v}}, true
+}
+
+// measurement[,tag=v...] field=v[,field=v...] [timestamp]
+// The timestamp is ignored: the gauge is reported at the telemetry flush time.
+func parseInfluxLine(fields []string) ([]sample, bool) {
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, false
+	}
+
+	p := strings.Split(fields[0], ",")
+	name := p[0]
+	if name == "" {
+		return nil, false
+	}
+
+	tagKeys := make([]string, 0, len(p)-1)
+	tagMap := make(map[string]string, len(p)-1)
+	for _, kv := range p[1:] {
+		idx := strings.IndexByte(kv, '=')
+		if idx < 1 {
+			return nil, false
+		}
+		tagKeys = append(tagKeys, kv[:idx])
+		tagMap[kv[:idx]] = kv[idx+1:]
+	}
+	sort.Strings(tagKeys)
+	tags := make([]string, len(tagKeys))
+	for i, k := range tagKeys {
+		tags[i] = tagMap[k]
+	}
+
+	var samples []sample
+	for _, kv := range strings.Split(fields[1], ",") {
+		idx := strings.IndexByte(kv, '=')
+		if idx < 1 {
+			return nil, false
+		}
+
+		// integer field has the 'i' suffix
+		v, err := strconv.ParseFloat(strings.TrimSuffix(kv[idx+1:], "i"), 64)
+		if err != nil {
+			return nil, false
+		}
+
+		s := sample{name: name, tags: tags, value: v}
+		if field := kv[:idx]; field != "value" {
+			s.name = name + "." + field
+		}
+		samples = append(samples, s)
+	}
+
+	return samples, true
+}
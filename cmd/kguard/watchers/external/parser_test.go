@@ -0,0 +1,35 @@
+package external
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestParseOutput(t *testing.T) {
+	out := `
+# comment line
+disk.used,mount=/data,dev=sda value=81.5 1471565204000000000
+disk.inode used=12i,free=88
+load.avg1 0.75
+bad line here ok
+load.avg5 xx
+`
+	samples, bad := parseOutput([]byte(out))
+	assert.Equal(t, 2, bad)
+	assert.Equal(t, 4, len(samples))
+
+	assert.Equal(t, "disk.used", samples[0].name)
+	assert.Equal(t, 81.5, samples[0].value)
+	assert.Equal(t, 2, len(samples[0].tags))
+	assert.Equal(t, "sda", samples[0].tags[0]) // dev sorts before mount
+	assert.Equal(t, "/data", samples[0].tags[1])
+
+	assert.Equal(t, "disk.inode.used", samples[1].name)
+	assert.Equal(t, float64(12), samples[1].value)
+	assert.Equal(t, "disk.inode.free", samples[2].name)
+
+	assert.Equal(t, "load.avg1", samples[3].name)
+	assert.Equal(t, 0.75, samples[3].value)
+	assert.Equal(t, 0, len(samples[3].tags))
+}
@@ -0,0 +1,228 @@
+package external
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/funkygao/gafka/telemetry"
+	"github.com/funkygao/go-metrics"
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	defaultInterval = time.Minute
+	defaultTimeout  = time.Second * 10
+	maxTags         = 3 // telemetry tag has 3 slots
+)
+
+var (
+	ErrEmptyCommand   = errors.New("empty script command")
+	ErrInvalidTimeout = errors.New("timeout must be less than interval")
+	ErrTooManyTags    = errors.New("at most 3 tags")
+)
+
+// scriptConfig is the definition of an external script in confd, e.g.
+//
+//     {
+//         "cmd": "/opt/kguard/check_disk.sh -v",
+//         "interval": "30s",
+//         "timeout": "5s",
+//         "name": "ext.disk",
+//         "tags": ["bigdata"]
+//     }
+type scriptConfig struct {
+	Cmd      string   `json:"cmd"`
+	Interval string   `json:"interval"`
+	Timeout  string   `json:"timeout"`
+	Name     string   `json:"name"` // metric name prefix
+	Tags     []string `json:"tags"` // metric tags, prepended to the script output tags
+
+	interval, timeout time.Duration
+}
+
+func loadScriptConfig(fn string) (*scriptConfig, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	cf := &scriptConfig{}
+	if err = json.Unmarshal(b, cf); err != nil {
+		return nil, err
+	}
+
+	if err = cf.validate(); err != nil {
+		return nil, err
+	}
+
+	return cf, nil
+}
+
+func (this *scriptConfig) validate() (err error) {
+	if strings.TrimSpace(this.Cmd) == "" {
+		return ErrEmptyCommand
+	}
+
+	this.interval, this.timeout = defaultInterval, defaultTimeout
+	if this.Interval != "" {
+		if this.interval, err = time.ParseDuration(this.Interval); err != nil {
+			return
+		}
+	}
+	if this.Timeout != "" {
+		if this.timeout, err = time.ParseDuration(this.Timeout); err != nil {
+			return
+		}
+	}
+	if this.timeout <= 0 || this.timeout >= this.interval {
+		return ErrInvalidTimeout
+	}
+
+	if len(this.Tags) > maxTags {
+		return ErrTooManyTags
+	}
+
+	return
+}
+
+// script periodically runs an external command and feeds its stdout into gauges.
+type script struct {
+	fn   string // config filename
+	cf   *scriptConfig
+	quit chan struct{}
+	done chan struct{}
+
+	gauges map[string]metrics.GaugeFloat64
+}
+
+func newScript(fn string, cf *scriptConfig) *script {
+	return &script{
+		fn:     fn,
+		cf:     cf,
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+		gauges: make(map[string]metrics.GaugeFloat64),
+	}
+}
+
+func (this *script) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(this.done)
+
+	log.Trace("external.exec[%s] started: %s every %s", this.fn, this.cf.Cmd, this.cf.interval)
+
+	ticker := time.NewTicker(this.cf.interval)
+	defer ticker.Stop()
+
+	this.execute()
+	for {
+		select {
+		case <-this.quit:
+			this.unregisterGauges()
+			log.Trace("external.exec[%s] stopped", this.fn)
+			return
+
+		case <-ticker.C:
+			this.execute()
+		}
+	}
+}
+
+// stop awaits the script to quit and unregister its gauges, so that the gauges of the
+// same names registered by a reloaded script are not unregistered by the old one.
+func (this *script) stop() {
+	close(this.quit)
+	<-this.done
+}
+
+func (this *script) execute() {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", this.cf.Cmd)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// the script might fork children, kill them all on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	t0 := time.Now()
+	if err := cmd.Start(); err != nil {
+		log.Error("external.exec[%s] %s: %v", this.fn, this.cf.Cmd, err)
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Error("external.exec[%s] %s: %v %s", this.fn, this.cf.Cmd, err, strings.TrimSpace(stderr.String()))
+			return
+		}
+
+	case <-time.After(this.cf.timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+
+		log.Error("external.exec[%s] %s: timeout %s", this.fn, this.cf.Cmd, this.cf.timeout)
+		return
+
+	case <-this.quit:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return
+	}
+
+	samples, badLines := parseOutput(stdout.Bytes())
+	if badLines > 0 {
+		log.Warn("external.exec[%s] %d bad lines", this.fn, badLines)
+	}
+
+	for _, s := range samples {
+		this.gauge(s).Update(s.value)
+	}
+
+	log.Debug("external.exec[%s] %d samples in %s", this.fn, len(samples), time.Since(t0))
+}
+
+// gauge returns the registered gauge of a sample, which will be reported through telemetry.Default.
+func (this *script) gauge(s sample) metrics.GaugeFloat64 {
+	name := s.name
+	if this.cf.Name != "" {
+		name = this.cf.Name + "." + name
+	}
+
+	tags := append(append([]string{}, this.cf.Tags...), s.tags...)
+	if len(tags) > 0 {
+		// extra tags are dropped
+		for len(tags) < maxTags {
+			tags = append(tags, "")
+		}
+		for i := 0; i < maxTags; i++ {
+			tags[i] = strings.Replace(tags[i], ".", "_", -1)
+		}
+		name = telemetry.Tag(tags[0], tags[1], tags[2]) + name
+	}
+
+	g, present := this.gauges[name]
+	if !present {
+		g = metrics.GetOrRegisterGaugeFloat64(name, nil)
+		this.gauges[name] = g
+	}
+	return g
+}
+
+func (this *script) unregisterGauges() {
+	for name := range this.gauges {
+		metrics.Unregister(name)
+	}
+	this.gauges = make(map[string]metrics.GaugeFloat64)
+}
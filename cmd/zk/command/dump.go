@@ -1,6 +1,7 @@
 package command
 
 import (
+	"bufio"
 	"encoding/binary"
 	"flag"
 	"fmt"
@@ -98,42 +99,46 @@ func (this *Dump) Run(args []string) (exitCode int) {
 func (this *Dump) diplayDumppedFile() {
 	f, err := os.Open(this.infile)
 	must(err)
+	defer f.Close()
 
+	r := bufio.NewReader(f)
 	for {
-		// read line, got the znode path
-		var buf [1]byte
-		zpath := make([]byte, 0, 8<<10)
-		for {
-			b := buf[:]
-			_, err := f.Read(b)
-			if err == io.EOF {
-				return
-			}
-			must(err)
-
-			if b[0] == '\n' {
-				break
-			}
-			zpath = append(zpath, b[0])
+		zpath, zdata, err := readDumpRecord(r)
+		if err == io.EOF {
+			return
 		}
-
-		this.Ui.Info(string(zpath))
-
-		// read the znode data
-		// 1. data len
-		// 2. data itself
-		var dataLen int32
-		err = binary.Read(f, binary.BigEndian, &dataLen)
-		must(err)
-
-		zdata := make([]byte, dataLen)
-		_, err = io.ReadFull(f, zdata)
 		must(err)
 
+		this.Ui.Info(zpath)
 		this.Ui.Output(string(zdata))
 	}
 }
 
+// readDumpRecord reads a znode from the dump stream.
+// Each record is: znode path, '\n', data len(int32), data.
+func readDumpRecord(r *bufio.Reader) (zpath string, zdata []byte, err error) {
+	zpath, err = r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && zpath != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	zpath = zpath[:len(zpath)-1]
+
+	var dataLen int32
+	if err = binary.Read(r, binary.BigEndian, &dataLen); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	zdata = make([]byte, dataLen)
+	_, err = io.ReadFull(r, zdata)
+	return
+}
+
 func (this *Dump) dump(conn *zk.Conn, path string) {
 	children, _, err := conn.Children(path)
 	if err != nil {
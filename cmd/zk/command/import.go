@@ -1,34 +1,61 @@
 package command
 
 import (
+	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"os"
+	"path"
 	"strings"
 
 	"github.com/funkygao/gafka/ctx"
+	gzk "github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	importModeCreate    = "create"
+	importModeOverwrite = "overwrite"
+	importModeDiff      = "diff"
 )
 
 type Import struct {
 	Ui  cli.Ui
 	Cmd string
 
-	zone string
-	path string
+	zone   string
+	path   string
+	infile string
+	strip  string
+	mode   string
+	acl    string
+	dryRun bool
+
+	conn    *zk.Conn
+	acls    []zk.ACL
+	created map[string]struct{} // created during dry run
 }
 
 func (this *Import) Run(args []string) (exitCode int) {
 	cmdFlags := flag.NewFlagSet("import", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
 	cmdFlags.StringVar(&this.zone, "z", ctx.ZkDefaultZone(), "")
-	cmdFlags.StringVar(&this.path, "p", "", "")
+	cmdFlags.StringVar(&this.path, "p", "/", "")
+	cmdFlags.StringVar(&this.infile, "in", "", "")
+	cmdFlags.StringVar(&this.strip, "strip", "", "")
+	cmdFlags.StringVar(&this.mode, "mode", importModeCreate, "")
+	cmdFlags.StringVar(&this.acl, "acl", "", "")
+	cmdFlags.BoolVar(&this.dryRun, "dryrun", false, "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
 	if validateArgs(this, this.Ui).
-		require("-p").
-		requireAdminRights("-p").
+		require("-in").
+		requireAdminRights("-in").
 		invalid(args) {
 		return 2
 	}
@@ -38,21 +65,206 @@ func (this *Import) Run(args []string) (exitCode int) {
 		return 2
 	}
 
+	switch this.mode {
+	case importModeCreate, importModeOverwrite, importModeDiff:
+	default:
+		this.Ui.Error("invalid mode: " + this.mode)
+		return 2
+	}
+
+	if !strings.HasPrefix(this.path, "/") {
+		this.Ui.Error("target path must be absolute")
+		return 2
+	}
+
+	zkzone := gzk.NewZkZone(gzk.DefaultConfig(this.zone, ctx.ZoneZkAddrs(this.zone)))
+	defer zkzone.Close()
+	this.conn = zkzone.Conn()
+	this.created = make(map[string]struct{})
+
+	this.acls = zk.WorldACL(zk.PermAll)
+	if this.acl != "" {
+		// digest acl: user:password
+		p := strings.SplitN(this.acl, ":", 2)
+		if len(p) != 2 {
+			this.Ui.Error("invalid acl, expected user:password")
+			return 2
+		}
+
+		this.acls = zk.DigestACL(zk.PermAll, p[0], p[1])
+		must(this.conn.AddAuth("digest", []byte(this.acl)))
+	}
+
+	f, err := os.Open(this.infile)
+	must(err)
+	defer f.Close()
+
+	var (
+		r                                     = bufio.NewReader(f)
+		created, updated, same, kept, refused int
+		targetRoot                            = path.Clean(this.path)
+		targetRootEnsured                     bool
+		zpath, target                         string
+		zdata                                 []byte
+	)
+	for {
+		zpath, zdata, err = readDumpRecord(r)
+		if err == io.EOF {
+			break
+		}
+		must(err)
+
+		if this.strip != "" {
+			if !strings.HasPrefix(zpath, this.strip) {
+				continue
+			}
+			zpath = strings.TrimPrefix(zpath, this.strip)
+		}
+		target = path.Join(targetRoot, zpath)
+
+		if isProtectedZnode(target) {
+			this.Ui.Warn(fmt.Sprintf("! %s refused: ephemeral registry", target))
+			refused++
+			continue
+		}
+
+		if !targetRootEnsured {
+			this.ensureParents(target)
+			targetRootEnsured = true
+		}
+
+		data, stat, exists := this.get(target)
+		switch {
+		case !exists:
+			this.Ui.Output(fmt.Sprintf("+ %s %d bytes", target, len(zdata)))
+			created++
+			if this.mode != importModeDiff {
+				this.create(target, zdata)
+			}
+
+		case bytes.Equal(data, zdata):
+			same++
+
+		case this.mode == importModeOverwrite:
+			this.Ui.Output(fmt.Sprintf("~ %s %d -> %d bytes", target, len(data), len(zdata)))
+			updated++
+			if !this.dryRun {
+				_, err = this.conn.Set(target, zdata, stat.Version)
+				must(err)
+				if this.acl != "" {
+					_, err = this.conn.SetACL(target, this.acls, -1)
+					must(err)
+				}
+			}
+
+		default:
+			// create mode keeps the existing znode, diff mode only reports
+			this.Ui.Output(fmt.Sprintf("~ %s %d -> %d bytes, kept", target, len(data), len(zdata)))
+			kept++ // changed but not updated
+		}
+	}
+
+	summary := fmt.Sprintf("mode:%s created:%d updated:%d kept:%d same:%d refused:%d",
+		this.mode, created, updated, kept, same, refused)
+	if this.dryRun || this.mode == importModeDiff {
+		summary += ", nothing changed"
+	}
+	this.Ui.Info(summary)
+
 	return
 }
 
+// get returns the znode data, taking dry run creates into account.
+func (this *Import) get(zpath string) (data []byte, stat *zk.Stat, exists bool) {
+	if _, present := this.created[zpath]; present {
+		return nil, nil, false
+	}
+
+	data, stat, err := this.conn.Get(zpath)
+	if err == zk.ErrNoNode {
+		return nil, nil, false
+	}
+	must(err)
+
+	return data, stat, true
+}
+
+func (this *Import) create(zpath string, data []byte) {
+	if this.dryRun {
+		this.created[zpath] = struct{}{}
+		return
+	}
+
+	_, err := this.conn.Create(zpath, data, 0, this.acls)
+	must(err)
+}
+
+// ensureParents creates the missing ancestors of the first imported znode.
+func (this *Import) ensureParents(zpath string) {
+	dir := path.Dir(zpath)
+	if dir == "/" {
+		return
+	}
+
+	this.ensureParents(dir)
+	if _, _, exists := this.get(dir); !exists {
+		this.Ui.Output(fmt.Sprintf("+ %s", dir))
+		if this.mode != importModeDiff {
+			this.create(dir, nil)
+		}
+	}
+}
+
+// isProtectedZnode checks whether the znode is an ephemeral registry that
+// must never be restored from a dump, e,g. kafka broker ids and controller.
+func isProtectedZnode(zpath string) bool {
+	for _, ephemeral := range []string{
+		gzk.BrokerIdsPath,
+		gzk.ControllerPath,
+		gzk.KatewayIdsRoot,
+		gzk.PubsubActors,
+	} {
+		if strings.HasSuffix(zpath, ephemeral) || strings.Contains(zpath, ephemeral+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (*Import) Synopsis() string {
-	return "Load directories and contents to Zookeeper TODO"
+	return "Load directories and contents to Zookeeper from zk dump"
 }
 
 func (this *Import) Help() string {
 	help := fmt.Sprintf(`
-Usage: %s import [options]
+Usage: %s import -z zone -in dumpfile [options]
+
+    Load directories and contents to Zookeeper from zk dump
 
-    Load directories and contents to Zookeeper
+    Broker/kateway/actord ephemeral registry znodes are always refused.
 
 Options:
 
+    -p target path
+      Default '/'.
+      Dumpped znodes are restored under this path.
+
+    -strip prefix
+      Only import dumpped znodes under prefix, and strip it before restoring.
+      e,g. zk import -in test.zk.dump -strip /_kateway -p /_kateway_restore
+
+    -mode <create|overwrite|diff>
+      create: only create missing znodes, existing znodes are kept. The default.
+      overwrite: create missing znodes and overwrite the changed ones.
+      diff: only display the difference, nothing changed.
+
+    -acl user:password
+      Apply digest ACL to the created/overwritten znodes.
+      Default world ACL.
+
+    -dryrun
+      Display the creates and updates without applying them.
 
 `, this.Cmd)
 	return strings.TrimSpace(help)
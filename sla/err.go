@@ -8,5 +8,6 @@ var (
 	ErrNegative         = errors.New("can not be negative")
 	ErrEmptyArg         = errors.New("empty argument")
 	ErrNotNumber        = errors.New("not number")
+	ErrNotBool          = errors.New("not bool")
 	ErrTooBigPartitions = errors.New("too big partitions")
 )
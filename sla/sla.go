@@ -10,6 +10,7 @@ const (
 	SlaKeyRetentionBytes = "retention.bytes"
 	SlaKeyPartitions     = "partitions"
 	SlaKeyReplicas       = "replicas"
+	SlaKeyArchive        = "archive"
 
 	SlaKeyRetryTopic      = "retry"
 	SlaKeyDeadLetterTopic = "dead"
@@ -31,6 +32,10 @@ type TopicSla struct {
 	RetentionBytes int
 	Partitions     int
 	Replicas       int
+
+	// Archive copies the messages to object store before they expire with retention.
+	// It's not a kafka topic config and is kept in zk by gafka.
+	Archive bool
 }
 
 func DefaultSla() *TopicSla {
@@ -46,7 +51,8 @@ func (this *TopicSla) IsDefault() bool {
 	return this.Replicas == defaultReplicas &&
 		this.Partitions == defaultPartitions &&
 		this.RetentionBytes == defaultRetentionBytes &&
-		this.RetentionHours == defaultRetentionHours
+		this.RetentionHours == defaultRetentionHours &&
+		!this.Archive
 }
 
 func (this *TopicSla) Validate() error {
@@ -57,6 +63,24 @@ func (this *TopicSla) Validate() error {
 	return nil
 }
 
+func (this *TopicSla) ParseArchive(s string) error {
+	switch s {
+	case "":
+		return ErrEmptyArg
+
+	case "1", "true":
+		this.Archive = true
+
+	case "0", "false":
+		this.Archive = false
+
+	default:
+		return ErrNotBool
+	}
+
+	return nil
+}
+
 func (this *TopicSla) ParseRetentionHours(s string) error {
 	if len(s) == 0 {
 		return ErrEmptyArg
@@ -53,6 +53,19 @@ func TestSlaRententionHoursFloat(t *testing.T) {
 	assert.Equal(t, ErrNegative, sla.ParseRetentionHours("-9"))
 }
 
+func TestSlaParseArchive(t *testing.T) {
+	sla := DefaultSla()
+	assert.Equal(t, nil, sla.ParseArchive("1"))
+	assert.Equal(t, true, sla.Archive)
+	assert.Equal(t, false, sla.IsDefault())
+	assert.Equal(t, 0, len(sla.DumpForAlterTopic())) // not a kafka topic config
+	assert.Equal(t, nil, sla.ParseArchive("false"))
+	assert.Equal(t, true, sla.IsDefault())
+
+	assert.Equal(t, ErrEmptyArg, sla.ParseArchive(""))
+	assert.Equal(t, ErrNotBool, sla.ParseArchive("yes"))
+}
+
 func TestValidateShadowName(t *testing.T) {
 	assert.Equal(t, true, ValidateShadowName("dead"))
 	assert.Equal(t, true, ValidateShadowName("retry"))
@@ -8,4 +8,8 @@ var (
 	ErrDupConnect      = errors.New("connect while being connected")
 	ErrClaimedByOthers = errors.New("claimed by others")
 	ErrNotClaimed      = errors.New("release non-claimed")
+
+	ErrInvalidOffsetTime = errors.New("invalid offset time, expected unix timestamp or RFC3339")
+
+	ErrInvalidMigrationState = errors.New("invalid migration state transition")
 )
@@ -62,6 +62,103 @@ func (this *WebhookMeta) Bytes() []byte {
 	return b
 }
 
+const (
+	MigrationDualWrite = "dualwrite" // Pub writes both clusters, Sub stays on the source cluster
+	MigrationCutover   = "cutover"   // Pub writes the target cluster, Sub drains the source cluster
+	MigrationDone      = "done"      // Pub/Sub are both on the target cluster
+)
+
+// MigrationMeta is the state of an app moving from one kafka cluster to another.
+type MigrationMeta struct {
+	Appid     string `json:"appid"`
+	Source    string `json:"from"`
+	Target    string `json:"to"`
+	State     string `json:"state"`
+	Ctime     int64  `json:"ctime"`
+	Mtime     int64  `json:"mtime"`
+	CutoverAt int64  `json:"cutover_at,omitempty"` // unix timestamp in ms when Pub switched to target cluster
+}
+
+func (this *MigrationMeta) From(b []byte) error {
+	return json.Unmarshal(b, this)
+}
+
+func (this *MigrationMeta) Bytes() []byte {
+	b, _ := json.Marshal(this)
+	return b
+}
+
+// Advance moves the migration forward: dualwrite -> cutover -> done.
+func (this *MigrationMeta) Advance(now time.Time) error {
+	switch this.State {
+	case MigrationDualWrite:
+		this.State = MigrationCutover
+		this.CutoverAt = now.UnixNano() / int64(time.Millisecond)
+
+	case MigrationCutover:
+		this.State = MigrationDone
+
+	default:
+		return ErrInvalidMigrationState
+	}
+
+	this.Mtime = now.Unix()
+	return nil
+}
+
+// CutoverTime returns when Pub switched to the target cluster.
+func (this *MigrationMeta) CutoverTime() time.Time {
+	return time.Unix(0, this.CutoverAt*int64(time.Millisecond))
+}
+
+// OffsetSync maps a source offset to the target offset it is mirrored to.
+type OffsetSync struct {
+	Source int64 `json:"src"`
+	Target int64 `json:"dst"`
+}
+
+// MirrorCheckpoint is the recent offset syncs of a mirrored partition in ascending order.
+type MirrorCheckpoint struct {
+	Syncs []OffsetSync `json:"syncs"`
+	Mtime int64        `json:"mtime"`
+}
+
+func (this *MirrorCheckpoint) From(b []byte) error {
+	return json.Unmarshal(b, this)
+}
+
+func (this *MirrorCheckpoint) Bytes() []byte {
+	b, _ := json.Marshal(this)
+	return b
+}
+
+// Add appends a sync and keeps at most max recent syncs.
+func (this *MirrorCheckpoint) Add(sync OffsetSync, max int) {
+	if n := len(this.Syncs); n > 0 && this.Syncs[n-1].Source >= sync.Source {
+		// the mirror restarted from an earlier offset
+		this.Syncs = this.Syncs[:0]
+	}
+
+	this.Syncs = append(this.Syncs, sync)
+	if len(this.Syncs) > max {
+		this.Syncs = this.Syncs[len(this.Syncs)-max:]
+	}
+}
+
+// Translate converts a consumer group offset on the source partition to the target partition.
+// It never skips messages: the target offsets between syncs are not dense because of
+// duplicates and filtered messages, so it resumes right after the latest consumed sync
+// and messages mirrored since that sync are consumed again.
+func (this *MirrorCheckpoint) Translate(offset int64) (int64, bool) {
+	for i := len(this.Syncs) - 1; i >= 0; i-- {
+		if sync := this.Syncs[i]; sync.Source < offset {
+			return sync.Target + 1, true
+		}
+	}
+
+	return -1, false
+}
+
 type ControllerMeta struct {
 	Broker *BrokerZnode
 	Mtime  ZkTimestamp
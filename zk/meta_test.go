@@ -2,6 +2,7 @@ package zk
 
 import (
 	"testing"
+	"time"
 
 	"github.com/funkygao/assert"
 	log "github.com/funkygao/log4go"
@@ -50,3 +51,53 @@ func TestWebhook(t *testing.T) {
 	hook.Endpoints = []string{"http://localhost:9876"}
 	t.Logf("%s", string(hook.Bytes()))
 }
+
+func TestMigrationAdvance(t *testing.T) {
+	m := MigrationMeta{Appid: "app1", Source: "trade", Target: "trade2", State: MigrationDualWrite}
+	now := time.Unix(1471565204, 0)
+
+	assert.Equal(t, nil, m.Advance(now))
+	assert.Equal(t, MigrationCutover, m.State)
+	assert.Equal(t, int64(1471565204000), m.CutoverAt)
+	assert.Equal(t, now, m.CutoverTime())
+
+	assert.Equal(t, nil, m.Advance(now.Add(time.Hour)))
+	assert.Equal(t, MigrationDone, m.State)
+	assert.Equal(t, int64(1471565204000), m.CutoverAt)
+	assert.Equal(t, ErrInvalidMigrationState, m.Advance(now))
+
+	var m1 MigrationMeta
+	assert.Equal(t, nil, m1.From(m.Bytes()))
+	assert.Equal(t, m, m1)
+}
+
+func TestMirrorCheckpointTranslate(t *testing.T) {
+	var cp MirrorCheckpoint
+	_, ok := cp.Translate(100)
+	assert.Equal(t, false, ok)
+
+	cp.Add(OffsetSync{Source: 100, Target: 10}, 3)
+	cp.Add(OffsetSync{Source: 200, Target: 112}, 3) // 2 duplicates mirrored
+	cp.Add(OffsetSync{Source: 300, Target: 212}, 3)
+	cp.Add(OffsetSync{Source: 400, Target: 312}, 3)
+	assert.Equal(t, 3, len(cp.Syncs))
+	assert.Equal(t, int64(200), cp.Syncs[0].Source)
+
+	_, ok = cp.Translate(200)
+	assert.Equal(t, false, ok)
+	offset, ok := cp.Translate(201)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(113), offset)
+	offset, _ = cp.Translate(350)
+	assert.Equal(t, int64(213), offset)
+	offset, _ = cp.Translate(1000)
+	assert.Equal(t, int64(313), offset)
+
+	// mirror restarted from an earlier offset
+	cp.Add(OffsetSync{Source: 300, Target: 500}, 3)
+	assert.Equal(t, 1, len(cp.Syncs))
+
+	var cp1 MirrorCheckpoint
+	assert.Equal(t, nil, cp1.From(cp.Bytes()))
+	assert.Equal(t, cp, cp1)
+}
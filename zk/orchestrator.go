@@ -1,6 +1,7 @@
 package zk
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/samuel/go-zookeeper/zk"
@@ -96,6 +97,81 @@ func (this *Orchestrator) ReleaseResource(actorId, root, resource string) error
 	return this.conn.Delete(path, -1)
 }
 
+// WatchResourceWeights returns the weights snapshot {resource: weight} stored in path,
+// resources without weight are absent. The watch fires on any weight change so that all
+// actors rebalance with the same weights.
+func (this *Orchestrator) WatchResourceWeights(path string) (map[string]int, <-chan zk.Event, error) {
+	this.connectIfNeccessary()
+
+	for {
+		weights := make(map[string]int)
+		data, _, c, err := this.conn.GetW(path)
+		if err == zk.ErrNoNode {
+			var exists bool
+			if exists, _, c, err = this.conn.ExistsW(path); err != nil {
+				return nil, nil, err
+			} else if exists {
+				// created meanwhile
+				continue
+			}
+
+			return weights, c, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &weights); err != nil {
+				return nil, nil, err
+			}
+		}
+		return weights, c, nil
+	}
+}
+
+// UpdateResourceWeights merges the weights into the snapshot stored in path with optimistic
+// lock on the znode version, the snapshot is written only if any weight changes.
+func (this *Orchestrator) UpdateResourceWeights(path string, weights map[string]int) error {
+	this.connectIfNeccessary()
+
+	for {
+		data, stat, err := this.conn.Get(path)
+		if err == zk.ErrNoNode {
+			b, _ := json.Marshal(weights)
+			this.ensureParentDirExists(path)
+			if err = this.createZnode(path, b); err == zk.ErrNodeExists {
+				// created by another actor
+				continue
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		current := make(map[string]int)
+		if len(data) > 0 {
+			// a corrupted snapshot is overwritten
+			json.Unmarshal(data, &current)
+		}
+
+		changed := false
+		for resource, weight := range weights {
+			if current[resource] != weight {
+				current[resource] = weight
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+
+		b, _ := json.Marshal(current)
+		if _, err = this.conn.Set(path, b, stat.Version); err != zk.ErrBadVersion {
+			return err
+		}
+	}
+}
+
 type ActorList []string
 
 func (this ActorList) Len() int {
@@ -12,17 +12,23 @@ const (
 	katewayMetricsRoot = "/_kateway/metrics"
 	KatewayMysqlPath   = "/_kateway/mysql"
 
-	PubsubJobConfig      = "/_kateway/orchestrator/jobconfig"
-	PubsubJobQueues      = "/_kateway/orchestrator/jobs"
-	PubsubActors         = "/_kateway/orchestrator/actors/ids"
-	PubsubJobQueueOwners = "/_kateway/orchestrator/actors/job_owners"
-	PubsubWebhooks       = "/_kateway/orchestrator/webhooks"
-	PubsubWebhooksOff    = "/_kateway/orchestrator/webhooks_off"
-	PubsubWebhookOwners  = "/_kateway/orchestrator/actors/webhook_owners"
+	PubsubJobConfig       = "/_kateway/orchestrator/jobconfig"
+	PubsubJobQueues       = "/_kateway/orchestrator/jobs"
+	PubsubActors          = "/_kateway/orchestrator/actors/ids"
+	PubsubJobQueueOwners  = "/_kateway/orchestrator/actors/job_owners"
+	PubsubWebhooks        = "/_kateway/orchestrator/webhooks"
+	PubsubWebhooksOff     = "/_kateway/orchestrator/webhooks_off"
+	PubsubWebhookOwners   = "/_kateway/orchestrator/actors/webhook_owners"
+	PubsubJobQueueWeights = "/_kateway/orchestrator/job_weights"
+	PubsubMigrations      = "/_kateway/migrations"
+	PubsubFailovers       = "/_kateway/failovers"
 	//PubsubActorRebalance = "/_kateway/orchestrator/rebalance"
 
 	KguardLeaderPath = "_kguard/leader"
 
+	mirrorCheckpointRoot = "/_mirror/checkpoints"
+	archiveTopicsRoot    = "/_archive/topics"
+
 	ConsumersPath           = "/consumers"
 	BrokerIdsPath           = "/brokers/ids"
 	BrokerTopicsPath        = "/brokers/topics"
@@ -41,6 +47,22 @@ func katewayMetricsRootByKey(id, key string) string {
 	return fmt.Sprintf("%s/%s/%s", katewayMetricsRoot, id, key)
 }
 
+func migrationPath(appid string) string {
+	return fmt.Sprintf("%s/%s", PubsubMigrations, appid)
+}
+
+func migrationDrainedRoot(appid string) string {
+	return migrationPath(appid) + "/drained"
+}
+
+func migrationDrainedPath(appid, topic, group string) string {
+	return fmt.Sprintf("%s/%s:%s", migrationDrainedRoot(appid), group, topic)
+}
+
+func migrationDrainingPath(appid, topic, group string) string {
+	return fmt.Sprintf("%s/draining/%s:%s", migrationPath(appid), group, topic)
+}
+
 func ClusterPath(cluster string) string {
 	return fmt.Sprintf("%s/%s", clusterRoot, cluster)
 }
@@ -112,3 +134,11 @@ func (this *ZkCluster) consumerGroupOffsetOfTopicPartitionPath(group, topic, par
 func (this *ZkCluster) consumerGroupOwnerOfTopicPath(group, topic string) string {
 	return this.ConsumerGroupRoot(group) + "/owners/" + topic
 }
+
+func mirrorCheckpointPath(name, topic string, partition int32) string {
+	return fmt.Sprintf("%s/%s/%s/%d", mirrorCheckpointRoot, name, topic, partition)
+}
+
+func archiveTopicPath(cluster, topic string) string {
+	return fmt.Sprintf("%s/%s/%s", archiveTopicsRoot, cluster, topic)
+}
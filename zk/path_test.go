@@ -44,3 +44,11 @@ func TestZkClusterPath(t *testing.T) {
 func TestClusterPath(t *testing.T) {
 	assert.Equal(t, "/_kafka_clusters/test-cluster", ClusterPath("test-cluster"))
 }
+
+func TestMigrationPath(t *testing.T) {
+	assert.Equal(t, "/_kateway/migrations/app1", migrationPath("app1"))
+	assert.Equal(t, "/_kateway/migrations/app1/drained/group1:app1.foo.v1",
+		migrationDrainedPath("app1", "app1.foo.v1", "group1"))
+	assert.Equal(t, "/_kateway/migrations/app1/draining/group1:app1.foo.v1",
+		migrationDrainingPath("app1", "app1.foo.v1", "group1"))
+}
@@ -19,6 +19,23 @@ func TimestampToTime(ts string) time.Time {
 	return time.Unix(sec, 0)
 }
 
+// ParseOffsetTime parses the time to reset consumer offsets to, which is
+// either unix timestamp in seconds or RFC3339, e,g. 2016-08-19T14:05:00+08:00.
+func ParseOffsetTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if sec <= 0 {
+			return time.Time{}, ErrInvalidOffsetTime
+		}
+		return time.Unix(sec, 0), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, ErrInvalidOffsetTime
+	}
+	return t, nil
+}
+
 func withRecover(fn func()) {
 	defer func() {
 		handler := PanicHandler
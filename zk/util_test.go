@@ -13,6 +13,21 @@ func TestTimestampToTime(t *testing.T) {
 	assert.Equal(t, "July", tm.Month().String())
 }
 
+func TestParseOffsetTime(t *testing.T) {
+	tm, err := ParseOffsetTime("1471565204")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1471565204), tm.Unix())
+
+	tm, err = ParseOffsetTime("2016-08-19T14:05:00+08:00")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1471586700), tm.Unix())
+
+	_, err = ParseOffsetTime("14:05")
+	assert.Equal(t, ErrInvalidOffsetTime, err)
+	_, err = ParseOffsetTime("-1")
+	assert.Equal(t, ErrInvalidOffsetTime, err)
+}
+
 func TestParseConsumerHost(t *testing.T) {
 	fixtures := assert.Fixtures{
 		assert.Fixture{Input: "console-consumer-48389_mac-2.local-1449108222694-9f9b7aa7", Expected: "mac-2.local"},
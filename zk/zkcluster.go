@@ -558,6 +558,53 @@ func (this *ZkCluster) Broker(id int) (b *BrokerZnode) {
 	return
 }
 
+// TopicSla returns the partitions, replicas, retention and archival of an existing topic.
+func (this *ZkCluster) TopicSla(topic string) (*sla.TopicSla, error) {
+	this.zone.connectIfNeccessary()
+
+	data, _, err := this.zone.conn.Get(this.topicsRoot() + "/" + topic)
+	if err != nil {
+		return nil, err
+	}
+
+	var tz TopicZnode
+	if err = json.Unmarshal(data, &tz); err != nil {
+		return nil, err
+	}
+
+	ts := sla.DefaultSla()
+	ts.Partitions = len(tz.Partitions)
+	for _, replicas := range tz.Partitions {
+		ts.Replicas = len(replicas)
+		break
+	}
+	if ts.Archive, _, err = this.zone.conn.Exists(archiveTopicPath(this.name, topic)); err != nil {
+		return nil, err
+	}
+
+	data, _, err = this.zone.conn.Get(this.GetTopicConfigPath(topic))
+	if err == zk.ErrNoNode {
+		return ts, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Config map[string]string `json:"config"`
+	}
+	if err = json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if ms, err := strconv.ParseInt(config.Config["retention.ms"], 10, 64); err == nil {
+		ts.RetentionHours = float64(ms) / float64(time.Hour/time.Millisecond)
+	}
+	if n, err := strconv.Atoi(config.Config["retention.bytes"]); err == nil {
+		ts.RetentionBytes = n
+	}
+
+	return ts, nil
+}
+
 func (this *ZkCluster) AddTopic(topic string, ts *sla.TopicSla) (output []string, err error) {
 	zkAddrs := this.ZkConnectAddr()
 	args := []string{
@@ -677,6 +724,147 @@ func (this *ZkCluster) ResetConsumerGroupOffset(topic, group, partition string,
 	return this.zone.setZnode(path, []byte(data))
 }
 
+// OffsetsOfTime returns {partitionId: offset} of the topic at the given time, which
+// is the earliest offset whose message timestamp is not earlier than t.
+// Brokers before 0.10.1 have no time index, the offset is resolved by log
+// segment mtime and is of segment granularity.
+func (this *ZkCluster) OffsetsOfTime(topic string, t time.Time) (map[int32]int64, error) {
+	cf := sarama.NewConfig()
+	cf.Version = sarama.V0_10_1_0
+	r, err := this.offsetsOfTime(topic, t, cf)
+	if err != nil {
+		log.Warn("cluster[%s] topic[%s] time index: %v, fallback to segment", this.name, topic, err)
+		r, err = this.offsetsOfTime(topic, t, sarama.NewConfig())
+	}
+
+	return r, err
+}
+
+func (this *ZkCluster) offsetsOfTime(topic string, t time.Time, cf *sarama.Config) (map[int32]int64, error) {
+	kfk, err := sarama.NewClient(this.BrokerList(), cf)
+	if err != nil {
+		return nil, err
+	}
+	defer kfk.Close()
+
+	partitions, err := kfk.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	timeIndex := cf.Version.IsAtLeast(sarama.V0_10_1_0)
+	r := make(map[int32]int64, len(partitions))
+	for _, partitionId := range partitions {
+		oldest, err := kfk.GetOffset(topic, partitionId, sarama.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		newest, err := kfk.GetOffset(topic, partitionId, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+
+		offset, err := kfk.GetOffset(topic, partitionId, t.UnixNano()/int64(time.Millisecond))
+		switch {
+		case err != nil:
+			return nil, err
+
+		case offset < 0 && timeIndex:
+			// no message after t
+			offset = newest
+
+		case offset < oldest:
+			// t is earlier than the oldest segment
+			offset = oldest
+
+		case offset > newest:
+			offset = newest
+		}
+
+		r[partitionId] = offset
+	}
+
+	return r, nil
+}
+
+// ResetConsumerGroupOffsetsOfTime resets the consumer group offsets of all partitions
+// to the given time and returns the new {partitionId: offset}.
+// The online consumers of the group will overwrite the reset offsets when they commit,
+// so they should be stopped first.
+func (this *ZkCluster) ResetConsumerGroupOffsetsOfTime(topic, group string, t time.Time) (map[int32]int64, error) {
+	offsets, err := this.OffsetsOfTime(topic, t)
+	if err != nil {
+		return nil, err
+	}
+
+	for partitionId, offset := range offsets {
+		if err = this.CommitConsumerGroupOffset(topic, group, partitionId, offset); err != nil {
+			return nil, err
+		}
+	}
+
+	return offsets, nil
+}
+
+// CommitConsumerGroupOffset writes the offset of a consumer group partition, the
+// znode is created if the group never committed offset of the partition.
+func (this *ZkCluster) CommitConsumerGroupOffset(topic, group string, partitionId int32, offset int64) error {
+	this.zone.connectIfNeccessary()
+
+	partition := strconv.Itoa(int(partitionId))
+	err := this.ResetConsumerGroupOffset(topic, group, partition, offset)
+	if err == zk.ErrNoNode {
+		path := this.consumerGroupOffsetOfTopicPartitionPath(group, topic, partition)
+		if err = this.zone.ensureParentDirExists(path); err == nil {
+			err = this.zone.createZnode(path, []byte(strconv.FormatInt(offset, 10)))
+		}
+	}
+
+	return err
+}
+
+// ConsumerGroupLag returns the total lag of a consumer group on a topic.
+// A group that never committed offset of the topic has no lag.
+func (this *ZkCluster) ConsumerGroupLag(topic, group string) (int64, error) {
+	offsets, present := this.ConsumerOffsetsOfGroup(group)[topic]
+	if !present || len(offsets) == 0 {
+		return 0, nil
+	}
+
+	kfk, err := sarama.NewClient(this.BrokerList(), sarama.NewConfig())
+	if err != nil {
+		return 0, err
+	}
+	defer kfk.Close()
+
+	partitions, err := kfk.Partitions(topic)
+	if err != nil {
+		return 0, err
+	}
+
+	var lag int64
+	for _, partitionId := range partitions {
+		newest, err := kfk.GetOffset(topic, partitionId, sarama.OffsetNewest)
+		if err != nil {
+			return 0, err
+		}
+
+		consumerOffset, present := offsets[strconv.Itoa(int(partitionId))]
+		if !present {
+			// never consumed this partition
+			if consumerOffset, err = kfk.GetOffset(topic, partitionId, sarama.OffsetOldest); err != nil {
+				return 0, err
+			}
+		}
+
+		if newest > consumerOffset {
+			lag += newest - consumerOffset
+		}
+	}
+
+	return lag, nil
+}
+
 func (this *ZkCluster) ListChildren(recursive bool) ([]string, error) {
 	excludedPaths := map[string]struct{}{
 		"/zookeeper": {},
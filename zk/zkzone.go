@@ -286,6 +286,250 @@ func (this *Orchestrator) WebhookInfo(topic string) (*WebhookMeta, error) {
 	return hook, err
 }
 
+// WatchWebhook returns the webhook info and watches its changes, e,g. endpoints changed.
+func (this *Orchestrator) WatchWebhook(topic string) (*WebhookMeta, <-chan zk.Event, error) {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", PubsubWebhooks, topic)
+	data, _, c, err := this.conn.GetW(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var hook = &WebhookMeta{}
+	err = hook.From(data)
+	return hook, c, err
+}
+
+// PauseWebhook turns off a webhook, actord will stop delivering its messages.
+func (this *ZkZone) PauseWebhook(topic string) error {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", PubsubWebhooksOff, topic)
+	this.ensureParentDirExists(path)
+	err := this.createZnode(path, nil)
+	if err == zk.ErrNodeExists {
+		return nil
+	}
+	return err
+}
+
+// ResumeWebhook turns on a paused webhook.
+func (this *ZkZone) ResumeWebhook(topic string) error {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", PubsubWebhooksOff, topic)
+	err := this.conn.Delete(path, -1)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	return err
+}
+
+// WebhookPaused checks whether a webhook is turned off.
+func (this *ZkZone) WebhookPaused(topic string) (bool, error) {
+	this.connectIfNeccessary()
+
+	return this.exists(fmt.Sprintf("%s/%s", PubsubWebhooksOff, topic))
+}
+
+// CreateMigration starts moving an app from one cluster to another, zk.ErrNodeExists
+// if the app is being migrated.
+func (this *ZkZone) CreateMigration(appid, from, to string) error {
+	this.connectIfNeccessary()
+
+	now := time.Now().Unix()
+	m := MigrationMeta{
+		Appid:  appid,
+		Source: from,
+		Target: to,
+		State:  MigrationDualWrite,
+		Ctime:  now,
+		Mtime:  now,
+	}
+
+	path := migrationPath(appid)
+	if err := this.ensureParentDirExists(path); err != nil {
+		return err
+	}
+	return this.createZnode(path, m.Bytes())
+}
+
+func (this *ZkZone) Migration(appid string) (*MigrationMeta, error) {
+	this.connectIfNeccessary()
+
+	data, _, err := this.conn.Get(migrationPath(appid))
+	if err != nil {
+		return nil, err
+	}
+
+	var m = &MigrationMeta{}
+	err = m.From(data)
+	return m, err
+}
+
+// Migrations returns all the apps being migrated: {appid: migration}.
+func (this *ZkZone) Migrations() map[string]*MigrationMeta {
+	r := make(map[string]*MigrationMeta)
+	for appid, zdata := range this.ChildrenWithData(PubsubMigrations) {
+		var m = &MigrationMeta{}
+		if err := m.From(zdata.data); err != nil {
+			log.Error("migration[%s] %v", appid, err)
+			continue
+		}
+
+		r[appid] = m
+	}
+
+	return r
+}
+
+// AdvanceMigration moves the migration of an app to the next state.
+func (this *ZkZone) AdvanceMigration(appid string) (*MigrationMeta, error) {
+	m, err := this.Migration(appid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.Advance(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return m, this.setZnode(migrationPath(appid), m.Bytes())
+}
+
+// DeleteMigration finishes or rolls back the migration of an app.
+func (this *ZkZone) DeleteMigration(appid string) error {
+	return this.DeleteRecursive(migrationPath(appid))
+}
+
+// MarkMigrationDrained records that a consumer group has consumed up all the messages
+// of a topic on the source cluster, zk.ErrNodeExists if already marked.
+func (this *ZkZone) MarkMigrationDrained(appid, topic, group string) error {
+	this.connectIfNeccessary()
+
+	path := migrationDrainedPath(appid, topic, group)
+	if err := this.ensureParentDirExists(path); err != nil {
+		return err
+	}
+	return this.createZnode(path, nil)
+}
+
+// LockMigrationDrain makes sure only one kateway moves a consumer group to the target
+// cluster, zk.ErrNodeExists if locked by another. The lock is gone with its session.
+func (this *ZkZone) LockMigrationDrain(appid, topic, group string) error {
+	return this.CreateEphemeralZnode(migrationDrainingPath(appid, topic, group), nil)
+}
+
+func (this *ZkZone) UnlockMigrationDrain(appid, topic, group string) error {
+	this.connectIfNeccessary()
+
+	return this.conn.Delete(migrationDrainingPath(appid, topic, group), -1)
+}
+
+func (this *ZkZone) MigrationDrained(appid, topic, group string) (bool, error) {
+	this.connectIfNeccessary()
+
+	return this.exists(migrationDrainedPath(appid, topic, group))
+}
+
+// MigrationDrainedGroups returns the drained consumer groups of a migration: {group: [topic]}.
+func (this *ZkZone) MigrationDrainedGroups(appid string) map[string][]string {
+	r := make(map[string][]string)
+	for _, name := range this.children(migrationDrainedRoot(appid)) {
+		parts := strings.SplitN(name, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		r[parts[0]] = append(r[parts[0]], parts[1])
+	}
+
+	return r
+}
+
+// SaveMirrorCheckpoint persists the offset syncs of a mirrored partition, name identifies the mirror.
+func (this *ZkZone) SaveMirrorCheckpoint(name, topic string, partition int32, cp *MirrorCheckpoint) error {
+	this.connectIfNeccessary()
+
+	path := mirrorCheckpointPath(name, topic, partition)
+	err := this.setZnode(path, cp.Bytes())
+	if err == zk.ErrNoNode {
+		if err = this.ensureParentDirExists(path); err == nil {
+			err = this.createZnode(path, cp.Bytes())
+		}
+	}
+	return err
+}
+
+func (this *ZkZone) MirrorCheckpoint(name, topic string, partition int32) (*MirrorCheckpoint, error) {
+	this.connectIfNeccessary()
+
+	data, _, err := this.conn.Get(mirrorCheckpointPath(name, topic, partition))
+	if err != nil {
+		return nil, err
+	}
+
+	var cp = &MirrorCheckpoint{}
+	err = cp.From(data)
+	return cp, err
+}
+
+// SetTopicArchive turns on/off the archival of a topic to object store.
+func (this *ZkZone) SetTopicArchive(cluster, topic string, on bool) error {
+	this.connectIfNeccessary()
+
+	path := archiveTopicPath(cluster, topic)
+	if !on {
+		err := this.conn.Delete(path, -1)
+		if err == zk.ErrNoNode {
+			return nil
+		}
+		return err
+	}
+
+	this.ensureParentDirExists(path)
+	err := this.createZnode(path, nil)
+	if err == zk.ErrNodeExists {
+		return nil
+	}
+	return err
+}
+
+// ArchivedTopics returns the topics of a cluster that are archived.
+func (this *ZkZone) ArchivedTopics(cluster string) []string {
+	return this.children(fmt.Sprintf("%s/%s", archiveTopicsRoot, cluster))
+}
+
+// SetPubFailover configures the cluster that takes over Pub of an app when its cluster is down.
+func (this *ZkZone) SetPubFailover(appid, cluster string) error {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", PubsubFailovers, appid)
+	this.ensureParentDirExists(path)
+
+	err := this.createZnode(path, []byte(cluster))
+	if err == zk.ErrNodeExists {
+		return this.setZnode(path, []byte(cluster))
+	}
+	return err
+}
+
+func (this *ZkZone) DelPubFailover(appid string) error {
+	this.connectIfNeccessary()
+
+	return this.conn.Delete(fmt.Sprintf("%s/%s", PubsubFailovers, appid), -1)
+}
+
+// PubFailovers returns {appid: failoverCluster}.
+func (this *ZkZone) PubFailovers() map[string]string {
+	r := make(map[string]string)
+	for appid, zdata := range this.ChildrenWithData(PubsubFailovers) {
+		r[appid] = string(zdata.data)
+	}
+	return r
+}
+
 func (this *ZkZone) LoadKatewayMetrics(katewayId string, key string) ([]byte, error) {
 	this.connectIfNeccessary()
 